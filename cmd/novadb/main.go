@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"time"
 
 	"github.com/MartinMurithi/NovaDB.git/internal/engine"
 	"github.com/MartinMurithi/NovaDB.git/internal/repl"
@@ -13,10 +14,24 @@ import (
 func main() {
 	mode := flag.String("mode", "repl", "repl | web | both")
 	addr := flag.String("addr", ":7070", "http address")
+	dataDir := flag.String("data-dir", "", "directory for the write-ahead log (in-memory only if empty)")
+	checkpointInterval := flag.Duration("checkpoint-interval", 5*time.Minute, "time between automatic checkpoints (0 disables)")
+	checkpointRecords := flag.Int("checkpoint-records", 1000, "log records between automatic checkpoints (0 disables)")
+	maxRecursion := flag.Int("max-recursion", engine.DefaultRecursionLimit, "runs of the step of a WITH RECURSIVE query before it fails (0 disables)")
 	flag.Parse()
 
 	db := storage.NewDatabase()
 	eng := engine.NewEngine(db)
+	eng.SetRecursionLimit(*maxRecursion)
+
+	if *dataDir != "" {
+		if err := eng.Open(*dataDir); err != nil {
+			log.Fatalf("failed to open data directory: %v", err)
+		}
+		defer eng.Close()
+
+		eng.StartCheckpointer(*checkpointInterval, *checkpointRecords)
+	}
 
 	Seed(db, eng)
 
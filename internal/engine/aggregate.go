@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Aggregate
+// --------------------------
+
+// aggregateOp groups its input by the GROUP BY expressions in a hash table
+// and computes the aggregates of every group. It produces one row per
+// group, in the order the groups were first seen, holding each key and
+// aggregate under its SQL text; expressions over the groups are rewritten
+// by groupOutput to read them from there. Without GROUP BY all rows form a
+// single group, even when there are none.
+type aggregateOp struct {
+	opStats
+	input  operator
+	keys   []expr.Expr
+	aggs   []*expr.Aggregate
+	having expr.Expr // rewritten by groupOutput; nil for none
+	groups float64   // estimated number of groups
+}
+
+// buildAggregate builds the operators of a SELECT that aggregates the
+// rows of input: aggregate, then DISTINCT, sort and limit over the groups,
+// and the select list.
+func (e *Engine) buildAggregate(plan *planner.Plan, input operator, s *scope) (operator, error) {
+	op := &aggregateOp{input: input, keys: plan.GroupBy}
+
+	// Every distinct aggregate is computed once, whichever clauses use it
+	seen := make(map[string]bool)
+	collect := func(e expr.Expr) {
+		expr.Walk(e, func(n expr.Expr) {
+			if agg, ok := n.(*expr.Aggregate); ok && !seen[agg.String()] {
+				seen[agg.String()] = true
+				op.aggs = append(op.aggs, agg)
+			}
+		})
+	}
+	for _, item := range plan.Select {
+		collect(item)
+	}
+	if plan.Having != nil {
+		collect(plan.Having)
+	}
+	for _, k := range plan.OrderBy {
+		collect(k.Expr)
+	}
+	for _, x := range plan.DistinctOn {
+		collect(x)
+	}
+
+	if plan.Having != nil {
+		having, err := groupOutput(plan.GroupBy, plan.Having)
+		if err != nil {
+			return nil, err
+		}
+		op.having = having
+	}
+	rows, _ := input.estimate()
+	op.groups = estimateGroups(s, plan.GroupBy, rows)
+
+	keys := make([]planner.SortKey, len(plan.OrderBy))
+	for i, k := range plan.OrderBy {
+		out, err := groupOutput(plan.GroupBy, k.Expr)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = planner.SortKey{Expr: out, Desc: k.Desc, NullsFirst: k.NullsFirst}
+	}
+
+	exprs := make([]expr.Expr, len(plan.Select))
+	for i, item := range plan.Select {
+		out, err := groupOutput(plan.GroupBy, item)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = out
+	}
+	var on []expr.Expr
+	for _, x := range plan.DistinctOn {
+		out, err := groupOutput(plan.GroupBy, x)
+		if err != nil {
+			return nil, err
+		}
+		on = append(on, out)
+	}
+	return e.buildOutput(plan, op, keys, exprs, on)
+}
+
+// groupOutput rewrites an expression over the groups of an aggregate so
+// that it reads the aggregates and GROUP BY keys it uses from the rows
+// aggregateOp produces. Any other column is an error, since it has no
+// single value in a group.
+func groupOutput(keys []expr.Expr, e expr.Expr) (expr.Expr, error) {
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		names[k.String()] = true
+	}
+
+	out := expr.Replace(e, func(n expr.Expr) (expr.Expr, bool) {
+		if agg, ok := n.(*expr.Aggregate); ok {
+			names[agg.String()] = true
+			return &expr.ColumnRef{Name: agg.String()}, true
+		}
+		if s := n.String(); names[s] {
+			if _, ok := n.(*expr.Literal); !ok {
+				return &expr.ColumnRef{Name: s}, true
+			}
+		}
+		return nil, false
+	})
+
+	for _, col := range expr.Columns(out) {
+		if !names[col] {
+			return nil, fmt.Errorf("column '%s' must appear in the GROUP BY clause or be used in an aggregate function", col)
+		}
+	}
+	return out, nil
+}
+
+// estimateGroups guesses the number of groups from the distinct values of
+// the grouped columns, assuming they vary independently. Other keys are
+// taken to have ten values.
+func estimateGroups(s *scope, keys []expr.Expr, rows float64) float64 {
+	if len(keys) == 0 {
+		return 1
+	}
+
+	groups := 1.0
+	for _, k := range keys {
+		distinct := 10.0
+		if ref, ok := k.(*expr.ColumnRef); ok {
+			src, err := s.find(ref)
+			if err != nil || src.table == nil {
+				continue
+			}
+			if cs := src.table.Stats().Columns[ref.Name]; cs != nil {
+				distinct = float64(cs.Distinct)
+				if cs.Nulls > 0 {
+					distinct++
+				}
+			}
+		}
+		groups *= math.Max(distinct, 1)
+	}
+	return math.Min(groups, math.Max(rows, 1))
+}
+
+func (op *aggregateOp) describe() (string, []string) {
+	name := "Aggregate"
+	var details []string
+	if len(op.keys) > 0 {
+		name = "HashAggregate"
+		keys := make([]string, len(op.keys))
+		for i, k := range op.keys {
+			keys[i] = k.String()
+		}
+		details = append(details, "Group Key: "+strings.Join(keys, ", "))
+	}
+	if op.having != nil {
+		details = append(details, "Filter: "+op.having.String())
+	}
+	return name, details
+}
+
+func (op *aggregateOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	return op.groups, cost + rows
+}
+
+func (op *aggregateOp) inputs() []operator { return []operator{op.input} }
+
+// group is one group of an aggregate: its key values and the state of
+// each aggregate.
+type group struct {
+	keys []any
+	accs []*accumulator
+}
+
+func (op *aggregateOp) newGroup(keys []any) *group {
+	g := &group{keys: keys, accs: make([]*accumulator, len(op.aggs))}
+	for i, agg := range op.aggs {
+		g.accs[i] = newAccumulator(agg)
+	}
+	return g
+}
+
+func (op *aggregateOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*group)
+	var order []*group
+	if len(op.keys) == 0 {
+		g := op.newGroup(nil)
+		groups[""] = g
+		order = append(order, g)
+	}
+
+	for _, row := range rows {
+		keys := make([]any, len(op.keys))
+		for i, k := range op.keys {
+			if keys[i], err = k.Eval(row.Data); err != nil {
+				return nil, err
+			}
+		}
+
+		h := hashKey(keys)
+		g, ok := groups[h]
+		if !ok {
+			g = op.newGroup(keys)
+			groups[h] = g
+			order = append(order, g)
+		}
+		for _, acc := range g.accs {
+			if err := acc.add(row.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]*storage.Row, 0, len(order))
+	for _, g := range order {
+		data := make(map[string]any, len(op.keys)+len(op.aggs))
+		for i, k := range op.keys {
+			data[k.String()] = g.keys[i]
+		}
+		for i, agg := range op.aggs {
+			data[agg.String()] = g.accs[i].result()
+		}
+
+		row := &storage.Row{Data: data}
+		if ok, err := matches(row, op.having); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// hashKey encodes values so that equal values, NULLs included, give equal
+// keys.
+func hashKey(values []any) string {
+	var b strings.Builder
+	for _, v := range values {
+		switch x := v.(type) {
+		case nil:
+			b.WriteString("n")
+		case time.Time:
+			fmt.Fprintf(&b, "t%d", x.UnixNano())
+		default:
+			fmt.Fprintf(&b, "%T:%v", v, v)
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// --------------------------
+// Accumulators
+// --------------------------
+
+// accumulator computes one aggregate over the rows added to it. NULL
+// arguments are skipped; SUM, AVG, MIN and MAX of no values are NULL.
+type accumulator struct {
+	agg      *expr.Aggregate
+	distinct map[string]bool
+
+	count int64
+	sum   any     // int64 while every value is an INT, else float64
+	total float64 // for AVG
+	best  any     // MIN or MAX so far
+}
+
+func newAccumulator(agg *expr.Aggregate) *accumulator {
+	acc := &accumulator{agg: agg}
+	if agg.Distinct {
+		acc.distinct = make(map[string]bool)
+	}
+	return acc
+}
+
+func (a *accumulator) add(row map[string]any) error {
+	if a.agg.Arg == nil {
+		a.count++ // COUNT(*)
+		return nil
+	}
+
+	v, err := a.agg.Arg.Eval(row)
+	if err != nil || v == nil {
+		return err
+	}
+	if a.distinct != nil {
+		h := hashKey([]any{v})
+		if a.distinct[h] {
+			return nil
+		}
+		a.distinct[h] = true
+	}
+	a.count++
+
+	switch a.agg.Func {
+	case "SUM", "AVG":
+		f, ok := v.(float64)
+		i, isInt := v.(int64)
+		if !ok && !isInt {
+			return fmt.Errorf("%s cannot be applied to %s", a.agg.Func, storage.TypeOf(v))
+		}
+		if isInt {
+			f = float64(i)
+		}
+		a.total += f
+
+		switch s := a.sum.(type) {
+		case nil:
+			a.sum = v
+		case int64:
+			if isInt {
+				a.sum = s + i
+			} else {
+				a.sum = float64(s) + f
+			}
+		case float64:
+			a.sum = s + f
+		}
+
+	case "MIN", "MAX":
+		if a.best == nil {
+			a.best = v
+			return nil
+		}
+		cmp, err := storage.Compare(v, a.best)
+		if err != nil {
+			return err
+		}
+		if (a.agg.Func == "MIN" && cmp < 0) || (a.agg.Func == "MAX" && cmp > 0) {
+			a.best = v
+		}
+	}
+	return nil
+}
+
+func (a *accumulator) result() any {
+	switch a.agg.Func {
+	case "COUNT":
+		return a.count
+	case "SUM":
+		return a.sum
+	case "AVG":
+		if a.count == 0 {
+			return nil
+		}
+		return a.total / float64(a.count)
+	default:
+		return a.best
+	}
+}
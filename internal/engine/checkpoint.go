@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// snapshotFileName is the name of the checkpoint snapshot inside the data
+// directory.
+const snapshotFileName = "snapshot.db"
+
+// checkpointer holds the checkpoint policy and progress of an engine.
+type checkpointer struct {
+	dir     string
+	records int // checkpoint once this many records were logged; 0 disables
+	pending int // records logged since the last checkpoint
+	stop    chan struct{}
+}
+
+// StartCheckpointer enables automatic checkpoints. A checkpoint is taken
+// every interval and whenever records log records have been written since
+// the previous one. A zero value disables the corresponding trigger.
+//
+// It must be called after Open.
+func (e *Engine) StartCheckpointer(interval time.Duration, records int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.checkpoint.records = records
+
+	if interval <= 0 || e.checkpoint.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	e.checkpoint.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.mu.Lock()
+				// Nothing to do if the engine was closed or nothing changed
+				if e.wal != nil && e.checkpoint.pending > 0 {
+					if err := e.runCheckpoint(); err != nil {
+						log.Printf("checkpoint failed: %v", err)
+					}
+				}
+				e.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Checkpoint writes a snapshot of the whole database and truncates the
+// write-ahead log records it covers.
+func (e *Engine) Checkpoint() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.runCheckpoint()
+}
+
+func (e *Engine) runCheckpoint() error {
+	if e.wal == nil {
+		return fmt.Errorf("checkpoint requires a data directory")
+	}
+
+	path := filepath.Join(e.checkpoint.dir, snapshotFileName)
+	if err := storage.WriteSnapshot(path, e.db, e.wal.LastLSN()); err != nil {
+		return err
+	}
+
+	// Only drop the log once the snapshot is durable
+	if err := e.wal.Reset(); err != nil {
+		return err
+	}
+
+	e.checkpoint.pending = 0
+	return nil
+}
+
+// maybeCheckpoint takes a checkpoint if enough records have been logged
+// since the last one. It runs after a statement has been fully applied
+// and logged, so a failed checkpoint does not fail the statement: it is
+// logged, and tried again after the next one.
+func (e *Engine) maybeCheckpoint() {
+	if e.wal == nil || e.checkpoint.records <= 0 || e.checkpoint.pending < e.checkpoint.records {
+		return
+	}
+
+	if err := e.runCheckpoint(); err != nil {
+		log.Printf("checkpoint failed: %v", err)
+	}
+}
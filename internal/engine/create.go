@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// CreateTable creates a table with the given columns.
+func (e *Engine) CreateTable(tableName string, columns ...*storage.Column) (*storage.Table, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	table, err := e.createTable(tableName, columns, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.maybeCheckpoint()
+	return table, nil
+}
+
+// AddColumn adds a column to a table as ALTER TABLE ... ADD COLUMN does,
+// logging it.
+func (e *Engine) AddColumn(tableName string, col *storage.Column) error {
+	_, err := e.ExecutePlan(&planner.Plan{Type: planner.AddColumnPlan, TableName: tableName, Schema: []*storage.Column{col}})
+	return err
+}
+
+// createTable creates and registers a table, its foreign keys and the
+// sequences of its identity columns, then logs them. sequences may supply
+// the sequence of an identity column; the others start at 1. The table is
+// built in memory first so that invalid columns, constraints or references
+// are rejected before anything reaches the log.
+func (e *Engine) createTable(tableName string, columns []*storage.Column, constraints []*storage.Constraint,
+	foreignKeys []*storage.ForeignKey, sequences []*storage.Sequence) (*storage.Table, error) {
+	if tableName == "" {
+		return nil, fmt.Errorf("table name cannot be empty")
+	}
+
+	// Log the columns as declared; constraints may change their flags below
+	recs := []*storage.Record{{Type: storage.RecordCreateTable, Table: tableName}}
+	for _, col := range columns {
+		if col == nil {
+			return nil, fmt.Errorf("column cannot be empty")
+		}
+		declared := *col
+		recs = append(recs, &storage.Record{Type: storage.RecordAddColumn, Table: tableName, Column: &declared})
+	}
+	for _, c := range constraints {
+		recs = append(recs, &storage.Record{Type: storage.RecordAddConstraint, Table: tableName, Constraint: c})
+	}
+	for _, fk := range foreignKeys {
+		recs = append(recs, &storage.Record{Type: storage.RecordAddForeignKey, Table: tableName, ForeignKey: fk})
+	}
+
+	identity, err := identitySequences(tableName, columns, sequences)
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range identity {
+		if _, exists := e.db.Sequences[seq.Name]; exists {
+			return nil, fmt.Errorf("sequence %s already exists", seq.Name)
+		}
+		recs = append(recs, &storage.Record{Type: storage.RecordCreateSequence, Sequence: seq})
+	}
+
+	table, err := e.db.CreateTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	before := len(e.db.ForeignKeys)
+	err = func() error {
+		for _, col := range columns {
+			if err := table.AddColumn(col); err != nil {
+				return err
+			}
+		}
+		for _, c := range constraints {
+			if err := table.AddConstraint(c); err != nil {
+				return err
+			}
+		}
+		// AddForeignKey resolves the referenced columns and name, so the
+		// records logged below carry the resolved key
+		for _, fk := range foreignKeys {
+			if err := e.db.AddForeignKey(fk); err != nil {
+				return err
+			}
+		}
+		return e.logChanges(recs...)
+	}()
+	if err != nil {
+		delete(e.db.Tables, tableName)
+		e.db.ForeignKeys = e.db.ForeignKeys[:before]
+		return nil, err
+	}
+
+	for _, seq := range identity {
+		if err := e.db.CreateSequence(seq); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// identitySequences returns the sequence of every identity column, taken
+// from sequences when it is there.
+func identitySequences(tableName string, columns []*storage.Column, sequences []*storage.Sequence) ([]*storage.Sequence, error) {
+	given := make(map[string]*storage.Sequence, len(sequences))
+	for _, seq := range sequences {
+		given[seq.Name] = seq
+	}
+
+	var out []*storage.Sequence
+	for _, col := range columns {
+		if col == nil || col.Identity == "" {
+			continue
+		}
+
+		name := storage.IdentitySequence(tableName, col.Name)
+		seq, ok := given[name]
+		if !ok {
+			var err error
+			if seq, err = storage.NewSequence(name, 1, 1); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, seq)
+	}
+	return out, nil
+}
@@ -0,0 +1,339 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// DefaultRecursionLimit is how many times the step of a recursive WITH
+// query may run before the statement fails, unless SetRecursionLimit
+// changes it.
+const DefaultRecursionLimit = 1000
+
+// SetRecursionLimit sets how many times the step of a recursive WITH query
+// may run before the statement fails, which stops a recursion that never
+// ends. 0 removes the limit.
+func (e *Engine) SetRecursionLimit(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recursionLimit = n
+}
+
+// --------------------------
+// Planning WITH
+// --------------------------
+
+// with plans the queries of WITH, each of which may read those before it,
+// and lets the statement planned in s read them all.
+func (s *scope) with(defs []parser.CTE) error {
+	if len(defs) == 0 {
+		return nil
+	}
+	ctes := make(map[string]*cte, len(s.ctes)+len(defs))
+	for name, c := range s.ctes {
+		ctes[name] = c
+	}
+	for _, def := range defs {
+		c, err := s.e.buildCTE(def, ctes)
+		if err != nil {
+			return err
+		}
+		ctes[def.Name] = c
+	}
+	s.ctes = ctes
+	return nil
+}
+
+// buildCTE builds the operators of a WITH query that may read ctes. The
+// step of a recursive query also reads the query itself, as its working
+// table.
+func (e *Engine) buildCTE(def parser.CTE, ctes map[string]*cte) (*cte, error) {
+	c := &cte{name: def.Name, unionAll: def.UnionAll}
+
+	plan, err := planner.CreatePlan(def.Query, e.db)
+	if err != nil {
+		return nil, err
+	}
+	inner := newScope(e, false)
+	inner.ctes = ctes
+	if c.anchor, err = e.build(plan, inner); err != nil {
+		return nil, err
+	}
+	c.anchorColumns = resultNames(plan, inner)
+	c.types = resultTypes(plan, inner)
+
+	c.columns = c.anchorColumns
+	if len(def.Columns) > 0 {
+		if len(def.Columns) != len(c.anchorColumns) {
+			return nil, fmt.Errorf("WITH query '%s' has %d columns available but %d columns specified", def.Name, len(c.anchorColumns), len(def.Columns))
+		}
+		c.columns = def.Columns
+	}
+	seen := make(map[string]bool, len(c.columns))
+	for _, col := range c.columns {
+		if seen[col] {
+			return nil, fmt.Errorf("column '%s' is specified more than once in WITH query '%s'", col, def.Name)
+		}
+		seen[col] = true
+	}
+
+	if def.Step == nil {
+		return c, nil
+	}
+	work := &cte{name: def.Name, columns: c.columns, types: c.types, of: c}
+	stepCTEs := make(map[string]*cte, len(ctes)+1)
+	for name, other := range ctes {
+		stepCTEs[name] = other
+	}
+	stepCTEs[def.Name] = work
+
+	if plan, err = planner.CreatePlan(def.Step, e.db); err != nil {
+		return nil, err
+	}
+	inner = newScope(e, false)
+	inner.ctes = stepCTEs
+	if c.step, err = e.build(plan, inner); err != nil {
+		return nil, err
+	}
+	c.stepColumns = resultNames(plan, inner)
+	if len(c.stepColumns) != len(c.columns) {
+		return nil, fmt.Errorf("each UNION query must have the same number of columns")
+	}
+	for i, t := range resultTypes(plan, inner) {
+		if c.types[i], err = commonType(parser.Union, c.types[i], t); err != nil {
+			return nil, err
+		}
+	}
+	c.recursive = work.refs > 0
+	return c, nil
+}
+
+// cteSource is a reference in FROM to a query of WITH. The step of a
+// recursive query may read its working table only once, and not from a
+// subquery, whose result would be kept from one run of the step to the
+// next.
+func (s *scope) cteSource(c *cte, alias string) (source, error) {
+	if c.of != nil {
+		if s.parent != nil {
+			return source{}, fmt.Errorf("recursive reference to query '%s' must not appear within a subquery", c.name)
+		}
+		if c.refs > 0 {
+			return source{}, fmt.Errorf("recursive reference to query '%s' must not appear more than once", c.name)
+		}
+	}
+	c.refs++
+
+	name := sourceName(c.name, alias)
+	scan := &cteScanOp{cte: c, alias: name, owner: c.of == nil && c.refs == 1}
+	return source{name: name, derived: scan, columns: c.columns, types: c.types}, nil
+}
+
+// --------------------------
+// CTE
+// --------------------------
+
+// cte is a query of WITH. It runs when the statement first reads it, and
+// its rows are kept for the statement's other references to it.
+//
+// A recursive query runs its anchor, then its step over and over: each run
+// reads the rows the run before it added, in work, until a run adds none.
+// With UNION rather than UNION ALL, rows seen before are not added again,
+// so a recursion over a cycle stops too. The engine's recursion limit
+// stops any other recursion that would never end.
+type cte struct {
+	opStats
+	name          string
+	columns       []string
+	types         []storage.ColumnType
+	anchor        operator
+	anchorColumns []string // the anchor's result columns, for columns
+
+	step        operator // nil unless the query is a UNION
+	stepColumns []string
+	unionAll    bool
+	recursive   bool // whether the step reads the working table
+
+	// The working table a step reads is a cte of its own, of the query
+	// it belongs to
+	of   *cte
+	refs int
+
+	done       bool
+	rows       []*storage.Row
+	work       []*storage.Row
+	iterations int
+}
+
+func (c *cte) describe() (string, []string) {
+	var details []string
+	switch {
+	case c.step == nil:
+	case c.recursive && c.unionAll:
+		details = append(details, "Recursive Union All")
+	case c.recursive:
+		details = append(details, "Recursive Union")
+	case c.unionAll:
+		details = append(details, "Union All")
+	default:
+		details = append(details, "Union")
+	}
+	if c.ran && c.recursive {
+		details = append(details, fmt.Sprintf("Iterations: %d", c.iterations))
+	}
+	return "CTE " + c.name, details
+}
+
+// estimate guesses that a recursive step runs ten times.
+func (c *cte) estimate() (float64, float64) {
+	rows, cost := c.anchor.estimate()
+	if c.step != nil {
+		stepRows, stepCost := c.step.estimate()
+		runs := 1.0
+		if c.recursive {
+			runs = 10
+		}
+		rows += runs * stepRows
+		cost += runs * stepCost
+	}
+	return rows, cost
+}
+
+func (c *cte) inputs() []operator {
+	if c.step != nil {
+		return []operator{c.anchor, c.step}
+	}
+	return []operator{c.anchor}
+}
+
+// materialize returns the rows of the query, running it the first time.
+func (c *cte) materialize(e *Engine) ([]*storage.Row, error) {
+	if !c.done {
+		rows, err := e.runOperator(c)
+		if err != nil {
+			return nil, err
+		}
+		c.rows, c.done = rows, true
+	}
+	return c.rows, nil
+}
+
+func (c *cte) run(e *Engine) ([]*storage.Row, error) {
+	var seen map[string]bool
+	if c.step != nil && !c.unionAll {
+		seen = make(map[string]bool)
+	}
+
+	rows, err := e.runOperator(c.anchor)
+	if err != nil {
+		return nil, err
+	}
+	all := c.add(rows, c.anchorColumns, seen)
+	if c.step == nil {
+		return all, nil
+	}
+
+	c.work = all
+	for c.iterations == 0 || (c.recursive && len(c.work) > 0) {
+		if c.recursive && e.recursionLimit > 0 && c.iterations >= e.recursionLimit {
+			return nil, fmt.Errorf("recursive query '%s' did not finish within %d iterations", c.name, e.recursionLimit)
+		}
+		c.iterations++
+
+		rows, err := e.runOperator(c.step)
+		if err != nil {
+			return nil, err
+		}
+		c.work = c.add(rows, c.stepColumns, seen)
+		all = append(all, c.work...)
+	}
+	return all, nil
+}
+
+// add renames the result columns from of rows to the query's columns, in
+// order. Rows already in seen are left out when seen is not nil.
+func (c *cte) add(rows []*storage.Row, from []string, seen map[string]bool) []*storage.Row {
+	out := make([]*storage.Row, 0, len(rows))
+	for _, row := range rows {
+		data := make(map[string]any, len(c.columns))
+		values := make([]any, len(c.columns))
+		for i, col := range c.columns {
+			data[col] = widen(row.Data[from[i]], c.types[i])
+			values[i] = keyValue(data[col])
+		}
+		if seen != nil {
+			key := hashKey(values)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		out = append(out, &storage.Row{Data: data})
+	}
+	return out
+}
+
+// cteScanOp reads the rows of a WITH query, or the working table of a
+// recursive one, and qualifies their columns with alias. The first scan of
+// a query shows the query's operators in EXPLAIN.
+type cteScanOp struct {
+	opStats
+	cte   *cte
+	alias string
+	owner bool
+}
+
+func (op *cteScanOp) describe() (string, []string) {
+	name := "CTE Scan on " + op.cte.name
+	if op.cte.of != nil {
+		name = "WorkTable Scan on " + op.cte.name
+	}
+	if op.alias != op.cte.name {
+		name += " " + op.alias
+	}
+	return name, nil
+}
+
+// estimate charges the work of the query to the scan that shows it.
+func (op *cteScanOp) estimate() (float64, float64) {
+	if op.cte.of != nil {
+		rows, _ := op.cte.of.anchor.estimate()
+		return rows, rows
+	}
+	rows, cost := op.cte.estimate()
+	if op.owner {
+		return rows, cost + rows
+	}
+	return rows, rows
+}
+
+func (op *cteScanOp) inputs() []operator {
+	if op.owner {
+		return []operator{op.cte}
+	}
+	return nil
+}
+
+func (op *cteScanOp) run(e *Engine) ([]*storage.Row, error) {
+	var rows []*storage.Row
+	if op.cte.of != nil {
+		rows = op.cte.of.work
+	} else {
+		var err error
+		if rows, err = op.cte.materialize(e); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*storage.Row, len(rows))
+	for i, row := range rows {
+		data := make(map[string]any, len(op.cte.columns))
+		for _, col := range op.cte.columns {
+			data[op.alias+"."+col] = row.Data[col]
+		}
+		out[i] = &storage.Row{Data: data}
+	}
+	return out, nil
+}
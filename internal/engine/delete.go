@@ -1,13 +1,31 @@
 package engine
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
 
 // Delete removes a row by primary key from the specified table.
+// Tables with a composite primary key take a []any as pk.
 func (e *Engine) Delete(tableName string, pk any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	table, ok := e.db.Tables[tableName]
 	if !ok {
 		return fmt.Errorf("table %s does not exist", tableName)
 	}
 
-	return table.Delete(pk)
-}
\ No newline at end of file
+	id, err := table.RowIDByPK(pk)
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.delete(table, []storage.RowID{id}); err != nil {
+		return err
+	}
+
+	e.maybeCheckpoint()
+	return nil
+}
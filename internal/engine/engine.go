@@ -3,24 +3,57 @@ package engine
 import (
 	// "github.com/MartinMurithi/NovaDB/internal/storage"
 	"fmt"
+	"sync"
 
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
 	"github.com/MartinMurithi/NovaDB.git/internal/planner"
 	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
 type Engine struct {
-	db *storage.Database
+	db  *storage.Database
+	wal *storage.WAL // nil when running purely in memory
+
+	// mu serializes statements against each other and against checkpoints
+	mu         sync.Mutex
+	checkpoint checkpointer
+
+	// runs allowed to the step of a recursive WITH query; 0 for no limit
+	recursionLimit int
 }
 
 func NewEngine(db *storage.Database) *Engine {
-	return &Engine{db: db}
+	return &Engine{db: db, recursionLimit: DefaultRecursionLimit}
 }
 
 func (e *Engine) DB() *storage.Database {
 	return e.db
 }
 
+// Plan plans query against the engine's database. It holds the same lock
+// as ExecutePlan, since choosing access paths reads table statistics.
+func (e *Engine) Plan(query *parser.Query) (*planner.Plan, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return planner.CreatePlan(query, e.db)
+}
+
 func (e *Engine) ExecutePlan(plan *planner.Plan) ([]*storage.Row, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rows, err := e.execute(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	e.maybeCheckpoint()
+	return rows, nil
+}
+
+func (e *Engine) execute(plan *planner.Plan) ([]*storage.Row, error) {
 	switch plan.Type {
 
 	// --------------------------
@@ -30,13 +63,39 @@ func (e *Engine) ExecutePlan(plan *planner.Plan) ([]*storage.Row, error) {
 			return nil, fmt.Errorf("table '%s' already exists", plan.TableName)
 		}
 
-		_, err := e.db.CreateTable(plan.TableName)
+		_, err := e.createTable(plan.TableName, plan.Schema, plan.Constraints, plan.ForeignKeys, plan.Sequences)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create table: %w", err)
 		}
 
 		return nil, nil // DDL commands return no rows
 
+	// --------------------------
+	case planner.CreateSequencePlan:
+		for _, seq := range plan.Sequences {
+			if err := e.createSequence(seq); err != nil {
+				return nil, fmt.Errorf("failed to create sequence: %w", err)
+			}
+		}
+		return nil, nil
+
+	// --------------------------
+	case planner.CreateIndexPlan:
+		ix := &storage.Index{
+			Name:    plan.IndexName,
+			Table:   plan.TableName,
+			Columns: plan.Columns,
+			Unique:  plan.Unique,
+		}
+		if err := e.createIndex(ix); err != nil {
+			return nil, fmt.Errorf("failed to create index: %w", err)
+		}
+		return nil, nil
+
+	// --------------------------
+	case planner.DropIndexPlan:
+		return nil, e.dropIndex(plan.IndexName)
+
 	// --------------------------
 	case planner.AddColumnPlan:
 		t, ok := e.db.Tables[plan.TableName]
@@ -44,15 +103,78 @@ func (e *Engine) ExecutePlan(plan *planner.Plan) ([]*storage.Row, error) {
 			return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
 		}
 
-		for i, col := range plan.ColumnsToAdd {
-			colType := storage.TextType
-			if i < len(plan.ColumnTypes) {
-				colType = storage.ColumnType(plan.ColumnTypes[i])
+		schema := plan.Schema
+		if len(schema) == 0 {
+			for i, col := range plan.ColumnsToAdd {
+				colType := storage.TextType
+				if i < len(plan.ColumnTypes) {
+					colType = storage.ColumnType(plan.ColumnTypes[i])
+				}
+				schema = append(schema, &storage.Column{
+					Name:       col,
+					ColumnType: colType,
+				})
+			}
+		}
+
+		recs := []*storage.Record{}
+		for _, col := range schema {
+			if e.TableHasColumn(plan.TableName, col.Name) {
+				return nil, fmt.Errorf("column '%s' already exists in table '%s'", col.Name, plan.TableName)
+			}
+			if col.IsPrimaryKey && hasPrimaryKey(t) {
+				return nil, fmt.Errorf("table '%s' already has a primary key", plan.TableName)
 			}
-			t.AddColumn(&storage.Column{
-				Name:       col,
-				ColumnType: colType,
+			recs = append(recs, &storage.Record{
+				Type:   storage.RecordAddColumn,
+				Table:  plan.TableName,
+				Column: col,
 			})
+
+			if t.Len() == 0 {
+				continue
+			}
+
+			// Existing rows take the column's DEFAULT, evaluated once
+			if col.IsPrimaryKey {
+				return nil, fmt.Errorf("cannot add primary key column '%s' to table '%s' because it already has rows", col.Name, plan.TableName)
+			}
+			value, err := col.DefaultValue()
+			if err != nil {
+				return nil, err
+			}
+			if value == nil && col.NotNull {
+				return nil, fmt.Errorf("cannot add NOT NULL column '%s' without a DEFAULT to table '%s' because it already has rows", col.Name, plan.TableName)
+			}
+			// Every row gets the same value, which UNIQUE allows only once
+			if value != nil && col.IsUnique && t.Len() > 1 {
+				return nil, fmt.Errorf("cannot add UNIQUE column '%s' with DEFAULT %s to table '%s' because it has more than one row", col.Name, storage.FormatValue(value), plan.TableName)
+			}
+
+			for _, id := range t.RowIDs() {
+				row := t.Row(id)
+				image := make(map[string]any, len(row.Data)+1)
+				for k, v := range row.Data {
+					image[k] = v
+				}
+				image[col.Name] = value
+				if err := col.CheckValue(image); err != nil {
+					return nil, err
+				}
+				if value != nil {
+					recs = append(recs, &storage.Record{Type: storage.RecordUpdate, Table: plan.TableName, Row: id, Data: map[string]any{col.Name: value}})
+				}
+			}
+		}
+
+		if err := e.logChanges(recs...); err != nil {
+			return nil, err
+		}
+
+		for _, rec := range recs {
+			if err := e.db.Apply(rec); err != nil {
+				return nil, err
+			}
 		}
 
 		return nil, nil
@@ -78,44 +200,36 @@ func (e *Engine) ExecutePlan(plan *planner.Plan) ([]*storage.Row, error) {
 		for _, col := range t.Columns {
 			rows = append(rows, &storage.Row{
 				Data: map[string]any{
-					"name": col.Name,
-					"type": col.ColumnType,
+					"name":       col.Name,
+					"type":       col.ColumnType,
+					"definition": col.Definition(),
 				},
 			})
 		}
 		return rows, nil
 
 	// --------------------------
-	case planner.SelectPlan:
-		t, ok := e.db.Tables[plan.TableName]
-		if !ok {
-			return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
-		}
-		return e.selectRows(plan, t)
+	case planner.SelectPlan, planner.InsertPlan, planner.UpdatePlan, planner.DeletePlan:
+		return e.runPlan(plan)
 
 	// --------------------------
-	case planner.InsertPlan:
-		t, ok := e.db.Tables[plan.TableName]
-		if !ok {
-			return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
+	case planner.ExplainPlan:
+		node, err := e.explain(plan.Statement, plan.Analyze)
+		if err != nil {
+			return nil, err
 		}
-		return e.insertRow(plan, t)
 
-	// --------------------------
-	case planner.UpdatePlan:
-		t, ok := e.db.Tables[plan.TableName]
-		if !ok {
-			return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
+		rows := []*storage.Row{}
+		for _, line := range node.Lines() {
+			rows = append(rows, &storage.Row{
+				Data: map[string]any{planner.QueryPlanColumn: line},
+			})
 		}
-		return e.updateRows(plan, t)
+		return rows, nil
 
 	// --------------------------
-	case planner.DeletePlan:
-		t, ok := e.db.Tables[plan.TableName]
-		if !ok {
-			return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
-		}
-		return e.deleteRows(plan, t)
+	case planner.CheckpointPlan:
+		return nil, e.runCheckpoint()
 
 	// --------------------------
 	default:
@@ -125,147 +239,77 @@ func (e *Engine) ExecutePlan(plan *planner.Plan) ([]*storage.Row, error) {
 
 
 // --------------------------
-// SELECT helper
+// Filters & column helpers
 // --------------------------
-func (e *Engine) selectRows(plan *planner.Plan, table *storage.Table) ([]*storage.Row, error) {
-	rows := []*storage.Row{}
 
-	for _, row := range table.Rows {
-		if !matchesFilters(row, plan.Filters) {
-			continue
-		}
-
-		// Project columns
-		if len(plan.Columns) == 1 && plan.Columns[0] == "*" {
-			rows = append(rows, row)
+// evalFilters evaluates filter values computed when the statement runs,
+// such as currval('seq'), once for the whole statement.
+func (e *Engine) evalFilters(filters []planner.Filter) ([]planner.Filter, error) {
+	out := make([]planner.Filter, len(filters))
+	for i, f := range filters {
+		out[i] = f
+		if f.Operator == "IN" {
+			values := make([]any, len(f.Values()))
+			for j, item := range f.Values() {
+				v, err := e.evalValue(item, nil)
+				if err != nil {
+					return nil, err
+				}
+				values[j] = v
+			}
+			out[i].Value = values
 			continue
 		}
 
-		newData := make(map[string]any)
-		for _, col := range plan.Columns {
-			if val, ok := row.Data[col]; ok {
-				newData[col] = val
-			} else {
-				return nil, fmt.Errorf("column '%s' does not exist in table '%s'", col, plan.TableName)
-			}
+		v, err := e.evalValue(f.Value, nil)
+		if err != nil {
+			return nil, err
 		}
-		rows = append(rows, &storage.Row{Data: newData})
+		out[i].Value = v
 	}
-
-	return rows, nil
+	return out, nil
 }
 
-// --------------------------
-// INSERT helper
-// --------------------------
-func (e *Engine) insertRow(plan *planner.Plan, table *storage.Table) ([]*storage.Row, error) {
-	newRow := &storage.Row{Data: make(map[string]any)}
-	for col, val := range plan.Values {
-		if !e.TableHasColumn(plan.TableName, col) {
-			return nil, fmt.Errorf("column '%s' does not exist in table '%s'", col, plan.TableName)
+// matchingRows returns the IDs of the rows that satisfy where, in the
+// order the access path reads them. Only those rows are checked.
+func matchingRows(table *storage.Table, access *planner.AccessPath, filters []planner.Filter, where expr.Expr) ([]storage.RowID, error) {
+	ids := []storage.RowID{}
+	for _, id := range accessRows(table, access, filters) {
+		ok, err := matches(table.Row(id), where)
+		if err != nil {
+			return nil, err
 		}
-		newRow.Data[col] = val
-	}
-
-	table.Rows = append(table.Rows, newRow)
-	return []*storage.Row{newRow}, nil
-}
-
-// --------------------------
-// UPDATE helper
-// --------------------------
-func (e *Engine) updateRows(plan *planner.Plan, table *storage.Table) ([]*storage.Row, error) {
-	updated := []*storage.Row{}
-
-	for _, row := range table.Rows {
-		if matchesFilters(row, plan.Filters) {
-			for col, val := range plan.Values {
-				if !e.TableHasColumn(plan.TableName, col) {
-					return nil, fmt.Errorf("column '%s' does not exist in table '%s'", col, plan.TableName)
-				}
-				row.Data[col] = val
-			}
-			updated = append(updated, row)
+		if ok {
+			ids = append(ids, id)
 		}
 	}
-
-	return updated, nil
+	return ids, nil
 }
 
-// --------------------------
-// DELETE helper
-// --------------------------
-func (e *Engine) deleteRows(plan *planner.Plan, table *storage.Table) ([]*storage.Row, error) {
-	remaining := []*storage.Row{}
-	deleted := []*storage.Row{}
-
-	for _, row := range table.Rows {
-		if matchesFilters(row, plan.Filters) {
-			deleted = append(deleted, row)
-		} else {
-			remaining = append(remaining, row)
-		}
+// matches reports whether row satisfies cond. SELECT, UPDATE and DELETE
+// all test their WHERE condition with it. Conditions use three-valued
+// logic and only TRUE matches, so a comparison with NULL never does;
+// comparing values of incompatible types, such as an INT column with
+// text, is an error. A nil condition matches every row.
+func matches(row *storage.Row, cond expr.Expr) (bool, error) {
+	if cond == nil {
+		return true, nil
 	}
-
-	table.Rows = remaining
-	return deleted, nil
-}
-
-// --------------------------
-// Filters & column helpers
-// --------------------------
-
-func matchesFilters(row *storage.Row, filters []planner.Filter) bool {
-	for _, f := range filters {
-		val, ok := row.Data[f.Column]
-		if !ok {
-			return false
-		}
-
-		switch f.Operator {
-		case "=":
-			if val != f.Value {
-				return false
-			}
-		case "!=":
-			if val == f.Value {
-				return false
-			}
-		case "<":
-			if toFloat(val) >= toFloat(f.Value) {
-				return false
-			}
-		case "<=":
-			if toFloat(val) > toFloat(f.Value) {
-				return false
-			}
-		case ">":
-			if toFloat(val) <= toFloat(f.Value) {
-				return false
-			}
-		case ">=":
-			if toFloat(val) < toFloat(f.Value) {
-				return false
-			}
-		default:
-			panic("unsupported operator: " + f.Operator)
-		}
+	t, err := expr.Test(cond, row.Data)
+	if err != nil {
+		return false, err
 	}
-	return true
+	return t == storage.True, nil
 }
 
-// Helper to convert int/float to float64 for comparison
-func toFloat(v any) float64 {
-	switch n := v.(type) {
-	case int:
-		return float64(n)
-	case int64:
-		return float64(n)
-	case float64:
-		return n
-	default:
-		panic(fmt.Sprintf("cannot convert %T to float64 for comparison", v))
+// hasPrimaryKey reports whether any column of t is part of its primary key.
+func hasPrimaryKey(t *storage.Table) bool {
+	for _, c := range t.Columns {
+		if c.IsPrimaryKey {
+			return true
+		}
 	}
+	return false
 }
 
 func (e *Engine) TableHasColumn(tableName, col string) bool {
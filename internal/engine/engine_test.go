@@ -1,10 +1,14 @@
 package engine
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
-	// "github.com/MartinMurithi/NovaDB.git/internal/parser"
-	// "github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
 	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
@@ -66,7 +70,7 @@ func TestEngineInsert(t *testing.T) {
 		t.Fatal("row data mismatch")
 	}
 
-	if len(table.Rows) != 1 {
+	if table.Len() != 1 {
 		t.Fatal("expected 1 row after insert")
 	}
 }
@@ -128,7 +132,7 @@ func TestEngineDelete(t *testing.T) {
 		t.Fatalf("Delete failed: %v", err)
 	}
 
-	if len(table.Rows) != 0 {
+	if table.Len() != 0 {
 		t.Fatal("row was not deleted")
 	}
 
@@ -156,6 +160,60 @@ func setupDB() (*storage.Database, *Engine) {
 
 	return db, eng
 }
+
+// run parses, plans and executes sql on e, returning the plan along with
+// the rows. A statement that does not parse fails the test.
+func run(t *testing.T, e *Engine, sql string) (*planner.Plan, []*storage.Row, error) {
+	t.Helper()
+	query, err := parser.Parse(sql)
+	if err != nil {
+		t.Fatalf("parse %q failed: %v", sql, err)
+	}
+	plan, err := e.Plan(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := e.ExecutePlan(plan)
+	return plan, rows, err
+}
+
+// mustRun runs sql on e and returns its rows, failing the test if it fails.
+func mustRun(t *testing.T, e *Engine, sql string) []*storage.Row {
+	t.Helper()
+	_, rows, err := run(t, e, sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return rows
+}
+
+// table runs sql on e and renders its rows as "a=1 b=x; ..." in the order
+// of the plan's columns, the way PrintRows shows them.
+func table(t *testing.T, e *Engine, sql string) string {
+	t.Helper()
+	plan, rows, err := run(t, e, sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	var lines []string
+	for _, row := range rows {
+		var cells []string
+		for _, col := range plan.Columns {
+			cells = append(cells, col+"="+storage.FormatValue(row.Data[col]))
+		}
+		lines = append(lines, strings.Join(cells, " "))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// ids renders the ids of rows as "1,2,3".
+func ids(rows []*storage.Row) string {
+	var out []string
+	for _, row := range rows {
+		out = append(out, storage.FormatValue(row.Data["id"]))
+	}
+	return strings.Join(out, ",")
+}
 // func TestExecutePlan_SelectAll(t *testing.T) {
 // 	_, eng := setupDB()
 
@@ -222,4 +280,1827 @@ func setupDB() (*storage.Database, *Engine) {
 // 	if err == nil {
 // 		t.Fatal("expected error for non-existent filter column")
 // 	}
-// }
\ No newline at end of file
+// }
+
+func TestEngineRecoversFromWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	eng.CreateTable("users",
+		&storage.Column{Name: "id", ColumnType: storage.IntType, IsPrimaryKey: true},
+		&storage.Column{Name: "name", ColumnType: storage.TextType},
+	)
+	eng.Insert("users", map[string]any{"id": 1, "name": "Alice"})
+	eng.Insert("users", map[string]any{"id": 2, "name": "Bob"})
+	eng.Update("users", 1, map[string]any{"name": "Alice Updated"})
+	eng.Delete("users", 2)
+	eng.Close()
+
+	db := storage.NewDatabase()
+	recovered := NewEngine(db)
+	if err := recovered.Open(dir); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer recovered.Close()
+
+	rows, err := recovered.SelectAll("users")
+	if err != nil {
+		t.Fatalf("SelectAll failed: %v", err)
+	}
+
+	if len(rows) != 1 || rows[0].Data["name"] != "Alice Updated" {
+		t.Fatalf("unexpected rows after recovery: %+v", rows)
+	}
+}
+
+func TestEngineCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	eng.StartCheckpointer(0, 4)
+
+	eng.CreateTable("users",
+		&storage.Column{Name: "id", ColumnType: storage.IntType, IsPrimaryKey: true},
+		&storage.Column{Name: "name", ColumnType: storage.TextType},
+	)
+	eng.Insert("users", map[string]any{"id": 1, "name": "Alice"})
+
+	// Three schema records plus the insert reach the threshold
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	if err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty log after checkpoint, got %v (err %v)", info.Size(), err)
+	}
+
+	eng.Insert("users", map[string]any{"id": 2, "name": "Bob"})
+	eng.Close()
+
+	recovered := NewEngine(storage.NewDatabase())
+	if err := recovered.Open(dir); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer recovered.Close()
+
+	rows, _ := recovered.SelectAll("users")
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows after recovery, got %d", len(rows))
+	}
+
+	if _, err := recovered.GetByPK("users", 2); err != nil {
+		t.Fatalf("GetByPK failed after recovery: %v", err)
+	}
+}
+
+func TestEngineCheckpointFailureKeepsStatement(t *testing.T) {
+	dir := t.TempDir()
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer eng.Close()
+	eng.StartCheckpointer(0, 1)
+
+	// The snapshot cannot be written while a directory is in its way
+	blocker := filepath.Join(dir, snapshotFileName+".tmp")
+	if err := os.Mkdir(blocker, 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+
+	mustRun(t, eng, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	rows := mustRun(t, eng, "INSERT INTO users (id, name) VALUES (1, 'Alice')")
+	if len(rows) != 1 {
+		t.Fatalf("insert returned %d rows, want 1", len(rows))
+	}
+	if err := eng.Insert("users", map[string]any{"id": 2, "name": "Bob"}); err != nil {
+		t.Fatalf("Insert failed because of the checkpoint: %v", err)
+	}
+
+	// The next statement checkpoints once the snapshot can be written
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	mustRun(t, eng, "INSERT INTO users (id, name) VALUES (3, 'Carol')")
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	if err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty log after checkpoint, got %v (err %v)", info.Size(), err)
+	}
+}
+
+func TestExecutePlanCreateTable(t *testing.T) {
+	db := storage.NewDatabase()
+	eng := NewEngine(db)
+
+	query, err := parser.Parse("CREATE TABLE accounts (id INT PRIMARY KEY, email TEXT UNIQUE NOT NULL, balance FLOAT)")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	plan, _ := eng.Plan(query)
+
+	if _, err := eng.ExecutePlan(plan); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+
+	if err := eng.Insert("accounts", map[string]any{"id": 1, "email": "a@test.com"}); err != nil {
+		t.Fatalf("insert into SQL-created table failed: %v", err)
+	}
+
+	if err := eng.Insert("accounts", map[string]any{"id": 2}); err == nil {
+		t.Fatal("expected NOT NULL violation for email, got nil")
+	}
+
+	// A failed CREATE TABLE leaves nothing behind
+	query, _ = parser.Parse("CREATE TABLE broken (a INT, b INT, PRIMARY KEY (a), UNIQUE (b, b))")
+	plan, _ = eng.Plan(query)
+	if _, err := eng.ExecutePlan(plan); err == nil {
+		t.Fatal("expected error for invalid constraint, got nil")
+	}
+	if _, exists := db.Tables["broken"]; exists {
+		t.Fatal("table from failed CREATE TABLE was registered")
+	}
+}
+
+func TestExecutePlanEnforcesConstraints(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	if _, _, err := run(t, eng, "CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE, age INT)"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	for _, sql := range []string{
+		"INSERT INTO users (id, email, age) VALUES (1, 'a@test.com', 20)",
+		"INSERT INTO users (id, email, age) VALUES (2, 'b@test.com', 30)",
+		"INSERT INTO users (id, email, age) VALUES (3, 'c@test.com', 40)",
+	} {
+		if _, _, err := run(t, eng, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	if _, _, err := run(t, eng, "INSERT INTO users (id, email) VALUES (1, 'd@test.com')"); err == nil {
+		t.Fatal("expected duplicate primary key error from SQL insert, got nil")
+	}
+	if _, _, err := run(t, eng, "INSERT INTO users (id, email) VALUES (4, 'a@test.com')"); err == nil {
+		t.Fatal("expected duplicate unique value error from SQL insert, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE users SET email = 'x@test.com' WHERE age > 10"); err == nil {
+		t.Fatal("expected unique violation when several rows get the same email, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE users SET id = 3 WHERE id = 1"); err == nil {
+		t.Fatal("expected duplicate primary key error from SQL update, got nil")
+	}
+
+	// The primary index must follow rows after a SQL DELETE
+	if _, _, err := run(t, eng, "DELETE FROM users WHERE id = 1"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	row, err := eng.GetByPK("users", 3)
+	if err != nil || row.Data["email"] != "c@test.com" {
+		t.Fatalf("GetByPK(3) after delete = %v, %v", row, err)
+	}
+
+	// ... and after an UPDATE that changes the key
+	if _, _, err := run(t, eng, "UPDATE users SET id = 10 WHERE id = 2"); err != nil {
+		t.Fatalf("update of primary key failed: %v", err)
+	}
+	if _, err := eng.GetByPK("users", 2); err == nil {
+		t.Fatal("old primary key still resolves after update")
+	}
+	row, err = eng.GetByPK("users", 10)
+	if err != nil || row.Data["email"] != "b@test.com" {
+		t.Fatalf("GetByPK(10) after update = %v, %v", row, err)
+	}
+
+	// The Go API goes through the same checks
+	if err := eng.Update("users", 10, map[string]any{"email": "c@test.com"}); err == nil {
+		t.Fatal("expected unique violation from Engine.Update, got nil")
+	}
+	if err := eng.Update("users", 10, map[string]any{"id": 3}); err == nil {
+		t.Fatal("expected duplicate primary key error from Engine.Update, got nil")
+	}
+}
+
+func TestExecutePlanChecksTypes(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	if _, _, err := run(t, eng, "CREATE TABLE products (id INT PRIMARY KEY, name TEXT, price FLOAT, active BOOL)"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, _, err := run(t, eng, "INSERT INTO products (id, name, price, active) VALUES (1, 'pen', 2, TRUE)"); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if _, _, err := run(t, eng, "INSERT INTO products (id, name, price) VALUES ('two', 'cup', 1.5)"); err == nil {
+		t.Fatal("expected type mismatch for TEXT in INT column, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE products SET active = 'yes' WHERE id = 1"); err == nil {
+		t.Fatal("expected type mismatch for TEXT in BOOL column, got nil")
+	}
+	if _, _, err := run(t, eng, "SELECT * FROM products WHERE price > 'cheap'"); err == nil {
+		t.Fatal("expected error comparing FLOAT with TEXT, got nil")
+	}
+
+	_, rows, err := run(t, eng, "SELECT name FROM products WHERE price >= 2 AND active = TRUE")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("select by typed filters = %v, %v", rows, err)
+	}
+
+	// VALUES and SET items are expressions, SET ones evaluated against each row
+	mustRun(t, eng, "INSERT INTO products (id, name, price) VALUES (3, '4', (SELECT MAX(price) FROM products) * 1.5)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price) VALUES (4, 'mug', 5)")
+	mustRun(t, eng, "UPDATE products SET price = price * 2 WHERE id > 2")
+	if got := table(t, eng, "SELECT id, price FROM products WHERE id > 2 ORDER BY id"); got != "id=3 price=6; id=4 price=10" {
+		t.Fatalf("SET price = price * 2 = %q", got)
+	}
+	mustRun(t, eng, "UPDATE products SET price = CAST(name AS FLOAT) WHERE id = 3")
+	if got := table(t, eng, "SELECT price FROM products WHERE id = 3"); got != "price=4" {
+		t.Fatalf("SET price = CAST(name AS FLOAT) = %q", got)
+	}
+	if _, _, err := run(t, eng, "UPDATE products SET price = CAST(name AS FLOAT) WHERE id = 4"); err == nil {
+		t.Fatal("expected CAST of 'mug' to fail when the row is updated, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE products SET price = cost WHERE id = 4"); err == nil || !strings.Contains(err.Error(), "'cost' does not exist") {
+		t.Fatalf("SET price = cost = %v", err)
+	}
+
+	if err := eng.Insert("products", map[string]any{"id": 2, "price": "free"}); err == nil {
+		t.Fatal("expected type mismatch from Engine.Insert, got nil")
+	}
+	row, err := eng.GetByPK("products", 1)
+	if err != nil || row.Data["price"] != float64(2) {
+		t.Fatalf("INT literal not widened to FLOAT: %v, %v", row, err)
+	}
+}
+
+func TestExecutePlanNulls(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	for _, sql := range []string{
+		"CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE, age INT)",
+		"INSERT INTO users (id, email, age) VALUES (1, NULL, 30)",
+		"INSERT INTO users (id, email) VALUES (2, NULL)",
+		"INSERT INTO users (id, email, age) VALUES (3, 'c@test.com', 40)",
+	} {
+		if _, _, err := run(t, eng, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	count := func(sql string) int {
+		_, rows, err := run(t, eng, sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return len(rows)
+	}
+
+	if n := count("SELECT * FROM users WHERE email IS NULL"); n != 2 {
+		t.Fatalf("IS NULL matched %d rows, want 2", n)
+	}
+	if n := count("SELECT * FROM users WHERE age IS NOT NULL"); n != 2 {
+		t.Fatalf("IS NOT NULL matched %d rows, want 2", n)
+	}
+	// Comparisons with NULL are unknown, never true
+	if n := count("SELECT * FROM users WHERE email = NULL"); n != 0 {
+		t.Fatalf("= NULL matched %d rows, want 0", n)
+	}
+	if n := count("SELECT * FROM users WHERE age != 30"); n != 1 {
+		t.Fatalf("!= skipped NULLs incorrectly: matched %d rows, want 1", n)
+	}
+	if n := count("SELECT * FROM users WHERE age < 100"); n != 2 {
+		t.Fatalf("< matched %d rows, want 2", n)
+	}
+}
+
+func TestExecutePlanDefaultAndCheck(t *testing.T) {
+	dir := t.TempDir()
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	if _, _, err := run(t, eng, `CREATE TABLE orders (id INT PRIMARY KEY, qty INT DEFAULT 1 CHECK (qty > 0),
+		placed DATE DEFAULT CURRENT_DATE, lo INT, hi INT, CHECK (lo <= hi))`); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	if _, _, err := run(t, eng, "INSERT INTO orders (id) VALUES (1)"); err != nil {
+		t.Fatalf("insert with defaults failed: %v", err)
+	}
+	row, _ := eng.GetByPK("orders", 1)
+	if row.Data["qty"] != int64(1) || row.Data["placed"] == nil {
+		t.Fatalf("defaults not applied: %v", row.Data)
+	}
+
+	if _, _, err := run(t, eng, "INSERT INTO orders (id, qty) VALUES (2, 0)"); err == nil {
+		t.Fatal("expected column CHECK violation, got nil")
+	}
+	if _, _, err := run(t, eng, "INSERT INTO orders (id, lo, hi) VALUES (2, 5, 1)"); err == nil {
+		t.Fatal("expected table CHECK violation, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE orders SET qty = -1 WHERE id = 1"); err == nil {
+		t.Fatal("expected CHECK violation on update, got nil")
+	}
+	if err := eng.Update("orders", 1, map[string]any{"lo": 3, "hi": 2}); err == nil {
+		t.Fatal("expected CHECK violation from Engine.Update, got nil")
+	}
+	// An unknown result passes a CHECK
+	if _, _, err := run(t, eng, "INSERT INTO orders (id, qty, lo) VALUES (3, NULL, 9)"); err != nil {
+		t.Fatalf("NULL should satisfy CHECK: %v", err)
+	}
+
+	if _, _, err := run(t, eng, "CREATE TABLE bad (a INT DEFAULT 'x')"); err == nil {
+		t.Fatal("expected error for DEFAULT of the wrong type, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE TABLE bad (a INT, b INT DEFAULT a)"); err == nil {
+		t.Fatal("expected error for DEFAULT referring to a column, got nil")
+	}
+
+	// Existing rows take the DEFAULT of an added column
+	if _, _, err := run(t, eng, "ALTER TABLE orders ADD COLUMN status TEXT DEFAULT 'new' NOT NULL"); err != nil {
+		t.Fatalf("add column with default failed: %v", err)
+	}
+	if row, _ := eng.GetByPK("orders", 3); row.Data["status"] != "new" {
+		t.Fatalf("existing row did not get the default: %v", row.Data)
+	}
+	if _, _, err := run(t, eng, "ALTER TABLE orders ADD COLUMN code INT UNIQUE DEFAULT 7"); err == nil {
+		t.Fatal("expected error for a UNIQUE default repeated across rows, got nil")
+	}
+	if _, _, err := run(t, eng, "ALTER TABLE orders ADD COLUMN code INT UNIQUE"); err != nil {
+		t.Fatalf("add UNIQUE column without default failed: %v", err)
+	}
+
+	_, rows, err := run(t, eng, "DESCRIBE orders")
+	if err != nil || rows[1].Data["definition"] != "INT DEFAULT 1 CHECK (qty > 0)" {
+		t.Fatalf("DESCRIBE shows %v, %v", rows, err)
+	}
+
+	// Constraints survive a restart from the log and from a snapshot
+	for _, checkpoint := range []bool{false, true} {
+		if checkpoint {
+			if err := eng.Checkpoint(); err != nil {
+				t.Fatalf("checkpoint failed: %v", err)
+			}
+		}
+		eng.Close()
+
+		eng = NewEngine(storage.NewDatabase())
+		if err := eng.Open(dir); err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+		if err := eng.Insert("orders", map[string]any{"id": 10, "qty": -5}); err == nil {
+			t.Fatal("CHECK was lost on recovery")
+		}
+		if row, _ := eng.GetByPK("orders", 3); row == nil || row.Data["status"] != "new" {
+			t.Fatalf("added column values were lost on recovery: %v", row)
+		}
+	}
+	eng.Close()
+}
+
+func TestExecutePlanForeignKeys(t *testing.T) {
+	dir := t.TempDir()
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	count := func(table string) int {
+		_, rows, err := run(t, eng, "SELECT * FROM "+table)
+		if err != nil {
+			t.Fatalf("select from %s failed: %v", table, err)
+		}
+		return len(rows)
+	}
+
+	mustRun(t, eng, "CREATE TABLE customers (id INT PRIMARY KEY, name TEXT)")
+	mustRun(t, eng, `CREATE TABLE orders (id INT PRIMARY KEY,
+		customer INT REFERENCES customers ON DELETE CASCADE ON UPDATE CASCADE)`)
+	mustRun(t, eng, `CREATE TABLE notes (id INT PRIMARY KEY, customer INT DEFAULT 0 REFERENCES customers (id)
+		ON DELETE SET DEFAULT ON UPDATE CASCADE)`)
+	mustRun(t, eng, "CREATE TABLE tags (id INT PRIMARY KEY, customer INT REFERENCES customers ON DELETE SET NULL ON UPDATE CASCADE)")
+	mustRun(t, eng, "CREATE TABLE invoices (id INT PRIMARY KEY, customer INT, FOREIGN KEY (customer) REFERENCES customers ON DELETE RESTRICT)")
+
+	if _, _, err := run(t, eng, "CREATE TABLE bad (id INT, customer TEXT REFERENCES customers)"); err == nil {
+		t.Fatal("expected error for mismatched column types, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE TABLE bad (id INT, customer INT REFERENCES customers (name))"); err == nil {
+		t.Fatal("expected error for referencing a non-unique column, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE TABLE bad (id INT, customer INT REFERENCES missing)"); err == nil {
+		t.Fatal("expected error for referencing a missing table, got nil")
+	}
+	if _, _, err := run(t, eng, "DESCRIBE bad"); err == nil {
+		t.Fatal("failed CREATE TABLE left the table behind")
+	}
+
+	mustRun(t, eng, "INSERT INTO customers (id, name) VALUES (0, 'nobody')")
+	mustRun(t, eng, "INSERT INTO customers (id, name) VALUES (1, 'Alice')")
+	mustRun(t, eng, "INSERT INTO customers (id, name) VALUES (2, 'Bob')")
+	mustRun(t, eng, "INSERT INTO orders (id, customer) VALUES (10, 1)")
+	mustRun(t, eng, "INSERT INTO orders (id, customer) VALUES (11, 2)")
+	mustRun(t, eng, "INSERT INTO orders (id, customer) VALUES (12, NULL)")
+	mustRun(t, eng, "INSERT INTO notes (id, customer) VALUES (20, 1)")
+	mustRun(t, eng, "INSERT INTO tags (id, customer) VALUES (30, 1)")
+
+	if _, _, err := run(t, eng, "INSERT INTO orders (id, customer) VALUES (13, 99)"); err == nil {
+		t.Fatal("expected error for a missing parent row, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE orders SET customer = 99 WHERE id = 10"); err == nil {
+		t.Fatal("expected error for updating to a missing parent row, got nil")
+	}
+
+	// ON UPDATE CASCADE follows the key
+	mustRun(t, eng, "UPDATE customers SET id = 5 WHERE id = 1")
+	if row, _ := eng.GetByPK("orders", 10); row.Data["customer"] != int64(5) {
+		t.Fatalf("ON UPDATE CASCADE did not update the order: %v", row.Data)
+	}
+	if _, _, err := run(t, eng, "UPDATE customers SET id = 7 WHERE id = 0"); err != nil {
+		t.Fatalf("updating an unreferenced key failed: %v", err)
+	}
+	mustRun(t, eng, "UPDATE customers SET id = 0 WHERE id = 7")
+
+	mustRun(t, eng, "INSERT INTO invoices (id, customer) VALUES (40, 2)")
+	if _, _, err := run(t, eng, "DELETE FROM customers WHERE id = 2"); err == nil {
+		t.Fatal("expected ON DELETE RESTRICT to block the delete, got nil")
+	}
+	if count("orders") != 3 {
+		t.Fatal("failed delete changed the orders table")
+	}
+	mustRun(t, eng, "DELETE FROM invoices WHERE id = 40")
+
+	// ON DELETE CASCADE removes orders, SET NULL and SET DEFAULT rewrite
+	// the other children
+	mustRun(t, eng, "DELETE FROM customers WHERE id = 2")
+	if count("orders") != 2 {
+		t.Fatal("ON DELETE CASCADE did not delete the order")
+	}
+
+	eng.Close()
+	eng = NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	mustRun(t, eng, "DELETE FROM customers WHERE id = 5")
+	if count("orders") != 1 {
+		t.Fatal("ON DELETE CASCADE was lost on recovery")
+	}
+	if row, _ := eng.GetByPK("notes", 20); row.Data["customer"] != int64(0) {
+		t.Fatalf("ON DELETE SET DEFAULT not applied: %v", row.Data)
+	}
+	if row, _ := eng.GetByPK("tags", 30); row.Data["customer"] != nil {
+		t.Fatalf("ON DELETE SET NULL not applied: %v", row.Data)
+	}
+
+	// SET DEFAULT fails when the default itself is the deleted key
+	if _, _, err := run(t, eng, "DELETE FROM customers WHERE id = 0"); err == nil {
+		t.Fatal("expected deleting the default's parent row to fail, got nil")
+	}
+
+	if err := eng.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	eng.Close()
+	eng = NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if _, _, err := run(t, eng, "INSERT INTO orders (id, customer) VALUES (14, 99)"); err == nil {
+		t.Fatal("foreign key was lost on recovery from a snapshot")
+	}
+	eng.Close()
+}
+
+func TestExecutePlanIdentityAndSequences(t *testing.T) {
+	dir := t.TempDir()
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	insert := func(sql string) *storage.Row {
+		_, rows, err := run(t, eng, sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return rows[0]
+	}
+
+	if _, _, err := run(t, eng, "CREATE TABLE users (id INT PRIMARY KEY AUTOINCREMENT, name TEXT)"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, _, err := run(t, eng, `CREATE TABLE events (id INT GENERATED ALWAYS AS IDENTITY (START WITH 100 INCREMENT BY 10),
+		name TEXT)`); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	// Generated keys are returned with the inserted row
+	if row := insert("INSERT INTO users (name) VALUES ('Alice')"); row.Data["id"] != int64(1) {
+		t.Fatalf("first generated id = %v, want 1", row.Data["id"])
+	}
+	// An explicit key moves the counter past it
+	insert("INSERT INTO users (id, name) VALUES (10, 'Bob')")
+	if row := insert("INSERT INTO users (name) VALUES ('Carol')"); row.Data["id"] != int64(11) {
+		t.Fatalf("id after explicit 10 = %v, want 11", row.Data["id"])
+	}
+
+	if row := insert("INSERT INTO events (name) VALUES ('a')"); row.Data["id"] != int64(100) {
+		t.Fatalf("identity START WITH gave %v, want 100", row.Data["id"])
+	}
+	if _, _, err := run(t, eng, "INSERT INTO events (id, name) VALUES (5, 'b')"); err == nil {
+		t.Fatal("expected error inserting into a GENERATED ALWAYS column, got nil")
+	}
+	if _, _, err := run(t, eng, "UPDATE events SET id = 5"); err == nil {
+		t.Fatal("expected error updating a GENERATED ALWAYS column, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE TABLE bad (id TEXT AUTOINCREMENT)"); err == nil {
+		t.Fatal("expected error for a TEXT identity column, got nil")
+	}
+
+	if _, _, err := run(t, eng, "CREATE SEQUENCE order_numbers START WITH 1000 INCREMENT BY 5"); err != nil {
+		t.Fatalf("create sequence failed: %v", err)
+	}
+	if _, _, err := run(t, eng, "CREATE SEQUENCE order_numbers"); err == nil {
+		t.Fatal("expected error creating a sequence twice, got nil")
+	}
+	if _, err := eng.CurrVal("order_numbers"); err == nil {
+		t.Fatal("expected currval before nextval to fail, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE TABLE orders (num INT PRIMARY KEY, copy INT)"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	row := insert("INSERT INTO orders (num, copy) VALUES (nextval('order_numbers'), currval('order_numbers'))")
+	if row.Data["num"] != int64(1000) {
+		t.Fatalf("nextval gave %v, want 1000", row.Data["num"])
+	}
+	if _, rows, err := run(t, eng, "SELECT * FROM orders WHERE num = currval('order_numbers')"); err != nil || len(rows) != 1 {
+		t.Fatalf("currval in WHERE matched %v, %v", rows, err)
+	}
+	if v, err := eng.NextVal("order_numbers"); err != nil || v != 1005 {
+		t.Fatalf("NextVal = %v, %v; want 1005", v, err)
+	}
+
+	// Counters survive a restart from the log and from a snapshot
+	for _, checkpoint := range []bool{false, true} {
+		if checkpoint {
+			if err := eng.Checkpoint(); err != nil {
+				t.Fatalf("checkpoint failed: %v", err)
+			}
+		}
+		eng.Close()
+
+		eng = NewEngine(storage.NewDatabase())
+		if err := eng.Open(dir); err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+
+		row, err := eng.InsertRow("users", map[string]any{"name": "Dave"})
+		if err != nil {
+			t.Fatalf("insert after restart failed: %v", err)
+		}
+		want := int64(12)
+		if checkpoint {
+			want = 13
+		}
+		if row.Data["id"] != want {
+			t.Fatalf("id after restart = %v, want %d", row.Data["id"], want)
+		}
+		if v, err := eng.CurrVal("order_numbers"); err != nil || v != 1005 {
+			t.Fatalf("currval after restart = %v, %v; want 1005", v, err)
+		}
+	}
+
+	// In a select list nextval advances once for each row returned
+	got := table(t, eng, "SELECT id, nextval('order_numbers') AS n FROM users ORDER BY id DESC LIMIT 2")
+	if want := "id=13 n=1010; id=12 n=1015"; got != want {
+		t.Fatalf("nextval in select list gave %q, want %q", got, want)
+	}
+	if got := table(t, eng, "SELECT currval('order_numbers') AS n FROM orders"); got != "n=1015" {
+		t.Fatalf("currval in select list gave %q, want n=1015", got)
+	}
+	// Without FROM it advances once
+	if got := table(t, eng, "SELECT nextval('order_numbers') AS n, currval('order_numbers') AS c"); got != "n=1020 c=1020" {
+		t.Fatalf("nextval without FROM gave %q, want n=1020 c=1020", got)
+	}
+	eng.Close()
+}
+
+func TestExecutePlanIndexes(t *testing.T) {
+	dir := t.TempDir()
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	mustRun(t, eng, "CREATE TABLE people (id INT PRIMARY KEY, age INT, email TEXT)")
+	for i, age := range []string{"30", "25", "NULL", "40", "25"} {
+		mustRun(t, eng, fmt.Sprintf("INSERT INTO people (id, age, email) VALUES (%d, %s, 'p%d@x')", i+1, age, i+1))
+	}
+
+	// Enough other rows for an index to be cheaper than a scan
+	for i := 100; i < 200; i++ {
+		mustRun(t, eng, fmt.Sprintf("INSERT INTO people (id, age, email) VALUES (%d, %d, 'f%d@x')", i, i, i))
+	}
+
+	mustRun(t, eng, "CREATE INDEX people_age ON people (age)")
+	if _, _, err := run(t, eng, "CREATE INDEX people_age ON people (email)"); err == nil {
+		t.Fatal("expected error creating an index twice, got nil")
+	}
+	if _, _, err := run(t, eng, "CREATE INDEX bad ON people (missing)"); err == nil {
+		t.Fatal("expected error indexing a missing column, got nil")
+	}
+
+	// Indexed queries return the same rows, in the same order, as a scan
+	for _, tc := range []struct {
+		where string
+		want  string
+	}{
+		{"age = 25", "2,5"},
+		{"age > 25 AND age < 50", "1,4"},
+		{"age >= 25 AND age < 40", "1,2,5"},
+		{"age <= 30.5", "1,2,5"},
+		{"age = 25 AND id = 5", "5"},
+	} {
+		query, _ := parser.Parse("SELECT * FROM people WHERE " + tc.where)
+		plan, _ := eng.Plan(query)
+		rows, err := eng.ExecutePlan(plan)
+		if err != nil {
+			t.Fatalf("WHERE %s: %v", tc.where, err)
+		}
+		if plan.Access == nil || plan.Access.Type == planner.FullScan {
+			t.Errorf("WHERE %s did not use an index: %+v", tc.where, plan.Access)
+		}
+		if got := ids(rows); got != tc.want {
+			t.Errorf("WHERE %s matched %s, want %s", tc.where, got, tc.want)
+		}
+	}
+
+	// The index follows writes
+	mustRun(t, eng, "UPDATE people SET age = 26 WHERE id = 2")
+	mustRun(t, eng, "DELETE FROM people WHERE age = 30")
+	mustRun(t, eng, "INSERT INTO people (id, age, email) VALUES (6, 25, 'p6@x')")
+	// Row 6 reuses the ID row 1 freed, so it comes first
+	if got := ids(mustRun(t, eng, "SELECT * FROM people WHERE age < 30")); got != "6,2,5" {
+		t.Fatalf("after writes age < 30 matched %s, want 6,2,5", got)
+	}
+
+	if _, _, err := run(t, eng, "CREATE UNIQUE INDEX people_age_u ON people (age)"); err == nil {
+		t.Fatal("expected error creating a unique index over duplicates, got nil")
+	}
+	mustRun(t, eng, "CREATE UNIQUE INDEX people_email ON people (email)")
+	if _, _, err := run(t, eng, "INSERT INTO people (id, email) VALUES (7, 'p4@x')"); err == nil {
+		t.Fatal("expected unique index violation, got nil")
+	}
+
+	// Indexes survive a restart from the log and from a snapshot
+	for _, checkpoint := range []bool{false, true} {
+		if checkpoint {
+			if err := eng.Checkpoint(); err != nil {
+				t.Fatalf("checkpoint failed: %v", err)
+			}
+		}
+		eng.Close()
+
+		eng = NewEngine(storage.NewDatabase())
+		if err := eng.Open(dir); err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+		if n := len(eng.db.Tables["people"].Indexes); n != 2 {
+			t.Fatalf("%d indexes after restart, want 2", n)
+		}
+		if got := ids(mustRun(t, eng, "SELECT * FROM people WHERE age = 25")); got != "6,5" {
+			t.Fatalf("age = 25 after restart matched %s, want 6,5", got)
+		}
+		if _, _, err := run(t, eng, "INSERT INTO people (id, email) VALUES (8, 'p4@x')"); err == nil {
+			t.Fatal("unique index not enforced after restart")
+		}
+	}
+
+	mustRun(t, eng, "DROP INDEX people_email")
+	if _, _, err := run(t, eng, "DROP INDEX people_email"); err == nil {
+		t.Fatal("expected error dropping a missing index, got nil")
+	}
+	mustRun(t, eng, "INSERT INTO people (id, email) VALUES (8, 'p4@x')")
+	eng.Close()
+}
+
+func TestExplain(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+	explain := func(sql string) string {
+		_, rows, err := run(t, eng, sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		var lines []string
+		for _, row := range rows {
+			lines = append(lines, row.Data[planner.QueryPlanColumn].(string))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if _, _, err := run(t, eng, "CREATE TABLE people (id INT PRIMARY KEY, age INT)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 200; i++ {
+		if _, _, err := run(t, eng, fmt.Sprintf("INSERT INTO people (id, age) VALUES (%d, %d)", i, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := run(t, eng, "CREATE INDEX people_age ON people (age)"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := explain("EXPLAIN SELECT id FROM people WHERE age < 10 AND id != 3")
+	for _, want := range []string{
+		"Project  (rows=",
+		"  Columns: id",
+		"  ->  Index Scan using people_age on people  (rows=",
+		"        Index Cond: age < 10",
+		"        Filter: id != 3",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("EXPLAIN output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "actual") {
+		t.Errorf("EXPLAIN reported actual figures:\n%s", out)
+	}
+
+	out = explain("EXPLAIN SELECT * FROM people WHERE id = 7")
+	if !strings.Contains(out, "Primary Key Lookup on people") || !strings.Contains(out, "Key: id = 7") {
+		t.Errorf("expected a primary key lookup:\n%s", out)
+	}
+
+	// EXPLAIN alone leaves the table untouched; EXPLAIN ANALYZE runs the
+	// statement and counts the rows each operator produced
+	explain("EXPLAIN DELETE FROM people WHERE age <= 5")
+	if n := eng.db.Tables["people"].Len(); n != 200 {
+		t.Fatalf("EXPLAIN DELETE changed the table: %d rows", n)
+	}
+	out = explain("EXPLAIN ANALYZE DELETE FROM people WHERE age <= 5")
+	if n := eng.db.Tables["people"].Len(); n != 195 {
+		t.Fatalf("EXPLAIN ANALYZE DELETE left %d rows, want 195", n)
+	}
+	for _, want := range []string{"Delete on people", "(actual rows=5 time=", "->  Index Scan using people_age"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("EXPLAIN ANALYZE output lacks %q:\n%s", want, out)
+		}
+	}
+
+	query, _ := parser.Parse("UPDATE people SET age = 0 WHERE id = 10")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if node.Operator != "Update on people" || !node.Analyzed || node.ActualRows != 1 || len(node.Children) != 1 ||
+		node.Children[0].Operator != "Primary Key Lookup on people" {
+		t.Fatalf("unexpected plan: %+v", node)
+	}
+
+	if _, _, err := run(t, eng, "EXPLAIN SELECT missing FROM people"); err == nil {
+		t.Fatal("expected error explaining a missing column, got nil")
+	}
+
+	// An index in the order of ORDER BY is read in that order instead of
+	// sorting a scan
+	mustRun(t, eng, "CREATE TABLE t (id INT PRIMARY KEY, a INT, b INT)")
+	for i := 1; i <= 50; i++ {
+		mustRun(t, eng, fmt.Sprintf("INSERT INTO t (id, a, b) VALUES (%d, %d, %d)", i, i*17%50, i%5))
+	}
+	mustRun(t, eng, "INSERT INTO t (id, b) VALUES (51, 1)")
+	mustRun(t, eng, "CREATE INDEX ta ON t (a)")
+	mustRun(t, eng, "CREATE INDEX tba ON t (b, a)")
+	for _, tc := range []struct {
+		sql   string
+		index string
+	}{
+		{"SELECT id FROM t ORDER BY a", "Index Scan using ta on t"},
+		{"SELECT id FROM t WHERE a > 3 ORDER BY a", "Index Scan using ta on t"},
+		{"SELECT id FROM t WHERE b = 1 ORDER BY b, a LIMIT 3", "Index Scan using tba on t"},
+	} {
+		out := explain("EXPLAIN " + tc.sql)
+		if !strings.Contains(out, tc.index) || strings.Contains(out, "Sort") {
+			t.Errorf("%s was sorted instead of read in index order:\n%s", tc.sql, out)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT id FROM t ORDER BY a DESC",
+		"SELECT id FROM t ORDER BY a, b",
+		"SELECT id FROM t WHERE b IN (1, 2) ORDER BY a",
+	} {
+		if out := explain("EXPLAIN " + sql); !strings.Contains(out, "Sort") {
+			t.Errorf("%s was not sorted:\n%s", sql, out)
+		}
+	}
+
+	var prev any
+	rows := mustRun(t, eng, "SELECT id, a FROM t WHERE a > 3 OR a IS NULL ORDER BY a")
+	for i, row := range rows {
+		a := row.Data["a"]
+		if i > 0 && (prev == nil || a != nil && a.(int64) <= prev.(int64)) {
+			t.Fatalf("ORDER BY a through the index returned %v after %v", a, prev)
+		}
+		prev = a
+	}
+	if len(rows) != 47 || prev != nil {
+		t.Fatalf("ORDER BY a through the index returned %d rows ending in %v, want 47 ending in NULL", len(rows), prev)
+	}
+	if got := table(t, eng, "SELECT id, a FROM t WHERE b = 1 ORDER BY a LIMIT 3"); got != "id=6 a=2; id=21 a=7; id=36 a=12" {
+		t.Fatalf("WHERE b = 1 ORDER BY a LIMIT 3 = %q", got)
+	}
+}
+
+func TestExecutePlanWhere(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE items (id INT PRIMARY KEY, name TEXT, price INT, qty INT, budget INT)")
+	mustRun(t, eng, "INSERT INTO items (id, name, price, qty, budget) VALUES (1, 'ANDROID', 10, 2, 30)")
+	mustRun(t, eng, "INSERT INTO items (id, name, price, qty, budget) VALUES (2, 'phone', 5, 10, 40)")
+	mustRun(t, eng, "INSERT INTO items (id, name, price, qty, budget) VALUES (3, 'tablet', 20, 1, 20)")
+	mustRun(t, eng, "INSERT INTO items (id, name, price, qty) VALUES (4, 'watch', 8, 3)")
+
+	for _, tc := range []struct {
+		where string
+		want  string
+	}{
+		{"name = 'ANDROID'", "1"},
+		{"id = 1 OR id = 3", "1,3"},
+		{"NOT (id = 1 OR id = 3)", "2,4"},
+		{"(id < 3 OR name = 'watch') AND qty > 2", "2,4"},
+		{"price * qty > budget", "2"},
+		{"price * qty <= budget", "1,3"},
+		{"price = budget - 0", "3"},
+		{"qty = price / 10 + 1", "1"},
+		{"NOT price * qty > budget", "1,3"},
+		{"budget IS NULL OR price > 10", "3,4"},
+		{"id = '1'", "1"},
+		{"'3' <= id", "3,4"},
+	} {
+		if got := ids(mustRun(t, eng, "SELECT * FROM items WHERE "+tc.where)); got != tc.want {
+			t.Errorf("WHERE %s matched %s, want %s", tc.where, got, tc.want)
+		}
+	}
+
+	// UPDATE and DELETE use the same conditions
+	mustRun(t, eng, "UPDATE items SET qty = 0 WHERE price >= 10 OR budget IS NULL")
+	if got := ids(mustRun(t, eng, "SELECT * FROM items WHERE qty = 0")); got != "1,3,4" {
+		t.Errorf("UPDATE changed %s, want 1,3,4", got)
+	}
+	mustRun(t, eng, "DELETE FROM items WHERE NOT (qty = 0 AND id > 1)")
+	if got := ids(mustRun(t, eng, "SELECT * FROM items")); got != "3,4" {
+		t.Errorf("after DELETE %s remain, want 3,4", got)
+	}
+
+	// String literals are read as dates, as they are when assigned
+	mustRun(t, eng, "CREATE TABLE events (id INT PRIMARY KEY, day DATE)")
+	mustRun(t, eng, "INSERT INTO events (id, day) VALUES (1, '2024-01-01')")
+	mustRun(t, eng, "INSERT INTO events (id, day) VALUES (2, '2024-03-15')")
+	for where, want := range map[string]string{
+		"day = '2024-01-01'":                        "1",
+		"day > '2024-01-01'":                        "2",
+		"day BETWEEN '2024-02-01' AND '2024-12-31'": "2",
+		"day IN ('2024-03-15', '2025-01-01')":       "2",
+	} {
+		if got := ids(mustRun(t, eng, "SELECT * FROM events WHERE "+where)); got != want {
+			t.Errorf("WHERE %s matched %s, want %s", where, got, want)
+		}
+	}
+
+	for _, where := range []string{"missing = 1", "name > 3", "price", "price = 'ten'"} {
+		if _, _, err := run(t, eng, "SELECT * FROM items WHERE "+where); err == nil {
+			t.Errorf("WHERE %s succeeded, want error", where)
+		}
+	}
+}
+
+func TestExecutePlanPredicates(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE items (id INT PRIMARY KEY, name TEXT, cat INT, price INT)")
+	names := []string{"apple", "apricot", "banana", "Avocado", "cherry", "a_b", "50%"}
+	for i := 1; i <= 300; i++ {
+		name := fmt.Sprintf("'%s%d'", names[i%len(names)], i)
+		if i%50 == 0 {
+			name = "NULL"
+		}
+		mustRun(t, eng, fmt.Sprintf("INSERT INTO items (id, name, cat, price) VALUES (%d, %s, %d, %d)", i, name, i%30, i%7))
+	}
+
+	conds := []string{
+		"id IN (3, 7, 7, 500)",
+		"id NOT IN (3, 7) AND id < 10",
+		"id IN (1, NULL)",
+		"id NOT IN (1, NULL)",
+		"cat IN (4, 9) AND price IN (2, 3)",
+		"cat BETWEEN 3 AND 5 AND price = 1",
+		"cat NOT BETWEEN 1 AND 28",
+		"name LIKE 'ap%'",
+		"name LIKE 'apple1_'",
+		"name ILIKE 'av%1'",
+		"name NOT LIKE '%a%'",
+		"name LIKE 'a\\_%'",
+		"name LIKE '50\\%2%'",
+		"name REGEXP '^ch.*7$'",
+		"name !~ '[aeiou]' OR name IS NULL",
+		"name LIKE 'banana' || '1%' AND cat = 1",
+	}
+
+	scanned := make([]string, len(conds))
+	for i, cond := range conds {
+		scanned[i] = ids(mustRun(t, eng, "SELECT * FROM items WHERE "+cond))
+	}
+	if scanned[0] != "3,7" || scanned[2] != "1" || scanned[3] != "" {
+		t.Fatalf("unexpected IN results %q", scanned[:4])
+	}
+
+	// Indexes must not change what matches
+	mustRun(t, eng, "CREATE INDEX items_name ON items (name)")
+	mustRun(t, eng, "CREATE INDEX items_cat_price ON items (cat, price)")
+	for i, cond := range conds {
+		if got := ids(mustRun(t, eng, "SELECT * FROM items WHERE "+cond)); got != scanned[i] {
+			t.Errorf("WHERE %s matched %s with indexes, %s without", cond, got, scanned[i])
+		}
+	}
+
+	for cond, want := range map[string]string{
+		"id IN (3, 7)":                      "Primary Key Lookup on items",
+		"name LIKE 'cherry1%'":              "Index Scan using items_name on items",
+		"cat IN (4, 9) AND price IN (2, 3)": "Index Scan using items_cat_price on items",
+		"cat BETWEEN 3 AND 4":               "Index Scan using items_cat_price on items",
+	} {
+		query, _ := parser.Parse("SELECT * FROM items WHERE " + cond)
+		plan, _ := eng.Plan(query)
+		node, err := eng.Explain(plan, false)
+		if err != nil {
+			t.Fatalf("explain %s: %v", cond, err)
+		}
+		if got := node.Children[0].Operator; got != want {
+			t.Errorf("WHERE %s ran as %s, want %s", cond, got, want)
+		}
+	}
+
+	for _, cond := range []string{"cat LIKE '1%'", "name ~ '('", "name IN (1, 2)"} {
+		if _, _, err := run(t, eng, "SELECT * FROM items WHERE "+cond); err == nil {
+			t.Errorf("WHERE %s succeeded, want error", cond)
+		}
+	}
+}
+
+func TestExecutePlanOrderByLimit(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE people (id INT PRIMARY KEY, name TEXT, age INT, score FLOAT, active BOOL, born DATE)")
+	mustRun(t, eng, "INSERT INTO people (id, name, age, score, active, born) VALUES (1, 'cara', 30, 2.5, TRUE, CAST('1994-05-01' AS DATE))")
+	mustRun(t, eng, "INSERT INTO people (id, name, age, score, active, born) VALUES (2, 'abe', 25, 9.75, FALSE, CAST('1999-01-20' AS DATE))")
+	mustRun(t, eng, "INSERT INTO people (id, name, age, score, active) VALUES (3, 'Bob', 30, -1, TRUE)")
+	mustRun(t, eng, "INSERT INTO people (id, name, score, born) VALUES (4, 'dee', 2.5, CAST('1980-12-31' AS DATE))")
+	mustRun(t, eng, "INSERT INTO people (id, name, age, active, born) VALUES (5, 'eve', 41, FALSE, CAST('1994-05-01' AS DATE))")
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"ORDER BY name", "3,2,1,4,5"},
+		{"ORDER BY age", "2,1,3,5,4"},
+		{"ORDER BY age DESC", "4,5,1,3,2"},
+		{"ORDER BY age NULLS FIRST", "4,2,1,3,5"},
+		{"ORDER BY age DESC NULLS LAST", "5,1,3,2,4"},
+		{"ORDER BY score DESC, id DESC", "5,2,4,1,3"},
+		{"ORDER BY active, id", "2,5,1,3,4"},
+		{"ORDER BY born, age DESC", "4,5,1,2,3"},
+		{"ORDER BY age * -1, name", "5,3,1,2,4"},
+		{"ORDER BY 4 DESC, 1", "5,2,1,4,3"},
+		{"WHERE age >= 30 ORDER BY age DESC, name", "5,3,1"},
+		{"ORDER BY age LIMIT 2", "2,1"},
+		{"ORDER BY age LIMIT 2 OFFSET 2", "3,5"},
+		{"ORDER BY age OFFSET 3", "5,4"},
+		{"ORDER BY age LIMIT 0", ""},
+		{"ORDER BY age LIMIT 3 OFFSET 10", ""},
+		{"LIMIT 2 OFFSET 1", "2,3"},
+	} {
+		if got := ids(mustRun(t, eng, "SELECT * FROM people "+tc.query)); got != tc.want {
+			t.Errorf("%s returned %s, want %s", tc.query, got, tc.want)
+		}
+	}
+
+	// ORDER BY may use a column that is not selected
+	rows := mustRun(t, eng, "SELECT name FROM people ORDER BY id DESC LIMIT 1")
+	if len(rows) != 1 || rows[0].Data["name"] != "eve" || len(rows[0].Data) != 1 {
+		t.Errorf("unexpected rows %v", rows)
+	}
+
+	// An integer constant is the position of a result column
+	var names []string
+	for _, row := range mustRun(t, eng, "SELECT name FROM people ORDER BY 1 DESC") {
+		names = append(names, row.Data["name"].(string))
+	}
+	if got := strings.Join(names, ","); got != "eve,dee,cara,abe,Bob" {
+		t.Errorf("ORDER BY 1 DESC returned %s", got)
+	}
+
+	// The top-K sort returns the same rows as sorting everything, ties
+	// included
+	mustRun(t, eng, "CREATE TABLE nums (id INT PRIMARY KEY, v INT)")
+	for i := 1; i <= 200; i++ {
+		v := fmt.Sprint((i * 37) % 23)
+		if i%9 == 0 {
+			v = "NULL"
+		}
+		mustRun(t, eng, fmt.Sprintf("INSERT INTO nums (id, v) VALUES (%d, %s)", i, v))
+	}
+	all := mustRun(t, eng, "SELECT * FROM nums ORDER BY v DESC")
+	for _, page := range [][2]int{{0, 1}, {0, 10}, {5, 20}, {190, 50}} {
+		sql := fmt.Sprintf("SELECT * FROM nums ORDER BY v DESC LIMIT %d OFFSET %d", page[1], page[0])
+		want := all[page[0]:min(page[0]+page[1], len(all))]
+		if got := ids(mustRun(t, eng, sql)); got != ids(want) {
+			t.Errorf("%s returned %s, want %s", sql, got, ids(want))
+		}
+	}
+
+	query, _ := parser.Parse("SELECT * FROM nums ORDER BY v DESC NULLS LAST LIMIT 5 OFFSET 2")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatalf("explain failed: %v", err)
+	}
+	text := strings.Join(node.Lines(), "\n")
+	for _, want := range []string{"Limit  (rows=5", "Offset: 2", "Top-K Sort", "Sort Key: v DESC NULLS LAST", "Keep: 7", "actual rows=7"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("plan does not contain %q:\n%s", want, text)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM people ORDER BY missing",
+		"SELECT * FROM people ORDER BY name + 1",
+		"SELECT * FROM people ORDER BY COALESCE(age, name) LIMIT 2",
+		"SELECT * FROM people ORDER BY 0",
+		"SELECT * FROM people ORDER BY 7",
+		"SELECT name FROM people ORDER BY 2",
+	} {
+		if _, _, err := run(t, eng, sql); err == nil {
+			t.Errorf("%s succeeded, want error", sql)
+		}
+	}
+}
+
+func TestExecutePlanAggregates(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE staff (id INT PRIMARY KEY, dept TEXT, age INT, salary FLOAT, hired DATE)")
+	mustRun(t, eng, "INSERT INTO staff (id, dept, age, salary, hired) VALUES (1, 'eng', 31, 100, CAST('2020-01-01' AS DATE))")
+	mustRun(t, eng, "INSERT INTO staff (id, dept, age, salary, hired) VALUES (2, 'ops', 45, 80, CAST('2018-06-01' AS DATE))")
+	mustRun(t, eng, "INSERT INTO staff (id, dept, age, salary, hired) VALUES (3, 'eng', 28, 120, CAST('2021-03-15' AS DATE))")
+	mustRun(t, eng, "INSERT INTO staff (id, dept, age, salary) VALUES (4, 'eng', 39, 100)")
+	mustRun(t, eng, "INSERT INTO staff (id, dept, age) VALUES (5, 'ops', 52)")
+	mustRun(t, eng, "INSERT INTO staff (id, age, salary) VALUES (6, 33, 60)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT COUNT(*), COUNT(salary), COUNT(DISTINCT salary) FROM staff",
+			"COUNT(*)=6 COUNT(salary)=5 COUNT(DISTINCT salary)=4"},
+		{"SELECT SUM(age), SUM(salary), AVG(age), MIN(hired), MAX(dept) FROM staff",
+			"SUM(age)=228 SUM(salary)=460 AVG(age)=38 MIN(hired)=2018-06-01 MAX(dept)=ops"},
+		{"SELECT COUNT(*), SUM(salary), AVG(salary), MIN(age) FROM staff WHERE age > 100",
+			"COUNT(*)=0 SUM(salary)=NULL AVG(salary)=NULL MIN(age)=NULL"},
+		{"SELECT dept, COUNT(*), AVG(salary) FROM staff GROUP BY dept",
+			"dept=eng COUNT(*)=3 AVG(salary)=106.66666666666667; dept=ops COUNT(*)=2 AVG(salary)=80; dept=NULL COUNT(*)=1 AVG(salary)=60"},
+		{"SELECT dept, SUM(DISTINCT salary) FROM staff GROUP BY dept HAVING COUNT(*) > 1 ORDER BY dept DESC",
+			"dept=ops SUM(DISTINCT salary)=80; dept=eng SUM(DISTINCT salary)=220"},
+		{"SELECT dept, MAX(age) - MIN(age) FROM staff WHERE dept IS NOT NULL GROUP BY dept ORDER BY MAX(age) - MIN(age)",
+			"dept=ops MAX(age) - MIN(age)=7; dept=eng MAX(age) - MIN(age)=11"},
+		{"SELECT age / 10, COUNT(*) FROM staff GROUP BY age / 10 ORDER BY age / 10",
+			"age / 10=2 COUNT(*)=1; age / 10=3 COUNT(*)=3; age / 10=4 COUNT(*)=1; age / 10=5 COUNT(*)=1"},
+		{"SELECT dept, age > 35, COUNT(*) FROM staff GROUP BY dept, age > 35 HAVING dept = 'eng'",
+			"dept=eng age > 35=false COUNT(*)=2; dept=eng age > 35=true COUNT(*)=1"},
+		{"SELECT dept FROM staff GROUP BY dept ORDER BY COUNT(*) DESC, dept LIMIT 2",
+			"dept=eng; dept=ops"},
+		{"SELECT COUNT(*) FROM staff GROUP BY dept HAVING COUNT(*) > 5", ""},
+		{"SELECT COUNT(*) FROM staff HAVING COUNT(*) > 6", ""},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	query, _ := parser.Parse("SELECT dept, COUNT(*) FROM staff WHERE age > 30 GROUP BY dept HAVING COUNT(*) > 1")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatalf("explain failed: %v", err)
+	}
+	text := strings.Join(node.Lines(), "\n")
+	for _, want := range []string{"Project", "HashAggregate", "Group Key: dept", "Filter: COUNT(*) > 1", "actual rows=2", "Full Scan on staff"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("plan does not contain %q:\n%s", want, text)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT dept, age FROM staff GROUP BY dept",
+		"SELECT dept, COUNT(*) FROM staff",
+		"SELECT COUNT(*) FROM staff HAVING age > 1",
+		"SELECT dept FROM staff GROUP BY dept ORDER BY age",
+		"SELECT SUM(dept) FROM staff",
+		"SELECT AVG(hired) FROM staff",
+		"SELECT COUNT(missing) FROM staff",
+		"SELECT COUNT(*) FROM staff GROUP BY missing",
+	} {
+		if _, _, err := run(t, eng, sql); err == nil {
+			t.Errorf("%s succeeded, want error", sql)
+		}
+	}
+}
+
+func TestExecutePlanJoins(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT, team_id INT)")
+	mustRun(t, eng, "CREATE TABLE teams (team_id INT PRIMARY KEY, name TEXT)")
+	mustRun(t, eng, "CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT)")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (1, 'ann', 10)")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (2, 'bob', 20)")
+	mustRun(t, eng, "INSERT INTO users (id, name) VALUES (3, 'cat')")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (4, 'dan', 30)")
+	mustRun(t, eng, "INSERT INTO teams (team_id, name) VALUES (10, 'red')")
+	mustRun(t, eng, "INSERT INTO teams (team_id, name) VALUES (20, 'blue')")
+	mustRun(t, eng, "INSERT INTO teams (team_id, name) VALUES (40, 'green')")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (100, 1, 5)")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (101, 1, 7)")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (102, 2, 3)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT u.name, t.name FROM users u JOIN teams t ON u.team_id = t.team_id ORDER BY u.id",
+			"u.name=ann t.name=red; u.name=bob t.name=blue"},
+		{"SELECT u.name, t.team_id FROM users u LEFT JOIN teams t ON u.team_id = t.team_id ORDER BY u.id",
+			"name=ann team_id=10; name=bob team_id=20; name=cat team_id=NULL; name=dan team_id=NULL"},
+		{"SELECT u.id, t.name FROM users u RIGHT OUTER JOIN teams t ON u.team_id = t.team_id ORDER BY t.team_id",
+			"id=1 name=red; id=2 name=blue; id=NULL name=green"},
+		{"SELECT u.id, t.team_id FROM users u FULL JOIN teams t ON u.team_id = t.team_id ORDER BY u.id, t.team_id",
+			"id=1 team_id=10; id=2 team_id=20; id=3 team_id=NULL; id=4 team_id=NULL; id=NULL team_id=40"},
+		{"SELECT COUNT(*) FROM users CROSS JOIN teams", "COUNT(*)=12"},
+		{"SELECT users.name FROM users, teams WHERE users.team_id = teams.team_id AND teams.name = 'blue'", "name=bob"},
+		{"SELECT u.id, o.id FROM users u JOIN orders o ON u.id < o.user_id ORDER BY o.id", "u.id=1 o.id=102"},
+		{"SELECT * FROM users JOIN teams USING (team_id) ORDER BY id",
+			"team_id=10 id=1 users.name=ann teams.name=red; team_id=20 id=2 users.name=bob teams.name=blue"},
+		{"SELECT team_id, COUNT(u.id) FROM users u FULL JOIN teams t USING (team_id) GROUP BY team_id ORDER BY team_id",
+			"team_id=10 COUNT(u.id)=1; team_id=20 COUNT(u.id)=1; team_id=30 COUNT(u.id)=1; team_id=40 COUNT(u.id)=0; team_id=NULL COUNT(u.id)=1"},
+		{"SELECT u.name, SUM(o.total) FROM users u JOIN orders o ON o.user_id = u.id GROUP BY u.name ORDER BY u.name",
+			"name=ann SUM(o.total)=12; name=bob SUM(o.total)=3"},
+		{"SELECT u.name, t.name, total FROM users u JOIN teams t ON u.team_id = t.team_id LEFT JOIN orders o ON o.user_id = u.id AND o.total > 4 ORDER BY u.id, total",
+			"u.name=ann t.name=red total=5; u.name=ann t.name=red total=7; u.name=bob t.name=blue total=NULL"},
+		{"SELECT u.id FROM users u LEFT JOIN orders o ON o.user_id = u.id WHERE o.id IS NULL ORDER BY u.id",
+			"id=3; id=4"},
+		{"SELECT p.name FROM users p WHERE p.id = 2", "name=bob"},
+		{"SELECT users.name FROM users WHERE users.team_id IS NULL", "name=cat"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	explain := func(sql string) string {
+		query, _ := parser.Parse(sql)
+		plan, err := eng.Plan(query)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		node, err := eng.Explain(plan, true)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return strings.Join(node.Lines(), "\n")
+	}
+	for sql, wants := range map[string][]string{
+		"SELECT u.name FROM users u JOIN orders o ON u.id = o.user_id WHERE o.total > 4 AND u.team_id != o.total": {
+			"Hash Join", "Hash Cond: u.id = o.user_id", "Join Filter: u.team_id != o.total",
+			"Full Scan on users u", "Full Scan on orders o", "Filter: total > 4", "actual rows=2",
+		},
+		"SELECT * FROM users u LEFT JOIN teams t ON u.team_id = t.team_id AND t.name = 'red'": {
+			"Hash Left Join", "Filter: name = 'red'", "actual rows=4",
+		},
+		"SELECT * FROM users u JOIN orders o ON u.id <= o.user_id WHERE u.id = 1": {
+			"Nested Loop", "Join Filter: u.id <= o.user_id", "Primary Key Lookup on users u", "Key: id = 1",
+		},
+		"SELECT * FROM users u RIGHT JOIN teams t ON u.team_id = t.team_id WHERE u.id IS NULL": {
+			"Filter", "Filter: u.id IS NULL", "Hash Right Join", "actual rows=1",
+		},
+	} {
+		out := explain(sql)
+		for _, want := range wants {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: plan lacks %q:\n%s", sql, want, out)
+			}
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT name FROM users JOIN teams USING (team_id)",
+		"SELECT id FROM users, orders",
+		"SELECT x.id FROM users u",
+		"SELECT users.id FROM users u",
+		"SELECT u.missing FROM users u JOIN teams t ON u.team_id = t.team_id",
+		"SELECT * FROM users JOIN users ON id = id",
+		"SELECT * FROM users JOIN missing ON 1 = 1",
+		"SELECT * FROM users u JOIN teams t ON u.id = o.user_id JOIN orders o ON 1 = 1",
+		"SELECT * FROM users JOIN teams USING (missing)",
+		"SELECT * FROM users JOIN orders USING (total)",
+	} {
+		if _, _, err := run(t, eng, sql); err == nil {
+			t.Errorf("%s succeeded, want error", sql)
+		}
+	}
+}
+
+func TestExecutePlanSubqueries(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT, team_id INT)")
+	mustRun(t, eng, "CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT)")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (1, 'ann', 10)")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (2, 'bob', 20)")
+	mustRun(t, eng, "INSERT INTO users (id, name) VALUES (3, 'cat')")
+	mustRun(t, eng, "INSERT INTO users (id, name, team_id) VALUES (4, 'dan', 10)")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (100, 1, 5)")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (101, 1, 7)")
+	mustRun(t, eng, "INSERT INTO orders (id, user_id, total) VALUES (102, 2, 3)")
+	mustRun(t, eng, "INSERT INTO orders (id, total) VALUES (103, 9)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		// Scalar
+		{"SELECT name FROM users WHERE id = (SELECT MAX(user_id) FROM orders)", "name=bob"},
+		{"SELECT id FROM orders WHERE total > (SELECT AVG(total) FROM orders) ORDER BY id", "id=101; id=103"},
+		{"SELECT name FROM users WHERE id = (SELECT user_id FROM orders WHERE total > 100)", ""},
+		{"SELECT u.name FROM users u WHERE (SELECT SUM(total) FROM orders o WHERE o.user_id = u.id) > 10", "name=ann"},
+		{"SELECT o.id, u.name FROM orders o JOIN users u ON u.id = o.user_id WHERE o.total = (SELECT MAX(total) FROM orders WHERE user_id = u.id) ORDER BY o.id",
+			"id=101 name=ann; id=102 name=bob"},
+
+		// IN
+		{"SELECT name FROM users WHERE id IN (SELECT user_id FROM orders) ORDER BY id", "name=ann; name=bob"},
+		{"SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders)", ""},
+		{"SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE user_id IS NOT NULL) ORDER BY id", "name=cat; name=dan"},
+		{"SELECT name FROM users u WHERE 7 IN (SELECT total FROM orders o WHERE o.user_id = u.id)", "name=ann"},
+		{"SELECT name FROM users WHERE team_id IN (SELECT team_id FROM users WHERE name = 'dan') ORDER BY id", "name=ann; name=dan"},
+
+		// EXISTS
+		{"SELECT name FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u.id) ORDER BY id", "name=ann; name=bob"},
+		{"SELECT name FROM users u WHERE NOT EXISTS (SELECT * FROM orders WHERE user_id = u.id) ORDER BY id", "name=cat; name=dan"},
+		{"SELECT name FROM users WHERE EXISTS (SELECT * FROM orders WHERE total > 8) AND id = 1", "name=ann"},
+		{"SELECT name FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u.id AND o.total < u.id + 3)", "name=bob"},
+		{"SELECT name FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u.id AND EXISTS (SELECT * FROM users v WHERE v.team_id = u.team_id AND v.id != u.id))",
+			"name=ann"},
+
+		// Derived tables
+		{"SELECT * FROM (SELECT id, name FROM users WHERE team_id = 10) AS t ORDER BY id", "id=1 name=ann; id=4 name=dan"},
+		{"SELECT * FROM (SELECT user_id, SUM(total) FROM orders GROUP BY user_id HAVING SUM(total) > 4) s ORDER BY s.user_id",
+			"user_id=1 SUM(total)=12; user_id=NULL SUM(total)=9"},
+		{"SELECT * FROM users u JOIN (SELECT user_id, COUNT(*) FROM orders GROUP BY user_id) s ON s.user_id = u.id WHERE u.id < 2",
+			"id=1 name=ann team_id=10 user_id=1 COUNT(*)=2"},
+		{"SELECT COUNT(*) FROM (SELECT * FROM orders) o", "COUNT(*)=4"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	// Subqueries in UPDATE and DELETE
+	mustRun(t, eng, "UPDATE users SET team_id = 30 WHERE id NOT IN (SELECT user_id FROM orders WHERE user_id IS NOT NULL)")
+	mustRun(t, eng, "DELETE FROM orders WHERE NOT EXISTS (SELECT * FROM users WHERE id = orders.user_id)")
+	if got := table(t, eng, "SELECT id FROM orders ORDER BY id"); got != "id=100; id=101; id=102" {
+		t.Errorf("orders after delete: %s", got)
+	}
+	if got := table(t, eng, "SELECT id FROM users WHERE team_id = 30 ORDER BY id"); got != "id=3; id=4" {
+		t.Errorf("users after update: %s", got)
+	}
+
+	explain := func(sql string) string {
+		query, _ := parser.Parse(sql)
+		plan, err := eng.Plan(query)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		node, err := eng.Explain(plan, true)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return strings.Join(node.Lines(), "\n")
+	}
+	for sql, wants := range map[string][]string{
+		"SELECT name FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u.id)": {
+			"Hashed SubPlan", "Hash Cond: user_id = id", "Loops: 1", "Full Scan on orders",
+		},
+		"SELECT name FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id > u.id)": {
+			"SubPlan", "Loops: 4", "Filter: user_id > id",
+		},
+		"SELECT name FROM users WHERE id = (SELECT MAX(user_id) FROM orders)": {
+			"InitPlan", "Loops: 1", "Aggregate", "Primary Key Lookup on users",
+		},
+		"SELECT * FROM (SELECT id FROM users) t WHERE t.id > 2": {
+			"Subquery Scan on t", "Filter: t.id > 2", "actual rows=2",
+		},
+	} {
+		out := explain(sql)
+		for _, want := range wants {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: plan lacks %q:\n%s", sql, want, out)
+			}
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT name FROM users WHERE id = (SELECT user_id FROM orders)",
+		"SELECT name FROM users WHERE id IN (SELECT id, total FROM orders)",
+		"SELECT name FROM users WHERE id = (SELECT * FROM orders)",
+		"SELECT name FROM users WHERE name IN (SELECT id FROM orders)",
+		"SELECT name FROM users WHERE EXISTS (SELECT * FROM missing)",
+		"SELECT name FROM users WHERE EXISTS (SELECT * FROM orders WHERE x.id = 1)",
+		"SELECT * FROM (SELECT id FROM users) t WHERE id = name",
+		"SELECT * FROM users u JOIN (SELECT * FROM orders WHERE user_id = u.id) o ON 1 = 1",
+	} {
+		if _, _, err := run(t, eng, sql); err == nil {
+			t.Errorf("%s succeeded, want error", sql)
+		}
+	}
+}
+
+func TestExecutePlanWith(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	// 1 -> 2 -> 4, 1 -> 3; 5 -> 6 -> 5 is a cycle
+	mustRun(t, eng, "CREATE TABLE categories (id INT PRIMARY KEY, name TEXT, parent INT)")
+	mustRun(t, eng, "INSERT INTO categories (id, name) VALUES (1, 'root')")
+	mustRun(t, eng, "INSERT INTO categories (id, name, parent) VALUES (2, 'books', 1)")
+	mustRun(t, eng, "INSERT INTO categories (id, name, parent) VALUES (3, 'music', 1)")
+	mustRun(t, eng, "INSERT INTO categories (id, name, parent) VALUES (4, 'novels', 2)")
+	mustRun(t, eng, "INSERT INTO categories (id, name, parent) VALUES (5, 'loop', 6)")
+	mustRun(t, eng, "INSERT INTO categories (id, name, parent) VALUES (6, 'pool', 5)")
+	mustRun(t, eng, "CREATE TABLE archive (id INT PRIMARY KEY, name TEXT)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		{"WITH books AS (SELECT id, name FROM categories WHERE parent = 2 OR id = 2) SELECT name FROM books ORDER BY id",
+			"name=books; name=novels"},
+		{"WITH top (cid, cname) AS (SELECT id, name FROM categories WHERE parent IS NULL) SELECT c.name FROM categories c JOIN top t ON c.parent = t.cid ORDER BY c.id",
+			"name=books; name=music"},
+		{"WITH a AS (SELECT id FROM categories WHERE id < 4), b AS (SELECT * FROM a WHERE id > 1) SELECT COUNT(*) FROM b JOIN a x ON x.id = b.id",
+			"COUNT(*)=2"},
+		{"WITH ids AS (SELECT id FROM categories WHERE parent = 1) SELECT name FROM categories WHERE id IN (SELECT id FROM ids) ORDER BY name",
+			"name=books; name=music"},
+		{"WITH RECURSIVE tree AS (SELECT id, name FROM categories WHERE id = 1 UNION ALL SELECT c.id, c.name FROM categories c JOIN tree t ON c.parent = t.id) SELECT name FROM tree ORDER BY id",
+			"name=root; name=books; name=music; name=novels"},
+		{"WITH RECURSIVE up (id, parent) AS (SELECT id, parent FROM categories WHERE id = 4 UNION ALL SELECT c.id, c.parent FROM up JOIN categories c ON c.id = up.parent) SELECT id FROM up",
+			"id=4; id=2; id=1"},
+		{"WITH RECURSIVE ring AS (SELECT id, parent FROM categories WHERE id = 5 UNION SELECT c.id, c.parent FROM categories c, ring r WHERE c.id = r.parent) SELECT COUNT(*) FROM ring",
+			"COUNT(*)=2"},
+		{"WITH RECURSIVE both_ AS (SELECT id FROM categories WHERE id = 1 UNION SELECT id FROM categories WHERE id < 3) SELECT * FROM both_ ORDER BY id",
+			"id=1; id=2"},
+		{"WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 4) SELECT SUM(i) AS s FROM n",
+			"s=10"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	// WITH before INSERT, UPDATE and DELETE
+	mustRun(t, eng, "WITH RECURSIVE tree AS (SELECT id, name FROM categories WHERE id = 2 UNION ALL SELECT c.id, c.name FROM categories c JOIN tree t ON c.parent = t.id) INSERT INTO archive (id, name) SELECT id, name FROM tree")
+	if got := table(t, eng, "SELECT id, name FROM archive ORDER BY id"); got != "id=2 name=books; id=4 name=novels" {
+		t.Errorf("archive after insert: %s", got)
+	}
+	mustRun(t, eng, "WITH old AS (SELECT id FROM archive) UPDATE categories SET name = 'old' WHERE id IN (SELECT id FROM old)")
+	mustRun(t, eng, "WITH old AS (SELECT id FROM categories WHERE name = 'old') DELETE FROM categories WHERE id IN (SELECT id FROM old)")
+	if got := table(t, eng, "SELECT id FROM categories ORDER BY id"); got != "id=1; id=3; id=5; id=6" {
+		t.Errorf("categories after delete: %s", got)
+	}
+
+	// An INSERT ... SELECT that fails inserts nothing
+	if _, _, err := run(t, eng, "INSERT INTO archive (id, name) SELECT c.id, c.name FROM categories c, categories d"); err == nil {
+		t.Error("INSERT ... SELECT of a duplicate key succeeded")
+	}
+	if got := table(t, eng, "SELECT COUNT(*) FROM archive"); got != "COUNT(*)=2" {
+		t.Errorf("archive after failed insert: %s", got)
+	}
+
+	// A recursion over a cycle with UNION ALL runs into the limit
+	eng.SetRecursionLimit(50)
+	_, _, err := run(t, eng, "WITH RECURSIVE ring AS (SELECT id, parent FROM categories WHERE id = 5 UNION ALL SELECT c.id, c.parent FROM categories c JOIN ring r ON c.id = r.parent) SELECT * FROM ring")
+	if err == nil || !strings.Contains(err.Error(), "50 iterations") {
+		t.Errorf("runaway recursion: %v", err)
+	}
+	eng.SetRecursionLimit(DefaultRecursionLimit)
+
+	query, _ := parser.Parse("WITH RECURSIVE tree AS (SELECT id FROM categories WHERE id = 1 UNION ALL SELECT c.id FROM categories c JOIN tree t ON c.parent = t.id) SELECT * FROM tree t1 JOIN tree t2 ON t1.id = t2.id")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Join(node.Lines(), "\n")
+	for _, want := range []string{"CTE Scan on tree t1", "CTE Scan on tree t2", "CTE tree", "Recursive Union All", "Iterations: 2", "WorkTable Scan on tree t"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "CTE tree") != 1 {
+		t.Errorf("plan shows the CTE more than once:\n%s", out)
+	}
+
+	for _, sql := range []string{
+		"WITH x (a, b) AS (SELECT id FROM categories) SELECT * FROM x",
+		"WITH x AS (SELECT id, id FROM categories) SELECT * FROM x",
+		"WITH x AS (SELECT * FROM y), y AS (SELECT id FROM categories) SELECT * FROM x",
+		"WITH RECURSIVE x AS (SELECT id FROM categories UNION SELECT id, name FROM categories) SELECT * FROM x",
+		"WITH RECURSIVE x AS (SELECT id FROM categories WHERE id = 1 UNION SELECT c.id FROM categories c WHERE c.parent IN (SELECT id FROM x)) SELECT * FROM x",
+		"WITH RECURSIVE x AS (SELECT id FROM categories WHERE id = 1 UNION SELECT a.id FROM x a JOIN x b ON a.id = b.id) SELECT * FROM x",
+		"INSERT INTO archive (id, name) SELECT id FROM categories",
+		"INSERT INTO archive (id, missing) SELECT id, name FROM categories",
+	} {
+		if _, _, err := run(t, eng, sql); err == nil {
+			t.Errorf("%s succeeded, want error", sql)
+		}
+	}
+}
+
+func TestExecutePlanSetOperations(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE staff (id INT PRIMARY KEY, name TEXT, city TEXT, pay INT)")
+	mustRun(t, eng, "CREATE TABLE guests (gid INT PRIMARY KEY, gname TEXT, gcity TEXT, fee FLOAT)")
+	mustRun(t, eng, "INSERT INTO staff (id, name, city, pay) VALUES (1, 'ann', 'oslo', 10)")
+	mustRun(t, eng, "INSERT INTO staff (id, name, city, pay) VALUES (2, 'bob', 'rome', 20)")
+	mustRun(t, eng, "INSERT INTO staff (id, name, city, pay) VALUES (3, 'cat', 'oslo', 30)")
+	mustRun(t, eng, "INSERT INTO staff (id, name, pay) VALUES (4, 'dan', 40)")
+	mustRun(t, eng, "INSERT INTO guests (gid, gname, gcity, fee) VALUES (1, 'eve', 'oslo', 10.0)")
+	mustRun(t, eng, "INSERT INTO guests (gid, gname, gcity, fee) VALUES (2, 'bob', 'lima', 2.5)")
+	mustRun(t, eng, "INSERT INTO guests (gid, gname, fee) VALUES (3, 'fay', 40)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT city FROM staff UNION SELECT gcity FROM guests ORDER BY city NULLS FIRST",
+			"city=NULL; city=lima; city=oslo; city=rome"},
+		{"SELECT city FROM staff UNION ALL SELECT gcity FROM guests ORDER BY city LIMIT 4",
+			"city=lima; city=oslo; city=oslo; city=oslo"},
+		{"SELECT name FROM staff WHERE id < 3 UNION ALL SELECT gname FROM guests WHERE gid < 3",
+			"name=ann; name=bob; name=eve; name=bob"},
+		{"SELECT city FROM staff INTERSECT SELECT gcity FROM guests", "city=oslo; city=NULL"},
+		{"SELECT city FROM staff INTERSECT ALL SELECT gcity FROM guests", "city=oslo; city=NULL"},
+		{"SELECT city FROM staff EXCEPT SELECT gcity FROM guests", "city=rome"},
+		{"SELECT city FROM staff EXCEPT ALL SELECT gcity FROM guests", "city=rome; city=oslo"},
+		{"SELECT city FROM staff EXCEPT ALL SELECT gcity FROM guests WHERE gid = 0 ORDER BY city DESC",
+			"city=NULL; city=rome; city=oslo; city=oslo"},
+		// INT widens to FLOAT, and equal numbers are equal rows
+		{"SELECT pay FROM staff UNION SELECT fee FROM guests ORDER BY pay", "pay=2.5; pay=10; pay=20; pay=30; pay=40"},
+		{"SELECT id, pay FROM staff INTERSECT SELECT gid, fee FROM guests", "id=1 pay=10"},
+		// INTERSECT binds tighter, the rest applies left to right
+		{"SELECT name FROM staff WHERE id = 1 UNION SELECT name FROM staff INTERSECT SELECT gname FROM guests ORDER BY name",
+			"name=ann; name=bob"},
+		{"(SELECT name FROM staff WHERE id = 1 UNION SELECT name FROM staff) INTERSECT SELECT gname FROM guests", "name=bob"},
+		{"(SELECT name FROM staff ORDER BY pay DESC LIMIT 1) UNION ALL (SELECT gname FROM guests ORDER BY fee LIMIT 1)",
+			"name=dan; name=bob"},
+		{"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY name LIMIT 2 OFFSET 3", "name=dan; name=eve"},
+		{"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY 1 DESC LIMIT 2", "name=fay; name=eve"},
+		// Star, aggregates and joins on either side
+		{"SELECT * FROM staff WHERE id = 2 UNION SELECT * FROM guests WHERE gid = 1", "id=2 name=bob city=rome pay=20; id=1 name=eve city=oslo pay=10"},
+		{"SELECT city, COUNT(*) FROM staff GROUP BY city HAVING COUNT(*) > 1 UNION ALL SELECT gname, gid FROM guests WHERE gid = 3",
+			"city=oslo COUNT(*)=2; city=fay COUNT(*)=3"},
+		{"SELECT s.name FROM staff s JOIN guests g ON g.gname = s.name UNION SELECT gname FROM guests WHERE fee > 20", "name=bob; name=fay"},
+		// As a derived table, a subquery and a WITH query
+		{"SELECT COUNT(*) FROM (SELECT city FROM staff UNION SELECT gcity FROM guests) c", "COUNT(*)=4"},
+		{"SELECT name FROM staff WHERE name IN (SELECT gname FROM guests UNION SELECT name FROM staff WHERE pay > 35) ORDER BY id",
+			"name=bob; name=dan"},
+		{"SELECT name FROM staff s WHERE EXISTS (SELECT gname FROM guests WHERE gname = s.name EXCEPT SELECT name FROM staff WHERE pay > 15)",
+			""},
+		{"WITH names AS (SELECT name FROM staff INTERSECT SELECT gname FROM guests) SELECT * FROM names", "name=bob"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	query, _ := parser.Parse("SELECT city FROM staff UNION SELECT gcity FROM guests INTERSECT ALL SELECT gcity FROM guests ORDER BY city LIMIT 1")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Join(node.Lines(), "\n")
+	for _, want := range []string{"Limit", "Top-K Sort", "Sort Key: city", "Hash Union", "Hash Intersect All", "Full Scan on staff", "Full Scan on guests"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan lacks %q:\n%s", want, out)
+		}
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT id, name FROM staff UNION SELECT gid FROM guests":                                   "same number of columns",
+		"SELECT name FROM staff UNION SELECT gid FROM guests":                                       "UNION types TEXT and INT cannot be matched",
+		"SELECT name, city FROM staff EXCEPT SELECT gname, fee FROM guests":                         "EXCEPT types TEXT and FLOAT cannot be matched",
+		"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY id":                         "column 'id' does not exist",
+		"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY staff.name":                 "column 'staff.name' does not exist",
+		"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY COUNT(*)":                   "aggregate functions are not allowed",
+		"SELECT name FROM staff UNION SELECT gname FROM guests ORDER BY 2":                          "ORDER BY position 2 is not in select list",
+		"WITH RECURSIVE r AS (SELECT id FROM staff UNION SELECT gname FROM guests) SELECT * FROM r": "UNION types INT and TEXT",
+	} {
+		_, _, err := run(t, eng, sql)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", sql, err, want)
+		}
+	}
+}
+
+func TestExecutePlanProjections(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE products (id INT PRIMARY KEY, name TEXT, price FLOAT, cat TEXT, qty INT)")
+	mustRun(t, eng, "CREATE TABLE cats (cat TEXT PRIMARY KEY, label TEXT)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price, cat, qty) VALUES (1, 'pen', 2.0, 'office', 10)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price, cat, qty) VALUES (2, 'ink', 5.0, 'office', 3)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price, cat, qty) VALUES (3, 'cup', 4.0, 'kitchen', 0)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price, cat, qty) VALUES (4, 'pot', 20.0, 'kitchen', 1)")
+	mustRun(t, eng, "INSERT INTO products (id, name, price, qty) VALUES (5, 'mug', 4.0, 5)")
+	mustRun(t, eng, "INSERT INTO cats (cat, label) VALUES ('office', 'Office')")
+	mustRun(t, eng, "INSERT INTO cats (cat, label) VALUES ('kitchen', 'Kitchen')")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		// Expressions are named by their alias or their text
+		{"SELECT name, price * 1.5 AS gross FROM products WHERE id < 3", "name=pen gross=3; name=ink gross=7.5"},
+		{"SELECT name AS product, qty * 2 FROM products ORDER BY product LIMIT 2", "product=cup qty * 2=0; product=ink qty * 2=6"},
+		{"SELECT name, price * qty AS total FROM products ORDER BY total DESC, name LIMIT 3",
+			"name=mug total=20; name=pen total=20; name=pot total=20"},
+		{"SELECT UPPER(name) AS name, id FROM products WHERE id = 1", "name=PEN id=1"},
+		// * and table.* among other items
+		{"SELECT *, price * 2 AS twice FROM products WHERE id = 2", "id=2 name=ink price=5 cat=office qty=3 twice=10"},
+		{"SELECT products.* FROM products WHERE id = 5", "id=5 name=mug price=4 cat=NULL qty=5"},
+		{"SELECT p.*, c.label FROM products p JOIN cats c ON c.cat = p.cat WHERE p.id = 1",
+			"id=1 name=pen price=2 cat=office qty=10 label=Office"},
+		{"SELECT c.*, p.name FROM products p JOIN cats c ON c.cat = p.cat WHERE p.id = 4", "cat=kitchen label=Kitchen name=pot"},
+		// DISTINCT compares the result rows, NULLs included
+		{"SELECT DISTINCT price FROM products ORDER BY price", "price=2; price=4; price=5; price=20"},
+		{"SELECT DISTINCT cat FROM products ORDER BY cat NULLS FIRST", "cat=NULL; cat=kitchen; cat=office"},
+		{"SELECT DISTINCT price > 3 AS dear FROM products ORDER BY dear", "dear=false; dear=true"},
+		{"SELECT DISTINCT c.label FROM products p JOIN cats c ON c.cat = p.cat ORDER BY c.label", "label=Kitchen; label=Office"},
+		{"SELECT DISTINCT cat, price FROM products WHERE price = 4 LIMIT 5", "cat=kitchen price=4; cat=NULL price=4"},
+		// DISTINCT ON keeps the first row of each value
+		{"SELECT DISTINCT ON (cat) cat, name FROM products ORDER BY cat, price DESC",
+			"cat=kitchen name=pot; cat=office name=ink; cat=NULL name=mug"},
+		{"SELECT DISTINCT ON (cat) products.* FROM products ORDER BY cat, price LIMIT 2",
+			"id=3 name=cup price=4 cat=kitchen qty=0; id=1 name=pen price=2 cat=office qty=10"},
+		{"SELECT DISTINCT ON (c.label) c.label, p.name AS cheapest FROM products p JOIN cats c ON c.cat = p.cat ORDER BY c.label, p.price",
+			"label=Kitchen cheapest=cup; label=Office cheapest=pen"},
+		// Aggregates, subqueries and derived tables
+		{"SELECT cat, COUNT(*) AS n, SUM(price * qty) AS total FROM products GROUP BY cat ORDER BY n DESC, cat",
+			"cat=kitchen n=2 total=20; cat=office n=2 total=35; cat=NULL n=1 total=20"},
+		{"SELECT DISTINCT COUNT(*) AS n FROM products GROUP BY cat ORDER BY n", "n=1; n=2"},
+		{"SELECT DISTINCT ON (COUNT(*)) COUNT(*) AS n, cat FROM products GROUP BY cat ORDER BY COUNT(*), cat",
+			"n=1 cat=NULL; n=2 cat=kitchen"},
+		{"SELECT name FROM products WHERE price * 2 > (SELECT MAX(price) / 2 AS half FROM products)", "name=pot"},
+		{"SELECT SUM(t.total) AS s FROM (SELECT price * qty AS total FROM products) t", "s=75"},
+		{"SELECT name FROM products p WHERE EXISTS (SELECT DISTINCT cat FROM products q WHERE q.cat = p.cat AND q.id <> p.id) ORDER BY name",
+			"name=cup; name=ink; name=pen; name=pot"},
+		// Without FROM the select list is computed from a single row
+		{"SELECT 1 + 2 AS x, 'a' AS y", "x=3 y=a"},
+		{"SELECT COUNT(*) AS n, (SELECT MAX(price) FROM products) AS top WHERE 1 = 1", "n=1 top=20"},
+		{"SELECT 1 AS n WHERE 1 = 2", ""},
+		{"SELECT name FROM products WHERE id IN (SELECT 2 UNION SELECT 5) ORDER BY id", "name=ink; name=mug"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	for sql, wants := range map[string][]string{
+		"SELECT DISTINCT ON (cat) name FROM products ORDER BY cat": {"Project", "Unique", "Key: cat", "Sort"},
+		"SELECT DISTINCT cat, qty + 1 AS q FROM products":          {"Unique", "Key: cat, q", "Columns: cat, q"},
+		"SELECT name, price * 2 AS twice FROM products":            {"Columns: name, twice"},
+		"SELECT 1 + 2 AS three, 'x' AS letter WHERE 1 = 1":         {"Project", "Filter", "Result"},
+	} {
+		query, _ := parser.Parse(sql)
+		plan, _ := eng.Plan(query)
+		node, err := eng.Explain(plan, false)
+		if err != nil {
+			t.Fatalf("explain %s: %v", sql, err)
+		}
+		out := strings.Join(node.Lines(), "\n")
+		for _, want := range wants {
+			if !strings.Contains(out, want) {
+				t.Errorf("plan of %s lacks %q:\n%s", sql, want, out)
+			}
+		}
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT DISTINCT name FROM products ORDER BY price":          "ORDER BY expressions must appear in select list",
+		"SELECT DISTINCT ON (cat) name FROM products ORDER BY name":  "DISTINCT ON expressions must match initial ORDER BY expressions",
+		"SELECT x.* FROM products":                                   "missing FROM-clause entry for table 'x'",
+		"SELECT c.* FROM products p JOIN cats c ON c.cat = p.cat, x": "table 'x' does not exist",
+		"SELECT nope * 2 FROM products":                              "column 'nope' does not exist",
+		"SELECT name AS n, id AS n FROM products ORDER BY n":         "column reference 'n' is ambiguous",
+		"SELECT id + 1 AS x, id + 2 AS x FROM products":              "column 'x' appears more than once in select list",
+		"SELECT id AS cat, cat FROM products":                        "column 'cat' appears more than once in select list",
+		"SELECT *, name FROM products":                               "column 'name' appears more than once in select list",
+		"SELECT 1 AS n, 2 AS n FROM products p, cats c":              "column 'n' appears more than once in select list",
+		"SELECT name, price * 2 AS twice WHERE price > 1":            "column 'price' does not exist",
+	} {
+		_, _, err := run(t, eng, sql)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", sql, err, want)
+		}
+	}
+}
+
+func TestExecutePlanWindows(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+
+	mustRun(t, eng, "CREATE TABLE emp (id INT PRIMARY KEY, name TEXT, dept TEXT, pay INT)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, dept, pay) VALUES (1, 'ann', 'eng', 100)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, dept, pay) VALUES (2, 'bob', 'eng', 80)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, dept, pay) VALUES (3, 'cat', 'eng', 100)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, dept, pay) VALUES (4, 'dan', 'ops', 50)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, dept, pay) VALUES (5, 'eve', 'ops', 70)")
+	mustRun(t, eng, "INSERT INTO emp (id, name, pay) VALUES (6, 'fay', 60)")
+
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		// Ranking
+		{"SELECT name, ROW_NUMBER() OVER (ORDER BY pay, id DESC) AS rn FROM emp ORDER BY id",
+			"name=ann rn=6; name=bob rn=4; name=cat rn=5; name=dan rn=1; name=eve rn=3; name=fay rn=2"},
+		{"SELECT name, RANK() OVER (PARTITION BY dept ORDER BY pay DESC) AS r, DENSE_RANK() OVER (ORDER BY pay DESC) AS d FROM emp ORDER BY id",
+			"name=ann r=1 d=1; name=bob r=3 d=2; name=cat r=1 d=1; name=dan r=2 d=5; name=eve r=1 d=3; name=fay r=1 d=4"},
+		// Aggregates over the default frame: the partition up to the peers of the row
+		{"SELECT id, SUM(pay) OVER (ORDER BY id) AS run FROM emp ORDER BY id",
+			"id=1 run=100; id=2 run=180; id=3 run=280; id=4 run=330; id=5 run=400; id=6 run=460"},
+		{"SELECT id, SUM(pay) OVER (ORDER BY pay) AS s FROM emp ORDER BY id",
+			"id=1 s=460; id=2 s=260; id=3 s=460; id=4 s=50; id=5 s=180; id=6 s=110"},
+		{"SELECT name, AVG(pay) OVER (PARTITION BY dept) AS avg, COUNT(*) OVER () AS n FROM emp WHERE dept = 'ops' ORDER BY id",
+			"name=dan avg=60 n=2; name=eve avg=60 n=2"},
+		// ROWS and RANGE frames
+		{"SELECT id, SUM(pay) OVER (ORDER BY id ROWS BETWEEN 1 PRECEDING AND 1 FOLLOWING) AS s FROM emp ORDER BY id",
+			"id=1 s=180; id=2 s=280; id=3 s=230; id=4 s=220; id=5 s=180; id=6 s=130"},
+		{"SELECT id, MAX(pay) OVER (ORDER BY id ROWS 2 PRECEDING) AS m, COUNT(*) OVER (ORDER BY id ROWS BETWEEN 2 FOLLOWING AND UNBOUNDED FOLLOWING) AS c FROM emp ORDER BY id",
+			"id=1 m=100 c=4; id=2 m=100 c=3; id=3 m=100 c=2; id=4 m=100 c=1; id=5 m=100 c=0; id=6 m=70 c=0"},
+		{"SELECT id, COUNT(*) OVER (ORDER BY pay RANGE BETWEEN 10 PRECEDING AND 10 FOLLOWING) AS near FROM emp ORDER BY id",
+			"id=1 near=2; id=2 near=2; id=3 near=2; id=4 near=2; id=5 near=3; id=6 near=3"},
+		{"SELECT id, COUNT(*) OVER (ORDER BY pay DESC RANGE BETWEEN 20 PRECEDING AND CURRENT ROW) AS c FROM emp ORDER BY id",
+			"id=1 c=2; id=2 c=3; id=3 c=2; id=4 c=3; id=5 c=2; id=6 c=3"},
+		// Value functions
+		{"SELECT id, LAG(pay) OVER (ORDER BY id) AS prev, LEAD(pay, 2, 0) OVER (ORDER BY id) AS next2 FROM emp ORDER BY id",
+			"id=1 prev=NULL next2=100; id=2 prev=100 next2=50; id=3 prev=80 next2=70; id=4 prev=100 next2=60; id=5 prev=50 next2=0; id=6 prev=70 next2=0"},
+		{"SELECT id, FIRST_VALUE(name) OVER (PARTITION BY dept ORDER BY pay) AS low, LAST_VALUE(name) OVER (PARTITION BY dept ORDER BY pay ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING) AS high FROM emp WHERE dept IS NOT NULL ORDER BY id",
+			"id=1 low=bob high=cat; id=2 low=bob high=cat; id=3 low=bob high=cat; id=4 low=dan high=eve; id=5 low=dan high=eve"},
+		{"SELECT id, LAST_VALUE(id) OVER (ORDER BY pay) AS l FROM emp ORDER BY id", "id=1 l=3; id=2 l=2; id=3 l=3; id=4 l=4; id=5 l=5; id=6 l=6"},
+		// After GROUP BY, in ORDER BY, over joins and with DISTINCT
+		{"SELECT dept, SUM(pay) AS total, RANK() OVER (ORDER BY SUM(pay) DESC) AS r FROM emp GROUP BY dept ORDER BY r",
+			"dept=eng total=280 r=1; dept=ops total=120 r=2; dept=NULL total=60 r=3"},
+		{"SELECT name FROM emp ORDER BY ROW_NUMBER() OVER (ORDER BY pay DESC, id) LIMIT 2", "name=ann; name=cat"},
+		{"SELECT e.name, COUNT(*) OVER (PARTITION BY e.dept) AS n FROM emp e JOIN emp f ON f.id = e.id WHERE e.dept = 'eng' ORDER BY e.id",
+			"name=ann n=3; name=bob n=3; name=cat n=3"},
+		{"SELECT DISTINCT dept, COUNT(*) OVER (PARTITION BY dept) AS n FROM emp ORDER BY n DESC, dept", "dept=eng n=3; dept=ops n=2; dept=NULL n=1"},
+		// In derived tables and subqueries
+		{"SELECT name FROM (SELECT name, RANK() OVER (PARTITION BY dept ORDER BY pay DESC) AS r FROM emp) t WHERE r = 1 ORDER BY name",
+			"name=ann; name=cat; name=eve; name=fay"},
+		{"SELECT name FROM emp e WHERE 2 IN (SELECT COUNT(*) OVER () FROM emp f WHERE f.dept = e.dept) ORDER BY id", "name=dan; name=eve"},
+	} {
+		if got := table(t, eng, tc.sql); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+
+	query, _ := parser.Parse("SELECT name, RANK() OVER (PARTITION BY dept ORDER BY pay) AS r FROM emp ORDER BY r")
+	plan, _ := eng.Plan(query)
+	node, err := eng.Explain(plan, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Join(node.Lines(), "\n")
+	for _, want := range []string{"Sort", "WindowAgg", "Function: RANK() OVER (PARTITION BY dept ORDER BY pay)", "Full Scan on emp"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan lacks %q:\n%s", want, out)
+		}
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT name FROM emp WHERE ROW_NUMBER() OVER () > 1":                                     "window functions are not allowed in WHERE",
+		"SELECT dept FROM emp GROUP BY dept HAVING RANK() OVER () > 1":                            "window functions are not allowed in HAVING",
+		"SELECT id FROM emp UNION SELECT id FROM emp ORDER BY ROW_NUMBER() OVER ()":               "window functions are not allowed in ORDER BY of UNION",
+		"SELECT dept, RANK() OVER (ORDER BY pay) FROM emp GROUP BY dept":                          "column 'pay' must appear in the GROUP BY clause",
+		"SELECT SUM(pay) OVER (ORDER BY name RANGE BETWEEN 1 PRECEDING AND CURRENT ROW) FROM emp": "RANGE with an offset needs a numeric ORDER BY key",
+		"SELECT LAG(pay, 'x') OVER () FROM emp":                                                   "LAG offset must be an integer",
+		"UPDATE emp SET pay = 1 WHERE RANK() OVER () = 1":                                         "window function RANK is not allowed here",
+	} {
+		_, _, err := run(t, eng, sql)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", sql, err, want)
+		}
+	}
+}
+
+func TestEngineCreateTableAndAddColumnRecover(t *testing.T) {
+	dir := t.TempDir()
+
+	eng := NewEngine(storage.NewDatabase())
+	if err := eng.Open(dir); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if _, err := eng.CreateTable("w"); err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+	if err := eng.AddColumn("w", &storage.Column{Name: "id", ColumnType: storage.IntType}); err != nil {
+		t.Fatalf("AddColumn failed: %v", err)
+	}
+	if err := eng.AddColumn("missing", &storage.Column{Name: "id", ColumnType: storage.IntType}); err == nil {
+		t.Fatal("expected an error adding a column to a missing table")
+	}
+	eng.Insert("w", map[string]any{"id": 1})
+	eng.Close()
+
+	recovered := NewEngine(storage.NewDatabase())
+	if err := recovered.Open(dir); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer recovered.Close()
+
+	rows, err := recovered.SelectAll("w")
+	if err != nil || len(rows) != 1 || rows[0].Data["id"] != int64(1) {
+		t.Fatalf("unexpected rows after recovery: %+v (err %v)", rows, err)
+	}
+}
+
+func TestExecutePlanForeignKeyLookups(t *testing.T) {
+	eng := NewEngine(storage.NewDatabase())
+	count := func(sql string) int {
+		_, rows, err := run(t, eng, sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return len(rows)
+	}
+
+	// The key is looked up in the primary index, whose columns are in
+	// another order, and in the index over the children's columns
+	mustRun(t, eng, "CREATE TABLE regions (code TEXT, num INT, PRIMARY KEY (num, code))")
+	mustRun(t, eng, `CREATE TABLE shops (id INT PRIMARY KEY, code TEXT, num INT,
+		FOREIGN KEY (code, num) REFERENCES regions (code, num) ON DELETE CASCADE ON UPDATE CASCADE)`)
+	mustRun(t, eng, "CREATE INDEX shops_region ON shops (num, code, id)")
+	mustRun(t, eng, "CREATE TABLE staff (id INT PRIMARY KEY, shop INT REFERENCES shops)")
+
+	mustRun(t, eng, "INSERT INTO regions (code, num) VALUES ('a', 1)")
+	mustRun(t, eng, "INSERT INTO regions (code, num) VALUES ('b', 1)")
+	mustRun(t, eng, "INSERT INTO regions (code, num) VALUES ('a', 2)")
+	for _, sql := range []string{
+		"INSERT INTO shops (id, code, num) VALUES (1, 'a', 1)",
+		"INSERT INTO shops (id, code, num) VALUES (2, 'a', 1)",
+		"INSERT INTO shops (id, code, num) VALUES (3, 'b', 1)",
+		"INSERT INTO shops (id, code, num) VALUES (4, 'a', 2)",
+		"INSERT INTO staff (id, shop) VALUES (1, 3)",
+	} {
+		mustRun(t, eng, sql)
+	}
+	if _, _, err := run(t, eng, "INSERT INTO shops (id, code, num) VALUES (5, 'b', 2)"); err == nil {
+		t.Fatal("expected error for a missing parent row, got nil")
+	}
+
+	if _, _, err := run(t, eng, "UPDATE staff SET shop = 2 WHERE id = 1"); err != nil {
+		t.Fatalf("pointing at an existing parent row failed: %v", err)
+	}
+	if _, _, err := run(t, eng, "UPDATE shops SET id = 6 WHERE id = 2"); err == nil {
+		t.Fatal("expected NO ACTION to block changing a referenced key, got nil")
+	}
+
+	// The cascaded key is only found among the statement's own changes
+	mustRun(t, eng, "UPDATE regions SET num = 3 WHERE code = 'a' AND num = 2")
+	if row, _ := eng.GetByPK("shops", 4); row.Data["num"] != int64(3) {
+		t.Fatalf("ON UPDATE CASCADE did not update the shop: %v", row.Data)
+	}
+
+	mustRun(t, eng, "DELETE FROM regions WHERE code = 'a' AND num = 3")
+	if n := count("SELECT * FROM shops"); n != 3 {
+		t.Fatalf("expected ON DELETE CASCADE to leave 3 shops, got %d", n)
+	}
+	if _, _, err := run(t, eng, "DELETE FROM regions WHERE code = 'a' AND num = 1"); err == nil {
+		t.Fatal("expected the cascade to be blocked by staff, got nil")
+	}
+	if n := count("SELECT * FROM shops WHERE num = 1"); n != 3 {
+		t.Fatalf("failed delete changed the shops table: %d rows", n)
+	}
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+)
+
+// PlanNode describes one operator of a plan for EXPLAIN. The actual figures
+// are only filled in by EXPLAIN ANALYZE.
+type PlanNode struct {
+	Operator string
+	Details  []string
+
+	// Estimates from the table statistics; Cost is in rows read by a
+	// full scan and includes the operator's inputs
+	Rows float64
+	Cost float64
+
+	Analyzed   bool
+	ActualRows int
+	Time       time.Duration // including the operator's inputs
+
+	Children []*PlanNode
+}
+
+// Explain describes the operators that would run a SELECT, INSERT, UPDATE
+// or DELETE plan. With analyze the statement is also executed, changes
+// included, and every node reports the rows it produced and the time it
+// took.
+func (e *Engine) Explain(plan *planner.Plan, analyze bool) (*PlanNode, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	node, err := e.explain(plan, analyze)
+	if err != nil {
+		return nil, err
+	}
+
+	if analyze {
+		e.maybeCheckpoint()
+	}
+	return node, nil
+}
+
+func (e *Engine) explain(plan *planner.Plan, analyze bool) (*PlanNode, error) {
+	op, err := e.buildOperator(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	if analyze {
+		if _, err := e.runOperator(op); err != nil {
+			return nil, err
+		}
+	}
+	return describeOperator(op), nil
+}
+
+func describeOperator(op operator) *PlanNode {
+	node := &PlanNode{}
+	node.Operator, node.Details = op.describe()
+	node.Rows, node.Cost = op.estimate()
+
+	// An input may not have run if the operator failed before reaching it
+	if s := op.stats(); s.ran {
+		node.Analyzed = true
+		node.ActualRows = s.rows
+		node.Time = s.elapsed
+	}
+
+	for _, input := range op.inputs() {
+		node.Children = append(node.Children, describeOperator(input))
+	}
+	// Subqueries come after the inputs of the operator evaluating them
+	for _, sub := range subplansOf(op) {
+		node.Children = append(node.Children, describeOperator(sub))
+	}
+	return node
+}
+
+// Lines renders the plan as text in the style of PostgreSQL: one line per
+// operator with its estimates, then its details, with the inputs of an
+// operator indented beneath it.
+func (n *PlanNode) Lines() []string {
+	var lines []string
+	n.render(&lines, 0)
+	return lines
+}
+
+func (n *PlanNode) render(lines *[]string, depth int) {
+	indent := ""
+	if depth > 0 {
+		indent = strings.Repeat(" ", 6*(depth-1)+2) + "->  "
+	}
+
+	line := fmt.Sprintf("%s%s  (rows=%.0f cost=%.2f)", indent, n.Operator, n.Rows, n.Cost)
+	if n.Analyzed {
+		line += fmt.Sprintf(" (actual rows=%d time=%.3f ms)", n.ActualRows, n.Time.Seconds()*1000)
+	}
+	*lines = append(*lines, line)
+
+	pad := strings.Repeat(" ", len(indent)+2)
+	for _, d := range n.Details {
+		*lines = append(*lines, pad+d)
+	}
+
+	for _, child := range n.Children {
+		child.render(lines, depth+1)
+	}
+}
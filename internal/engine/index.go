@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// CreateIndex builds and registers a secondary index.
+func (e *Engine) CreateIndex(ix *storage.Index) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.createIndex(ix); err != nil {
+		return err
+	}
+
+	e.maybeCheckpoint()
+	return nil
+}
+
+// DropIndex removes a secondary index.
+func (e *Engine) DropIndex(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.dropIndex(name); err != nil {
+		return err
+	}
+
+	e.maybeCheckpoint()
+	return nil
+}
+
+// createIndex builds the index in memory first, so that a missing column or
+// a duplicate key in a UNIQUE index is reported before anything is logged.
+func (e *Engine) createIndex(ix *storage.Index) error {
+	if ix == nil {
+		return fmt.Errorf("index cannot be empty")
+	}
+
+	if err := e.db.CreateIndex(ix); err != nil {
+		return err
+	}
+
+	rec := &storage.Record{Type: storage.RecordCreateIndex, Table: ix.Table, Index: ix}
+	if err := e.logChanges(rec); err != nil {
+		e.db.DropIndex(ix.Name)
+		return err
+	}
+	return nil
+}
+
+func (e *Engine) dropIndex(name string) error {
+	t, ix := e.db.FindIndex(name)
+	if ix == nil {
+		return fmt.Errorf("index %s does not exist", name)
+	}
+
+	rec := &storage.Record{Type: storage.RecordDropIndex, Table: t.Name, Index: &storage.Index{Name: name}}
+	if err := e.logChanges(rec); err != nil {
+		return err
+	}
+	return e.db.DropIndex(name)
+}
+
+// --------------------------
+// Index access
+// --------------------------
+
+// accessRows returns the IDs of the rows an access path reads, in
+// ascending order, or in key order for an ordered index scan. filters are
+// the access path's filters with computed values evaluated. An IN filter
+// looks up or scans once per value. If such a value turns out not to be
+// usable with the primary key or index, every row is read instead, through
+// the index if it is ordered; the WHERE condition is checked against each
+// row either way.
+func accessRows(table *storage.Table, access *planner.AccessPath, filters []planner.Filter) []storage.RowID {
+	switch access.Type {
+	case planner.PrimaryKeyLookup:
+		for _, f := range filters {
+			// PrimaryIndex holds exact values
+			for _, v := range f.Values() {
+				if storage.TypeOf(v) != findColumn(table, f.Column).ColumnType {
+					return table.RowIDs()
+				}
+			}
+		}
+
+		ids := []storage.RowID{}
+		for _, values := range combinations(filters) {
+			var pk any = values
+			if len(values) == 1 {
+				pk = values[0]
+			}
+			if id, err := table.RowIDByPK(pk); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		return uniqueRowIDs(ids)
+
+	case planner.IndexScan:
+		ix := findIndex(table, access.Index)
+		if ix == nil {
+			return table.RowIDs()
+		}
+
+		if !indexable(table, filters) {
+			if !access.Ordered {
+				return table.RowIDs()
+			}
+			filters = nil
+		}
+
+		var equalities, bounds []planner.Filter
+		for _, f := range filters {
+			if f.Operator == "=" || f.Operator == "IN" {
+				equalities = append(equalities, f)
+			} else {
+				bounds = append(bounds, f)
+			}
+		}
+
+		ids := []storage.RowID{}
+		for _, prefix := range combinations(equalities) {
+			var lo, hi *storage.Bound
+			for _, f := range bounds {
+				key := append(append([]any{}, prefix...), f.Value)
+				switch f.Operator {
+				case ">", ">=":
+					lo = &storage.Bound{Key: key, Inclusive: f.Operator == ">="}
+				case "<", "<=":
+					hi = &storage.Bound{Key: key, Inclusive: f.Operator == "<="}
+				}
+			}
+			if len(prefix) > 0 {
+				if lo == nil {
+					lo = &storage.Bound{Key: prefix, Inclusive: true}
+				}
+				if hi == nil {
+					hi = &storage.Bound{Key: prefix, Inclusive: true}
+				}
+			}
+
+			ix.Scan(lo, hi, func(id storage.RowID) bool {
+				ids = append(ids, id)
+				return true
+			})
+		}
+		if access.Ordered {
+			return ids // a single pass, in key order
+		}
+		return uniqueRowIDs(ids)
+
+	default:
+		return table.RowIDs()
+	}
+}
+
+// indexable reports whether every value of filters can be looked up in
+// an index of table.
+func indexable(table *storage.Table, filters []planner.Filter) bool {
+	for _, f := range filters {
+		for _, v := range f.Values() {
+			if !planner.Indexable(findColumn(table, f.Column), v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func findIndex(table *storage.Table, name string) *storage.Index {
+	for _, ix := range table.Indexes {
+		if ix.Name == name {
+			return ix
+		}
+	}
+	return nil
+}
+
+func findColumn(table *storage.Table, name string) *storage.Column {
+	for _, col := range table.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// combinations returns every choice of one value from each filter's
+// values, in filter order. No filters give a single empty choice.
+func combinations(filters []planner.Filter) [][]any {
+	out := [][]any{{}}
+	for _, f := range filters {
+		var next [][]any
+		for _, prefix := range out {
+			for _, v := range f.Values() {
+				next = append(next, append(append([]any{}, prefix...), v))
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+// uniqueRowIDs sorts ids and drops duplicates, which an IN list with a
+// repeated value produces.
+func uniqueRowIDs(ids []storage.RowID) []storage.RowID {
+	ids = storage.SortRowIDs(ids)
+	out := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
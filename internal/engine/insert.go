@@ -8,18 +8,30 @@ import (
 
 // Insert inserts a new row into a table
 func (e *Engine) Insert(tableName string, data map[string]any) error {
+	_, err := e.InsertRow(tableName, data)
+	return err
+}
+
+// InsertRow inserts a new row into a table and returns it as stored,
+// including the values generated for identity columns.
+func (e *Engine) InsertRow(tableName string, data map[string]any) (*storage.Row, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	if tableName == "" {
-		return fmt.Errorf("table name cannot be empty")
+		return nil, fmt.Errorf("table name cannot be empty")
 	}
 
 	table, exists := e.db.Tables[tableName]
 	if !exists {
-		return fmt.Errorf("table %s does not exist", tableName)
+		return nil, fmt.Errorf("table %s does not exist", tableName)
 	}
 
-	row := &storage.Row{
-		Data: data,
+	row, err := e.insert(table, data)
+	if err != nil {
+		return nil, err
 	}
 
-	return table.Insert(row)
-}
\ No newline at end of file
+	e.maybeCheckpoint()
+	return row, nil
+}
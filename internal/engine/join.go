@@ -0,0 +1,853 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Scope
+// --------------------------
+
+// source is a table of FROM under the name its columns are qualified with:
+// its alias, or else the table's own name. A derived table, a subquery in
+// FROM, has no table but the operators of its query and the names and
+// types of its result columns.
+type source struct {
+	name    string
+	table   *storage.Table
+	derived operator
+	columns []string             // of a derived table
+	types   []storage.ColumnType // of a derived table; "" where unknown
+}
+
+// has reports whether the source has a column called name.
+func (src *source) has(name string) bool {
+	if src.table != nil {
+		return findColumn(src.table, name) != nil
+	}
+	for _, col := range src.columns {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnNames returns the names of the source's columns in order.
+func (src *source) columnNames() []string {
+	if src.table == nil {
+		return src.columns
+	}
+	names := make([]string, len(src.table.Columns))
+	for i, col := range src.table.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// columnType returns the type of the source's column called name, or ""
+// when it is not known.
+func (src *source) columnType(name string) storage.ColumnType {
+	if src.table != nil {
+		if col := findColumn(src.table, name); col != nil {
+			return col.ColumnType
+		}
+		return ""
+	}
+	for i, col := range src.columns {
+		if col == name && i < len(src.types) {
+			return src.types[i]
+		}
+	}
+	return ""
+}
+
+// label names the source in errors: its table, or its alias when derived.
+func (src *source) label() string {
+	if src.table == nil {
+		return src.name
+	}
+	return src.table.Name
+}
+
+// scope resolves the column references of a statement against the tables
+// of its FROM. With a single table, references resolve to the table's bare
+// column names, as its rows hold them. Once tables are joined, every
+// reference resolves to the qualified name its column has in the joined
+// rows, and a column of a USING list to the value both tables share.
+//
+// The scope of a subquery has the scope of the enclosing query as parent.
+// References to the columns of the enclosing query resolve to OuterRefs
+// reading the row in outer, which makes the subquery correlated.
+type scope struct {
+	e       *Engine
+	sources []source
+	joined  bool
+
+	using  map[string]expr.Expr // USING column -> its merged value
+	merged []string             // USING columns, in the order they came
+	hidden map[string]bool      // qualified columns merged by USING
+
+	parent *scope
+	outer  *expr.OuterRow
+	sub    *subplan // the subquery the scope belongs to
+	refs   int      // references to the enclosing queries
+
+	ctes map[string]*cte // queries of WITH, read like tables
+}
+
+func newScope(e *Engine, joined bool) *scope {
+	return &scope{e: e, joined: joined, using: make(map[string]expr.Expr), hidden: make(map[string]bool)}
+}
+
+func (s *scope) add(src source) error {
+	for _, other := range s.sources {
+		if other.name == src.name {
+			return fmt.Errorf("table name '%s' is specified more than once", src.name)
+		}
+	}
+	s.sources = append(s.sources, src)
+	return nil
+}
+
+// find returns the source a column reference reads from.
+func (s *scope) find(ref *expr.ColumnRef) (*source, error) {
+	if ref.Table != "" {
+		for i := range s.sources {
+			src := &s.sources[i]
+			if src.name != ref.Table {
+				continue
+			}
+			if !src.has(ref.Name) {
+				return nil, fmt.Errorf("column '%s' does not exist in table '%s'", ref.Name, src.label())
+			}
+			return src, nil
+		}
+		return nil, fmt.Errorf("missing FROM-clause entry for table '%s'", ref.Table)
+	}
+
+	var found *source
+	for i := range s.sources {
+		src := &s.sources[i]
+		if !src.has(ref.Name) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("column reference '%s' is ambiguous", ref.Name)
+		}
+		found = src
+	}
+	if found == nil {
+		if len(s.sources) == 1 && s.sources[0].name != "" {
+			return nil, fmt.Errorf("column '%s' does not exist in table '%s'", ref.Name, s.sources[0].label())
+		}
+		return nil, fmt.Errorf("column '%s' does not exist", ref.Name)
+	}
+	return found, nil
+}
+
+// column is the reference to a column of src in the rows of the scope.
+func (s *scope) column(src *source, name string) *expr.ColumnRef {
+	if !s.joined {
+		return &expr.ColumnRef{Name: name}
+	}
+	return &expr.ColumnRef{Table: src.name, Name: name}
+}
+
+// declares reports whether ref reads a table of the scope itself rather
+// than one of an enclosing query.
+func (s *scope) declares(ref *expr.ColumnRef) bool {
+	if ref.Table == "" && s.using[ref.Name] != nil {
+		return true
+	}
+	for i := range s.sources {
+		src := &s.sources[i]
+		if ref.Table == src.name || ref.Table == "" && src.has(ref.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve rewrites the column references of e to read the rows of the
+// scope, and plans the subqueries of e. A nil expression stays nil.
+func (s *scope) resolve(e expr.Expr) (expr.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	var err error
+	out := expr.Replace(e, func(n expr.Expr) (expr.Expr, bool) {
+		if err != nil {
+			return nil, false
+		}
+		var out expr.Expr
+		switch n := n.(type) {
+		case *expr.Subquery:
+			out, err = s.subquery(n)
+		case *expr.ColumnRef:
+			out, err = s.resolveRef(n)
+		default:
+			return nil, false
+		}
+		if err != nil {
+			return n, true
+		}
+		return out, true
+	})
+	return out, err
+}
+
+func (s *scope) resolveRef(ref *expr.ColumnRef) (expr.Expr, error) {
+	if s.parent != nil && !s.declares(ref) {
+		return s.outerRef(ref)
+	}
+	if merged := s.using[ref.Name]; merged != nil && ref.Table == "" {
+		return merged, nil
+	}
+	src, err := s.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.column(src, ref.Name), nil
+}
+
+// outerRef resolves a reference to a column of an enclosing query. The
+// value is read from the row the enclosing query is at when the subquery
+// runs.
+func (s *scope) outerRef(ref *expr.ColumnRef) (expr.Expr, error) {
+	value, err := s.parent.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Replace(value, func(n expr.Expr) (expr.Expr, bool) {
+		switch n := n.(type) {
+		case *expr.ColumnRef:
+			s.refs++
+			return &expr.OuterRef{Name: n.String(), Row: s.outer}, true
+		case *expr.OuterRef:
+			s.refs++ // of a query further out, constant here too
+			return n, true
+		}
+		return nil, false
+	}), nil
+}
+
+// resolveAll resolves each expression of list into a new list.
+func (s *scope) resolveAll(list []expr.Expr) ([]expr.Expr, error) {
+	if list == nil {
+		return nil, nil
+	}
+	out := make([]expr.Expr, len(list))
+	for i, e := range list {
+		var err error
+		if out[i], err = s.resolve(e); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// resolvePlan resolves the expressions of a SELECT, INSERT, UPDATE or
+// DELETE plan. The plan shares its select list and GROUP BY with the
+// parsed query, so they are replaced rather than changed.
+func (s *scope) resolvePlan(plan *planner.Plan) error {
+	var err error
+	for col, v := range plan.Values {
+		if x, ok := v.(expr.Expr); ok {
+			if plan.Values[col], err = s.resolve(x); err != nil {
+				return err
+			}
+		}
+	}
+	if plan.Where, err = s.resolve(plan.Where); err != nil {
+		return err
+	}
+	if plan.Having, err = s.resolve(plan.Having); err != nil {
+		return err
+	}
+	if plan.Select, err = s.resolveAll(plan.Select); err != nil {
+		return err
+	}
+	if plan.GroupBy, err = s.resolveAll(plan.GroupBy); err != nil {
+		return err
+	}
+	if plan.DistinctOn, err = s.resolveAll(plan.DistinctOn); err != nil {
+		return err
+	}
+	for i, k := range plan.OrderBy {
+		if plan.OrderBy[i].Expr, err = s.resolve(k.Expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge joins the columns of a USING list: it returns the conditions that
+// the left and right values are equal, and from then on resolves each
+// column to the value the joined row has for it. That is the left value,
+// the right one for a right join, and whichever is not NULL for a full
+// join. right must not have been added to the scope yet.
+func (s *scope) merge(typ parser.JoinType, right source, cols []string) ([]expr.Expr, error) {
+	terms := make([]expr.Expr, len(cols))
+	values := make([]expr.Expr, len(cols))
+	for i, col := range cols {
+		if !right.has(col) {
+			return nil, fmt.Errorf("column '%s' in USING does not exist in table '%s'", col, right.label())
+		}
+		l, err := s.resolve(&expr.ColumnRef{Name: col})
+		if err != nil {
+			return nil, err
+		}
+		r := &expr.ColumnRef{Table: right.name, Name: col}
+		terms[i] = &expr.Binary{Op: "=", L: l, R: r}
+
+		switch typ {
+		case parser.RightJoin:
+			values[i] = r
+		case parser.FullJoin:
+			values[i] = &expr.Call{Name: "COALESCE", Args: []expr.Expr{l, r}}
+		default:
+			values[i] = l
+		}
+		if ref, ok := l.(*expr.ColumnRef); ok {
+			s.hidden[ref.String()] = true
+		}
+		s.hidden[r.String()] = true
+	}
+
+	for i, col := range cols {
+		if s.using[col] == nil {
+			s.merged = append(s.merged, col)
+		}
+		s.using[col] = values[i]
+	}
+	return terms, nil
+}
+
+// star returns the columns of SELECT * over the scope: the columns merged
+// by USING, then every other column of each table in FROM order.
+func (s *scope) star() (names []string, exprs []expr.Expr) {
+	for _, col := range s.merged {
+		names = append(names, col)
+		exprs = append(exprs, s.using[col])
+	}
+	for i := range s.sources {
+		src := &s.sources[i]
+		for _, col := range src.columnNames() {
+			ref := s.column(src, col)
+			if s.hidden[ref.String()] {
+				continue
+			}
+			names = append(names, col)
+			exprs = append(exprs, ref)
+		}
+	}
+	return names, exprs
+}
+
+// outputNames names the result columns of a join. Columns keep their
+// bare names, except that columns of different tables with the same name
+// are told apart by their qualified names.
+func outputNames(names []string, exprs []expr.Expr) []string {
+	count := make(map[string]int, len(names))
+	for _, name := range names {
+		count[name]++
+	}
+
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = name
+		if ref, ok := exprs[i].(*expr.ColumnRef); ok && count[name] > 1 {
+			out[i] = ref.String()
+		}
+	}
+	return out
+}
+
+// tablesOf returns the names of the tables whose columns e reads.
+func tablesOf(e expr.Expr) map[string]bool {
+	tables := make(map[string]bool)
+	expr.Walk(e, func(n expr.Expr) {
+		if ref, ok := n.(*expr.ColumnRef); ok {
+			tables[ref.Table] = true
+		}
+	})
+	return tables
+}
+
+// unqualified drops the table from the column references of e, so that it
+// reads the rows of a single table.
+func unqualified(e expr.Expr) expr.Expr {
+	if e == nil {
+		return nil
+	}
+	return expr.Replace(e, func(n expr.Expr) (expr.Expr, bool) {
+		if ref, ok := n.(*expr.ColumnRef); ok {
+			return &expr.ColumnRef{Name: ref.Name}, true
+		}
+		return nil, false
+	})
+}
+
+// --------------------------
+// Building joins
+// --------------------------
+
+// buildJoin builds the operators of a SELECT over joined tables: a scan of
+// each table, joined left to right, then the part of WHERE that could not
+// be checked earlier, then the rest of the SELECT. A derived table takes
+// the place of a scan with the operators of its query.
+//
+// A conjunct of WHERE that reads a single table is checked by that table's
+// scan, and one that reads several by the first join that has them all,
+// unless an outer join pads one of its tables with NULLs. The conjuncts of
+// an inner or left join's ON that read only the joined table are checked
+// by its scan. Conjuncts with a correlated subquery are checked on the
+// joined rows, whose columns it reads by their qualified names.
+func (e *Engine) buildJoin(plan *planner.Plan, s *scope) (operator, error) {
+	s.joined = true
+	conds := make([][]expr.Expr, len(plan.Joins))
+
+	first, err := s.fromSource(plan.TableName, plan.Derived, plan.Alias)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.add(first); err != nil {
+		return nil, err
+	}
+
+	// ON may only refer to the tables joined so far
+	for i, join := range plan.Joins {
+		right, err := s.fromSource(join.Table, join.Derived, join.Alias)
+		if err != nil {
+			return nil, err
+		}
+
+		var terms []expr.Expr
+		if len(join.Using) > 0 {
+			if terms, err = s.merge(join.Type, right, join.Using); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.add(right); err != nil {
+			return nil, err
+		}
+		on, err := s.resolve(join.On)
+		if err != nil {
+			return nil, err
+		}
+		conds[i] = append(terms, planner.Conjuncts(on)...)
+	}
+
+	names, exprs, err := s.selectList(plan)
+	if err != nil {
+		return nil, err
+	}
+	plan.Select = exprs
+	if err := orderByPositions(plan.OrderBy, exprs); err != nil {
+		return nil, err
+	}
+	if err := s.resolvePlan(plan); err != nil {
+		return nil, err
+	}
+	s.decorrelate(plan)
+	plan.Columns = outputNames(names, plan.Select)
+	if err := checkOutputNames(plan.Columns); err != nil {
+		return nil, err
+	}
+
+	// Tables an outer join pads with NULLs
+	index := make(map[string]int, len(s.sources))
+	for i, src := range s.sources {
+		index[src.name] = i
+	}
+	padded := make([]bool, len(s.sources))
+	for i, join := range plan.Joins {
+		if join.Type == parser.LeftJoin || join.Type == parser.FullJoin {
+			padded[i+1] = true
+		}
+		if join.Type == parser.RightJoin || join.Type == parser.FullJoin {
+			for j := 0; j <= i; j++ {
+				padded[j] = true
+			}
+		}
+	}
+
+	pushed := make([][]expr.Expr, len(s.sources))
+	var rest []expr.Expr
+	for _, term := range planner.Conjuncts(plan.Where) {
+		tables := tablesOf(term)
+		last, early := -1, true
+		for name := range tables {
+			i := index[name]
+			last = max(last, i)
+			early = early && !padded[i]
+		}
+		switch {
+		case last < 0 || !early || correlatedSubquery(term):
+			rest = append(rest, term)
+		case len(tables) == 1:
+			pushed[last] = append(pushed[last], term)
+		default:
+			conds[last-1] = append(conds[last-1], term)
+		}
+	}
+	for i, join := range plan.Joins {
+		if join.Type != parser.InnerJoin && join.Type != parser.LeftJoin {
+			continue
+		}
+		var kept []expr.Expr
+		for _, term := range conds[i] {
+			if tables := tablesOf(term); len(tables) == 1 && tables[s.sources[i+1].name] && !correlatedSubquery(term) {
+				pushed[i+1] = append(pushed[i+1], term)
+				continue
+			}
+			kept = append(kept, term)
+		}
+		conds[i] = kept
+	}
+
+	// The rows of a derived table are qualified already
+	scans := make([]operator, len(s.sources))
+	for i, src := range s.sources {
+		cond := planner.Conjoin(pushed[i])
+		switch {
+		case src.table != nil:
+			where := unqualified(cond)
+			scan := newScan(src.table, where, planner.ChooseAccess(src.table, where))
+			scan.alias = src.name
+			scans[i] = scan
+		case cond != nil:
+			scans[i] = &filterOp{input: src.derived, cond: cond}
+		default:
+			scans[i] = src.derived
+		}
+	}
+
+	var op operator = scans[0]
+	left := planner.JoinInput{Tables: map[string]*storage.Table{s.sources[0].name: s.sources[0].table}}
+	left.Rows, left.Cost = scans[0].estimate()
+	for i, join := range plan.Joins {
+		src := s.sources[i+1]
+		right := planner.JoinInput{Tables: map[string]*storage.Table{src.name: src.table}}
+		right.Rows, right.Cost = scans[i+1].estimate()
+		path := planner.ChooseJoin(join.Type, left, right, conds[i])
+		op = newJoin(join.Type, op, scans[i+1], path)
+
+		left.Tables[src.name] = src.table
+		left.Rows, left.Cost = path.Rows, path.Cost
+	}
+	if len(rest) > 0 {
+		op = &filterOp{input: op, cond: planner.Conjoin(rest)}
+	}
+
+	if plan.Grouped {
+		return e.buildAggregate(plan, op, s)
+	}
+	return e.buildOutput(plan, op, plan.OrderBy, plan.Select, plan.DistinctOn)
+}
+
+// fromSource looks up a table of FROM, a query of WITH first, or builds
+// the operators of a derived table. A derived table cannot refer to the
+// other tables of FROM. A SELECT without FROM reads a single row without
+// columns.
+func (s *scope) fromSource(table string, derived *parser.Query, alias string) (source, error) {
+	if table == "" && derived == nil {
+		return source{derived: &resultOp{}}, nil
+	}
+	if derived == nil {
+		if c := s.ctes[table]; c != nil {
+			return s.cteSource(c, alias)
+		}
+		t, ok := s.e.db.Tables[table]
+		if !ok {
+			return source{}, fmt.Errorf("table '%s' does not exist", table)
+		}
+		return source{name: sourceName(table, alias), table: t}, nil
+	}
+
+	plan, err := planner.CreatePlan(derived, s.e.db)
+	if err != nil {
+		return source{}, err
+	}
+	inner := newScope(s.e, false)
+	inner.ctes = s.ctes
+	op, err := s.e.build(plan, inner)
+	if err != nil {
+		return source{}, err
+	}
+
+	columns, types := resultNames(plan, inner), resultTypes(plan, inner)
+	seen := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		if seen[col] {
+			return source{}, fmt.Errorf("column '%s' is specified more than once in subquery '%s'", col, alias)
+		}
+		seen[col] = true
+	}
+	return source{
+		name:    alias,
+		derived: &derivedOp{input: op, alias: alias, columns: columns},
+		columns: columns,
+		types:   types,
+	}, nil
+}
+
+// sourceName is the name the columns of a table in FROM are qualified
+// with.
+func sourceName(table, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return table
+}
+
+// --------------------------
+// Join
+// --------------------------
+
+// joinOp holds what the join operators share: for each row of left, the
+// right rows that pass the condition are joined to it. A left or full
+// join also keeps left rows that join no right row, and a right or full
+// join right rows that join no left row, with NULL for the columns of the
+// other side; they come after the joined rows.
+type joinOp struct {
+	opStats
+	typ         parser.JoinType
+	left, right operator
+	path        *planner.JoinPath
+	residual    expr.Expr
+}
+
+// nestedLoopJoinOp checks the condition against every pair of rows.
+type nestedLoopJoinOp struct {
+	joinOp
+}
+
+// hashJoinOp puts the right rows in a hash table by the equi-join keys,
+// so that each left row is only checked against the rows with its keys.
+type hashJoinOp struct {
+	joinOp
+}
+
+func newJoin(typ parser.JoinType, left, right operator, path *planner.JoinPath) operator {
+	op := joinOp{typ: typ, left: left, right: right, path: path, residual: planner.Conjoin(path.Residual)}
+	if path.Method == planner.HashJoin {
+		return &hashJoinOp{op}
+	}
+	return &nestedLoopJoinOp{op}
+}
+
+func (op *joinOp) details() []string {
+	var details []string
+	if len(op.path.LeftKeys) > 0 {
+		keys := make([]string, len(op.path.LeftKeys))
+		for i := range op.path.LeftKeys {
+			keys[i] = op.path.LeftKeys[i].String() + " = " + op.path.RightKeys[i].String()
+		}
+		details = append(details, "Hash Cond: "+strings.Join(keys, " AND "))
+	}
+	if op.residual != nil {
+		details = append(details, "Join Filter: "+op.residual.String())
+	}
+	return details
+}
+
+// name names a join operator after its method and, for outer joins,
+// its type, as in "Hash Left Join".
+func (op *joinOp) name(method string) string {
+	switch op.typ {
+	case parser.InnerJoin, parser.CrossJoin:
+		return method
+	}
+	outer := string(op.typ[:1]) + strings.ToLower(string(op.typ[1:]))
+	return strings.TrimSuffix(method, " Join") + " " + outer + " Join"
+}
+
+func (op *joinOp) estimate() (float64, float64) { return op.path.Rows, op.path.Cost }
+
+func (op *joinOp) inputs() []operator { return []operator{op.left, op.right} }
+
+func (op *nestedLoopJoinOp) describe() (string, []string) {
+	return op.name("Nested Loop"), op.details()
+}
+
+func (op *hashJoinOp) describe() (string, []string) {
+	return op.name("Hash Join"), op.details()
+}
+
+func (op *nestedLoopJoinOp) run(e *Engine) ([]*storage.Row, error) {
+	return op.join(e, func([]*storage.Row) (func(*storage.Row) ([]int, error), error) {
+		return nil, nil
+	})
+}
+
+func (op *hashJoinOp) run(e *Engine) ([]*storage.Row, error) {
+	return op.join(e, func(rrows []*storage.Row) (func(*storage.Row) ([]int, error), error) {
+		table := make(map[string][]int)
+		for i, row := range rrows {
+			key, ok, err := joinKey(op.path.RightKeys, row.Data)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				table[key] = append(table[key], i)
+			}
+		}
+
+		return func(row *storage.Row) ([]int, error) {
+			key, ok, err := joinKey(op.path.LeftKeys, row.Data)
+			if err != nil || !ok {
+				return nil, err
+			}
+			return table[key], nil
+		}, nil
+	})
+}
+
+// join runs both inputs and joins their rows. build is given the right
+// rows and returns the function giving the positions of the right rows
+// that may join a left row; a nil function stands for all of them.
+func (op *joinOp) join(e *Engine, build func([]*storage.Row) (func(*storage.Row) ([]int, error), error)) ([]*storage.Row, error) {
+	lrows, err := e.runOperator(op.left)
+	if err != nil {
+		return nil, err
+	}
+	rrows, err := e.runOperator(op.right)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := build(rrows)
+	if err != nil {
+		return nil, err
+	}
+	if op.residual != nil {
+		expr.BindSequences(op.residual, sequenceSource{e})
+	}
+
+	all := make([]int, len(rrows))
+	for i := range all {
+		all[i] = i
+	}
+	joined := make([]bool, len(rrows))
+	var out []*storage.Row
+	for _, l := range lrows {
+		positions := all
+		if candidates != nil {
+			if positions, err = candidates(l); err != nil {
+				return nil, err
+			}
+		}
+
+		found := false
+		for _, i := range positions {
+			row := combine(l, rrows[i])
+			ok, err := matches(row, op.residual)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, row)
+				found = true
+				joined[i] = true
+			}
+		}
+		if !found && (op.typ == parser.LeftJoin || op.typ == parser.FullJoin) {
+			out = append(out, l)
+		}
+	}
+
+	if op.typ == parser.RightJoin || op.typ == parser.FullJoin {
+		for i, r := range rrows {
+			if !joined[i] {
+				out = append(out, r)
+			}
+		}
+	}
+	return out, nil
+}
+
+// combine returns a row holding the columns of both l and r.
+func combine(l, r *storage.Row) *storage.Row {
+	data := make(map[string]any, len(l.Data)+len(r.Data))
+	for col, v := range l.Data {
+		data[col] = v
+	}
+	for col, v := range r.Data {
+		data[col] = v
+	}
+	return &storage.Row{Data: data}
+}
+
+// joinKey evaluates the equi-join keys of a row and encodes them for the
+// hash table. Numbers are encoded by value, so that an INT key finds an
+// equal FLOAT one as "=" would. ok is false when a key is NULL.
+func joinKey(keys []expr.Expr, row map[string]any) (key string, ok bool, err error) {
+	values := make([]any, len(keys))
+	for i, k := range keys {
+		v, err := k.Eval(row)
+		if err != nil || v == nil {
+			return "", false, err
+		}
+		values[i] = keyValue(v)
+	}
+	return hashKey(values), true, nil
+}
+
+// keyValue returns a whole FLOAT as the INT it equals, for hashing.
+func keyValue(v any) any {
+	if f, isFloat := v.(float64); isFloat && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return int64(f)
+	}
+	return v
+}
+
+// --------------------------
+// Filter
+// --------------------------
+
+// filterOp keeps the rows of its input that satisfy cond.
+type filterOp struct {
+	opStats
+	input operator
+	cond  expr.Expr
+}
+
+func (op *filterOp) describe() (string, []string) {
+	return "Filter", []string{"Filter: " + op.cond.String()}
+}
+
+func (op *filterOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	return planner.FilterRows(rows, planner.Conjuncts(op.cond)), cost + rows
+}
+
+func (op *filterOp) inputs() []operator { return []operator{op.input} }
+
+func (op *filterOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+	expr.BindSequences(op.cond, sequenceSource{e})
+
+	out := []*storage.Row{}
+	for _, row := range rows {
+		ok, err := matches(row, op.cond)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// insert, update and delete are the only ways rows change. Both the Go API
+// and ExecutePlan go through them. Each builds a storage.ChangeSet holding
+// the statement's rows and every row touched by foreign key actions, which
+// is validated as a whole, logged, and only then applied.
+
+// insert prepares data as a new row of table and inserts it. The returned
+// row holds the values stored, including generated identity values.
+func (e *Engine) insert(table *storage.Table, data map[string]any) (*storage.Row, error) {
+	rows, err := e.insertAll(table, []map[string]any{data})
+	if err != nil {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// insertAll inserts a row for each of values as a single change: either
+// all of them are inserted or none is.
+func (e *Engine) insertAll(table *storage.Table, values []map[string]any) ([]*storage.Row, error) {
+	cs := e.db.NewChangeSet()
+	inserted := make([]*storage.Row, len(values))
+	for i, data := range values {
+		data, err := e.evalValues(table, data, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = e.fillIdentity(table, data)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = table.PrepareInsert(data)
+		if err != nil {
+			return nil, err
+		}
+
+		cs.Insert(table, data)
+		inserted[i] = &storage.Row{Data: data}
+	}
+
+	if err := e.commit(cs); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// update sets values on the rows with the given IDs. Values computed when
+// the statement runs, such as nextval('seq'), are evaluated once per row.
+func (e *Engine) update(table *storage.Table, ids []storage.RowID, values map[string]any) ([]*storage.Row, error) {
+	cs := e.db.NewChangeSet()
+
+	// Rows are returned as they will look after the update
+	updated := make([]*storage.Row, 0, len(ids))
+	for _, id := range ids {
+		rowValues, err := e.evalValues(table, values, table.Row(id).Data)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.checkIdentityUpdate(table, rowValues); err != nil {
+			return nil, err
+		}
+		rowValues, err = table.Coerce(rowValues)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := cs.Update(table, id, rowValues); err != nil {
+			return nil, err
+		}
+
+		row := table.Row(id)
+		data := make(map[string]any, len(row.Data))
+		for col, val := range row.Data {
+			data[col] = val
+		}
+		for col, val := range rowValues {
+			data[col] = val
+		}
+		updated = append(updated, &storage.Row{Data: data})
+	}
+
+	if err := e.commit(cs); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// delete removes the rows with the given IDs.
+func (e *Engine) delete(table *storage.Table, ids []storage.RowID) ([]*storage.Row, error) {
+	cs := e.db.NewChangeSet()
+	deleted := make([]*storage.Row, 0, len(ids))
+	for _, id := range ids {
+		deleted = append(deleted, table.Row(id))
+		if err := cs.Delete(table, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.commit(cs); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// commit validates, logs and applies a change set.
+func (e *Engine) commit(cs *storage.ChangeSet) error {
+	if err := cs.Validate(); err != nil {
+		return err
+	}
+
+	if err := e.logChanges(cs.Records()...); err != nil {
+		return err
+	}
+
+	return cs.Apply()
+}
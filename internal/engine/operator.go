@@ -0,0 +1,481 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Operators
+// --------------------------
+
+// SELECT, INSERT, UPDATE and DELETE run as a tree of operators built from
+// their plan; the same tree is what EXPLAIN shows. Operators are
+// materialized: each produces all of its rows when it runs, running its
+// inputs first.
+type operator interface {
+	// describe returns the operator's name, such as "Index Scan using
+	// people_age on people", and the details EXPLAIN lists under it
+	describe() (string, []string)
+
+	// estimate returns the number of rows the operator is expected to
+	// produce and the cost of producing them, including its inputs
+	estimate() (rows, cost float64)
+
+	inputs() []operator
+	run(e *Engine) ([]*storage.Row, error)
+	stats() *opStats
+}
+
+// opStats records what an operator did when it ran.
+type opStats struct {
+	ran     bool
+	rows    int
+	elapsed time.Duration
+}
+
+func (s *opStats) stats() *opStats { return s }
+
+// runOperator runs op and records its stats. Operators run their inputs
+// through it too, so the time of an operator includes its inputs.
+func (e *Engine) runOperator(op operator) ([]*storage.Row, error) {
+	start := time.Now()
+	rows, err := op.run(e)
+
+	s := op.stats()
+	s.ran = true
+	s.rows = len(rows)
+	s.elapsed = time.Since(start)
+	return rows, err
+}
+
+// buildOperator checks a SELECT, INSERT, UPDATE or DELETE plan against the
+// table's columns and builds its operators, choosing the access path.
+func (e *Engine) buildOperator(plan *planner.Plan) (operator, error) {
+	return e.build(plan, newScope(e, false))
+}
+
+// build builds the operators of a plan whose references resolve in s, the
+// empty scope of the statement or of one of its subqueries.
+func (e *Engine) build(plan *planner.Plan, s *scope) (operator, error) {
+	if err := s.with(plan.With); err != nil {
+		return nil, err
+	}
+	if plan.SetOp != "" {
+		return e.buildSetOp(plan, s)
+	}
+	if plan.Type == planner.SelectPlan && (len(plan.Joins) > 0 || plan.Derived != nil || plan.TableName == "" || s.ctes[plan.TableName] != nil) {
+		return e.buildJoin(plan, s)
+	}
+
+	table, ok := e.db.Tables[plan.TableName]
+	if !ok {
+		return nil, fmt.Errorf("table '%s' does not exist", plan.TableName)
+	}
+
+	checkColumn := func(col string) error {
+		if !e.TableHasColumn(plan.TableName, col) {
+			return fmt.Errorf("column '%s' does not exist in table '%s'", col, plan.TableName)
+		}
+		return nil
+	}
+	for col := range plan.Values {
+		if err := checkColumn(col); err != nil {
+			return nil, err
+		}
+	}
+
+	// References may be qualified by the table's name or alias
+	if err := s.add(source{name: sourceName(plan.TableName, plan.Alias), table: table}); err != nil {
+		return nil, err
+	}
+	// SELECT * keeps the rows of the table unless DISTINCT compares them
+	if plan.Type == planner.SelectPlan && (plan.Select != nil || plan.Distinct) {
+		var err error
+		if plan.Columns, plan.Select, err = s.selectList(plan); err != nil {
+			return nil, err
+		}
+		if err := checkOutputNames(plan.Columns); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Type == planner.SelectPlan {
+		_, exprs, err := s.selectList(plan)
+		if err != nil {
+			return nil, err
+		}
+		if err := orderByPositions(plan.OrderBy, exprs); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.resolvePlan(plan); err != nil {
+		return nil, err
+	}
+	s.decorrelate(plan)
+	// The planner leaves the access path to a WHERE that only has all of
+	// its values once resolved
+	if plan.Access == nil {
+		var keys []planner.SortKey
+		if plan.Type == planner.SelectPlan && !plan.Grouped {
+			keys = plan.OrderBy
+		}
+		plan.Access = planner.ChooseOrderedAccess(table, plan.Where, keys, sourceName(plan.TableName, plan.Alias))
+	}
+	scan := newScan(table, plan.Where, plan.Access)
+
+	switch plan.Type {
+	case planner.SelectPlan:
+		if plan.Grouped {
+			return e.buildAggregate(plan, scan, s)
+		}
+		return e.buildOutput(plan, scan, plan.OrderBy, plan.Select, plan.DistinctOn)
+
+	case planner.InsertPlan:
+		if plan.Source == nil {
+			return &insertOp{table: table, values: plan.Values}, nil
+		}
+
+		// INSERT ... SELECT fills the columns in order
+		for _, col := range plan.Columns {
+			if err := checkColumn(col); err != nil {
+				return nil, err
+			}
+		}
+		source, err := planner.CreatePlan(plan.Source, e.db)
+		if err != nil {
+			return nil, err
+		}
+		inner := newScope(e, false)
+		inner.ctes = s.ctes
+		op, err := e.build(source, inner)
+		if err != nil {
+			return nil, err
+		}
+		names := resultNames(source, inner)
+		if len(names) != len(plan.Columns) {
+			return nil, fmt.Errorf("INSERT has %d target columns but the query returns %d", len(plan.Columns), len(names))
+		}
+		return &insertOp{table: table, source: op, sourceColumns: names, columns: plan.Columns}, nil
+
+	case planner.UpdatePlan:
+		return &updateOp{table: table, scan: scan, values: plan.Values}, nil
+
+	case planner.DeletePlan:
+		return &deleteOp{table: table, scan: scan}, nil
+
+	default:
+		return nil, fmt.Errorf("%s has no operators", plan.Type)
+	}
+}
+
+// runPlan builds the operators of plan and runs them.
+func (e *Engine) runPlan(plan *planner.Plan) ([]*storage.Row, error) {
+	op, err := e.buildOperator(plan)
+	if err != nil {
+		return nil, err
+	}
+	return e.runOperator(op)
+}
+
+// --------------------------
+// Scan
+// --------------------------
+
+// scanOp reads the rows of a table that satisfy where through the access
+// path the planner chose. The scan of a joined table qualifies the columns
+// of its rows with alias.
+type scanOp struct {
+	opStats
+	table  *storage.Table
+	access *planner.AccessPath
+	where  expr.Expr
+	alias  string
+
+	ids []storage.RowID // IDs of the rows returned, for UPDATE and DELETE
+}
+
+func newScan(table *storage.Table, where expr.Expr, access *planner.AccessPath) *scanOp {
+	return &scanOp{table: table, access: access, where: where}
+}
+
+func (op *scanOp) describe() (string, []string) {
+	on := op.table.Name
+	if op.alias != "" && op.alias != op.table.Name {
+		on += " " + op.alias
+	}
+
+	var name, label string
+	switch op.access.Type {
+	case planner.PrimaryKeyLookup:
+		name, label = "Primary Key Lookup on "+on, "Key"
+	case planner.IndexScan:
+		name, label = "Index Scan using "+op.access.Index+" on "+on, "Index Cond"
+	default:
+		name = "Full Scan on " + on
+	}
+
+	var details []string
+	if len(op.access.Filters) > 0 {
+		cond := make([]string, len(op.access.Filters))
+		for i, f := range op.access.Filters {
+			cond[i] = f.String()
+		}
+		details = append(details, label+": "+strings.Join(cond, " AND "))
+	}
+	if rest := planner.Conjoin(op.access.Residual); rest != nil {
+		details = append(details, "Filter: "+rest.String())
+	}
+	return name, details
+}
+
+func (op *scanOp) estimate() (float64, float64) {
+	return op.access.Rows, op.access.Cost
+}
+
+// ordered reports whether the scan returns its rows in the order of the
+// SELECT's ORDER BY, through an index the planner chose for that.
+func (op *scanOp) ordered() bool {
+	return op.access.Ordered && findIndex(op.table, op.access.Index) != nil
+}
+
+func (op *scanOp) inputs() []operator { return nil }
+
+func (op *scanOp) run(e *Engine) ([]*storage.Row, error) {
+	filters, err := e.evalFilters(op.access.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.where != nil {
+		expr.BindSequences(op.where, sequenceSource{e})
+	}
+	op.ids, err = matchingRows(op.table, op.access, filters, op.where)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*storage.Row, len(op.ids))
+	for i, id := range op.ids {
+		rows[i] = op.table.Row(id)
+		if op.alias == "" {
+			continue
+		}
+		data := make(map[string]any, len(rows[i].Data))
+		for col, v := range rows[i].Data {
+			data[op.alias+"."+col] = v
+		}
+		rows[i] = &storage.Row{Data: data}
+	}
+	return rows, nil
+}
+
+// --------------------------
+// Result
+// --------------------------
+
+// resultOp produces the single row, without columns, that a SELECT
+// without FROM computes its select list from.
+type resultOp struct {
+	opStats
+}
+
+func (op *resultOp) describe() (string, []string) { return "Result", nil }
+
+func (op *resultOp) estimate() (float64, float64) { return 1, 0 }
+
+func (op *resultOp) inputs() []operator { return nil }
+
+func (op *resultOp) run(e *Engine) ([]*storage.Row, error) {
+	return []*storage.Row{{Data: map[string]any{}}}, nil
+}
+
+// --------------------------
+// Project
+// --------------------------
+
+// projectOp keeps the selected columns of each row. Columns a row has no
+// value for are NULL. When exprs is set, each result column is computed
+// from the expression at the same position instead.
+type projectOp struct {
+	opStats
+	input   operator
+	columns []string
+	exprs   []expr.Expr
+}
+
+func (op *projectOp) describe() (string, []string) {
+	return "Project", []string{"Columns: " + strings.Join(op.columns, ", ")}
+}
+
+func (op *projectOp) estimate() (float64, float64) {
+	return op.input.estimate()
+}
+
+func (op *projectOp) inputs() []operator { return []operator{op.input} }
+
+func (op *projectOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+	if len(op.columns) == 1 && op.columns[0] == "*" {
+		return rows, nil
+	}
+
+	// nextval in the select list advances once per output row
+	for _, x := range op.exprs {
+		expr.BindSequences(x, sequenceSource{e})
+	}
+
+	out := make([]*storage.Row, len(rows))
+	for i, row := range rows {
+		data := make(map[string]any, len(op.columns))
+		for j, col := range op.columns {
+			if op.exprs == nil {
+				data[col] = row.Data[col]
+				continue
+			}
+			v, err := op.exprs[j].Eval(row.Data)
+			if err != nil {
+				return nil, err
+			}
+			data[col] = v
+		}
+		out[i] = &storage.Row{Data: data}
+	}
+	return out, nil
+}
+
+// --------------------------
+// Insert, Update, Delete
+// --------------------------
+
+// insertOp inserts a row of values, or for INSERT ... SELECT the rows of
+// source, whose columns sourceColumns fill columns in order.
+type insertOp struct {
+	opStats
+	table  *storage.Table
+	values map[string]any
+
+	source        operator
+	sourceColumns []string
+	columns       []string
+}
+
+func (op *insertOp) describe() (string, []string) {
+	if op.source != nil {
+		return "Insert on " + op.table.Name, []string{"Columns: " + strings.Join(op.columns, ", ")}
+	}
+	return "Insert on " + op.table.Name, []string{"Values: " + assignments(op.values)}
+}
+
+func (op *insertOp) estimate() (float64, float64) {
+	if op.source != nil {
+		rows, cost := op.source.estimate()
+		return rows, cost + rows
+	}
+	return 1, 1
+}
+
+func (op *insertOp) inputs() []operator {
+	if op.source != nil {
+		return []operator{op.source}
+	}
+	return nil
+}
+
+func (op *insertOp) run(e *Engine) ([]*storage.Row, error) {
+	if op.source == nil {
+		row, err := e.insert(op.table, op.values)
+		if err != nil {
+			return nil, err
+		}
+		return []*storage.Row{row}, nil
+	}
+
+	rows, err := e.runOperator(op.source)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		values[i] = make(map[string]any, len(op.columns))
+		for j, col := range op.columns {
+			values[i][col] = row.Data[op.sourceColumns[j]]
+		}
+	}
+	return e.insertAll(op.table, values)
+}
+
+// updateOp sets values on the rows its scan finds and returns them as
+// updated.
+type updateOp struct {
+	opStats
+	table  *storage.Table
+	scan   *scanOp
+	values map[string]any
+}
+
+func (op *updateOp) describe() (string, []string) {
+	return "Update on " + op.table.Name, []string{"Set: " + assignments(op.values)}
+}
+
+func (op *updateOp) estimate() (float64, float64) {
+	rows, cost := op.scan.estimate()
+	return rows, cost + rows
+}
+
+func (op *updateOp) inputs() []operator { return []operator{op.scan} }
+
+func (op *updateOp) run(e *Engine) ([]*storage.Row, error) {
+	if _, err := e.runOperator(op.scan); err != nil {
+		return nil, err
+	}
+	return e.update(op.table, op.scan.ids, op.values)
+}
+
+// deleteOp removes the rows its scan finds and returns them.
+type deleteOp struct {
+	opStats
+	table *storage.Table
+	scan  *scanOp
+}
+
+func (op *deleteOp) describe() (string, []string) {
+	return "Delete on " + op.table.Name, nil
+}
+
+func (op *deleteOp) estimate() (float64, float64) {
+	rows, cost := op.scan.estimate()
+	return rows, cost + rows
+}
+
+func (op *deleteOp) inputs() []operator { return []operator{op.scan} }
+
+func (op *deleteOp) run(e *Engine) ([]*storage.Row, error) {
+	if _, err := e.runOperator(op.scan); err != nil {
+		return nil, err
+	}
+	return e.delete(op.table, op.scan.ids)
+}
+
+// assignments renders values as "col = value" pairs in column order.
+func assignments(values map[string]any) string {
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		parts[i] = col + " = " + planner.SQLValue(values[col])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Select list
+// --------------------------
+
+// selectList returns the names and expressions of the result columns of
+// a SELECT planned in s, with each * and table.* replaced by the columns
+// it stands for.
+func (s *scope) selectList(plan *planner.Plan) ([]string, []expr.Expr, error) {
+	if plan.Select == nil {
+		names, exprs := s.star()
+		return names, exprs, nil
+	}
+
+	var names []string
+	var exprs []expr.Expr
+	for i, item := range plan.Select {
+		star, ok := item.(*expr.Star)
+		switch {
+		case !ok:
+			names = append(names, plan.Columns[i])
+			exprs = append(exprs, item)
+
+		case star.Table == "":
+			n, x := s.star()
+			names = append(names, n...)
+			exprs = append(exprs, x...)
+
+		default:
+			src := s.named(star.Table)
+			if src == nil {
+				return nil, nil, fmt.Errorf("missing FROM-clause entry for table '%s'", star.Table)
+			}
+			for _, col := range src.columnNames() {
+				names = append(names, col)
+				exprs = append(exprs, s.column(src, col))
+			}
+		}
+	}
+	return names, exprs, nil
+}
+
+// checkOutputNames rejects a select list that names two result columns
+// alike. A result row holds its values by column name, so the second
+// would overwrite the first.
+func checkOutputNames(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("column '%s' appears more than once in select list", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// orderByPositions replaces each ORDER BY key that is an integer constant
+// with the result column at that position, counting from 1, so that
+// ORDER BY 2 sorts by the second column of exprs.
+func orderByPositions(keys []planner.SortKey, exprs []expr.Expr) error {
+	for i, k := range keys {
+		lit, ok := k.Expr.(*expr.Literal)
+		if !ok {
+			continue
+		}
+		n, ok := lit.Value.(int64)
+		if !ok {
+			continue
+		}
+		if n < 1 || n > int64(len(exprs)) {
+			return fmt.Errorf("ORDER BY position %d is not in select list", n)
+		}
+		keys[i].Expr = exprs[n-1]
+	}
+	return nil
+}
+
+// named returns the source of the scope called name, or nil.
+func (s *scope) named(name string) *source {
+	for i := range s.sources {
+		if s.sources[i].name == name {
+			return &s.sources[i]
+		}
+	}
+	return nil
+}
+
+// buildOutput puts the end of a SELECT on top of input: window functions,
+// DISTINCT or DISTINCT ON, ORDER BY, LIMIT and OFFSET, and the select
+// list. keys, exprs and on are the sort keys, the select list and the
+// DISTINCT ON expressions as they read the rows of input; exprs is nil
+// for SELECT * over a single table, whose rows are kept as they are.
+//
+// DISTINCT compares result rows, so the select list comes first, and
+// ORDER BY can then only sort by what was selected. DISTINCT ON keeps the
+// first row of each of its values in the order of ORDER BY, which must
+// start with its expressions. Nothing is sorted when input is an index
+// scan that reads the rows in that order already; every operator on top
+// of it keeps the order of its input.
+func (e *Engine) buildOutput(plan *planner.Plan, input operator, keys []planner.SortKey, exprs, on []expr.Expr) (operator, error) {
+	scan, ok := input.(*scanOp)
+	sorted := ok && scan.ordered()
+	input = buildWindows(input, keys, exprs, on)
+	switch {
+	case plan.Distinct:
+		out := make([]planner.SortKey, len(keys))
+		for i, k := range keys {
+			j := 0
+			for j < len(exprs) && exprs[j].String() != k.Expr.String() {
+				j++
+			}
+			if j == len(exprs) {
+				return nil, fmt.Errorf("for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+			}
+			out[i] = planner.SortKey{Expr: &expr.ColumnRef{Name: plan.Columns[j]}, Desc: k.Desc, NullsFirst: k.NullsFirst}
+		}
+		if sorted {
+			out = nil
+		}
+		var op operator = &projectOp{input: input, columns: plan.Columns, exprs: exprs}
+		op = &distinctOp{input: op, columns: plan.Columns}
+		return sortAndLimit(op, plan, out), nil
+
+	case on != nil:
+		distinct := make(map[string]bool, len(on))
+		for _, x := range on {
+			distinct[x.String()] = true
+		}
+		for i := 0; i < len(keys) && i < len(on); i++ {
+			if !distinct[keys[i].Expr.String()] {
+				return nil, fmt.Errorf("SELECT DISTINCT ON expressions must match initial ORDER BY expressions")
+			}
+		}
+		// Every row is sorted, since any of them may come first
+		var op operator = input
+		if len(keys) > 0 && !sorted {
+			op = &sortOp{input: op, keys: keys, limit: -1}
+		}
+		op = sortAndLimit(&distinctOp{input: op, on: on}, plan, nil)
+		return &projectOp{input: op, columns: plan.Columns, exprs: exprs}, nil
+
+	default:
+		if sorted {
+			keys = nil
+		}
+		op := sortAndLimit(input, plan, keys)
+		return &projectOp{input: op, columns: plan.Columns, exprs: exprs}, nil
+	}
+}
+
+// --------------------------
+// Distinct
+// --------------------------
+
+// distinctOp keeps the first of the rows of its input with equal values
+// of columns, the result columns of SELECT DISTINCT, or of on, the
+// expressions of DISTINCT ON. NULLs count as equal, and so do equal
+// numbers of different types.
+type distinctOp struct {
+	opStats
+	input   operator
+	columns []string
+	on      []expr.Expr
+}
+
+func (op *distinctOp) describe() (string, []string) {
+	key := strings.Join(op.columns, ", ")
+	if op.on != nil {
+		on := make([]string, len(op.on))
+		for i, x := range op.on {
+			on[i] = x.String()
+		}
+		key = strings.Join(on, ", ")
+	}
+	return "Unique", []string{"Key: " + key}
+}
+
+func (op *distinctOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	return rows, cost + rows
+}
+
+func (op *distinctOp) inputs() []operator { return []operator{op.input} }
+
+func (op *distinctOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(rows))
+	out := []*storage.Row{}
+	for _, row := range rows {
+		var values []any
+		if op.on != nil {
+			values = make([]any, len(op.on))
+			for i, x := range op.on {
+				v, err := x.Eval(row.Data)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = keyValue(v)
+			}
+		} else {
+			values = make([]any, len(op.columns))
+			for i, col := range op.columns {
+				values[i] = keyValue(row.Data[col])
+			}
+		}
+
+		if key := hashKey(values); !seen[key] {
+			seen[key] = true
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// walFileName is the name of the write-ahead log inside the data directory.
+const walFileName = "wal.log"
+
+func init() {
+	// DEFAULT and CHECK expressions are persisted as SQL text and compiled
+	// again when a snapshot or the log is read back
+	storage.ParseExpr = func(sql string) (storage.Expr, error) {
+		return parser.ParseExpr(sql)
+	}
+}
+
+// Open makes the engine durable by attaching a write-ahead log stored in
+// dir. The latest snapshot is loaded and the log records written after it
+// are replayed into the database first, so Open should be called on an
+// empty database before serving queries.
+func (e *Engine) Open(dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create data directory: %w", err)
+	}
+
+	snapshotLSN, err := storage.LoadSnapshot(filepath.Join(dir, snapshotFileName), e.db)
+	if err != nil {
+		return err
+	}
+
+	wal, err := storage.OpenWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		return err
+	}
+
+	// Records up to snapshotLSN survived a crash between writing the
+	// snapshot and truncating the log; the snapshot already has them.
+	err = wal.Replay(func(rec *storage.Record) error {
+		if rec.LSN <= snapshotLSN {
+			return nil
+		}
+		e.checkpoint.pending++
+		return e.db.Apply(rec)
+	})
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	wal.SkipTo(snapshotLSN + 1)
+
+	e.wal = wal
+	e.checkpoint.dir = dir
+	return nil
+}
+
+// Close stops automatic checkpoints and closes the write-ahead log, if one
+// is attached.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.checkpoint.stop != nil {
+		close(e.checkpoint.stop)
+		e.checkpoint.stop = nil
+	}
+
+	if e.wal == nil {
+		return nil
+	}
+
+	err := e.wal.Close()
+	e.wal = nil
+	return err
+}
+
+// logChanges writes recs to the write-ahead log before they are applied.
+// It is a no-op for engines running purely in memory.
+func (e *Engine) logChanges(recs ...*storage.Record) error {
+	if e.wal == nil {
+		return nil
+	}
+
+	if err := e.wal.Append(recs...); err != nil {
+		return err
+	}
+
+	e.checkpoint.pending += len(recs)
+	return nil
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"fmt"
 
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
 	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
@@ -24,7 +25,7 @@ func (e *Engine) SelectAll(tableName string) ([]*storage.Row, error) {
 		return nil, fmt.Errorf("table %s does not exist", tableName)
 	}
 
-	return table.Rows, nil
+	return table.GetRows(), nil
 }
 
 // SelectByColumnValue returns all rows in a table where the given column matches a value.
@@ -35,11 +36,26 @@ func (e *Engine) SelectByColumnValue(tableName, columnName string, value any) ([
 	}
 
 	var result []*storage.Row
-	for _, row := range table.Rows {
-		if rowVal, exists := row.Data[columnName]; exists && rowVal == value {
-			result = append(result, row)
+	if ix := table.IndexOn(columnName); ix != nil && value != nil {
+		col := findColumn(table, columnName)
+		if planner.Indexable(col, value) {
+			for _, id := range storage.SortRowIDs(ix.Lookup([]any{value})) {
+				result = append(result, table.Row(id))
+			}
+			return result, nil
 		}
 	}
 
+	table.Scan(func(_ storage.RowID, row *storage.Row) bool {
+		rowVal, exists := row.Data[columnName]
+		if !exists || rowVal == nil || value == nil {
+			return true
+		}
+		if cmp, err := storage.Compare(rowVal, value); err == nil && cmp == 0 {
+			result = append(result, row)
+		}
+		return true
+	})
+
 	return result, nil
 }
\ No newline at end of file
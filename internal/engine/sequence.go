@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// CreateSequence registers a new sequence.
+func (e *Engine) CreateSequence(seq *storage.Sequence) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.createSequence(seq); err != nil {
+		return err
+	}
+
+	e.maybeCheckpoint()
+	return nil
+}
+
+// NextVal advances a sequence and returns its new value.
+func (e *Engine) NextVal(name string) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.nextval(name)
+}
+
+// CurrVal returns the value most recently handed out by a sequence.
+func (e *Engine) CurrVal(name string) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.currval(name)
+}
+
+func (e *Engine) createSequence(seq *storage.Sequence) error {
+	if seq == nil {
+		return fmt.Errorf("sequence cannot be empty")
+	}
+	if _, exists := e.db.Sequences[seq.Name]; exists {
+		return fmt.Errorf("sequence %s already exists", seq.Name)
+	}
+
+	if err := e.logChanges(&storage.Record{Type: storage.RecordCreateSequence, Sequence: seq}); err != nil {
+		return err
+	}
+	return e.db.CreateSequence(seq)
+}
+
+// nextval hands out the next value of a sequence. The new value is logged
+// before it is used, so a value is never handed out twice, even when the
+// statement using it fails.
+func (e *Engine) nextval(name string) (int64, error) {
+	seq, err := e.db.Sequence(name)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := seq.Next()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := e.setSequence(seq, v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+func (e *Engine) currval(name string) (int64, error) {
+	seq, err := e.db.Sequence(name)
+	if err != nil {
+		return 0, err
+	}
+	return seq.Current()
+}
+
+// setSequence logs and records v as the last value of seq.
+func (e *Engine) setSequence(seq *storage.Sequence, v int64) error {
+	rec := &storage.Record{
+		Type:     storage.RecordSetSequence,
+		Sequence: &storage.Sequence{Name: seq.Name, Value: v},
+	}
+	if err := e.logChanges(rec); err != nil {
+		return err
+	}
+
+	seq.Set(v)
+	return nil
+}
+
+// sequenceSource lets expressions call nextval and currval while the
+// engine's lock is already held.
+type sequenceSource struct {
+	e *Engine
+}
+
+func (s sequenceSource) NextVal(name string) (int64, error) {
+	return s.e.nextval(name)
+}
+
+func (s sequenceSource) CurrVal(name string) (int64, error) {
+	return s.e.currval(name)
+}
+
+// evalValue evaluates a value that is computed when the statement runs,
+// such as nextval('seq'), reading the columns of row. Constants are
+// returned unchanged.
+func (e *Engine) evalValue(v any, row map[string]any) (any, error) {
+	x, ok := v.(expr.Expr)
+	if !ok {
+		return v, nil
+	}
+
+	expr.BindSequences(x, sequenceSource{e})
+	return x.Eval(row)
+}
+
+// evalValues evaluates every value of an INSERT or UPDATE with evalValue,
+// in the order of the table's columns so that nextval and currval in one
+// statement see each other predictably. The values of an UPDATE read the
+// row as it was before the update.
+func (e *Engine) evalValues(table *storage.Table, values map[string]any, row map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(values))
+	for _, col := range table.Columns {
+		v, ok := values[col.Name]
+		if !ok {
+			continue
+		}
+		val, err := e.evalValue(v, row)
+		if err != nil {
+			return nil, err
+		}
+		out[col.Name] = val
+	}
+
+	// Unknown columns are passed on for validation to reject
+	for col, v := range values {
+		if _, done := out[col]; !done {
+			out[col] = v
+		}
+	}
+	return out, nil
+}
+
+// --------------------------
+// Identity columns
+// --------------------------
+
+// fillIdentity generates values for the identity columns data leaves out
+// or sets to NULL. An explicit value is rejected for GENERATED ALWAYS
+// columns; for BY DEFAULT columns it moves the sequence past it so later
+// generated values do not collide with it.
+func (e *Engine) fillIdentity(table *storage.Table, data map[string]any) (map[string]any, error) {
+	var filled map[string]any
+	for _, col := range table.Columns {
+		if col.Identity == "" {
+			continue
+		}
+		if filled == nil {
+			filled = make(map[string]any, len(data)+1)
+			for k, v := range data {
+				filled[k] = v
+			}
+		}
+
+		if v := filled[col.Name]; v != nil {
+			if col.Identity == storage.IdentityAlways {
+				return nil, fmt.Errorf("cannot insert a value into column %s: it is GENERATED ALWAYS AS IDENTITY", col.Name)
+			}
+			if err := e.passIdentity(table, col, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		v, err := e.nextval(storage.IdentitySequence(table.Name, col.Name))
+		if err != nil {
+			return nil, err
+		}
+		filled[col.Name] = v
+	}
+
+	if filled == nil {
+		return data, nil
+	}
+	return filled, nil
+}
+
+// checkIdentityUpdate rejects updates of GENERATED ALWAYS columns and moves
+// the sequence of BY DEFAULT columns past the new values.
+func (e *Engine) checkIdentityUpdate(table *storage.Table, values map[string]any) error {
+	for _, col := range table.Columns {
+		v, set := values[col.Name]
+		if col.Identity == "" || !set {
+			continue
+		}
+		if col.Identity == storage.IdentityAlways {
+			return fmt.Errorf("column %s cannot be updated: it is GENERATED ALWAYS AS IDENTITY", col.Name)
+		}
+		if v != nil {
+			if err := e.passIdentity(table, col, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// passIdentity moves the sequence of an identity column past v.
+func (e *Engine) passIdentity(table *storage.Table, col *storage.Column, v any) error {
+	n, err := storage.Coerce(v, storage.IntType)
+	if err != nil {
+		return fmt.Errorf("column %s: %w", col.Name, err)
+	}
+
+	seq, err := e.db.Sequence(storage.IdentitySequence(table.Name, col.Name))
+	if err != nil {
+		return err
+	}
+	if !seq.Behind(n.(int64)) {
+		return nil
+	}
+	return e.setSequence(seq, n.(int64))
+}
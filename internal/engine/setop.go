@@ -0,0 +1,361 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Building set operations
+// --------------------------
+
+// buildSetOp builds the operators of a compound SELECT: each side in a
+// scope of its own, then the operator combining their rows under the
+// names of the left side's columns, then ORDER BY, LIMIT and OFFSET. The
+// combined rows are the only table ORDER BY can read.
+func (e *Engine) buildSetOp(plan *planner.Plan, s *scope) (operator, error) {
+	op := &setOpOp{typ: plan.SetOp, all: plan.All}
+	var rightTypes []storage.ColumnType
+	var err error
+	if op.left, op.leftColumns, op.types, err = s.buildOperand(plan.Left); err != nil {
+		return nil, err
+	}
+	if op.right, op.rightColumns, rightTypes, err = s.buildOperand(plan.Right); err != nil {
+		return nil, err
+	}
+	if len(op.leftColumns) != len(op.rightColumns) {
+		return nil, fmt.Errorf("each %s query must have the same number of columns", plan.SetOp)
+	}
+	for i := range op.types {
+		if op.types[i], err = commonType(plan.SetOp, op.types[i], rightTypes[i]); err != nil {
+			return nil, err
+		}
+	}
+	op.columns = op.leftColumns
+
+	src := source{derived: op, columns: op.columns, types: op.types}
+	if err := s.add(src); err != nil {
+		return nil, err
+	}
+	plan.Columns = op.columns
+	plan.Select = make([]expr.Expr, len(op.columns))
+	for i, col := range op.columns {
+		plan.Select[i] = &expr.ColumnRef{Name: col}
+	}
+	if err := orderByPositions(plan.OrderBy, plan.Select); err != nil {
+		return nil, err
+	}
+	for _, k := range plan.OrderBy {
+		for _, ref := range columnRefs(k.Expr) {
+			if ref.Table != "" || !src.has(ref.Name) {
+				return nil, fmt.Errorf("column '%s' does not exist in the result of %s", ref, plan.SetOp)
+			}
+		}
+	}
+
+	if err := s.resolvePlan(plan); err != nil {
+		return nil, err
+	}
+	return sortAndLimit(op, plan, plan.OrderBy), nil
+}
+
+// buildOperand builds a side of a compound SELECT in a scope of its own.
+// It reads what s can: the queries of WITH and, in a subquery, the
+// columns of the enclosing query.
+func (s *scope) buildOperand(plan *planner.Plan) (operator, []string, []storage.ColumnType, error) {
+	inner := newScope(s.e, false)
+	inner.parent, inner.outer, inner.ctes = s.parent, s.outer, s.ctes
+	op, err := s.e.build(plan, inner)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.refs += inner.refs
+	return op, resultNames(plan, inner), resultTypes(plan, inner), nil
+}
+
+// columnRefs returns the column references of e, leaving out those of its
+// subqueries.
+func columnRefs(e expr.Expr) []*expr.ColumnRef {
+	var refs []*expr.ColumnRef
+	expr.Walk(e, func(n expr.Expr) {
+		if ref, ok := n.(*expr.ColumnRef); ok {
+			refs = append(refs, ref)
+		}
+	})
+	return refs
+}
+
+// --------------------------
+// Result types
+// --------------------------
+
+// resultTypes returns the types of the result columns of a SELECT built in
+// s, "" where a type is not known, such as that of NULL.
+func resultTypes(plan *planner.Plan, s *scope) []storage.ColumnType {
+	items := plan.Select
+	if len(plan.Columns) == 1 && plan.Columns[0] == "*" {
+		_, items = s.star()
+	}
+	types := make([]storage.ColumnType, len(items))
+	for i, item := range items {
+		types[i] = s.typeOf(item)
+	}
+	return types
+}
+
+// typeOf returns the type of the values of e, an expression resolved in s,
+// or "" when it cannot be told without evaluating e.
+func (s *scope) typeOf(e expr.Expr) storage.ColumnType {
+	switch e := e.(type) {
+	case *expr.Literal:
+		if e.Value == nil {
+			return ""
+		}
+		return storage.TypeOf(e.Value)
+
+	case *expr.ColumnRef:
+		for i := range s.sources {
+			src := &s.sources[i]
+			if (!s.joined || src.name == e.Table) && src.has(e.Name) {
+				return src.columnType(e.Name)
+			}
+		}
+		return ""
+
+	case *expr.Cast:
+		return e.Type
+
+	case *expr.Unary:
+		if e.Op == "NOT" {
+			return storage.BoolType
+		}
+		return s.typeOf(e.X)
+
+	case *expr.Binary:
+		switch e.Op {
+		case "+", "-", "*", "/", "%":
+			l, r := s.typeOf(e.L), s.typeOf(e.R)
+			switch {
+			case l == storage.IntType && r == storage.IntType:
+				return storage.IntType
+			case l == storage.FloatType || r == storage.FloatType:
+				return storage.FloatType
+			}
+			return ""
+		case "||":
+			return storage.TextType
+		default:
+			return storage.BoolType
+		}
+
+	case *expr.IsNull, *expr.In, *expr.Between, *expr.Match:
+		return storage.BoolType
+
+	case *expr.Aggregate:
+		switch e.Func {
+		case "COUNT":
+			return storage.IntType
+		case "AVG":
+			return storage.FloatType
+		default:
+			return s.typeOf(e.Arg)
+		}
+
+	case *expr.Window:
+		switch {
+		case e.Agg != nil:
+			return s.typeOf(e.Agg)
+		case len(e.Args) == 0: // ROW_NUMBER, RANK and DENSE_RANK
+			return storage.IntType
+		default:
+			return s.typeOf(e.Args[0])
+		}
+
+	case *expr.Call:
+		switch e.Name {
+		case "LOWER", "UPPER":
+			return storage.TextType
+		case "LENGTH":
+			return storage.IntType
+		case "CURRENT_DATE":
+			return storage.DateType
+		case "COALESCE":
+			for _, arg := range e.Args {
+				if t := s.typeOf(arg); t != "" {
+					return t
+				}
+			}
+			return ""
+		default:
+			return s.typeOf(e.Args[0])
+		}
+
+	case *expr.SequenceCall:
+		return storage.IntType
+
+	case *expr.Subquery:
+		if e.Kind == expr.ScalarSubquery {
+			return ""
+		}
+		return storage.BoolType
+
+	default:
+		return ""
+	}
+}
+
+// commonType is the type of a result column of a compound SELECT whose
+// sides have types a and b: INT widens to FLOAT, and a side of unknown
+// type takes the other's. Any other pair of types cannot be combined.
+func commonType(op parser.SetOp, a, b storage.ColumnType) (storage.ColumnType, error) {
+	switch {
+	case a == b || b == "":
+		return a, nil
+	case a == "":
+		return b, nil
+	case (a == storage.IntType && b == storage.FloatType) || (a == storage.FloatType && b == storage.IntType):
+		return storage.FloatType, nil
+	default:
+		return "", fmt.Errorf("%s types %s and %s cannot be matched", op, a, b)
+	}
+}
+
+// widen returns an INT value of a FLOAT result column as a FLOAT.
+func widen(v any, t storage.ColumnType) any {
+	if i, ok := v.(int64); ok && t == storage.FloatType {
+		return float64(i)
+	}
+	return v
+}
+
+// --------------------------
+// Set operations
+// --------------------------
+
+// setOpOp combines the rows of two SELECTs, renaming the columns of each
+// to columns in order. UNION ALL appends the right rows to the left ones.
+// The others hash the rows by all of their values, NULLs included: UNION
+// keeps each distinct row once, INTERSECT the distinct left rows also on
+// the right and EXCEPT those that are not. INTERSECT ALL and EXCEPT ALL
+// keep duplicates, as many times as the counts on both sides allow.
+type setOpOp struct {
+	opStats
+	typ         parser.SetOp
+	all         bool
+	left, right operator
+
+	leftColumns, rightColumns []string
+	columns                   []string
+	types                     []storage.ColumnType
+}
+
+func (op *setOpOp) describe() (string, []string) {
+	if op.typ == parser.Union && op.all {
+		return "Append", nil
+	}
+	name := "Hash " + string(op.typ[:1]) + strings.ToLower(string(op.typ[1:]))
+	if op.all {
+		name += " All"
+	}
+	return name, nil
+}
+
+func (op *setOpOp) estimate() (float64, float64) {
+	lrows, lcost := op.left.estimate()
+	rrows, rcost := op.right.estimate()
+
+	rows := lrows
+	switch op.typ {
+	case parser.Union:
+		rows += rrows
+	case parser.Intersect:
+		rows = math.Min(lrows, rrows)
+	}
+	return rows, lcost + rcost + lrows + rrows
+}
+
+func (op *setOpOp) inputs() []operator { return []operator{op.left, op.right} }
+
+func (op *setOpOp) run(e *Engine) ([]*storage.Row, error) {
+	lrows, err := e.runOperator(op.left)
+	if err != nil {
+		return nil, err
+	}
+	rrows, err := e.runOperator(op.right)
+	if err != nil {
+		return nil, err
+	}
+	left, right := op.rename(lrows, op.leftColumns), op.rename(rrows, op.rightColumns)
+
+	if op.typ == parser.Union {
+		rows := append(left, right...)
+		if op.all {
+			return rows, nil
+		}
+		seen := make(map[string]bool, len(rows))
+		out := []*storage.Row{}
+		for _, row := range rows {
+			if key := op.key(row); !seen[key] {
+				seen[key] = true
+				out = append(out, row)
+			}
+		}
+		return out, nil
+	}
+
+	counts := make(map[string]int, len(right))
+	for _, row := range right {
+		counts[op.key(row)]++
+	}
+	seen := make(map[string]bool)
+	out := []*storage.Row{}
+	for _, row := range left {
+		key := op.key(row)
+		found := counts[key] > 0
+		if op.all {
+			// Each right row cancels out one left row
+			if found {
+				counts[key]--
+			}
+			if found == (op.typ == parser.Intersect) {
+				out = append(out, row)
+			}
+			continue
+		}
+		if !seen[key] && found == (op.typ == parser.Intersect) {
+			out = append(out, row)
+		}
+		seen[key] = true
+	}
+	return out, nil
+}
+
+// rename puts the values of rows, whose columns are from, under the
+// result's columns.
+func (op *setOpOp) rename(rows []*storage.Row, from []string) []*storage.Row {
+	out := make([]*storage.Row, len(rows))
+	for i, row := range rows {
+		data := make(map[string]any, len(op.columns))
+		for j, col := range op.columns {
+			data[col] = widen(row.Data[from[j]], op.types[j])
+		}
+		out[i] = &storage.Row{Data: data}
+	}
+	return out
+}
+
+// key hashes the values of a result row, so that equal rows, NULLs and
+// equal numbers of different types included, give equal keys.
+func (op *setOpOp) key(row *storage.Row) string {
+	values := make([]any, len(op.columns))
+	for i, col := range op.columns {
+		values[i] = keyValue(row.Data[col])
+	}
+	return hashKey(values)
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Sort
+// --------------------------
+
+// sortAndLimit puts the ORDER BY, LIMIT and OFFSET of plan on top of op.
+// keys are the plan's sort keys, rewritten if need be to suit op's rows.
+func sortAndLimit(op operator, plan *planner.Plan, keys []planner.SortKey) operator {
+	limit := int64(-1)
+	if plan.Limit != nil {
+		limit = *plan.Limit
+	}
+	if len(keys) > 0 {
+		// With a LIMIT only the rows up to the end of the page are
+		// sorted
+		keep := limit
+		if keep >= 0 {
+			keep += plan.Offset
+		}
+		op = &sortOp{input: op, keys: keys, limit: keep}
+	}
+	if limit >= 0 || plan.Offset > 0 {
+		op = &limitOp{input: op, limit: limit, offset: plan.Offset}
+	}
+	return op
+}
+
+// sortOp orders its input by ORDER BY. Rows with equal keys keep their
+// input order. When the query has a LIMIT, only the first limit rows are
+// needed: sortOp then keeps the best of them in a heap as it goes instead
+// of sorting everything.
+type sortOp struct {
+	opStats
+	input operator
+	keys  []planner.SortKey
+	limit int64 // rows to keep, or -1 for all
+}
+
+func (op *sortOp) describe() (string, []string) {
+	keys := make([]string, len(op.keys))
+	for i, k := range op.keys {
+		keys[i] = k.String()
+	}
+	details := []string{"Sort Key: " + strings.Join(keys, ", ")}
+
+	if op.limit >= 0 {
+		return "Top-K Sort", append(details, fmt.Sprintf("Keep: %d", op.limit))
+	}
+	return "Sort", details
+}
+
+func (op *sortOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	k := rows
+	if op.limit >= 0 {
+		k = math.Min(rows, float64(op.limit))
+	}
+	return rows, cost + rows*math.Log2(k+1)
+}
+
+func (op *sortOp) inputs() []operator { return []operator{op.input} }
+
+func (op *sortOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sorter{keys: op.keys}
+	if op.limit >= 0 && op.limit < int64(len(rows)) {
+		return s.top(rows, int(op.limit))
+	}
+	return s.sort(rows)
+}
+
+// sortItem is a row with its evaluated sort keys. pos is the row's place
+// in the input, which breaks ties.
+type sortItem struct {
+	row  *storage.Row
+	keys []any
+	pos  int
+}
+
+// sorter compares rows by a list of sort keys. Comparing values of
+// different types is an error; the first one is kept in err.
+type sorter struct {
+	keys  []planner.SortKey
+	items []sortItem
+	err   error
+}
+
+func (s *sorter) item(row *storage.Row, pos int) (sortItem, error) {
+	item := sortItem{row: row, keys: make([]any, len(s.keys)), pos: pos}
+	for i, k := range s.keys {
+		v, err := k.Expr.Eval(row.Data)
+		if err != nil {
+			return item, err
+		}
+		item.keys[i] = v
+	}
+	return item, nil
+}
+
+// compare orders a before b when it is negative.
+func (s *sorter) compare(a, b sortItem) int {
+	for i, k := range s.keys {
+		if c := s.compareKey(k, a.keys[i], b.keys[i]); c != 0 {
+			return c
+		}
+	}
+	return a.pos - b.pos
+}
+
+func (s *sorter) compareKey(k planner.SortKey, a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil || b == nil:
+		// NULLS FIRST and LAST hold whatever the direction
+		if (a == nil) == k.NullsFirst {
+			return -1
+		}
+		return 1
+	}
+
+	c, err := storage.Compare(a, b)
+	if err != nil {
+		if s.err == nil {
+			s.err = fmt.Errorf("cannot sort by %s: %w", k.Expr, err)
+		}
+		return 0
+	}
+	if k.Desc {
+		return -c
+	}
+	return c
+}
+
+// sort returns all rows in order.
+func (s *sorter) sort(rows []*storage.Row) ([]*storage.Row, error) {
+	s.items = make([]sortItem, len(rows))
+	for i, row := range rows {
+		item, err := s.item(row, i)
+		if err != nil {
+			return nil, err
+		}
+		s.items[i] = item
+	}
+	return s.sorted()
+}
+
+// top returns the first k rows in order, keeping no more than k rows at a
+// time. The heap has the worst row kept at its root, so a row better than
+// it replaces it.
+func (s *sorter) top(rows []*storage.Row, k int) ([]*storage.Row, error) {
+	s.items = make([]sortItem, 0, k)
+	for i, row := range rows {
+		item, err := s.item(row, i)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(s.items) < k:
+			heap.Push(s, item)
+		case k > 0 && s.compare(item, s.items[0]) < 0:
+			s.items[0] = item
+			heap.Fix(s, 0)
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+	}
+	return s.sorted()
+}
+
+func (s *sorter) sorted() ([]*storage.Row, error) {
+	sort.Slice(s.items, func(i, j int) bool { return s.compare(s.items[i], s.items[j]) < 0 })
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	out := make([]*storage.Row, len(s.items))
+	for i, item := range s.items {
+		out[i] = item.row
+	}
+	return out, nil
+}
+
+// heap.Interface, with the greatest item first
+func (s *sorter) Len() int           { return len(s.items) }
+func (s *sorter) Less(i, j int) bool { return s.compare(s.items[i], s.items[j]) > 0 }
+func (s *sorter) Swap(i, j int)      { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s *sorter) Push(x any)         { s.items = append(s.items, x.(sortItem)) }
+func (s *sorter) Pop() any {
+	last := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return last
+}
+
+// --------------------------
+// Limit
+// --------------------------
+
+// limitOp skips the first offset rows of its input and returns at most
+// limit of the rest.
+type limitOp struct {
+	opStats
+	input  operator
+	limit  int64 // -1 for no limit
+	offset int64
+}
+
+func (op *limitOp) describe() (string, []string) {
+	var details []string
+	if op.limit >= 0 {
+		details = append(details, fmt.Sprintf("Limit: %d", op.limit))
+	}
+	if op.offset > 0 {
+		details = append(details, fmt.Sprintf("Offset: %d", op.offset))
+	}
+	return "Limit", details
+}
+
+func (op *limitOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	rows = math.Max(rows-float64(op.offset), 0)
+	if op.limit >= 0 {
+		rows = math.Min(rows, float64(op.limit))
+	}
+	return rows, cost
+}
+
+func (op *limitOp) inputs() []operator { return []operator{op.input} }
+
+func (op *limitOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.offset >= int64(len(rows)) {
+		return []*storage.Row{}, nil
+	}
+	rows = rows[op.offset:]
+	if op.limit >= 0 && op.limit < int64(len(rows)) {
+		rows = rows[:op.limit]
+	}
+	return rows, nil
+}
@@ -0,0 +1,433 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Planning subqueries
+// --------------------------
+
+// subquery plans a subquery of an expression being resolved in s. Its
+// references resolve in a scope of its own, with s as parent.
+func (s *scope) subquery(q *expr.Subquery) (expr.Expr, error) {
+	x, err := s.resolve(q.X)
+	if err != nil {
+		return nil, err
+	}
+	query, ok := q.Query.(*parser.Query)
+	if !ok {
+		return nil, fmt.Errorf("subqueries cannot be used here")
+	}
+	plan, err := planner.CreatePlan(query, s.e.db)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subplan{e: s.e, kind: q.Kind, outer: &expr.OuterRow{}}
+	inner := newScope(s.e, false)
+	inner.parent, inner.outer, inner.sub = s, sub.outer, sub
+	inner.ctes = s.ctes
+	if sub.op, err = s.e.build(plan, inner); err != nil {
+		return nil, err
+	}
+	sub.correlated = inner.refs > 0
+
+	columns := resultNames(plan, inner)
+	if q.Kind != expr.ExistsSubquery {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("subquery must return only one column")
+		}
+		sub.value = &expr.ColumnRef{Name: columns[0]}
+		if proj, ok := sub.op.(*projectOp); ok && sub.inner != nil && proj.exprs != nil {
+			sub.value = proj.exprs[0]
+		}
+	}
+
+	out := *q
+	out.X, out.Plan, out.Correlated = x, sub, sub.correlated
+	return &out, nil
+}
+
+// decorrelate lets the correlated subquery a plan belongs to run once
+// instead of once per row of the enclosing query: the keys of WHERE tying
+// its rows to the enclosing row are taken out, and its subplan looks the
+// rows up by them. This needs every row of the subquery, so not with
+// grouping, window functions, DISTINCT, LIMIT or OFFSET, and a select
+// list that does not read the enclosing row.
+func (s *scope) decorrelate(plan *planner.Plan) {
+	if s.sub == nil || s.refs == 0 || plan.Grouped || plan.Windowed || plan.Distinct || plan.DistinctOn != nil ||
+		plan.Limit != nil || plan.Offset > 0 {
+		return
+	}
+	for _, item := range plan.Select {
+		if planner.Correlated(item) {
+			return
+		}
+	}
+	inner, outer, rest, ok := planner.Decorrelate(plan.Where)
+	if !ok {
+		return
+	}
+	plan.Where = planner.Conjoin(rest)
+	plan.OrderBy = nil // without a LIMIT the order of the rows is moot
+	s.sub.inner, s.sub.outerKeys = inner, outer
+}
+
+// resultNames names the result columns of a SELECT built in s.
+func resultNames(plan *planner.Plan, s *scope) []string {
+	if len(plan.Columns) == 1 && plan.Columns[0] == "*" {
+		names, _ := s.star()
+		return names
+	}
+	return plan.Columns
+}
+
+// correlatedSubquery reports whether e has a subquery reading the row it
+// is evaluated for.
+func correlatedSubquery(e expr.Expr) bool {
+	found := false
+	expr.Walk(e, func(n expr.Expr) {
+		if q, ok := n.(*expr.Subquery); ok && q.Correlated {
+			found = true
+		}
+	})
+	return found
+}
+
+// --------------------------
+// SubPlan
+// --------------------------
+
+// subplan runs the query of a subquery for the expressions that use it.
+// An uncorrelated subquery runs once and its values are kept. A correlated
+// one runs again for every row of the enclosing query, with outer holding
+// that row, unless it was decorrelated: then it runs once without the keys
+// that tie it to the enclosing row, and its values are looked up by them.
+//
+// EXPLAIN shows a subplan under the operator that evaluates it.
+type subplan struct {
+	opStats
+	e          *Engine
+	kind       expr.SubqueryKind
+	op         operator
+	value      expr.Expr // the value of a row of op; nil for EXISTS
+	outer      *expr.OuterRow
+	correlated bool
+
+	inner, outerKeys []expr.Expr // decorrelated keys
+
+	loops   int
+	done    bool
+	values  []any            // uncorrelated
+	set     map[string]bool  // uncorrelated IN: its values, hashed
+	hasNull bool             // uncorrelated IN: whether a value is NULL
+	byKey   map[string][]any // decorrelated: values by inner keys
+}
+
+// source is the operator the subplan runs: below the projection when
+// decorrelated, since the inner keys read the rows before it.
+func (p *subplan) source() operator {
+	if proj, ok := p.op.(*projectOp); ok && p.inner != nil {
+		return proj.input
+	}
+	return p.op
+}
+
+func (p *subplan) describe() (string, []string) {
+	var name string
+	var details []string
+	switch {
+	case p.inner != nil:
+		name = "Hashed SubPlan"
+		keys := make([]string, len(p.inner))
+		for i := range p.inner {
+			keys[i] = p.inner[i].String() + " = " + p.outerKeys[i].String()
+		}
+		details = append(details, "Hash Cond: "+strings.Join(keys, " AND "))
+	case p.correlated:
+		name = "SubPlan"
+	default:
+		name = "InitPlan"
+	}
+	if p.ran {
+		details = append(details, fmt.Sprintf("Loops: %d", p.loops))
+	}
+	return name, details
+}
+
+func (p *subplan) estimate() (float64, float64) { return p.source().estimate() }
+
+func (p *subplan) inputs() []operator { return []operator{p.source()} }
+
+// run runs the subquery once more, adding to its stats rather than
+// replacing them.
+func (p *subplan) run(e *Engine) ([]*storage.Row, error) {
+	start := time.Now()
+	rows, err := e.runOperator(p.source())
+	p.loops++
+	p.ran = true
+	p.rows += len(rows)
+	p.elapsed += time.Since(start)
+	return rows, err
+}
+
+// valuesOf evaluates the value of each row of the subquery.
+func (p *subplan) valuesOf(rows []*storage.Row) ([]any, error) {
+	values := make([]any, len(rows))
+	if p.value == nil {
+		return values, nil
+	}
+	for i, row := range rows {
+		v, err := p.value.Eval(row.Data)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// valuesFor returns the values of the subquery for a row of the enclosing
+// query.
+func (p *subplan) valuesFor(row map[string]any) ([]any, error) {
+	switch {
+	case p.inner != nil:
+		if p.byKey == nil {
+			rows, err := p.run(p.e)
+			if err != nil {
+				return nil, err
+			}
+			p.byKey = make(map[string][]any)
+			for _, r := range rows {
+				key, ok, err := joinKey(p.inner, r.Data)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue // a NULL key equals nothing
+				}
+				var v any
+				if p.value != nil {
+					if v, err = p.value.Eval(r.Data); err != nil {
+						return nil, err
+					}
+				}
+				p.byKey[key] = append(p.byKey[key], v)
+			}
+		}
+		p.outer.Data = row
+		key, ok, err := joinKey(p.outerKeys, nil)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return p.byKey[key], nil
+
+	case p.correlated:
+		p.outer.Data = row
+		rows, err := p.run(p.e)
+		if err != nil {
+			return nil, err
+		}
+		return p.valuesOf(rows)
+
+	default:
+		if !p.done {
+			rows, err := p.run(p.e)
+			if err != nil {
+				return nil, err
+			}
+			if p.values, err = p.valuesOf(rows); err != nil {
+				return nil, err
+			}
+			p.done = true
+		}
+		return p.values, nil
+	}
+}
+
+// Eval evaluates a subquery of kind p.kind for a row of the enclosing
+// query.
+func (p *subplan) Eval(q *expr.Subquery, row map[string]any) (any, error) {
+	values, err := p.valuesFor(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch q.Kind {
+	case expr.ExistsSubquery:
+		return len(values) > 0, nil
+
+	case expr.InSubquery:
+		x, err := q.X.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if !p.correlated {
+			return p.in(x, q.Not)
+		}
+		list := make([]expr.Expr, len(values))
+		for i, v := range values {
+			list[i] = &expr.Literal{Value: v}
+		}
+		return (&expr.In{X: &expr.Literal{Value: x}, List: list, Not: q.Not}).Eval(nil)
+
+	default:
+		switch len(values) {
+		case 0:
+			return nil, nil
+		case 1:
+			return values[0], nil
+		default:
+			return nil, fmt.Errorf("more than one row returned by a subquery used as an expression")
+		}
+	}
+}
+
+// in is "x [NOT] IN (values)" for the values of an uncorrelated subquery,
+// looked up in a hash set. The result is the same as that of expr.In: NULL
+// when x is NULL, or when x is not found and a value is NULL.
+func (p *subplan) in(x any, not bool) (any, error) {
+	if p.set == nil {
+		p.set = make(map[string]bool, len(p.values))
+		for _, v := range p.values {
+			if v == nil {
+				p.hasNull = true
+				continue
+			}
+			p.set[hashKey([]any{keyValue(v)})] = true
+		}
+	}
+	if x == nil {
+		return nil, nil
+	}
+
+	result := storage.False
+	switch {
+	case p.set[hashKey([]any{keyValue(x)})]:
+		result = storage.True
+	case p.hasNull:
+		result = storage.Unknown
+	}
+	// A value of another type cannot be compared with x
+	for _, v := range p.values {
+		if v != nil {
+			if _, err := storage.Compare(x, v); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if not {
+		result = result.Not()
+	}
+	switch result {
+	case storage.True:
+		return true, nil
+	case storage.False:
+		return false, nil
+	default:
+		return nil, nil
+	}
+}
+
+// subplansOf returns the subplans of the expressions an operator
+// evaluates, each once.
+func subplansOf(op operator) []*subplan {
+	var exprs []expr.Expr
+	switch op := op.(type) {
+	case *scanOp:
+		exprs = append(exprs, op.where)
+	case *filterOp:
+		exprs = append(exprs, op.cond)
+	case *nestedLoopJoinOp:
+		exprs = append(exprs, op.residual)
+	case *hashJoinOp:
+		exprs = append(exprs, op.residual)
+		exprs = append(exprs, op.path.LeftKeys...)
+		exprs = append(exprs, op.path.RightKeys...)
+	case *projectOp:
+		exprs = append(exprs, op.exprs...)
+	case *distinctOp:
+		exprs = append(exprs, op.on...)
+	case *windowOp:
+		for _, w := range op.windows {
+			exprs = append(exprs, w)
+		}
+	case *aggregateOp:
+		exprs = append(exprs, op.having)
+		exprs = append(exprs, op.keys...)
+		for _, agg := range op.aggs {
+			exprs = append(exprs, agg)
+		}
+	case *sortOp:
+		for _, k := range op.keys {
+			exprs = append(exprs, k.Expr)
+		}
+	}
+
+	var subs []*subplan
+	seen := make(map[*subplan]bool)
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		expr.Walk(e, func(n expr.Expr) {
+			q, ok := n.(*expr.Subquery)
+			if !ok {
+				return
+			}
+			if sub, ok := q.Plan.(*subplan); ok && !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+		})
+	}
+	return subs
+}
+
+// --------------------------
+// Derived tables
+// --------------------------
+
+// derivedOp runs the query of a derived table, a subquery in FROM, and
+// qualifies the columns of its rows with the table's alias.
+type derivedOp struct {
+	opStats
+	input   operator
+	alias   string
+	columns []string
+}
+
+func (op *derivedOp) describe() (string, []string) {
+	return "Subquery Scan on " + op.alias, nil
+}
+
+func (op *derivedOp) estimate() (float64, float64) { return op.input.estimate() }
+
+func (op *derivedOp) inputs() []operator { return []operator{op.input} }
+
+func (op *derivedOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*storage.Row, len(rows))
+	for i, row := range rows {
+		data := make(map[string]any, len(op.columns))
+		for _, col := range op.columns {
+			data[op.alias+"."+col] = row.Data[col]
+		}
+		out[i] = &storage.Row{Data: data}
+	}
+	return out, nil
+}
@@ -1,13 +1,31 @@
 package engine
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
 
 // Update updates the values of a row identified by its primary key.
+// Tables with a composite primary key take a []any as pk.
 func (e *Engine) Update(tableName string, pk any, values map[string]any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	table, ok := e.db.Tables[tableName]
 	if !ok {
 		return fmt.Errorf("table %s does not exist", tableName)
 	}
 
-	return table.Update(pk, values)
-}
\ No newline at end of file
+	id, err := table.RowIDByPK(pk)
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.update(table, []storage.RowID{id}, values); err != nil {
+		return err
+	}
+
+	e.maybeCheckpoint()
+	return nil
+}
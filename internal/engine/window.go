@@ -0,0 +1,382 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/planner"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Window
+// --------------------------
+
+// buildWindows puts a windowOp on top of input for the window functions
+// that keys, exprs and on use, if they use any.
+func buildWindows(input operator, keys []planner.SortKey, exprs, on []expr.Expr) operator {
+	op := &windowOp{input: input}
+	seen := make(map[string]bool)
+	collect := func(e expr.Expr) {
+		expr.Walk(e, func(n expr.Expr) {
+			if w, ok := n.(*expr.Window); ok && !seen[w.String()] {
+				seen[w.String()] = true
+				op.windows = append(op.windows, w)
+			}
+		})
+	}
+	for _, k := range keys {
+		collect(k.Expr)
+	}
+	for _, e := range exprs {
+		collect(e)
+	}
+	for _, e := range on {
+		collect(e)
+	}
+
+	if len(op.windows) == 0 {
+		return input
+	}
+	return op
+}
+
+// windowOp computes window functions over the rows of its input. It
+// returns the rows in their input order, each with the value of every
+// window function under its SQL text. For each window the rows are sorted
+// by PARTITION BY and then ORDER BY, so that every partition is a run of
+// rows, and so is every set of peers: rows of a partition with equal
+// ORDER BY keys.
+type windowOp struct {
+	opStats
+	input   operator
+	windows []*expr.Window
+}
+
+func (op *windowOp) describe() (string, []string) {
+	details := make([]string, len(op.windows))
+	for i, w := range op.windows {
+		details[i] = "Function: " + w.String()
+	}
+	return "WindowAgg", details
+}
+
+func (op *windowOp) estimate() (float64, float64) {
+	rows, cost := op.input.estimate()
+	return rows, cost + float64(len(op.windows))*rows*math.Log2(rows+1)
+}
+
+func (op *windowOp) inputs() []operator { return []operator{op.input} }
+
+func (op *windowOp) run(e *Engine) ([]*storage.Row, error) {
+	rows, err := e.runOperator(op.input)
+	if err != nil {
+		return nil, err
+	}
+
+	// The input rows may be those of a table
+	out := make([]*storage.Row, len(rows))
+	for i, row := range rows {
+		data := make(map[string]any, len(row.Data)+len(op.windows))
+		for col, v := range row.Data {
+			data[col] = v
+		}
+		out[i] = &storage.Row{Data: data}
+	}
+
+	for _, w := range op.windows {
+		if err := computeWindow(w, out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// computeWindow stores the value of w in each of rows.
+func computeWindow(w *expr.Window, rows []*storage.Row) error {
+	keys := make([]planner.SortKey, 0, len(w.Partition)+len(w.Order))
+	for _, x := range w.Partition {
+		keys = append(keys, planner.SortKey{Expr: x})
+	}
+	for _, k := range w.Order {
+		keys = append(keys, planner.SortKey{Expr: k.X, Desc: k.Desc, NullsFirst: k.NullsFirst})
+	}
+	s := &sorter{keys: keys}
+	if _, err := s.sort(rows); err != nil {
+		return err
+	}
+
+	name := w.String()
+	items := s.items
+	for start := 0; start < len(items); {
+		end := start + 1
+		for end < len(items) && s.equal(items[start], items[end], 0, len(w.Partition)) {
+			end++
+		}
+		p := &partition{w: w, s: s, items: items[start:end], order: len(w.Partition)}
+		values, err := p.values()
+		if err != nil {
+			return err
+		}
+		for i, item := range p.items {
+			item.row.Data[name] = values[i]
+		}
+		start = end
+	}
+	return s.err
+}
+
+// equal reports whether a and b have equal keys from the key at from up
+// to the one at to.
+func (s *sorter) equal(a, b sortItem, from, to int) bool {
+	for i := from; i < to; i++ {
+		if s.compareKey(s.keys[i], a.keys[i], b.keys[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// --------------------------
+// Partitions
+// --------------------------
+
+// partition is the sorted rows of one partition of a window. The sort
+// keys of its items start with those of PARTITION BY; ORDER BY's start at
+// order.
+type partition struct {
+	w     *expr.Window
+	s     *sorter
+	items []sortItem
+	order int
+
+	// The peers of row i are the rows first[i] up to last[i]
+	first, last []int
+}
+
+// values returns the value of the window function for each row.
+func (p *partition) values() ([]any, error) {
+	n := len(p.items)
+	p.first, p.last = make([]int, n), make([]int, n)
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && p.s.equal(p.items[i], p.items[j], p.order, len(p.s.keys)) {
+			j++
+		}
+		for k := i; k < j; k++ {
+			p.first[k], p.last[k] = i, j
+		}
+		i = j
+	}
+
+	values := make([]any, n)
+	var err error
+	switch p.w.Func {
+	case "ROW_NUMBER":
+		for i := range values {
+			values[i] = int64(i + 1)
+		}
+
+	case "RANK":
+		for i := range values {
+			values[i] = int64(p.first[i] + 1)
+		}
+
+	case "DENSE_RANK":
+		rank := int64(0)
+		for i := range values {
+			if p.first[i] == i {
+				rank++
+			}
+			values[i] = rank
+		}
+
+	case "LAG", "LEAD":
+		for i := range values {
+			if values[i], err = p.shift(i); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		err = p.frameValues(values)
+	}
+	return values, err
+}
+
+// shift returns the value of LAG or LEAD for row i: the first argument for
+// the row offset rows before or after it, or the default when there is no
+// such row in the partition.
+func (p *partition) shift(i int) (any, error) {
+	args, row := p.w.Args, p.items[i].row.Data
+	offset := int64(1)
+	if len(args) > 1 {
+		v, err := args[1].Eval(row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%s offset must be an integer, not %s", p.w.Func, storage.TypeOf(v))
+		}
+		offset = n
+	}
+	if p.w.Func == "LAG" {
+		offset = -offset
+	}
+
+	if j := int64(i) + offset; j >= 0 && j < int64(len(p.items)) {
+		return args[0].Eval(p.items[j].row.Data)
+	}
+	if len(args) > 2 {
+		return args[2].Eval(row)
+	}
+	return nil, nil
+}
+
+// frameValues computes FIRST_VALUE, LAST_VALUE or an aggregate over the
+// frame of each row. An aggregate whose frame starts at the start of the
+// partition keeps adding rows to a single accumulator, since the frame
+// of each row then holds that of the row before it.
+func (p *partition) frameValues(values []any) error {
+	frame := p.w.Frame
+	if frame == nil {
+		frame = &expr.Frame{
+			Range: true,
+			Start: expr.FrameBound{Type: expr.UnboundedPreceding},
+			End:   expr.FrameBound{Type: expr.CurrentRow},
+		}
+	}
+
+	var acc *accumulator
+	added := 0
+	for i := range values {
+		lo, err := p.bound(frame, frame.Start, i, false)
+		if err != nil {
+			return err
+		}
+		hi, err := p.bound(frame, frame.End, i, true)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case p.w.Agg != nil:
+			if acc == nil || frame.Start.Type != expr.UnboundedPreceding {
+				acc, added = newAccumulator(p.w.Agg), lo
+			}
+			for ; added < hi; added++ {
+				if err := acc.add(p.items[added].row.Data); err != nil {
+					return err
+				}
+			}
+			values[i] = acc.result()
+
+		case lo >= hi:
+			values[i] = nil
+
+		case p.w.Func == "FIRST_VALUE":
+			if values[i], err = p.w.Args[0].Eval(p.items[lo].row.Data); err != nil {
+				return err
+			}
+
+		default: // LAST_VALUE
+			if values[i], err = p.w.Args[0].Eval(p.items[hi-1].row.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bound returns where b puts the start of the frame of row i, or its end
+// when end is set, as an index of the partition's rows; the frame ends
+// before the row at its end.
+func (p *partition) bound(frame *expr.Frame, b expr.FrameBound, i int, end bool) (int, error) {
+	n := len(p.items)
+	next := 0 // ends are one past the last row of the frame
+	if end {
+		next = 1
+	}
+
+	switch b.Type {
+	case expr.UnboundedPreceding:
+		return 0, nil
+	case expr.UnboundedFollowing:
+		return n, nil
+	case expr.CurrentRow:
+		if !frame.Range {
+			return i + next, nil
+		}
+		if end {
+			return p.last[i], nil
+		}
+		return p.first[i], nil
+	}
+
+	offset, err := b.Offset.Eval(nil)
+	if err != nil {
+		return 0, err
+	}
+	if !frame.Range {
+		k := int(offset.(int64))
+		if b.Type == expr.Preceding {
+			k = -k
+		}
+		return min(max(i+k+next, 0), n), nil
+	}
+	return p.rangeBound(b, numeric(offset), i, end)
+}
+
+// rangeBound returns the bound of a RANGE frame of row i at a distance of
+// offset from its ORDER BY key. The distances of the other rows grow in
+// the order of the partition, so the bound is found by binary search.
+// Rows whose key is NULL have NULLs only as their peers.
+func (p *partition) rangeBound(b expr.FrameBound, offset float64, i int, end bool) (int, error) {
+	k := p.s.keys[p.order]
+	v := p.items[i].keys[p.order]
+	if v == nil {
+		if end {
+			return p.last[i], nil
+		}
+		return p.first[i], nil
+	}
+	if _, ok := v.(int64); !ok {
+		if _, ok := v.(float64); !ok {
+			return 0, fmt.Errorf("RANGE with an offset needs a numeric ORDER BY key, not %s", storage.TypeOf(v))
+		}
+	}
+
+	if b.Type == expr.Preceding {
+		offset = -offset
+	}
+	distance := func(j int) float64 {
+		w := p.items[j].keys[p.order]
+		if w == nil {
+			if k.NullsFirst {
+				return math.Inf(-1)
+			}
+			return math.Inf(1)
+		}
+		d := numeric(w) - numeric(v)
+		if k.Desc {
+			d = -d
+		}
+		return d
+	}
+	if end {
+		return sort.Search(len(p.items), func(j int) bool { return distance(j) > offset }), nil
+	}
+	return sort.Search(len(p.items), func(j int) bool { return distance(j) >= offset }), nil
+}
+
+// numeric returns an INT or FLOAT value as a float64.
+func numeric(v any) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	f, _ := v.(float64)
+	return f
+}
@@ -0,0 +1,56 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Aggregate is a call of an aggregate function: COUNT, SUM, AVG, MIN or
+// MAX. Arg is nil for COUNT(*). Distinct makes the function see each
+// distinct argument value once.
+//
+// An aggregate is computed over a group of rows by the engine, which
+// stores the result in the group's row under the aggregate's SQL text;
+// evaluating the aggregate reads it back from there.
+type Aggregate struct {
+	Func     string // upper case
+	Arg      Expr
+	Distinct bool
+}
+
+var aggregates = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+}
+
+// IsAggregate reports whether name is an aggregate function.
+func IsAggregate(name string) bool {
+	return aggregates[strings.ToUpper(name)]
+}
+
+// HasAggregate reports whether e contains an aggregate.
+func HasAggregate(e Expr) bool {
+	found := false
+	Walk(e, func(n Expr) {
+		if _, ok := n.(*Aggregate); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+func (e *Aggregate) Eval(row map[string]any) (any, error) {
+	if v, ok := row[e.String()]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("aggregate function %s is not allowed here", e.Func)
+}
+
+func (e *Aggregate) String() string {
+	if e.Arg == nil {
+		return e.Func + "(*)"
+	}
+	if e.Distinct {
+		return e.Func + "(DISTINCT " + e.Arg.String() + ")"
+	}
+	return e.Func + "(" + e.Arg.String() + ")"
+}
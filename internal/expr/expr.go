@@ -0,0 +1,582 @@
+// Package expr holds SQL expression trees and evaluates them against rows.
+//
+// Expressions are built by the parser and used wherever a value has to be
+// computed from a row: CHECK constraints, column defaults and WHERE
+// clauses. Evaluation follows SQL semantics: NULL propagates through
+// arithmetic and comparisons, and boolean operators use three-valued logic
+// (storage.Truth), with an unknown result represented as NULL.
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// Expr is a node of an expression tree. String renders the node as SQL
+// that parses back to an equivalent tree.
+type Expr interface {
+	Eval(row map[string]any) (any, error)
+	String() string
+}
+
+// Literal is a constant value.
+type Literal struct {
+	Value any
+}
+
+// ColumnRef reads a column of the current row. A column the row has no
+// value for is NULL. Table is the table or alias qualifying the column,
+// as in "u.id"; rows that combine tables hold each column under its
+// qualified name.
+type ColumnRef struct {
+	Table string
+	Name  string
+}
+
+// Star is "*" or "table.*" in a select list: every column of the tables
+// of FROM, or of one of them. The engine expands it into column references
+// before the query runs, so it has no value of its own.
+type Star struct {
+	Table string
+}
+
+// Unary is "-x" or "NOT x".
+type Unary struct {
+	Op string
+	X  Expr
+}
+
+// Binary is an arithmetic, concatenation, comparison or boolean operator.
+type Binary struct {
+	Op   string
+	L, R Expr
+}
+
+// IsNull is "x IS NULL", or "x IS NOT NULL" when Not is set.
+type IsNull struct {
+	X   Expr
+	Not bool
+}
+
+// Cast is "CAST(x AS type)".
+type Cast struct {
+	X    Expr
+	Type storage.ColumnType
+}
+
+// Call is a call of a built-in scalar function.
+type Call struct {
+	Name string // upper case
+	Args []Expr
+}
+
+// --------------------------
+// Evaluation
+// --------------------------
+
+func (e *Literal) Eval(map[string]any) (any, error) {
+	return e.Value, nil
+}
+
+func (e *ColumnRef) Eval(row map[string]any) (any, error) {
+	return row[e.String()], nil
+}
+
+func (e *Star) Eval(map[string]any) (any, error) {
+	return nil, fmt.Errorf("%s cannot be used here", e)
+}
+
+func (e *Unary) Eval(row map[string]any) (any, error) {
+	x, err := e.X.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "NOT":
+		t, err := Truth(x)
+		if err != nil {
+			return nil, err
+		}
+		return truthValue(t.Not()), nil
+	case "-":
+		return arithmetic("-", int64(0), x)
+	default:
+		return nil, fmt.Errorf("unknown unary operator %s", e.Op)
+	}
+}
+
+func (e *Binary) Eval(row map[string]any) (any, error) {
+	l, err := e.L.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	// AND and OR look at the right side even when the left is NULL, since
+	// FALSE AND NULL is FALSE and TRUE OR NULL is TRUE
+	if e.Op == "AND" || e.Op == "OR" {
+		lt, err := Truth(l)
+		if err != nil {
+			return nil, err
+		}
+		if (e.Op == "AND" && lt == storage.False) || (e.Op == "OR" && lt == storage.True) {
+			return truthValue(lt), nil
+		}
+
+		r, err := e.R.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		rt, err := Truth(r)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "AND" {
+			return truthValue(lt.And(rt)), nil
+		}
+		return truthValue(lt.Or(rt)), nil
+	}
+
+	r, err := e.R.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "+", "-", "*", "/", "%":
+		return arithmetic(e.Op, l, r)
+	case "||":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		ls, _ := storage.Cast(l, storage.TextType)
+		rs, _ := storage.Cast(r, storage.TextType)
+		return ls.(string) + rs.(string), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		cmp, err := compare(e.L, l, e.R, r)
+		if err != nil {
+			return nil, err
+		}
+		return compareResult(e.Op, cmp), nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", e.Op)
+	}
+}
+
+// compare orders l and r, the values of le and re. A string literal
+// compared with a value of another type is read as that type, the way
+// PostgreSQL reads an untyped literal, so d = '2024-01-01' compares DATEs
+// and id = '1' INTs.
+func compare(le Expr, l any, re Expr, r any) (int, error) {
+	var err error
+	if l, err = literalAs(le, l, r); err != nil {
+		return 0, err
+	}
+	if r, err = literalAs(re, r, l); err != nil {
+		return 0, err
+	}
+	return storage.Compare(l, r)
+}
+
+// literalAs returns v, the value of e, converted to the type of other
+// when e is a string literal and other is not a string.
+func literalAs(e Expr, v, other any) (any, error) {
+	s, ok := v.(string)
+	if _, lit := e.(*Literal); !lit || !ok {
+		return v, nil
+	}
+	if _, text := other.(string); text {
+		return v, nil
+	}
+	return storage.Cast(s, storage.TypeOf(other))
+}
+
+func (e *IsNull) Eval(row map[string]any) (any, error) {
+	x, err := e.X.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return (x == nil) != e.Not, nil
+}
+
+func (e *Cast) Eval(row map[string]any) (any, error) {
+	x, err := e.X.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Cast(x, e.Type)
+}
+
+func (e *Call) Eval(row map[string]any) (any, error) {
+	fn, ok := functions[e.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", e.Name)
+	}
+
+	args := make([]any, len(e.Args))
+	for i, arg := range e.Args {
+		v, err := arg.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return fn.call(args)
+}
+
+// Truth converts the result of a condition to a storage.Truth. NULL is
+// Unknown; anything other than a BOOL is an error.
+func Truth(v any) (storage.Truth, error) {
+	switch x := v.(type) {
+	case nil:
+		return storage.Unknown, nil
+	case bool:
+		return storage.TruthOf(x), nil
+	default:
+		return storage.False, fmt.Errorf("expected BOOL condition but got %s", storage.TypeOf(v))
+	}
+}
+
+// Test evaluates a condition against row.
+func Test(e Expr, row map[string]any) (storage.Truth, error) {
+	v, err := e.Eval(row)
+	if err != nil {
+		return storage.False, err
+	}
+	return Truth(v)
+}
+
+// Columns returns the distinct columns e refers to, in order of first use.
+// Qualified columns are named with their table, as in "u.id".
+func Columns(e Expr) []string {
+	var cols []string
+	seen := make(map[string]bool)
+	Walk(e, func(n Expr) {
+		if ref, ok := n.(*ColumnRef); ok && !seen[ref.String()] {
+			seen[ref.String()] = true
+			cols = append(cols, ref.String())
+		}
+	})
+	return cols
+}
+
+// Walk calls fn for e and every expression below it.
+func Walk(e Expr, fn func(Expr)) {
+	fn(e)
+	switch n := e.(type) {
+	case *Unary:
+		Walk(n.X, fn)
+	case *Binary:
+		Walk(n.L, fn)
+		Walk(n.R, fn)
+	case *IsNull:
+		Walk(n.X, fn)
+	case *Cast:
+		Walk(n.X, fn)
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	case *In:
+		Walk(n.X, fn)
+		for _, item := range n.List {
+			Walk(item, fn)
+		}
+	case *Between:
+		Walk(n.X, fn)
+		Walk(n.Lo, fn)
+		Walk(n.Hi, fn)
+	case *Match:
+		Walk(n.X, fn)
+		Walk(n.Pattern, fn)
+	case *Aggregate:
+		if n.Arg != nil {
+			Walk(n.Arg, fn)
+		}
+	case *Subquery:
+		if n.X != nil {
+			Walk(n.X, fn)
+		}
+	case *Window:
+		for _, x := range n.exprs() {
+			Walk(x, fn)
+		}
+	}
+}
+
+// Replace returns a copy of e in which every expression fn returns a
+// replacement for is replaced; fn is not called below a replacement. The
+// parts of e that are not replaced are shared with it only when they are
+// leaves.
+func Replace(e Expr, fn func(Expr) (Expr, bool)) Expr {
+	if r, ok := fn(e); ok {
+		return r
+	}
+
+	list := func(items []Expr) []Expr {
+		out := make([]Expr, len(items))
+		for i, item := range items {
+			out[i] = Replace(item, fn)
+		}
+		return out
+	}
+
+	switch n := e.(type) {
+	case *Unary:
+		return &Unary{Op: n.Op, X: Replace(n.X, fn)}
+	case *Binary:
+		return &Binary{Op: n.Op, L: Replace(n.L, fn), R: Replace(n.R, fn)}
+	case *IsNull:
+		return &IsNull{X: Replace(n.X, fn), Not: n.Not}
+	case *Cast:
+		return &Cast{X: Replace(n.X, fn), Type: n.Type}
+	case *Call:
+		return &Call{Name: n.Name, Args: list(n.Args)}
+	case *In:
+		return &In{X: Replace(n.X, fn), List: list(n.List), Not: n.Not}
+	case *Between:
+		return &Between{X: Replace(n.X, fn), Lo: Replace(n.Lo, fn), Hi: Replace(n.Hi, fn), Not: n.Not}
+	case *Match:
+		return &Match{Op: n.Op, X: Replace(n.X, fn), Pattern: Replace(n.Pattern, fn), Not: n.Not}
+	case *Aggregate:
+		if n.Arg == nil {
+			return n
+		}
+		return &Aggregate{Func: n.Func, Arg: Replace(n.Arg, fn), Distinct: n.Distinct}
+	case *Subquery:
+		out := *n
+		if n.X != nil {
+			out.X = Replace(n.X, fn)
+		}
+		return &out
+	case *Window:
+		return n.replace(fn)
+	default:
+		return e
+	}
+}
+
+func truthValue(t storage.Truth) any {
+	switch t {
+	case storage.True:
+		return true
+	case storage.False:
+		return false
+	default:
+		return nil
+	}
+}
+
+func compareResult(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// arithmetic applies op to two numbers. INT op INT stays INT (division
+// truncates); if either side is FLOAT the result is FLOAT.
+func arithmetic(op string, l, r any) (any, error) {
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+
+	lf, lok := number(l)
+	rf, rok := number(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s cannot be applied to %s and %s", op, storage.TypeOf(l), storage.TypeOf(r))
+	}
+
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	default:
+		return nil, fmt.Errorf("operator %% cannot be applied to FLOAT")
+	}
+}
+
+func number(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// --------------------------
+// SQL rendering
+// --------------------------
+
+// Operator precedence, loosest first. Used to decide where String needs
+// parentheses.
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precAdd
+	precMul
+	precUnary
+	precPrimary
+)
+
+func precedence(e Expr) int {
+	switch n := e.(type) {
+	case *Binary:
+		switch n.Op {
+		case "OR":
+			return precOr
+		case "AND":
+			return precAnd
+		case "=", "!=", "<", "<=", ">", ">=":
+			return precCompare
+		case "+", "-", "||":
+			return precAdd
+		default:
+			return precMul
+		}
+	case *Unary:
+		if n.Op == "NOT" {
+			return precNot
+		}
+		return precUnary
+	case *IsNull, *In, *Between, *Match:
+		return precCompare
+	case *Subquery:
+		if n.Kind == InSubquery {
+			return precCompare
+		}
+		return precPrimary
+	default:
+		return precPrimary
+	}
+}
+
+// wrap renders e, in parentheses if it binds looser than prec.
+func wrap(e Expr, prec int) string {
+	if precedence(e) < prec {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+func (e *Literal) String() string {
+	switch v := e.Value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64, float64:
+		return storage.FormatValue(v)
+	default:
+		return "CAST('" + storage.FormatValue(v) + "' AS " + string(storage.TypeOf(v)) + ")"
+	}
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return e.Table + "." + e.Name
+	}
+	return e.Name
+}
+
+func (e *Star) String() string {
+	if e.Table != "" {
+		return e.Table + ".*"
+	}
+	return "*"
+}
+
+func (e *Unary) String() string {
+	if e.Op == "NOT" {
+		return "NOT " + wrap(e.X, precNot)
+	}
+	x := wrap(e.X, precUnary)
+	if strings.HasPrefix(x, "-") {
+		// "--" would start a comment
+		x = "(" + x + ")"
+	}
+	return e.Op + x
+}
+
+func (e *Binary) String() string {
+	prec := precedence(e)
+	// Operators are left associative, so the right side needs parentheses
+	// at equal precedence
+	return wrap(e.L, prec) + " " + e.Op + " " + wrap(e.R, prec+1)
+}
+
+func (e *IsNull) String() string {
+	if e.Not {
+		return wrap(e.X, precAdd) + " IS NOT NULL"
+	}
+	return wrap(e.X, precAdd) + " IS NULL"
+}
+
+func (e *Cast) String() string {
+	return "CAST(" + e.X.String() + " AS " + string(e.Type) + ")"
+}
+
+func (e *Call) String() string {
+	if len(e.Args) == 0 && functions[e.Name].bare {
+		return e.Name
+	}
+
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,266 @@
+package expr
+
+import (
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	row := map[string]any{"a": int64(7), "b": 2.5, "name": "Ada", "missing": nil, "u.a": int64(1)}
+	col := func(name string) Expr { return &ColumnRef{Name: name} }
+	lit := func(v any) Expr { return &Literal{Value: v} }
+
+	cases := []struct {
+		e    Expr
+		want any
+	}{
+		{&Binary{Op: "+", L: col("a"), R: lit(int64(3))}, int64(10)},
+		{&Binary{Op: "/", L: col("a"), R: lit(int64(2))}, int64(3)},
+		{&Binary{Op: "*", L: col("a"), R: col("b")}, 17.5},
+		{&Binary{Op: "+", L: col("a"), R: col("missing")}, nil},
+		{&Binary{Op: "||", L: col("name"), R: lit(int64(1))}, "Ada1"},
+		{&Binary{Op: ">", L: col("a"), R: col("b")}, true},
+		{&Binary{Op: "=", L: col("missing"), R: lit(nil)}, nil},
+		{&Unary{Op: "-", X: col("a")}, int64(-7)},
+		{&IsNull{X: col("missing")}, true},
+		{&IsNull{X: col("unknown_column"), Not: true}, false},
+		{&Binary{Op: "+", L: col("a"), R: &ColumnRef{Table: "u", Name: "a"}}, int64(8)},
+		// Three-valued logic
+		{&Binary{Op: "AND", L: lit(nil), R: lit(false)}, false},
+		{&Binary{Op: "AND", L: lit(nil), R: lit(true)}, nil},
+		{&Binary{Op: "OR", L: lit(nil), R: lit(true)}, true},
+		{&Binary{Op: "OR", L: lit(nil), R: lit(false)}, nil},
+		{&Unary{Op: "NOT", X: lit(nil)}, nil},
+		{&Call{Name: "COALESCE", Args: []Expr{col("missing"), lit("x")}}, "x"},
+		{&Call{Name: "LENGTH", Args: []Expr{col("name")}}, int64(3)},
+		// Predicates
+		{&In{X: col("a"), List: []Expr{lit(int64(1)), lit(7.0)}}, true},
+		{&In{X: col("a"), List: []Expr{lit(int64(1)), lit(nil)}}, nil},
+		{&In{X: col("a"), List: []Expr{lit(int64(7)), lit(nil)}, Not: true}, false},
+		{&In{X: col("missing"), List: []Expr{lit(int64(1))}, Not: true}, nil},
+		{&Between{X: col("a"), Lo: lit(int64(7)), Hi: lit(int64(9))}, true},
+		{&Between{X: col("a"), Lo: lit(int64(1)), Hi: lit(int64(5)), Not: true}, true},
+		{&Between{X: col("a"), Lo: lit(nil), Hi: lit(int64(5))}, false},
+		{&Between{X: col("a"), Lo: lit(nil), Hi: lit(int64(9))}, nil},
+		{&Match{Op: "LIKE", X: col("name"), Pattern: lit("A_a")}, true},
+		{&Match{Op: "LIKE", X: col("name"), Pattern: lit("a%")}, false},
+		{&Match{Op: "ILIKE", X: col("name"), Pattern: lit("a%")}, true},
+		{&Match{Op: "LIKE", X: lit("50%"), Pattern: lit(`50\%`)}, true},
+		{&Match{Op: "LIKE", X: lit("a.c"), Pattern: lit("a.c")}, true},
+		{&Match{Op: "LIKE", X: lit("abc"), Pattern: lit("a.c")}, false},
+		{&Match{Op: "~", X: col("name"), Pattern: lit("d")}, true},
+		{&Match{Op: "~", X: col("name"), Pattern: lit("^d"), Not: true}, true},
+		{&Match{Op: "LIKE", X: col("missing"), Pattern: lit("%")}, nil},
+		// A string literal takes the type of what it is compared with
+		{&Binary{Op: "=", L: col("a"), R: lit("7")}, true},
+		{&Binary{Op: "<", L: lit("3"), R: col("b")}, false},
+		{&In{X: col("a"), List: []Expr{lit("1"), lit("7")}}, true},
+		{&Between{X: col("b"), Lo: lit("2"), Hi: lit("2.5")}, true},
+	}
+
+	for _, tc := range cases {
+		got, err := tc.e.Eval(row)
+		if err != nil || got != tc.want {
+			t.Errorf("%s = %v (%T), %v; want %v", tc.e, got, got, err, tc.want)
+		}
+	}
+
+	errs := []Expr{
+		&Binary{Op: "/", L: col("a"), R: lit(int64(0))},
+		&Binary{Op: "+", L: col("name"), R: lit(int64(1))},
+		&Binary{Op: "<", L: col("name"), R: col("a")},
+		&Unary{Op: "NOT", X: col("a")},
+		&In{X: col("name"), List: []Expr{lit(int64(1))}},
+		&Binary{Op: "=", L: col("a"), R: lit("seven")},
+		&Match{Op: "LIKE", X: col("a"), Pattern: lit("7")},
+		&Match{Op: "~", X: col("name"), Pattern: lit("(")},
+	}
+	for _, e := range errs {
+		if _, err := e.Eval(row); err == nil {
+			t.Errorf("%s: expected error, got nil", e)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	e := &Binary{
+		Op: "*",
+		L:  &Binary{Op: "+", L: &ColumnRef{Name: "a"}, R: &Literal{Value: int64(1)}},
+		R:  &Unary{Op: "-", X: &Literal{Value: int64(-2)}},
+	}
+	if got := e.String(); got != "(a + 1) * -(-2)" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	cond := &Unary{Op: "NOT", X: &Binary{
+		Op: "OR",
+		L:  &IsNull{X: &ColumnRef{Name: "b"}},
+		R:  &Binary{Op: "=", L: &ColumnRef{Name: "c"}, R: &Literal{Value: "it's"}},
+	}}
+	if got := cond.String(); got != "NOT (b IS NULL OR c = 'it''s')" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	preds := map[string]Expr{
+		"a NOT IN (1, 2)":           &In{X: &ColumnRef{Name: "a"}, List: []Expr{&Literal{Value: int64(1)}, &Literal{Value: int64(2)}}, Not: true},
+		"a + 1 BETWEEN 0 AND b * 2": &Between{X: &Binary{Op: "+", L: &ColumnRef{Name: "a"}, R: &Literal{Value: int64(1)}}, Lo: &Literal{Value: int64(0)}, Hi: &Binary{Op: "*", L: &ColumnRef{Name: "b"}, R: &Literal{Value: int64(2)}}},
+		"name NOT ILIKE 'a%'":       &Match{Op: "ILIKE", X: &ColumnRef{Name: "name"}, Pattern: &Literal{Value: "a%"}, Not: true},
+		"u.id = o.user_id":          &Binary{Op: "=", L: &ColumnRef{Table: "u", Name: "id"}, R: &ColumnRef{Table: "o", Name: "user_id"}},
+		"name !~ '^x'":              &Match{Op: "~", X: &ColumnRef{Name: "name"}, Pattern: &Literal{Value: "^x"}, Not: true},
+		"NOT a BETWEEN 1 AND 2 = b": &Unary{Op: "NOT", X: &Binary{Op: "=", L: &Between{X: &ColumnRef{Name: "a"}, Lo: &Literal{Value: int64(1)}, Hi: &Literal{Value: int64(2)}}, R: &ColumnRef{Name: "b"}}},
+	}
+	for want, e := range preds {
+		if got := e.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	sum := &Aggregate{Func: "SUM", Arg: &ColumnRef{Name: "x"}}
+	e := &Binary{Op: ">", L: &Binary{Op: "/", L: sum, R: &Aggregate{Func: "COUNT"}}, R: &ColumnRef{Name: "x"}}
+	if got := e.String(); got != "SUM(x) / COUNT(*) > x" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	out := Replace(e, func(n Expr) (Expr, bool) {
+		if agg, ok := n.(*Aggregate); ok {
+			return &ColumnRef{Name: agg.String()}, true
+		}
+		return nil, false
+	})
+	if got := Columns(out); len(got) != 3 || got[0] != "SUM(x)" || got[1] != "COUNT(*)" || got[2] != "x" {
+		t.Errorf("Columns() = %q", got)
+	}
+	if e.L.(*Binary).L != sum {
+		t.Error("Replace changed the original expression")
+	}
+
+	v, err := out.Eval(map[string]any{"SUM(x)": int64(10), "COUNT(*)": int64(2), "x": int64(4)})
+	if err != nil || v != true {
+		t.Errorf("Eval() = %v, %v", v, err)
+	}
+	if _, err := sum.Eval(map[string]any{"x": int64(1)}); err == nil {
+		t.Error("expected error evaluating an aggregate outside a group")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	sum := &Aggregate{Func: "SUM", Arg: &Aggregate{Func: "SUM", Arg: &ColumnRef{Name: "x"}}}
+	w := &Window{
+		Agg:       sum,
+		Partition: []Expr{&ColumnRef{Name: "g"}},
+		Order:     []OrderKey{{X: &ColumnRef{Name: "d"}, Desc: true, NullsFirst: false}},
+		Frame: &Frame{
+			Start: FrameBound{Type: Preceding, Offset: &Literal{Value: int64(2)}},
+			End:   FrameBound{Type: UnboundedFollowing},
+		},
+	}
+	want := "SUM(SUM(x)) OVER (PARTITION BY g ORDER BY d DESC NULLS LAST ROWS BETWEEN 2 PRECEDING AND UNBOUNDED FOLLOWING)"
+	if got := w.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	// The aggregate of the window is not one of the query, but its
+	// argument is
+	var aggs []string
+	Walk(w, func(n Expr) {
+		if agg, ok := n.(*Aggregate); ok {
+			aggs = append(aggs, agg.String())
+		}
+	})
+	if len(aggs) != 1 || aggs[0] != "SUM(x)" {
+		t.Errorf("Walk found aggregates %q", aggs)
+	}
+
+	out := Replace(w, func(n Expr) (Expr, bool) {
+		if agg, ok := n.(*Aggregate); ok {
+			return &ColumnRef{Name: agg.String()}, true
+		}
+		return nil, false
+	})
+	if got := Columns(out); len(got) != 3 || got[0] != "SUM(x)" || got[1] != "g" || got[2] != "d" {
+		t.Errorf("Columns() = %q", got)
+	}
+	if w.Agg.Arg != sum.Arg {
+		t.Error("Replace changed the original expression")
+	}
+
+	row := map[string]any{out.String(): int64(7)}
+	if v, err := out.Eval(row); err != nil || v != int64(7) {
+		t.Errorf("Eval() = %v, %v", v, err)
+	}
+	if _, err := w.Eval(map[string]any{"x": int64(1)}); err == nil {
+		t.Error("expected error evaluating a window function that was not computed")
+	}
+
+	if _, err := NewWindow("lag", nil); err == nil {
+		t.Error("expected error calling LAG without arguments")
+	}
+	if lead, err := NewWindow("lead", []Expr{&ColumnRef{Name: "x"}}); err != nil || lead.String() != "LEAD(x) OVER ()" {
+		t.Errorf("NewWindow() = %v, %v", lead, err)
+	}
+}
+
+// sqlText stands in for a parsed SELECT.
+type sqlText string
+
+func (s sqlText) String() string { return string(s) }
+
+// firstRow is a SubPlan whose subquery gives the value of column v of the
+// row it is evaluated for.
+type firstRow struct{}
+
+func (firstRow) Eval(q *Subquery, row map[string]any) (any, error) { return row["v"], nil }
+
+func TestSubquery(t *testing.T) {
+	query := sqlText("SELECT id FROM t")
+	for want, e := range map[string]Expr{
+		"(SELECT id FROM t)":              &Subquery{Kind: ScalarSubquery, Query: query},
+		"EXISTS (SELECT id FROM t)":       &Subquery{Kind: ExistsSubquery, Query: query},
+		"NOT EXISTS (SELECT id FROM t)":   &Unary{Op: "NOT", X: &Subquery{Kind: ExistsSubquery, Query: query}},
+		"a + 1 NOT IN (SELECT id FROM t)": &Subquery{Kind: InSubquery, X: &Binary{Op: "+", L: &ColumnRef{Name: "a"}, R: &Literal{Value: int64(1)}}, Not: true, Query: query},
+		"(SELECT id FROM t) * 2":          &Binary{Op: "*", L: &Subquery{Kind: ScalarSubquery, Query: query}, R: &Literal{Value: int64(2)}},
+	} {
+		if got := e.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+
+	q := &Subquery{Kind: ScalarSubquery, Query: query}
+	if _, err := q.Eval(nil); err == nil {
+		t.Error("expected error evaluating an unplanned subquery")
+	}
+	q.Plan = firstRow{}
+	if v, err := q.Eval(map[string]any{"v": int64(3)}); err != nil || v != int64(3) {
+		t.Errorf("Eval() = %v, %v", v, err)
+	}
+
+	// An outer reference reads the enclosing row it is given
+	outer := &OuterRow{}
+	e := &Binary{Op: "=", L: &ColumnRef{Name: "id"}, R: &OuterRef{Name: "u.id", Row: outer}}
+	for _, tc := range []struct {
+		outer map[string]any
+		want  any
+	}{
+		{map[string]any{"u.id": int64(1)}, true},
+		{map[string]any{"u.id": int64(2)}, false},
+		{map[string]any{}, nil},
+	} {
+		outer.Data = tc.outer
+		if v, err := e.Eval(map[string]any{"id": int64(1)}); err != nil || v != tc.want {
+			t.Errorf("Eval() with %v = %v, %v, want %v", tc.outer, v, err, tc.want)
+		}
+	}
+
+	// Replace rewrites the operand of IN but shares the plan
+	in := &Subquery{Kind: InSubquery, X: &ColumnRef{Name: "a"}, Query: query, Plan: firstRow{}}
+	out := Replace(in, func(n Expr) (Expr, bool) {
+		if ref, ok := n.(*ColumnRef); ok {
+			return &ColumnRef{Table: "t", Name: ref.Name}, true
+		}
+		return nil, false
+	}).(*Subquery)
+	if out == in || out.X.String() != "t.a" || in.X.String() != "a" || out.Plan == nil {
+		t.Errorf("Replace() = %s, original %s", out, in)
+	}
+}
@@ -0,0 +1,100 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// function is a built-in scalar function.
+type function struct {
+	minArgs, maxArgs int  // maxArgs < 0 means no limit
+	bare             bool // may be written without parentheses, e.g. CURRENT_DATE
+	call             func(args []any) (any, error)
+}
+
+var functions = map[string]function{
+	"CURRENT_DATE": {bare: true, call: func([]any) (any, error) {
+		y, m, d := time.Now().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+	}},
+	"LOWER":    {minArgs: 1, maxArgs: 1, call: textFunc(strings.ToLower)},
+	"UPPER":    {minArgs: 1, maxArgs: 1, call: textFunc(strings.ToUpper)},
+	"LENGTH":   {minArgs: 1, maxArgs: 1, call: length},
+	"ABS":      {minArgs: 1, maxArgs: 1, call: abs},
+	"COALESCE": {minArgs: 1, maxArgs: -1, call: coalesce},
+}
+
+// IsFunction reports whether name is a built-in function and whether it may
+// be called without parentheses.
+func IsFunction(name string) (exists, bare bool) {
+	fn, ok := functions[strings.ToUpper(name)]
+	return ok, fn.bare
+}
+
+// NewCall builds a call of a built-in function, checking the number of
+// arguments.
+func NewCall(name string, args []Expr) (*Call, error) {
+	name = strings.ToUpper(name)
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %d", name, len(args))
+	}
+	return &Call{Name: name, Args: args}, nil
+}
+
+func textFunc(f func(string) string) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		switch s := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return f(s), nil
+		default:
+			return nil, fmt.Errorf("expected TEXT argument but got %s", storage.TypeOf(s))
+		}
+	}
+}
+
+func length(args []any) (any, error) {
+	switch s := args[0].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return int64(utf8.RuneCountInString(s)), nil
+	default:
+		return nil, fmt.Errorf("LENGTH expects TEXT but got %s", storage.TypeOf(s))
+	}
+}
+
+func abs(args []any) (any, error) {
+	switch x := args[0].(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if x < 0 {
+			return -x, nil
+		}
+		return x, nil
+	case float64:
+		return math.Abs(x), nil
+	default:
+		return nil, fmt.Errorf("ABS expects a number but got %s", storage.TypeOf(x))
+	}
+}
+
+func coalesce(args []any) (any, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
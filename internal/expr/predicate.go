@@ -0,0 +1,265 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// In is "x IN (list)", or "x NOT IN (list)" when Not is set.
+type In struct {
+	X    Expr
+	List []Expr
+	Not  bool
+}
+
+// Between is "x BETWEEN lo AND hi", or "x NOT BETWEEN lo AND hi" when Not
+// is set. The bounds are inclusive.
+type Between struct {
+	X, Lo, Hi Expr
+	Not       bool
+}
+
+// Match is a pattern match. Op is LIKE, ILIKE (LIKE ignoring case) or "~"
+// for a regular expression, written REGEXP or ~. In LIKE patterns "%"
+// matches any run of characters and "_" any single character; a
+// backslash escapes the next character. A regular expression matches
+// anywhere in the text unless it is anchored. Not negates the match.
+type Match struct {
+	Op         string
+	X, Pattern Expr
+	Not        bool
+
+	// the last pattern compiled; patterns are usually constant
+	compiled atomic.Pointer[compiledPattern]
+}
+
+type compiledPattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// --------------------------
+// Evaluation
+// --------------------------
+
+// Eval follows SQL: NULL IN (...) is NULL, and when no item is equal a
+// NULL item makes the result NULL rather than FALSE.
+func (e *In) Eval(row map[string]any) (any, error) {
+	x, err := e.X.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil {
+		return nil, nil
+	}
+
+	result := storage.False
+	for _, item := range e.List {
+		v, err := item.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			result = result.Or(storage.Unknown)
+			continue
+		}
+		cmp, err := compare(e.X, x, item, v)
+		if err != nil {
+			return nil, err
+		}
+		if cmp == 0 {
+			result = storage.True
+			break
+		}
+	}
+
+	if e.Not {
+		result = result.Not()
+	}
+	return truthValue(result), nil
+}
+
+func (e *Between) Eval(row map[string]any) (any, error) {
+	exprs := []Expr{e.X, e.Lo, e.Hi}
+	var vals [3]any
+	for i, x := range exprs {
+		v, err := x.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+
+	bound := func(i int, ok func(int) bool) (storage.Truth, error) {
+		if vals[0] == nil || vals[i] == nil {
+			return storage.Unknown, nil
+		}
+		cmp, err := compare(e.X, vals[0], exprs[i], vals[i])
+		if err != nil {
+			return storage.False, err
+		}
+		return storage.TruthOf(ok(cmp)), nil
+	}
+	lo, err := bound(1, func(c int) bool { return c >= 0 })
+	if err != nil {
+		return nil, err
+	}
+	hi, err := bound(2, func(c int) bool { return c <= 0 })
+	if err != nil {
+		return nil, err
+	}
+
+	result := lo.And(hi)
+	if e.Not {
+		result = result.Not()
+	}
+	return truthValue(result), nil
+}
+
+func (e *Match) Eval(row map[string]any) (any, error) {
+	x, err := e.X.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+	p, err := e.Pattern.Eval(row)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil || p == nil {
+		return nil, nil
+	}
+
+	text, ok := x.(string)
+	pattern, pok := p.(string)
+	if !ok || !pok {
+		return nil, fmt.Errorf("%s cannot be applied to %s and %s", e.opName(), storage.TypeOf(x), storage.TypeOf(p))
+	}
+
+	re, err := e.regexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(text) != e.Not, nil
+}
+
+// regexp compiles pattern, reusing the last compiled pattern when it is
+// the same.
+func (e *Match) regexp(pattern string) (*regexp.Regexp, error) {
+	if c := e.compiled.Load(); c != nil && c.source == pattern {
+		return c.re, nil
+	}
+
+	var source string
+	switch e.Op {
+	case "LIKE":
+		source = LikeRegexp(pattern)
+	case "ILIKE":
+		source = "(?i)" + LikeRegexp(pattern)
+	default:
+		source = pattern
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %s: %w", (&Literal{Value: pattern}).String(), err)
+	}
+
+	e.compiled.Store(&compiledPattern{source: pattern, re: re})
+	return re, nil
+}
+
+// LikeRegexp translates a LIKE pattern into an anchored regular expression.
+func LikeRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		b.WriteString(`\\`)
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// LikePrefix returns the text every string matching a LIKE pattern starts
+// with, and whether the pattern is nothing but that text.
+func LikePrefix(pattern string) (prefix string, exact bool) {
+	var b strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%' || r == '_':
+			return b.String(), false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+	return b.String(), true
+}
+
+func (e *Match) opName() string {
+	if e.Op == "~" {
+		return "REGEXP"
+	}
+	return e.Op
+}
+
+// --------------------------
+// SQL rendering
+// --------------------------
+
+func (e *In) String() string {
+	items := make([]string, len(e.List))
+	for i, item := range e.List {
+		items[i] = item.String()
+	}
+	op := " IN ("
+	if e.Not {
+		op = " NOT IN ("
+	}
+	return wrap(e.X, precAdd) + op + strings.Join(items, ", ") + ")"
+}
+
+func (e *Between) String() string {
+	op := " BETWEEN "
+	if e.Not {
+		op = " NOT BETWEEN "
+	}
+	return wrap(e.X, precAdd) + op + wrap(e.Lo, precAdd) + " AND " + wrap(e.Hi, precAdd)
+}
+
+func (e *Match) String() string {
+	op := e.Op
+	switch {
+	case e.Op == "~" && e.Not:
+		op = "!~"
+	case e.Not:
+		op = "NOT " + e.Op
+	}
+	return wrap(e.X, precAdd) + " " + op + " " + wrap(e.Pattern, precAdd)
+}
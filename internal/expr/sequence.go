@@ -0,0 +1,51 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sequences hands out sequence values to NEXTVAL and CURRVAL. The engine
+// implements it; the expression package has no access to the database.
+type Sequences interface {
+	NextVal(name string) (int64, error)
+	CurrVal(name string) (int64, error)
+}
+
+// SequenceCall is nextval('name') or currval('name'). Unlike other calls it
+// has a side effect and needs the database, so it can only be evaluated
+// after BindSequences.
+type SequenceCall struct {
+	Func     string // NEXTVAL or CURRVAL
+	Sequence string
+	Seqs     Sequences
+}
+
+// IsSequenceFunction reports whether name is NEXTVAL or CURRVAL.
+func IsSequenceFunction(name string) bool {
+	name = strings.ToUpper(name)
+	return name == "NEXTVAL" || name == "CURRVAL"
+}
+
+// BindSequences gives every sequence call in e access to seqs.
+func BindSequences(e Expr, seqs Sequences) {
+	Walk(e, func(n Expr) {
+		if call, ok := n.(*SequenceCall); ok {
+			call.Seqs = seqs
+		}
+	})
+}
+
+func (e *SequenceCall) Eval(map[string]any) (any, error) {
+	if e.Seqs == nil {
+		return nil, fmt.Errorf("%s cannot be used here", strings.ToLower(e.Func))
+	}
+	if e.Func == "CURRVAL" {
+		return e.Seqs.CurrVal(e.Sequence)
+	}
+	return e.Seqs.NextVal(e.Sequence)
+}
+
+func (e *SequenceCall) String() string {
+	return strings.ToLower(e.Func) + "(" + (&Literal{Value: e.Sequence}).String() + ")"
+}
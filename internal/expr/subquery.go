@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"fmt"
+)
+
+// SubqueryKind is the way an expression uses the rows of a subquery.
+type SubqueryKind string
+
+const (
+	ScalarSubquery SubqueryKind = "SCALAR" // (SELECT ...)
+	ExistsSubquery SubqueryKind = "EXISTS" // EXISTS (SELECT ...)
+	InSubquery     SubqueryKind = "IN"     // x [NOT] IN (SELECT ...)
+)
+
+// Subquery is a SELECT used within an expression. A scalar subquery gives
+// the single value of its single row, or NULL when it has no row; EXISTS
+// tells whether it has any row; and IN compares X with the values of its
+// rows as IN does with a list.
+//
+// Query is the parsed SELECT, a *parser.Query, which this package cannot
+// name. Like a sequence call, a subquery needs the database: the engine
+// plans it and sets Plan to run it. A correlated subquery refers to the
+// columns of the enclosing query, so its result changes from row to row.
+type Subquery struct {
+	Kind SubqueryKind
+	X    Expr // IN
+	Not  bool // NOT IN
+	// Query renders as the SQL of the SELECT
+	Query fmt.Stringer
+
+	Plan       SubPlan
+	Correlated bool
+}
+
+// SubPlan evaluates a subquery for the engine, given the row of the
+// enclosing query it is evaluated for.
+type SubPlan interface {
+	Eval(q *Subquery, row map[string]any) (any, error)
+}
+
+// OuterRow holds the row of the enclosing query a correlated subquery is
+// running for.
+type OuterRow struct {
+	Data map[string]any
+}
+
+// OuterRef reads a column of the enclosing query from within a correlated
+// subquery. During one run of the subquery it is a constant. Name is the
+// column's name in the rows of the enclosing query.
+type OuterRef struct {
+	Name string
+	Row  *OuterRow
+}
+
+func (e *Subquery) Eval(row map[string]any) (any, error) {
+	if e.Plan == nil {
+		return nil, fmt.Errorf("subqueries cannot be used here")
+	}
+	return e.Plan.Eval(e, row)
+}
+
+func (e *OuterRef) Eval(map[string]any) (any, error) {
+	return e.Row.Data[e.Name], nil
+}
+
+func (e *Subquery) String() string {
+	sql := "(" + e.Query.String() + ")"
+	switch e.Kind {
+	case ExistsSubquery:
+		return "EXISTS " + sql
+	case InSubquery:
+		if e.Not {
+			return wrap(e.X, precAdd) + " NOT IN " + sql
+		}
+		return wrap(e.X, precAdd) + " IN " + sql
+	default:
+		return sql
+	}
+}
+
+func (e *OuterRef) String() string {
+	return e.Name
+}
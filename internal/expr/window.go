@@ -0,0 +1,220 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Window is a call of a window function: FUNC(args) OVER (PARTITION BY
+// ... ORDER BY ... frame). Agg is set for an aggregate used as a window
+// function, such as SUM(x) OVER (...); otherwise Func is one of the
+// ranking and value functions and Args are its arguments.
+//
+// Like an aggregate, a window function is computed by the engine, over
+// the rows of the partition of each row, and stored in the row under the
+// window function's SQL text; evaluating it reads it back from there.
+// Walk and Replace treat Agg as part of the window function, so an
+// aggregate used as a window function does not make a query aggregate.
+type Window struct {
+	Func      string // upper case
+	Args      []Expr
+	Agg       *Aggregate
+	Partition []Expr
+	Order     []OrderKey
+	Frame     *Frame // nil for the default frame
+}
+
+// OrderKey is one key of the ORDER BY of a window. NullsFirst holds the
+// default for the direction when NULLS is not given.
+type OrderKey struct {
+	X          Expr
+	Desc       bool
+	NullsFirst bool
+}
+
+// Frame is the frame of a window: the rows of the partition, relative to
+// the current row, that FIRST_VALUE, LAST_VALUE and aggregates see. ROWS
+// counts rows; RANGE compares the value of the window's single ORDER BY
+// key, and takes rows with equal keys, its peers, along with the current
+// row. Without a frame it is RANGE BETWEEN UNBOUNDED PRECEDING AND
+// CURRENT ROW: the whole partition when the window has no ORDER BY.
+type Frame struct {
+	Range      bool
+	Start, End FrameBound
+}
+
+// BoundType is the kind of a frame bound.
+type BoundType string
+
+const (
+	UnboundedPreceding BoundType = "UNBOUNDED PRECEDING"
+	Preceding          BoundType = "PRECEDING"
+	CurrentRow         BoundType = "CURRENT ROW"
+	Following          BoundType = "FOLLOWING"
+	UnboundedFollowing BoundType = "UNBOUNDED FOLLOWING"
+)
+
+// FrameBound is the start or end of a frame. Offset is the constant of
+// "n PRECEDING" and "n FOLLOWING".
+type FrameBound struct {
+	Type   BoundType
+	Offset Expr
+}
+
+// windowFunction is a ranking or value function.
+type windowFunction struct {
+	minArgs, maxArgs int
+}
+
+var windowFunctions = map[string]windowFunction{
+	"ROW_NUMBER":  {0, 0},
+	"RANK":        {0, 0},
+	"DENSE_RANK":  {0, 0},
+	"LAG":         {1, 3}, // LAG(x [, offset [, default]])
+	"LEAD":        {1, 3},
+	"FIRST_VALUE": {1, 1},
+	"LAST_VALUE":  {1, 1},
+}
+
+// IsWindowFunction reports whether name is a function that can only be
+// used with OVER.
+func IsWindowFunction(name string) bool {
+	_, ok := windowFunctions[strings.ToUpper(name)]
+	return ok
+}
+
+// NewWindow builds a call of a ranking or value function, checking the
+// number of arguments. The window is left empty.
+func NewWindow(name string, args []Expr) (*Window, error) {
+	name = strings.ToUpper(name)
+	fn, ok := windowFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown window function %s", name)
+	}
+	if len(args) < fn.minArgs || len(args) > fn.maxArgs {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %d", name, len(args))
+	}
+	return &Window{Func: name, Args: args}, nil
+}
+
+// HasWindow reports whether e contains a window function.
+func HasWindow(e Expr) bool {
+	found := false
+	Walk(e, func(n Expr) {
+		if _, ok := n.(*Window); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// exprs returns the expressions below w, for Walk.
+func (w *Window) exprs() []Expr {
+	out := append([]Expr{}, w.Args...)
+	if w.Agg != nil && w.Agg.Arg != nil {
+		out = append(out, w.Agg.Arg)
+	}
+	out = append(out, w.Partition...)
+	for _, k := range w.Order {
+		out = append(out, k.X)
+	}
+	return out
+}
+
+// replace returns a copy of w with Replace applied to the expressions
+// below it.
+func (w *Window) replace(fn func(Expr) (Expr, bool)) *Window {
+	out := &Window{Func: w.Func, Frame: w.Frame}
+	for _, arg := range w.Args {
+		out.Args = append(out.Args, Replace(arg, fn))
+	}
+	if w.Agg != nil {
+		out.Agg = &Aggregate{Func: w.Agg.Func, Distinct: w.Agg.Distinct}
+		if w.Agg.Arg != nil {
+			out.Agg.Arg = Replace(w.Agg.Arg, fn)
+		}
+	}
+	for _, x := range w.Partition {
+		out.Partition = append(out.Partition, Replace(x, fn))
+	}
+	for _, k := range w.Order {
+		out.Order = append(out.Order, OrderKey{X: Replace(k.X, fn), Desc: k.Desc, NullsFirst: k.NullsFirst})
+	}
+	return out
+}
+
+func (e *Window) Eval(row map[string]any) (any, error) {
+	if v, ok := row[e.String()]; ok {
+		return v, nil
+	}
+	name := e.Func
+	if e.Agg != nil {
+		name = e.Agg.Func
+	}
+	return nil, fmt.Errorf("window function %s is not allowed here", name)
+}
+
+func (e *Window) String() string {
+	var b strings.Builder
+	if e.Agg != nil {
+		b.WriteString(e.Agg.String())
+	} else {
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = arg.String()
+		}
+		b.WriteString(e.Func + "(" + strings.Join(args, ", ") + ")")
+	}
+
+	var clauses []string
+	if len(e.Partition) > 0 {
+		keys := make([]string, len(e.Partition))
+		for i, x := range e.Partition {
+			keys[i] = x.String()
+		}
+		clauses = append(clauses, "PARTITION BY "+strings.Join(keys, ", "))
+	}
+	if len(e.Order) > 0 {
+		keys := make([]string, len(e.Order))
+		for i, k := range e.Order {
+			keys[i] = k.String()
+		}
+		clauses = append(clauses, "ORDER BY "+strings.Join(keys, ", "))
+	}
+	if e.Frame != nil {
+		clauses = append(clauses, e.Frame.String())
+	}
+	b.WriteString(" OVER (" + strings.Join(clauses, " ") + ")")
+	return b.String()
+}
+
+// String renders the key as SQL, leaving out NULLS when it is the default.
+func (k OrderKey) String() string {
+	s := k.X.String()
+	if k.Desc {
+		s += " DESC"
+	}
+	if k.NullsFirst != k.Desc {
+		if k.NullsFirst {
+			s += " NULLS FIRST"
+		} else {
+			s += " NULLS LAST"
+		}
+	}
+	return s
+}
+
+func (f *Frame) String() string {
+	unit := "ROWS"
+	if f.Range {
+		unit = "RANGE"
+	}
+	return unit + " BETWEEN " + f.Start.String() + " AND " + f.End.String()
+}
+
+func (b FrameBound) String() string {
+	if b.Offset != nil {
+		return b.Offset.String() + " " + string(b.Type)
+	}
+	return string(b.Type)
+}
@@ -0,0 +1,31 @@
+package parser
+
+import "fmt"
+
+// SyntaxError describes why a statement could not be parsed and where.
+type SyntaxError struct {
+	Line   int // 1-based line of the offending token
+	Column int // 1-based column, counted in characters
+	Offset int // byte offset into the statement
+
+	Token    string // the offending token as written, or "end of input"
+	Expected string // what the parser was looking for, if known
+	Message  string // free-form description used instead of Expected
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = fmt.Sprintf("expected %s but found %s", e.Expected, e.Token)
+	}
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, msg)
+}
+
+func newSyntaxError(pos Position, token string) *SyntaxError {
+	return &SyntaxError{
+		Line:   pos.Line,
+		Column: pos.Column,
+		Offset: pos.Offset,
+		Token:  token,
+	}
+}
@@ -0,0 +1,717 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// ParseExpr parses a standalone SQL expression such as "age >= 18". It is
+// used to compile stored CHECK and DEFAULT expressions.
+func ParseExpr(sql string) (expr.Expr, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{tokens: tokens}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.at(EOFToken) {
+		return nil, p.expected("end of expression")
+	}
+	return e, nil
+}
+
+// parseExpr reads an expression. From loosest to tightest binding:
+//
+//	OR
+//	AND
+//	NOT
+//	= != <> < <= > >=, IS [NOT] NULL, [NOT] IN, [NOT] BETWEEN,
+//	[NOT] LIKE, [NOT] ILIKE, [NOT] REGEXP, ~ and !~
+//	+ - ||
+//	* / %
+//	unary -
+func (p *Parser) parseExpr() (expr.Expr, error) {
+	return p.parseOr()
+}
+
+func (p *Parser) parseOr() (expr.Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.Binary{Op: "OR", L: left, R: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseAnd() (expr.Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.Binary{Op: "AND", L: left, R: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (expr.Expr, error) {
+	if p.acceptKeyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Unary{Op: "NOT", X: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *Parser) parseComparison() (expr.Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.acceptKeyword("IS") {
+			not := p.acceptKeyword("NOT")
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			left = &expr.IsNull{X: left, Not: not}
+			continue
+		}
+
+		if p.isPredicate() {
+			left, err = p.parsePredicate(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		tok := p.peek()
+		if tok.Type == OperatorToken && (tok.Value == "~" || tok.Value == "!~") {
+			p.next()
+			pattern, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			left = &expr.Match{Op: "~", X: left, Pattern: pattern, Not: tok.Value == "!~"}
+			continue
+		}
+
+		op, ok := comparisonOperators[tok.Value]
+		if tok.Type != OperatorToken || !ok {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.Binary{Op: op, L: left, R: right}
+	}
+}
+
+// predicateKeywords start the predicates that may follow NOT.
+var predicateKeywords = []string{"IN", "BETWEEN", "LIKE", "ILIKE", "REGEXP"}
+
+// isPredicate reports whether the current tokens start "[NOT] IN",
+// "[NOT] BETWEEN", "[NOT] LIKE", "[NOT] ILIKE" or "[NOT] REGEXP".
+func (p *Parser) isPredicate() bool {
+	tok := p.peek()
+	if tok.Type == IdentToken && strings.EqualFold(tok.Value, "NOT") {
+		tok = p.tokens[p.pos+1]
+	}
+	if tok.Type != IdentToken {
+		return false
+	}
+	for _, kw := range predicateKeywords {
+		if strings.EqualFold(tok.Value, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePredicate reads the rest of "x [NOT] IN (list)", "x [NOT] BETWEEN lo
+// AND hi" or "x [NOT] LIKE|ILIKE|REGEXP pattern". The bounds of BETWEEN
+// leave out boolean operators, so its AND is not taken for a conjunction.
+func (p *Parser) parsePredicate(x expr.Expr) (expr.Expr, error) {
+	not := p.acceptKeyword("NOT")
+
+	switch {
+	case p.acceptKeyword("IN"):
+		if p.atSubquery() {
+			q, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &expr.Subquery{Kind: expr.InSubquery, X: x, Not: not, Query: q}, nil
+		}
+
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		list := []expr.Expr{}
+		for {
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &expr.In{X: x, List: list, Not: not}, nil
+
+	case p.acceptKeyword("BETWEEN"):
+		lo, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Between{X: x, Lo: lo, Hi: hi, Not: not}, nil
+
+	default:
+		op := strings.ToUpper(p.next().Value)
+		if op == "REGEXP" {
+			op = "~"
+		}
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Match{Op: op, X: x, Pattern: pattern, Not: not}, nil
+	}
+}
+
+func (p *Parser) parseAdditive() (expr.Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.Type != OperatorToken || (tok.Value != "+" && tok.Value != "-" && tok.Value != "||") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.Binary{Op: tok.Value, L: left, R: right}
+	}
+}
+
+func (p *Parser) parseMultiplicative() (expr.Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.Type != OperatorToken || (tok.Value != "*" && tok.Value != "/" && tok.Value != "%") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.Binary{Op: tok.Value, L: left, R: right}
+	}
+}
+
+func (p *Parser) parseUnary() (expr.Expr, error) {
+	if p.acceptOperator("+") {
+		return p.parseUnary()
+	}
+
+	if p.acceptOperator("-") {
+		// Fold the sign into numeric literals so "-1" stays a constant
+		if tok := p.peek(); tok.Type == NumberToken {
+			p.next()
+			v, err := p.parseNumber(tok, true)
+			if err != nil {
+				return nil, err
+			}
+			return &expr.Literal{Value: v}, nil
+		}
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Unary{Op: "-", X: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *Parser) parsePrimary() (expr.Expr, error) {
+	tok := p.peek()
+
+	switch tok.Type {
+	case NumberToken:
+		p.next()
+		v, err := p.parseNumber(tok, false)
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Literal{Value: v}, nil
+
+	case StringToken:
+		p.next()
+		return &expr.Literal{Value: tok.Value}, nil
+
+	case QuotedIdentToken:
+		p.next()
+		return p.parseColumnRef(tok.Value)
+
+	case PunctToken:
+		if tok.Value != "(" {
+			break
+		}
+		if p.atSubquery() {
+			q, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &expr.Subquery{Kind: expr.ScalarSubquery, Query: q}, nil
+		}
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+
+	case IdentToken:
+		word := strings.ToUpper(tok.Value)
+		switch word {
+		case "TRUE", "FALSE":
+			p.next()
+			return &expr.Literal{Value: word == "TRUE"}, nil
+		case "NULL":
+			p.next()
+			return &expr.Literal{Value: nil}, nil
+		case "CAST":
+			return p.parseCastExpr()
+		case "EXISTS":
+			if next := p.tokens[p.pos+1]; next.Type != PunctToken || next.Value != "(" {
+				break
+			}
+			p.next()
+			q, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &expr.Subquery{Kind: expr.ExistsSubquery, Query: q}, nil
+		}
+
+		if expr.IsAggregate(word) && p.tokens[p.pos+1].Type == PunctToken && p.tokens[p.pos+1].Value == "(" {
+			return p.parseAggregate()
+		}
+
+		if expr.IsWindowFunction(word) && p.tokens[p.pos+1].Type == PunctToken && p.tokens[p.pos+1].Value == "(" {
+			return p.parseWindowCall()
+		}
+
+		if expr.IsSequenceFunction(word) && p.tokens[p.pos+1].Type == PunctToken && p.tokens[p.pos+1].Value == "(" {
+			return p.parseSequenceCall()
+		}
+
+		if exists, bare := expr.IsFunction(word); exists {
+			if p.tokens[p.pos+1].Type == PunctToken && p.tokens[p.pos+1].Value == "(" {
+				return p.parseCall()
+			}
+			if bare {
+				p.next()
+				return expr.NewCall(word, nil)
+			}
+		}
+
+		if reserved[word] {
+			break
+		}
+		p.next()
+		return p.parseColumnRef(tok.Value)
+	}
+
+	return nil, p.expected("expression")
+}
+
+// parseColumnRef reads the rest of a column reference that starts with
+// name: nothing, or ".column" when name is a table or alias.
+func (p *Parser) parseColumnRef(name string) (expr.Expr, error) {
+	if !p.acceptPunct(".") {
+		return &expr.ColumnRef{Name: name}, nil
+	}
+	col, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &expr.ColumnRef{Table: name, Name: col}, nil
+}
+
+// parseCastExpr reads CAST(expr AS type). A cast of a literal is done
+// while parsing when it can be; one that fails is left to fail when the
+// statement runs, as any other cast does.
+func (p *Parser) parseCastExpr() (expr.Expr, error) {
+	p.next()
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+
+	typ, err := p.parseColumnType()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	if lit, ok := x.(*expr.Literal); ok {
+		if v, err := storage.Cast(lit.Value, typ); err == nil {
+			return &expr.Literal{Value: v}, nil
+		}
+	}
+	return &expr.Cast{X: x, Type: typ}, nil
+}
+
+// parseCall reads name(arg, ...) for a built-in function.
+func (p *Parser) parseCall() (expr.Expr, error) {
+	name := p.next()
+	p.next() // (
+
+	args := []expr.Expr{}
+	if !p.isPunct(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	call, err := expr.NewCall(name.Value, args)
+	if err != nil {
+		return nil, p.errorAt(name, "%v", err)
+	}
+	return call, nil
+}
+
+// parseAggregate reads COUNT(*) or an aggregate function of one argument,
+// which may be preceded by DISTINCT. With OVER it is a window function,
+// whose argument may be an aggregate of the groups of the query.
+func (p *Parser) parseAggregate() (expr.Expr, error) {
+	name := p.next()
+	p.next() // (
+
+	agg := &expr.Aggregate{Func: strings.ToUpper(name.Value)}
+	if agg.Func == "COUNT" && p.acceptOperator("*") {
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		if p.isKeyword("OVER") {
+			return p.parseOver(&expr.Window{Agg: agg})
+		}
+		return agg, nil
+	}
+
+	agg.Distinct = p.acceptKeyword("DISTINCT")
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if expr.HasWindow(arg) {
+		return nil, p.errorAt(name, "aggregate function calls cannot contain window function calls")
+	}
+	agg.Arg = arg
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("OVER") {
+		return p.parseOver(&expr.Window{Agg: agg})
+	}
+	if expr.HasAggregate(arg) {
+		return nil, p.errorAt(name, "aggregate function calls cannot be nested")
+	}
+	return agg, nil
+}
+
+// parseWindowCall reads a ranking or value function and its OVER clause,
+// which it cannot go without.
+func (p *Parser) parseWindowCall() (expr.Expr, error) {
+	name := p.next()
+	p.next() // (
+
+	args := []expr.Expr{}
+	if !p.isPunct(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if expr.HasWindow(arg) {
+				return nil, p.errorAt(name, "window function calls cannot be nested")
+			}
+			args = append(args, arg)
+
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	w, err := expr.NewWindow(name.Value, args)
+	if err != nil {
+		return nil, p.errorAt(name, "%v", err)
+	}
+	if !p.isKeyword("OVER") {
+		return nil, p.errorAt(name, "window function %s requires an OVER clause", w.Func)
+	}
+	return p.parseOver(w)
+}
+
+// parseOver reads "OVER ([PARTITION BY ...] [ORDER BY ...] [frame])" into
+// w.
+func (p *Parser) parseOver(w *expr.Window) (expr.Expr, error) {
+	over := p.next()
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	var keys []expr.Expr
+	if p.acceptKeyword("PARTITION") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			w.Partition = append(w.Partition, x)
+			keys = append(keys, x)
+
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	items, err := p.parseOrderBy()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		w.Order = append(w.Order, expr.OrderKey{X: item.Expr, Desc: item.Desc, NullsFirst: item.NullsFirst})
+		keys = append(keys, item.Expr)
+	}
+	for _, x := range keys {
+		if expr.HasWindow(x) {
+			return nil, p.errorAt(over, "window function calls cannot be nested")
+		}
+	}
+
+	if p.isKeyword("ROWS") || p.isKeyword("RANGE") {
+		if w.Frame, err = p.parseFrame(len(w.Order)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parseFrame reads "ROWS | RANGE BETWEEN start AND end", or "ROWS | RANGE
+// start", which ends at the current row. An offset of RANGE is a distance
+// from the value of the single ORDER BY key of the window, which has
+// orderKeys keys.
+func (p *Parser) parseFrame(orderKeys int) (*expr.Frame, error) {
+	unit := p.next()
+	frame := &expr.Frame{Range: strings.EqualFold(unit.Value, "RANGE")}
+
+	var err error
+	if p.acceptKeyword("BETWEEN") {
+		if frame.Start, err = p.parseFrameBound(frame.Range); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		if frame.End, err = p.parseFrameBound(frame.Range); err != nil {
+			return nil, err
+		}
+	} else {
+		if frame.Start, err = p.parseFrameBound(frame.Range); err != nil {
+			return nil, err
+		}
+		frame.End = expr.FrameBound{Type: expr.CurrentRow}
+	}
+
+	// Bounds in the order of the rows they stand for
+	order := map[expr.BoundType]int{
+		expr.UnboundedPreceding: 0, expr.Preceding: 1, expr.CurrentRow: 2,
+		expr.Following: 3, expr.UnboundedFollowing: 4,
+	}
+	switch {
+	case frame.Start.Type == expr.UnboundedFollowing:
+		return nil, p.errorAt(unit, "frame start cannot be UNBOUNDED FOLLOWING")
+	case frame.End.Type == expr.UnboundedPreceding:
+		return nil, p.errorAt(unit, "frame end cannot be UNBOUNDED PRECEDING")
+	case order[frame.Start.Type] > order[frame.End.Type]:
+		return nil, p.errorAt(unit, "frame starting at %s cannot end at %s", frame.Start, frame.End)
+	}
+	if frame.Range && (frame.Start.Offset != nil || frame.End.Offset != nil) && orderKeys != 1 {
+		return nil, p.errorAt(unit, "RANGE with an offset needs exactly one ORDER BY key")
+	}
+	return frame, nil
+}
+
+// parseFrameBound reads UNBOUNDED PRECEDING, n PRECEDING, CURRENT ROW, n
+// FOLLOWING or UNBOUNDED FOLLOWING. n is a number, an integer for ROWS.
+func (p *Parser) parseFrameBound(isRange bool) (expr.FrameBound, error) {
+	var bound expr.FrameBound
+	tok := p.peek()
+	switch {
+	case p.acceptKeyword("UNBOUNDED"):
+		switch {
+		case p.acceptKeyword("PRECEDING"):
+			bound.Type = expr.UnboundedPreceding
+		case p.acceptKeyword("FOLLOWING"):
+			bound.Type = expr.UnboundedFollowing
+		default:
+			return bound, p.expected("PRECEDING or FOLLOWING")
+		}
+		return bound, nil
+
+	case p.acceptKeyword("CURRENT"):
+		bound.Type = expr.CurrentRow
+		return bound, p.expectKeyword("ROW")
+
+	case tok.Type == NumberToken:
+		p.next()
+		v, err := p.parseNumber(tok, false)
+		if err != nil {
+			return bound, err
+		}
+		if _, ok := v.(int64); !ok && !isRange {
+			return bound, p.errorAt(tok, "ROWS offset must be an integer")
+		}
+		bound.Offset = &expr.Literal{Value: v}
+
+		switch {
+		case p.acceptKeyword("PRECEDING"):
+			bound.Type = expr.Preceding
+		case p.acceptKeyword("FOLLOWING"):
+			bound.Type = expr.Following
+		default:
+			return bound, p.expected("PRECEDING or FOLLOWING")
+		}
+		return bound, nil
+
+	default:
+		return bound, p.expected("frame bound")
+	}
+}
+
+// parseSequenceCall reads nextval('name') or currval('name').
+func (p *Parser) parseSequenceCall() (*expr.SequenceCall, error) {
+	fn := p.next()
+	p.next() // (
+
+	tok := p.peek()
+	if tok.Type != StringToken {
+		return nil, p.expected("sequence name as a quoted string")
+	}
+	p.next()
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return &expr.SequenceCall{Func: strings.ToUpper(fn.Value), Sequence: tok.Value}, nil
+}
+
+// parseNumber converts a number token: integers become int64, anything
+// else float64.
+func (p *Parser) parseNumber(tok Token, negative bool) (any, error) {
+	text := tok.Value
+	if negative {
+		text = "-" + text
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorAt(tok, "invalid number %s", text)
+}
@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenType int
+
+const (
+	EOFToken         TokenType = iota
+	IdentToken                 // users, id, SELECT (keywords are identifiers until the parser decides)
+	QuotedIdentToken           // "Order Details"
+	StringToken                // 'it''s'
+	NumberToken                // 42, 3.14, 1e9
+	OperatorToken              // = != <> < <= > >= + - * / % || ~ !~
+	PunctToken                 // ( ) , ; .
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case EOFToken:
+		return "end of input"
+	case IdentToken, QuotedIdentToken:
+		return "identifier"
+	case StringToken:
+		return "string"
+	case NumberToken:
+		return "number"
+	case OperatorToken:
+		return "operator"
+	case PunctToken:
+		return "punctuation"
+	default:
+		return "unknown"
+	}
+}
+
+// Position is a location in the SQL source. Line and Column are 1-based;
+// Column counts characters, not bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Token is a single lexical unit. For strings and quoted identifiers Value
+// holds the unquoted, unescaped text.
+type Token struct {
+	Type  TokenType
+	Value string
+	Pos   Position
+}
+
+func (t Token) String() string {
+	switch t.Type {
+	case EOFToken:
+		return "end of input"
+	case StringToken:
+		return "'" + strings.ReplaceAll(t.Value, "'", "''") + "'"
+	case QuotedIdentToken:
+		return `"` + strings.ReplaceAll(t.Value, `"`, `""`) + `"`
+	default:
+		return t.Value
+	}
+}
+
+// lexer splits SQL source into tokens.
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+// Tokenize splits sql into tokens, skipping whitespace and comments. The
+// returned slice always ends with an EOFToken.
+func Tokenize(sql string) ([]Token, error) {
+	l := &lexer{src: sql, line: 1, col: 1}
+
+	var tokens []Token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.Type == EOFToken {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) pos() Position {
+	return Position{Offset: l.off, Line: l.line, Column: l.col}
+}
+
+func (l *lexer) peek() rune {
+	if l.off >= len(l.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.off:])
+	return r
+}
+
+func (l *lexer) peekAt(n int) rune {
+	off := l.off
+	for i := 0; i < n && off < len(l.src); i++ {
+		_, size := utf8.DecodeRuneInString(l.src[off:])
+		off += size
+	}
+	if off >= len(l.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[off:])
+	return r
+}
+
+func (l *lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(l.src[l.off:])
+	l.off += size
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+// skipSpace skips whitespace, "-- line" comments and "/* block */" comments.
+func (l *lexer) skipSpace() error {
+	for l.off < len(l.src) {
+		switch r := l.peek(); {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '-' && l.peekAt(1) == '-':
+			for l.off < len(l.src) && l.peek() != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peekAt(1) == '*':
+			start := l.pos()
+			l.advance()
+			l.advance()
+			for {
+				if l.off >= len(l.src) {
+					return l.errorAt(start, "unterminated block comment")
+				}
+				if l.peek() == '*' && l.peekAt(1) == '/' {
+					l.advance()
+					l.advance()
+					break
+				}
+				l.advance()
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) next() (Token, error) {
+	if err := l.skipSpace(); err != nil {
+		return Token{}, err
+	}
+
+	start := l.pos()
+	if l.off >= len(l.src) {
+		return Token{Type: EOFToken, Pos: start}, nil
+	}
+
+	r := l.peek()
+	switch {
+	case isIdentStart(r):
+		for l.off < len(l.src) && isIdentPart(l.peek()) {
+			l.advance()
+		}
+		return Token{Type: IdentToken, Value: l.src[start.Offset:l.off], Pos: start}, nil
+
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peekAt(1))):
+		return l.number(start)
+
+	case r == '\'':
+		value, err := l.quoted('\'', start, "unterminated string literal")
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: StringToken, Value: value, Pos: start}, nil
+
+	case r == '"':
+		value, err := l.quoted('"', start, "unterminated quoted identifier")
+		if err != nil {
+			return Token{}, err
+		}
+		if value == "" {
+			return Token{}, l.errorAt(start, "zero-length quoted identifier")
+		}
+		return Token{Type: QuotedIdentToken, Value: value, Pos: start}, nil
+	}
+
+	// Two character operators first
+	if l.off+1 < len(l.src) {
+		switch two := l.src[l.off : l.off+2]; two {
+		case "!=", "<>", "<=", ">=", "||", "!~":
+			l.advance()
+			l.advance()
+			return Token{Type: OperatorToken, Value: two, Pos: start}, nil
+		}
+	}
+
+	switch r {
+	case '=', '<', '>', '+', '-', '*', '/', '%', '~':
+		l.advance()
+		return Token{Type: OperatorToken, Value: string(r), Pos: start}, nil
+	case '(', ')', ',', ';', '.':
+		l.advance()
+		return Token{Type: PunctToken, Value: string(r), Pos: start}, nil
+	}
+
+	return Token{}, l.errorAt(start, "unexpected character %q", r)
+}
+
+func (l *lexer) number(start Position) (Token, error) {
+	for unicode.IsDigit(l.peek()) {
+		l.advance()
+	}
+	if l.peek() == '.' {
+		l.advance()
+		for unicode.IsDigit(l.peek()) {
+			l.advance()
+		}
+	}
+	if r := l.peek(); r == 'e' || r == 'E' {
+		next := l.peekAt(1)
+		if unicode.IsDigit(next) || ((next == '+' || next == '-') && unicode.IsDigit(l.peekAt(2))) {
+			l.advance()
+			if next == '+' || next == '-' {
+				l.advance()
+			}
+			for unicode.IsDigit(l.peek()) {
+				l.advance()
+			}
+		}
+	}
+
+	if isIdentStart(l.peek()) {
+		return Token{}, l.errorAt(start, "invalid number %q", l.src[start.Offset:l.off+1])
+	}
+
+	return Token{Type: NumberToken, Value: l.src[start.Offset:l.off], Pos: start}, nil
+}
+
+// quoted reads text enclosed in quote, where a doubled quote stands for a
+// single literal one.
+func (l *lexer) quoted(quote rune, start Position, unterminated string) (string, error) {
+	l.advance() // opening quote
+
+	var b strings.Builder
+	for {
+		if l.off >= len(l.src) {
+			return "", l.errorAt(start, "%s", unterminated)
+		}
+		r := l.advance()
+		if r == quote {
+			if l.peek() != quote {
+				return b.String(), nil
+			}
+			l.advance()
+		}
+		b.WriteRune(r)
+	}
+}
+
+func (l *lexer) errorAt(pos Position, format string, args ...any) error {
+	token := "end of input"
+	if r, _ := utf8.DecodeRuneInString(l.src[pos.Offset:]); pos.Offset < len(l.src) {
+		token = string(r)
+	}
+
+	err := newSyntaxError(pos, token)
+	err.Message = fmt.Sprintf(format, args...)
+	return err
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -1,193 +1,1425 @@
 package parser
 
 import (
-	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
 	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
 type QueryType string
 
 const (
-	SelectQuery        QueryType = "SELECT"
-	InsertQuery        QueryType = "INSERT"
-	UpdateQuery        QueryType = "UPDATE"
-	DeleteQuery        QueryType = "DELETE"
-	CreateTableQuery   QueryType = "CREATE_TABLE"
-	AddColumnQuery     QueryType = "ADD_COLUMN"
-	ShowTablesQuery    QueryType = "SHOW_TABLES"
-	DescribeTableQuery QueryType = "DESCRIBE_TABLE"
+	SelectQuery         QueryType = "SELECT"
+	InsertQuery         QueryType = "INSERT"
+	UpdateQuery         QueryType = "UPDATE"
+	DeleteQuery         QueryType = "DELETE"
+	CreateTableQuery    QueryType = "CREATE_TABLE"
+	CreateSequenceQuery QueryType = "CREATE_SEQUENCE"
+	CreateIndexQuery    QueryType = "CREATE_INDEX"
+	DropIndexQuery      QueryType = "DROP_INDEX"
+	AddColumnQuery      QueryType = "ADD_COLUMN"
+	ShowTablesQuery     QueryType = "SHOW_TABLES"
+	DescribeTableQuery  QueryType = "DESCRIBE_TABLE"
+	CheckpointQuery     QueryType = "CHECKPOINT"
+	ExplainQuery        QueryType = "EXPLAIN"
 )
 
-type Filter struct {
-	Column   string
-	Operator string
-	Value    any
-}
-
+// Assignment sets Column to Value. Value is a constant, or an expr.Expr
+// that is evaluated for each row when the statement runs, such as
+// nextval('seq') or, in UPDATE, price * 2.
 type Assignment struct {
 	Column string
 	Value  any
 }
 
+// JoinType is the kind of a join in FROM.
+type JoinType string
+
+const (
+	InnerJoin JoinType = "INNER"
+	LeftJoin  JoinType = "LEFT"
+	RightJoin JoinType = "RIGHT"
+	FullJoin  JoinType = "FULL"
+	CrossJoin JoinType = "CROSS"
+)
+
+// Join joins a table to the tables before it in FROM: "[type] JOIN table
+// [[AS] alias] ON cond" or "... USING (cols)". A comma between tables is a
+// cross join, which has neither ON nor USING. The table may be a derived
+// table, "(SELECT ...) [AS] alias", whose query is in Derived.
+type Join struct {
+	Type    JoinType
+	Table   string
+	Derived *Query
+	Alias   string
+	On      expr.Expr
+	Using   []string
+}
+
+// SetOp is the operator of a compound SELECT.
+type SetOp string
+
+const (
+	Union     SetOp = "UNION"
+	Intersect SetOp = "INTERSECT"
+	Except    SetOp = "EXCEPT"
+)
+
+// CTE is a common table expression of WITH: "name [(cols)] AS (SELECT
+// ...)", a query the statement reads like a table. Under WITH RECURSIVE
+// its query may be "anchor UNION [ALL] step": the anchor's rows, then
+// those of each run of Step, which reads the rows of the run before it
+// under name, until a run adds none. UNION without ALL drops rows seen
+// before.
+type CTE struct {
+	Name     string
+	Columns  []string // named by the query's own result columns if empty
+	Query    *Query
+	Step     *Query // recursive CTEs only
+	UnionAll bool
+}
+
+// OrderItem is one key of ORDER BY: "expr [ASC | DESC] [NULLS FIRST |
+// NULLS LAST]". NULL sorts above every value unless NULLS says otherwise,
+// so by default it comes last in ascending order and first in descending.
+type OrderItem struct {
+	Expr       expr.Expr
+	Desc       bool
+	NullsFirst bool
+}
+
+// ColumnDef is a column definition in CREATE TABLE or ALTER TABLE ADD COLUMN.
+type ColumnDef struct {
+	Name       string
+	Type       storage.ColumnType
+	PrimaryKey bool
+	Unique     bool
+	NotNull    bool
+	Default    expr.Expr
+	Check      expr.Expr
+	References *References
+
+	// Identity is set for AUTOINCREMENT and GENERATED ... AS IDENTITY
+	// columns; IdentityOptions holds the options of the implicit sequence,
+	// if any were given.
+	Identity        storage.IdentityKind
+	IdentityOptions *SequenceDef
+}
+
+// SequenceDef is a sequence declared by CREATE SEQUENCE, or the options of
+// an identity column's sequence.
+type SequenceDef struct {
+	Name      string // empty for identity columns
+	Start     *int64 // nil means 1, or -1 when counting down
+	Increment int64
+}
+
+// References is the target of a foreign key: "REFERENCES table [(cols)]
+// [ON DELETE action] [ON UPDATE action]". Columns is empty when the
+// referenced table's primary key is meant; unset actions are empty.
+type References struct {
+	Table    string
+	Columns  []string
+	OnDelete storage.RefAction
+	OnUpdate storage.RefAction
+}
+
+// TableConstraint is a constraint declared after the columns of
+// CREATE TABLE, e.g. PRIMARY KEY (a, b), CHECK (a < b) or
+// FOREIGN KEY (a) REFERENCES t (b).
+type TableConstraint struct {
+	Name       string
+	Type       storage.ConstraintType
+	Columns    []string
+	Check      expr.Expr
+	References *References // FOREIGN KEY only
+}
+
 type Query struct {
 	Type  QueryType
 	Table string
 
-	// SELECT
+	// SELECT; Columns names the result columns of Select, the selected
+	// expressions, which is nil for SELECT *. A column is named by its AS
+	// alias, if any; an *expr.Star stands for the columns it expands to
 	Columns []string
+	Select  []expr.Expr
+
+	// SELECT DISTINCT keeps one of each set of equal result rows; SELECT
+	// DISTINCT ON (exprs) the first row for each value of DistinctOn
+	Distinct   bool
+	DistinctOn []expr.Expr
+
+	// SELECT ... FROM Table [AS Alias] JOIN ...; a derived table,
+	// "FROM (SELECT ...) [AS] Alias", has its query in Derived and no Table.
+	// A SELECT without FROM has neither
+	Derived *Query
+	Alias   string
+	Joins   []Join
+
+	// WHERE (shared); nil when there is none
+	Where expr.Expr
+
+	// SELECT ... GROUP BY ... HAVING ...
+	GroupBy []expr.Expr
+	Having  expr.Expr
+
+	// SELECT ... ORDER BY ... LIMIT n OFFSET m; Limit is nil without LIMIT
+	OrderBy []OrderItem
+	Limit   *int64
+	Offset  int64
 
-	// WHERE (shared)
-	Filters []Filter
+	// SELECT ... UNION [ALL] SELECT ...; a compound SELECT combines the
+	// rows of Left and Right and has no select list or FROM of its own.
+	// Its ORDER BY, LIMIT and OFFSET apply to the combined rows
+	SetOp       SetOp
+	All         bool
+	Left, Right *Query
 
 	// INSERT / UPDATE
 	Assignments []Assignment
 
+	// INSERT INTO Table (Columns) SELECT ...
+	Source *Query
+
+	// WITH ... before a SELECT, INSERT, UPDATE or DELETE
+	With []CTE
+
 	// DDL
 	ColumnTypes []string
+	ColumnDefs  []ColumnDef
+	Constraints []TableConstraint
+	Sequence    *SequenceDef // CREATE SEQUENCE
+
+	// CREATE INDEX / DROP INDEX; the indexed columns are in Columns
+	Index  string
+	Unique bool
+
+	// EXPLAIN [ANALYZE]; Statement is the query being explained
+	Analyze   bool
+	Statement *Query
 }
 
+// Parse tokenizes a single SQL statement and parses it into a Query.
+// A trailing semicolon is optional.
 func Parse(sql string) (*Query, error) {
-	sql = strings.TrimSpace(sql)
-	sql = strings.TrimSuffix(sql, ";")
-	sql = strings.ToUpper(sql[:6]) + sql[6:]
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{tokens: tokens}
+	q, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptPunct(";")
+	if !p.at(EOFToken) {
+		return nil, p.expected("end of statement")
+	}
+
+	return q, nil
+}
 
+func (p *Parser) parseStatement() (*Query, error) {
 	switch {
-	case strings.HasPrefix(sql, "SELECT"):
-		return parseSelect(sql)
-	case strings.HasPrefix(sql, "INSERT"):
-		return parseInsert(sql)
-	case strings.HasPrefix(sql, "UPDATE"):
-		return parseUpdate(sql)
-	case strings.HasPrefix(sql, "DELETE"):
-		return parseDelete(sql)
-	case strings.HasPrefix(sql, "CREATE TABLE"):
-		return parseCreateTable(sql)
-	case strings.HasPrefix(sql, "ALTER TABLE") && strings.Contains(sql, "ADD COLUMN"):
-		return parseAddColumn(sql)
-	case strings.HasPrefix(sql, "SHOW TABLES"):
+	case p.isKeyword("WITH"):
+		return p.parseWith()
+	case p.isKeyword("SELECT"), p.atSubquery():
+		return p.parseSelect()
+	case p.isKeyword("INSERT"):
+		return p.parseInsert()
+	case p.isKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.isKeyword("DELETE"):
+		return p.parseDelete()
+	case p.isKeyword("CREATE"):
+		switch next := p.tokens[p.pos+1]; {
+		case next.Type != IdentToken:
+		case strings.EqualFold(next.Value, "SEQUENCE"):
+			return p.parseCreateSequence()
+		case strings.EqualFold(next.Value, "INDEX"), strings.EqualFold(next.Value, "UNIQUE"):
+			return p.parseCreateIndex()
+		}
+		return p.parseCreateTable()
+	case p.isKeyword("DROP"):
+		return p.parseDropIndex()
+	case p.isKeyword("ALTER"):
+		return p.parseAddColumn()
+	case p.isKeyword("SHOW"):
+		p.next()
+		if err := p.expectKeyword("TABLES"); err != nil {
+			return nil, err
+		}
 		return &Query{Type: ShowTablesQuery}, nil
-	case strings.HasPrefix(sql, "DESCRIBE"):
-		table := strings.Fields(sql)[1]
+	case p.isKeyword("DESCRIBE"):
+		p.next()
+		table, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
 		return &Query{Type: DescribeTableQuery, Table: table}, nil
+	case p.isKeyword("CHECKPOINT"):
+		p.next()
+		return &Query{Type: CheckpointQuery}, nil
+	case p.isKeyword("EXPLAIN"):
+		return p.parseExplain()
 	default:
-		return nil, fmt.Errorf("unsupported SQL statement")
+		return nil, p.expected("SELECT, INSERT, UPDATE, DELETE, WITH, CREATE, ALTER, DROP, SHOW, DESCRIBE, CHECKPOINT or EXPLAIN")
 	}
 }
 
-func parseCreateTable(sql string) (*Query, error) {
-	// CREATE TABLE users
-	parts := strings.Fields(sql)
-	if len(parts) < 3 {
-		return nil, fmt.Errorf("invalid CREATE TABLE syntax")
+func (p *Parser) parseExplain() (*Query, error) {
+	// EXPLAIN ANALYZE SELECT * FROM t WHERE id = 1
+	p.next()
+	analyze := p.acceptKeyword("ANALYZE")
+
+	if !p.atDML() && !p.isKeyword("WITH") {
+		return nil, p.expected("SELECT, INSERT, UPDATE or DELETE")
+	}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
 	}
-	table := parts[2]
 
-	return &Query{
+	return &Query{Type: ExplainQuery, Analyze: analyze, Statement: stmt}, nil
+}
+
+// atDML reports whether a SELECT, INSERT, UPDATE or DELETE starts here.
+func (p *Parser) atDML() bool {
+	return p.isKeyword("SELECT") || p.atSubquery() || p.isKeyword("INSERT") || p.isKeyword("UPDATE") || p.isKeyword("DELETE")
+}
+
+func (p *Parser) parseWith() (*Query, error) {
+	// WITH RECURSIVE tree (id, depth) AS (SELECT id, 0 FROM t WHERE parent IS NULL
+	// UNION ALL SELECT t.id, depth + 1 FROM t JOIN tree ON t.parent = tree.id) SELECT * FROM tree
+	p.next()
+	recursive := p.acceptKeyword("RECURSIVE")
+
+	var ctes []CTE
+	for {
+		cte, err := p.parseCTE(recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range ctes {
+			if other.Name == cte.Name {
+				return nil, p.errorAt(p.peek(), "WITH query name '%s' specified more than once", cte.Name)
+			}
+		}
+		ctes = append(ctes, cte)
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if !p.atDML() {
+		return nil, p.expected("SELECT, INSERT, UPDATE or DELETE")
+	}
+	q, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	q.With = ctes
+	return q, nil
+}
+
+func (p *Parser) parseCTE(recursive bool) (CTE, error) {
+	var cte CTE
+	var err error
+	if cte.Name, err = p.parseIdent(); err != nil {
+		return cte, err
+	}
+	if p.isPunct("(") {
+		if cte.Columns, err = p.parseIdentList(); err != nil {
+			return cte, err
+		}
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return cte, err
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return cte, err
+	}
+	if !p.isKeyword("SELECT") && !p.atSubquery() {
+		return cte, p.expected("SELECT")
+	}
+	if cte.Query, err = p.parseSelect(); err != nil {
+		return cte, err
+	}
+	// The step of a recursive query is the SELECT after its last UNION
+	if q := cte.Query; recursive && q.SetOp == Union && q.OrderBy == nil && q.Limit == nil && q.Offset == 0 {
+		cte.Query, cte.Step, cte.UnionAll = q.Left, q.Right, q.All
+	}
+	return cte, p.expectPunct(")")
+}
+
+func (p *Parser) parseCreateTable() (*Query, error) {
+	// CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE NOT NULL)
+	p.next()
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{
 		Type:  CreateTableQuery,
 		Table: table,
+	}
+
+	// The column list is optional; columns can be added later with ALTER TABLE
+	if !p.acceptPunct("(") {
+		return q, nil
+	}
+
+	for {
+		if p.isKeyword("CONSTRAINT") || p.isKeyword("PRIMARY") || p.isKeyword("UNIQUE") || p.isKeyword("CHECK") ||
+			p.isKeyword("FOREIGN") {
+			c, err := p.parseTableConstraint()
+			if err != nil {
+				return nil, err
+			}
+			q.Constraints = append(q.Constraints, c)
+		} else {
+			def, err := p.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			q.Columns = append(q.Columns, def.Name)
+			q.ColumnTypes = append(q.ColumnTypes, string(def.Type))
+			q.ColumnDefs = append(q.ColumnDefs, def)
+		}
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (p *Parser) parseCreateSequence() (*Query, error) {
+	// CREATE SEQUENCE order_numbers START WITH 1000 INCREMENT BY 10
+	p.next()
+	p.next() // SEQUENCE
+
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := p.parseSequenceOptions()
+	if err != nil {
+		return nil, err
+	}
+	seq.Name = name
+
+	return &Query{Type: CreateSequenceQuery, Sequence: seq}, nil
+}
+
+func (p *Parser) parseCreateIndex() (*Query, error) {
+	// CREATE UNIQUE INDEX users_email ON users (email)
+	p.next()
+	unique := p.acceptKeyword("UNIQUE")
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	cols, err := p.parseIdentList()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{
+		Type:    CreateIndexQuery,
+		Table:   table,
+		Index:   name,
+		Unique:  unique,
+		Columns: cols,
 	}, nil
 }
 
-func parseAddColumn(sql string) (*Query, error) {
-	// Example: ALTER TABLE users ADD COLUMN age INT
-	parts := strings.Fields(sql)
-	if len(parts) < 6 {
-		return nil, fmt.Errorf("invalid ALTER TABLE ADD COLUMN syntax")
-	}
-
-	table := parts[2]
-	colName := parts[5]
-
-	// Default to TEXT if type is not specified
-	colType := storage.TextType
-	if len(parts) >= 7 {
-		switch strings.ToUpper(parts[6]) {
-		case "INT":
-			colType = storage.IntType
-		case "TEXT":
-			colType = storage.TextType
-		case "FLOAT":
-			colType = storage.FloatType
-		case "BOOL":
-			colType = storage.BoolType
-		case "DATE":
-			colType = storage.DateType
+func (p *Parser) parseDropIndex() (*Query, error) {
+	// DROP INDEX users_email
+	p.next()
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{Type: DropIndexQuery, Index: name}, nil
+}
+
+// parseSequenceOptions reads any of "START [WITH] n" and
+// "INCREMENT [BY] n", in either order.
+func (p *Parser) parseSequenceOptions() (*SequenceDef, error) {
+	seq := &SequenceDef{Increment: 1}
+	for {
+		switch {
+		case p.acceptKeyword("START"):
+			p.acceptKeyword("WITH")
+			n, err := p.parseInteger()
+			if err != nil {
+				return nil, err
+			}
+			seq.Start = &n
+		case p.acceptKeyword("INCREMENT"):
+			p.acceptKeyword("BY")
+			tok := p.peek()
+			n, err := p.parseInteger()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, p.errorAt(tok, "INCREMENT cannot be zero")
+			}
+			seq.Increment = n
 		default:
-			return nil, fmt.Errorf("unknown column type: %s", parts[6])
+			return seq, nil
 		}
 	}
+}
+
+// parseInteger reads an optionally negative integer literal.
+func (p *Parser) parseInteger() (int64, error) {
+	negative := p.acceptOperator("-")
+	tok := p.peek()
+	if tok.Type != NumberToken {
+		return 0, p.expected("integer")
+	}
+	p.next()
+
+	v, err := p.parseNumber(tok, negative)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, p.errorAt(tok, "expected an integer but got %s", tok.Value)
+	}
+	return n, nil
+}
+
+func (p *Parser) parseAddColumn() (*Query, error) {
+	// Example: ALTER TABLE users ADD COLUMN age INT
+	p.next()
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("ADD"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("COLUMN")
+
+	def, err := p.parseColumnDef()
+	if err != nil {
+		return nil, err
+	}
 
 	return &Query{
 		Type:        AddColumnQuery,
 		Table:       table,
-		Columns:     []string{colName},
-		ColumnTypes: []string{string(colType)}, // store type
+		Columns:     []string{def.Name},
+		ColumnTypes: []string{string(def.Type)}, // store type
+		ColumnDefs:  []ColumnDef{def},
 	}, nil
 }
 
-func parseSelect(sql string) (*Query, error) {
-	// SELECT a,b FROM table WHERE c=1
-	parts := strings.Split(sql, "FROM")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid SELECT syntax")
+// parseColumnDef reads "name [type] [constraint ...]". The type defaults to
+// TEXT when it is left out. Column constraints are PRIMARY KEY, UNIQUE,
+// [NOT] NULL, DEFAULT expr, CHECK (expr), REFERENCES table [(col)],
+// AUTOINCREMENT and GENERATED {ALWAYS | BY DEFAULT} AS IDENTITY [(options)].
+func (p *Parser) parseColumnDef() (ColumnDef, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return ColumnDef{}, err
 	}
 
-	colsPart := strings.TrimSpace(strings.TrimPrefix(parts[0], "SELECT"))
-	columns := strings.Split(colsPart, ",")
+	def := ColumnDef{Name: name, Type: storage.TextType}
+	if p.at(IdentToken) && !p.isColumnConstraint() {
+		def.Type, err = p.parseColumnType()
+		if err != nil {
+			return ColumnDef{}, err
+		}
+	}
 
-	for i := range columns {
-		columns[i] = strings.TrimSpace(columns[i])
+	for p.isColumnConstraint() {
+		switch {
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return ColumnDef{}, err
+			}
+			def.PrimaryKey = true
+		case p.acceptKeyword("UNIQUE"):
+			def.Unique = true
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return ColumnDef{}, err
+			}
+			def.NotNull = true
+		case p.acceptKeyword("NULL"):
+			def.NotNull = false
+		case p.acceptKeyword("DEFAULT"):
+			// Boolean operators are left out so "DEFAULT 0 NOT NULL" reads
+			// as a default followed by a constraint
+			def.Default, err = p.parseAdditive()
+			if err != nil {
+				return ColumnDef{}, err
+			}
+		case p.isKeyword("CHECK"):
+			def.Check, err = p.parseCheck()
+			if err != nil {
+				return ColumnDef{}, err
+			}
+		case p.isKeyword("REFERENCES"):
+			def.References, err = p.parseReferences()
+			if err != nil {
+				return ColumnDef{}, err
+			}
+		case p.acceptKeyword("AUTOINCREMENT"), p.acceptKeyword("AUTO_INCREMENT"):
+			def.Identity = storage.IdentityByDefault
+		case p.acceptKeyword("GENERATED"):
+			def.Identity, def.IdentityOptions, err = p.parseIdentity()
+			if err != nil {
+				return ColumnDef{}, err
+			}
+		}
 	}
 
-	rest := strings.TrimSpace(parts[1])
-	tableAndWhere := strings.Split(rest, "WHERE")
+	return def, nil
+}
 
-	q := &Query{
-		Type:    SelectQuery,
-		Table:   strings.TrimSpace(tableAndWhere[0]),
-		Columns: columns,
+func (p *Parser) isColumnConstraint() bool {
+	return p.isKeyword("PRIMARY") || p.isKeyword("UNIQUE") || p.isKeyword("NOT") || p.isKeyword("NULL") ||
+		p.isKeyword("DEFAULT") || p.isKeyword("CHECK") || p.isKeyword("REFERENCES") ||
+		p.isKeyword("AUTOINCREMENT") || p.isKeyword("AUTO_INCREMENT") || p.isKeyword("GENERATED")
+}
+
+// parseIdentity reads the rest of "GENERATED {ALWAYS | BY DEFAULT} AS
+// IDENTITY [(sequence options)]".
+func (p *Parser) parseIdentity() (storage.IdentityKind, *SequenceDef, error) {
+	kind := storage.IdentityAlways
+	if p.acceptKeyword("BY") {
+		if err := p.expectKeyword("DEFAULT"); err != nil {
+			return "", nil, err
+		}
+		kind = storage.IdentityByDefault
+	} else if err := p.expectKeyword("ALWAYS"); err != nil {
+		return "", nil, err
+	}
+
+	if err := p.expectKeyword("AS"); err != nil {
+		return "", nil, err
+	}
+	if err := p.expectKeyword("IDENTITY"); err != nil {
+		return "", nil, err
+	}
+
+	if !p.acceptPunct("(") {
+		return kind, nil, nil
+	}
+	options, err := p.parseSequenceOptions()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return "", nil, err
+	}
+	return kind, options, nil
+}
+
+// parseCheck reads "CHECK (expr)".
+func (p *Parser) parseCheck() (expr.Expr, error) {
+	if err := p.expectKeyword("CHECK"); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	cond, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return cond, nil
+}
+
+// parseReferences reads "REFERENCES table [(cols)]" followed by any of
+// "ON DELETE action" and "ON UPDATE action".
+func (p *Parser) parseReferences() (*References, error) {
+	if err := p.expectKeyword("REFERENCES"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	ref := &References{Table: table}
+
+	if p.isPunct("(") {
+		ref.Columns, err = p.parseIdentList()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for p.acceptKeyword("ON") {
+		var target *storage.RefAction
+		switch {
+		case p.acceptKeyword("DELETE"):
+			target = &ref.OnDelete
+		case p.acceptKeyword("UPDATE"):
+			target = &ref.OnUpdate
+		default:
+			return nil, p.expected("DELETE or UPDATE")
+		}
+		if *target != "" {
+			return nil, p.expected("a single ON DELETE and ON UPDATE")
+		}
+
+		action, err := p.parseRefAction()
+		if err != nil {
+			return nil, err
+		}
+		*target = action
+	}
+
+	return ref, nil
+}
+
+// parseRefAction reads RESTRICT, CASCADE, SET NULL, SET DEFAULT or
+// NO ACTION.
+func (p *Parser) parseRefAction() (storage.RefAction, error) {
+	switch {
+	case p.acceptKeyword("RESTRICT"):
+		return storage.Restrict, nil
+	case p.acceptKeyword("CASCADE"):
+		return storage.Cascade, nil
+	case p.acceptKeyword("SET"):
+		if p.acceptKeyword("NULL") {
+			return storage.SetNull, nil
+		}
+		if p.acceptKeyword("DEFAULT") {
+			return storage.SetDefault, nil
+		}
+		return "", p.expected("NULL or DEFAULT")
+	case p.acceptKeyword("NO"):
+		if err := p.expectKeyword("ACTION"); err != nil {
+			return "", err
+		}
+		return storage.NoAction, nil
+	default:
+		return "", p.expected("RESTRICT, CASCADE, SET NULL, SET DEFAULT or NO ACTION")
+	}
+}
+
+// parseTableConstraint reads "[CONSTRAINT name] PRIMARY KEY (cols)",
+// "[CONSTRAINT name] UNIQUE (cols)", "[CONSTRAINT name] CHECK (expr)" or
+// "[CONSTRAINT name] FOREIGN KEY (cols) REFERENCES ...".
+func (p *Parser) parseTableConstraint() (TableConstraint, error) {
+	var c TableConstraint
+	if p.acceptKeyword("CONSTRAINT") {
+		name, err := p.parseIdent()
+		if err != nil {
+			return c, err
+		}
+		c.Name = name
+	}
+
+	switch {
+	case p.acceptKeyword("PRIMARY"):
+		if err := p.expectKeyword("KEY"); err != nil {
+			return c, err
+		}
+		c.Type = storage.PrimaryKeyConstraint
+	case p.acceptKeyword("UNIQUE"):
+		c.Type = storage.UniqueConstraint
+	case p.isKeyword("CHECK"):
+		c.Type = storage.CheckConstraint
+		cond, err := p.parseCheck()
+		if err != nil {
+			return c, err
+		}
+		c.Check = cond
+		c.Columns = expr.Columns(cond)
+		return c, nil
+	case p.acceptKeyword("FOREIGN"):
+		if err := p.expectKeyword("KEY"); err != nil {
+			return c, err
+		}
+		c.Type = storage.ForeignKeyConstraint
+	default:
+		return c, p.expected("PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY")
+	}
+
+	cols, err := p.parseIdentList()
+	if err != nil {
+		return c, err
+	}
+	c.Columns = cols
+
+	if c.Type == storage.ForeignKeyConstraint {
+		c.References, err = p.parseReferences()
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// parseSelect reads a SELECT, or a compound SELECT combining several with
+// UNION, INTERSECT and EXCEPT. INTERSECT binds tighter than the others,
+// which apply left to right. The ORDER BY, LIMIT and OFFSET after the last
+// SELECT belong to the combined rows; a SELECT in parentheses may have its
+// own.
+func (p *Parser) parseSelect() (*Query, error) {
+	// SELECT a FROM t UNION ALL (SELECT a FROM u LIMIT 1) EXCEPT SELECT a FROM v
+	// INTERSECT SELECT a FROM w ORDER BY a LIMIT 10
+	q, err := p.parseIntersect()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op SetOp
+		switch {
+		case p.acceptKeyword("UNION"):
+			op = Union
+		case p.acceptKeyword("EXCEPT"):
+			op = Except
+		default:
+			return q, p.parseSelectTail(q)
+		}
+		all := p.acceptKeyword("ALL")
+		right, err := p.parseIntersect()
+		if err != nil {
+			return nil, err
+		}
+		q = &Query{Type: SelectQuery, SetOp: op, All: all, Left: q, Right: right}
+	}
+}
+
+// parseIntersect reads SELECTs combined by INTERSECT.
+func (p *Parser) parseIntersect() (*Query, error) {
+	q, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("INTERSECT") {
+		all := p.acceptKeyword("ALL")
+		right, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		q = &Query{Type: SelectQuery, SetOp: Intersect, All: all, Left: q, Right: right}
+	}
+	return q, nil
+}
+
+// parseSetOperand reads a SELECT without its ORDER BY, LIMIT and OFFSET,
+// or any SELECT in parentheses.
+func (p *Parser) parseSetOperand() (*Query, error) {
+	if p.isPunct("(") {
+		return p.parseSubquery()
+	}
+	if !p.isKeyword("SELECT") {
+		return nil, p.expected("SELECT")
+	}
+	return p.parseSelectCore()
+}
+
+// parseSelectTail reads the ORDER BY, LIMIT and OFFSET that end a SELECT.
+// A SELECT in parentheses cannot be given a second one.
+func (p *Parser) parseSelectTail(q *Query) error {
+	tok := p.peek()
+	orderBy, err := p.parseOrderBy()
+	if err != nil {
+		return err
+	}
+	if orderBy != nil {
+		if q.OrderBy != nil {
+			return p.errorAt(tok, "multiple ORDER BY clauses not allowed")
+		}
+		q.OrderBy = orderBy
+	}
+
+	tok = p.peek()
+	limit, offset, err := p.parseLimit()
+	if err != nil {
+		return err
+	}
+	if limit != nil || offset > 0 {
+		if q.Limit != nil || q.Offset > 0 {
+			return p.errorAt(tok, "multiple LIMIT or OFFSET clauses not allowed")
+		}
+		q.Limit, q.Offset = limit, offset
+	}
+	return nil
+}
+
+func (p *Parser) parseSelectCore() (*Query, error) {
+	// SELECT DISTINCT ON (a) a, b * 2 AS c, COUNT(*) FROM table t JOIN other o ON t.id = o.t_id
+	// WHERE c=1 GROUP BY a HAVING COUNT(*) > 1
+	p.next()
+	q := &Query{Type: SelectQuery}
+
+	if p.acceptKeyword("DISTINCT") {
+		if p.acceptKeyword("ON") {
+			if err := p.expectPunct("("); err != nil {
+				return nil, err
+			}
+			for {
+				e, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				q.DistinctOn = append(q.DistinctOn, e)
+
+				if !p.acceptPunct(",") {
+					break
+				}
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+		} else {
+			q.Distinct = true
+		}
+	} else {
+		p.acceptKeyword("ALL")
+	}
+
+	for {
+		item, name, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		q.Select = append(q.Select, item)
+		q.Columns = append(q.Columns, name)
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	// SELECT * alone has no select list
+	if star, ok := q.Select[0].(*expr.Star); ok && len(q.Select) == 1 && star.Table == "" {
+		q.Select = nil
+	}
+
+	// Without FROM the select list is computed once, from no table, so
+	// there are no columns for * to stand for
+	var err error
+	if p.acceptKeyword("FROM") {
+		if q.Table, q.Derived, q.Alias, err = p.parseTableRef(); err != nil {
+			return nil, err
+		}
+		if q.Joins, err = p.parseJoins(); err != nil {
+			return nil, err
+		}
+	} else if tok := p.peek(); hasStar(q.Select) || q.Select == nil ||
+		tok.Type == QuotedIdentToken || (tok.Type == IdentToken && !reserved[strings.ToUpper(tok.Value)]) {
+		return nil, p.expected("FROM")
+	}
+
+	q.Where, err = p.parseWhere()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			q.GroupBy = append(q.GroupBy, e)
+
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("HAVING") {
+		if q.Having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// hasStar reports whether a select list has a * or table.* item.
+func hasStar(items []expr.Expr) bool {
+	for _, item := range items {
+		if _, ok := item.(*expr.Star); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSelectItem reads an item of a select list and names its result
+// column: "*", "table.*", or an expression with an optional alias,
+// "expr AS alias".
+func (p *Parser) parseSelectItem() (expr.Expr, string, error) {
+	if p.acceptOperator("*") {
+		return &expr.Star{}, "*", nil
+	}
+	if tok := p.peek(); (tok.Type == IdentToken || tok.Type == QuotedIdentToken) && p.pos+2 < len(p.tokens) {
+		dot, star := p.tokens[p.pos+1], p.tokens[p.pos+2]
+		if dot.Type == PunctToken && dot.Value == "." && star.Type == OperatorToken && star.Value == "*" {
+			p.pos += 3
+			return &expr.Star{Table: tok.Value}, tok.Value + ".*", nil
+		}
+	}
+
+	item, err := p.parseExpr()
+	if err != nil {
+		return nil, "", err
+	}
+	name := ColumnName(item)
+	if p.acceptKeyword("AS") {
+		name, err = p.parseIdent()
+	}
+	return item, name, err
+}
+
+// parseTableRef reads a table in FROM and its optional alias: a table
+// name, or a derived table, which must have an alias.
+func (p *Parser) parseTableRef() (table string, derived *Query, alias string, err error) {
+	if p.isPunct("(") {
+		if derived, err = p.parseSubquery(); err != nil {
+			return "", nil, "", err
+		}
+	} else if table, err = p.parseIdent(); err != nil {
+		return "", nil, "", err
 	}
 
-	if len(tableAndWhere) == 2 {
-		q.Filters = parseWhere(tableAndWhere[1])
+	tok := p.peek()
+	switch {
+	case p.acceptKeyword("AS"):
+		alias, err = p.parseIdent()
+	case tok.Type == QuotedIdentToken || (tok.Type == IdentToken && !reserved[strings.ToUpper(tok.Value)]):
+		alias, err = p.parseIdent()
+	case derived != nil:
+		err = p.expected("alias for the subquery in FROM")
+	}
+	return table, derived, alias, err
+}
+
+// atSubquery reports whether the current tokens start "(SELECT".
+func (p *Parser) atSubquery() bool {
+	if !p.isPunct("(") {
+		return false
 	}
+	next := p.tokens[p.pos+1]
+	return next.Type == IdentToken && strings.EqualFold(next.Value, "SELECT")
+}
 
+// parseSubquery reads a SELECT in parentheses.
+func (p *Parser) parseSubquery() (*Query, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("SELECT") && !p.atSubquery() {
+		return nil, p.expected("SELECT")
+	}
+	q, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
 	return q, nil
 }
 
-func parseInsert(sql string) (*Query, error) {
+// parseJoins reads the joins following the first table of FROM.
+func (p *Parser) parseJoins() ([]Join, error) {
+	var joins []Join
+	for {
+		var typ JoinType
+		tok := p.peek()
+		switch word := JoinType(strings.ToUpper(tok.Value)); {
+		case p.acceptPunct(","):
+			typ = CrossJoin
+		case p.acceptKeyword("JOIN"):
+			typ = InnerJoin
+		case tok.Type == IdentToken && (word == InnerJoin || word == CrossJoin):
+			p.next()
+			typ = word
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		case tok.Type == IdentToken && (word == LeftJoin || word == RightJoin || word == FullJoin):
+			p.next()
+			typ = word
+			p.acceptKeyword("OUTER")
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		default:
+			return joins, nil
+		}
+
+		join := Join{Type: typ}
+		var err error
+		if join.Table, join.Derived, join.Alias, err = p.parseTableRef(); err != nil {
+			return nil, err
+		}
+
+		if typ != CrossJoin {
+			switch {
+			case p.acceptKeyword("ON"):
+				if join.On, err = p.parseExpr(); err != nil {
+					return nil, err
+				}
+			case p.acceptKeyword("USING"):
+				if join.Using, err = p.parseIdentList(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, p.expected("ON or USING")
+			}
+		}
+		joins = append(joins, join)
+	}
+}
+
+// ColumnName is the name of the result column holding a selected
+// expression: the column's own name for a column, otherwise the
+// expression's SQL, such as "COUNT(*)".
+func ColumnName(e expr.Expr) string {
+	if ref, ok := e.(*expr.ColumnRef); ok {
+		return ref.Name
+	}
+	return e.String()
+}
+
+// String renders a SELECT as SQL.
+func (q *Query) String() string {
+	var b strings.Builder
+	if q.SetOp != "" {
+		b.WriteString(operandSQL(q.Left, q.SetOp, false) + " " + string(q.SetOp))
+		if q.All {
+			b.WriteString(" ALL")
+		}
+		b.WriteString(" " + operandSQL(q.Right, q.SetOp, true))
+	} else {
+		q.coreSQL(&b)
+	}
+
+	for i, item := range q.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(item.String())
+	}
+	if q.Limit != nil {
+		b.WriteString(" LIMIT " + strconv.FormatInt(*q.Limit, 10))
+	}
+	if q.Offset > 0 {
+		b.WriteString(" OFFSET " + strconv.FormatInt(q.Offset, 10))
+	}
+	return b.String()
+}
+
+// coreSQL renders the part of a SELECT before its ORDER BY.
+func (q *Query) coreSQL(b *strings.Builder) {
+	b.WriteString("SELECT ")
+	switch {
+	case q.Distinct:
+		b.WriteString("DISTINCT ")
+	case q.DistinctOn != nil:
+		on := make([]string, len(q.DistinctOn))
+		for i, e := range q.DistinctOn {
+			on[i] = e.String()
+		}
+		b.WriteString("DISTINCT ON (" + strings.Join(on, ", ") + ") ")
+	}
+	if q.Select == nil {
+		b.WriteString("*")
+	}
+	for i, item := range q.Select {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(item.String())
+		if _, star := item.(*expr.Star); !star && q.Columns[i] != ColumnName(item) {
+			b.WriteString(" AS " + q.Columns[i])
+		}
+	}
+
+	if q.Table != "" || q.Derived != nil {
+		b.WriteString(" FROM " + tableSQL(q.Table, q.Derived, q.Alias))
+	}
+	for _, j := range q.Joins {
+		switch j.Type {
+		case InnerJoin:
+			b.WriteString(" JOIN ")
+		default:
+			b.WriteString(" " + string(j.Type) + " JOIN ")
+		}
+		b.WriteString(tableSQL(j.Table, j.Derived, j.Alias))
+		switch {
+		case j.On != nil:
+			b.WriteString(" ON " + j.On.String())
+		case len(j.Using) > 0:
+			b.WriteString(" USING (" + strings.Join(j.Using, ", ") + ")")
+		}
+	}
+
+	if q.Where != nil {
+		b.WriteString(" WHERE " + q.Where.String())
+	}
+	for i, e := range q.GroupBy {
+		if i == 0 {
+			b.WriteString(" GROUP BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(e.String())
+	}
+	if q.Having != nil {
+		b.WriteString(" HAVING " + q.Having.String())
+	}
+}
+
+// operandSQL renders an operand of a compound SELECT with op, in
+// parentheses where it would otherwise parse differently.
+func operandSQL(q *Query, op SetOp, right bool) string {
+	paren := q.OrderBy != nil || q.Limit != nil || q.Offset > 0
+	if q.SetOp != "" {
+		paren = paren || right || (op == Intersect && q.SetOp != Intersect)
+	}
+	if paren {
+		return "(" + q.String() + ")"
+	}
+	return q.String()
+}
+
+// tableSQL renders a table of FROM.
+func tableSQL(table string, derived *Query, alias string) string {
+	if derived != nil {
+		table = "(" + derived.String() + ")"
+	}
+	if alias != "" {
+		return table + " " + alias
+	}
+	return table
+}
+
+// String renders the item as SQL, leaving out NULLS when it is the default.
+func (o OrderItem) String() string {
+	s := o.Expr.String()
+	if o.Desc {
+		s += " DESC"
+	}
+	if o.NullsFirst != o.Desc {
+		if o.NullsFirst {
+			s += " NULLS FIRST"
+		} else {
+			s += " NULLS LAST"
+		}
+	}
+	return s
+}
+
+// parseOrderBy reads an optional ORDER BY clause.
+func (p *Parser) parseOrderBy() ([]OrderItem, error) {
+	if !p.acceptKeyword("ORDER") {
+		return nil, nil
+	}
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+
+	var items []OrderItem
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := OrderItem{Expr: e}
+
+		if p.acceptKeyword("DESC") {
+			item.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		item.NullsFirst = item.Desc
+		if p.acceptKeyword("NULLS") {
+			switch {
+			case p.acceptKeyword("FIRST"):
+				item.NullsFirst = true
+			case p.acceptKeyword("LAST"):
+				item.NullsFirst = false
+			default:
+				return nil, p.expected("FIRST or LAST")
+			}
+		}
+		items = append(items, item)
+
+		if !p.acceptPunct(",") {
+			return items, nil
+		}
+	}
+}
+
+// parseLimit reads optional LIMIT and OFFSET clauses, in either order.
+// LIMIT ALL is the same as no LIMIT.
+func (p *Parser) parseLimit() (limit *int64, offset int64, err error) {
+	seenLimit, seenOffset := false, false
+	for {
+		switch {
+		case !seenLimit && p.acceptKeyword("LIMIT"):
+			seenLimit = true
+			if p.acceptKeyword("ALL") {
+				continue
+			}
+			n, err := p.parseCount("LIMIT")
+			if err != nil {
+				return nil, 0, err
+			}
+			limit = &n
+
+		case !seenOffset && p.acceptKeyword("OFFSET"):
+			seenOffset = true
+			if offset, err = p.parseCount("OFFSET"); err != nil {
+				return nil, 0, err
+			}
+			if !p.acceptKeyword("ROWS") {
+				p.acceptKeyword("ROW")
+			}
+
+		default:
+			return limit, offset, nil
+		}
+	}
+}
+
+// parseCount reads the non-negative row count of LIMIT or OFFSET.
+func (p *Parser) parseCount(clause string) (int64, error) {
+	tok := p.peek()
+	n, err := p.parseInteger()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, p.errorAt(tok, "%s must not be negative", clause)
+	}
+	return n, nil
+}
+
+func (p *Parser) parseInsert() (*Query, error) {
 	// INSERT INTO t (a,b) VALUES (1,2)
-	parts := strings.Split(sql, "VALUES")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid INSERT syntax")
+	p.next()
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
 	}
 
-	head := parts[0]
-	valuesPart := parts[1]
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
 
-	table := strings.Fields(head)[2]
+	cols, err := p.parseIdentList()
+	if err != nil {
+		return nil, err
+	}
 
-	cols := strings.Split(
-		strings.TrimSpace(head[strings.Index(head, "(")+1:strings.Index(head, ")")]),
-		",",
-	)
+	// INSERT INTO t (a,b) SELECT x, y FROM u
+	if p.isKeyword("SELECT") {
+		source, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		return &Query{Type: InsertQuery, Table: table, Columns: cols, Source: source}, nil
+	}
 
-	vals := strings.Split(
-		strings.Trim(valuesPart, " ()"),
-		",",
-	)
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	valuesStart := p.peek()
+	vals := []any{}
+	for {
+		val, err := p.parseValue(false)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
 
 	if len(cols) != len(vals) {
-		return nil, fmt.Errorf("columns/values mismatch")
+		return nil, p.errorAt(valuesStart, "columns/values mismatch: %d columns but %d values", len(cols), len(vals))
 	}
 
 	assignments := []Assignment{}
 	for i := range cols {
 		assignments = append(assignments, Assignment{
-			Column: strings.TrimSpace(cols[i]),
-			Value:  parseValue(vals[i]),
+			Column: cols[i],
+			Value:  vals[i],
 		})
 	}
 
@@ -198,23 +1430,43 @@ func parseInsert(sql string) (*Query, error) {
 	}, nil
 }
 
-func parseUpdate(sql string) (*Query, error) {
+func (p *Parser) parseUpdate() (*Query, error) {
 	// UPDATE t SET a=1 WHERE id=2
-	parts := strings.Split(sql, "SET")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid UPDATE syntax")
+	p.next()
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
 	}
 
-	table := strings.Fields(parts[0])[1]
-	setAndWhere := strings.Split(parts[1], "WHERE")
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
 
 	assignments := []Assignment{}
-	for _, a := range strings.Split(setAndWhere[0], ",") {
-		kv := strings.Split(a, "=")
+	for {
+		col, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectOperator("="); err != nil {
+			return nil, err
+		}
+
+		val, err := p.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+
 		assignments = append(assignments, Assignment{
-			Column: strings.TrimSpace(kv[0]),
-			Value:  parseValue(kv[1]),
+			Column: col,
+			Value:  val,
 		})
+
+		if !p.acceptPunct(",") {
+			break
+		}
 	}
 
 	q := &Query{
@@ -223,25 +1475,34 @@ func parseUpdate(sql string) (*Query, error) {
 		Assignments: assignments,
 	}
 
-	if len(setAndWhere) == 2 {
-		q.Filters = parseWhere(setAndWhere[1])
+	q.Where, err = p.parseWhere()
+	if err != nil {
+		return nil, err
 	}
 
 	return q, nil
 }
 
-func parseDelete(sql string) (*Query, error) {
+func (p *Parser) parseDelete() (*Query, error) {
 	// DELETE FROM t WHERE id=1
-	parts := strings.Split(sql, "WHERE")
-	table := strings.Fields(parts[0])[2]
+	p.next()
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
 
 	q := &Query{
 		Type:  DeleteQuery,
 		Table: table,
 	}
 
-	if len(parts) == 2 {
-		q.Filters = parseWhere(parts[1])
+	q.Where, err = p.parseWhere()
+	if err != nil {
+		return nil, err
 	}
 
 	return q, nil
@@ -1,63 +1,223 @@
 package parser
 
 import (
-	"strconv"
+	"fmt"
 	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
+// Parser is a recursive-descent parser over the tokens of one statement.
+type Parser struct {
+	tokens []Token
+	pos    int
+}
+
+// reserved words cannot be used as bare identifiers; quote them instead.
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true,
+	"INSERT": true, "INTO": true, "VALUES": true,
+	"UPDATE": true, "SET": true, "DELETE": true,
+	"CREATE": true, "TABLE": true, "ALTER": true, "ADD": true,
+	"AND": true, "OR": true, "NOT": true,
+	"PRIMARY": true, "UNIQUE": true, "CONSTRAINT": true,
+	"NULL": true, "IS": true, "CHECK": true, "DEFAULT": true,
+	"FOREIGN": true, "REFERENCES": true, "INDEX": true, "ON": true, "DROP": true,
+	"IN": true, "BETWEEN": true, "LIKE": true, "ILIKE": true,
+	"ORDER": true, "LIMIT": true, "OFFSET": true,
+	"GROUP": true, "HAVING": true, "DISTINCT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"CROSS": true, "OUTER": true, "USING": true, "AS": true,
+	"WITH": true, "UNION": true, "INTERSECT": true, "EXCEPT": true,
+	"OVER": true,
+}
+
+// comparisonOperators maps the comparison tokens of expressions to the
+// operator stored in an expr.Binary.
+var comparisonOperators = map[string]string{
+	"=": "=", "!=": "!=", "<>": "!=",
+	"<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *Parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Type != EOFToken {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *Parser) at(t TokenType) bool {
+	return p.peek().Type == t
+}
+
+// isKeyword reports whether the current token is the unquoted word kw.
+func (p *Parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.Type == IdentToken && strings.EqualFold(tok.Value, kw)
+}
+
+func (p *Parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *Parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.expected(kw)
+	}
+	return nil
+}
+
+func (p *Parser) isPunct(v string) bool {
+	tok := p.peek()
+	return tok.Type == PunctToken && tok.Value == v
+}
 
-func parseValue(v string) any {
-	v = strings.TrimSpace(v)
+func (p *Parser) acceptPunct(v string) bool {
+	if p.isPunct(v) {
+		p.next()
+		return true
+	}
+	return false
+}
 
-	if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
-		return strings.Trim(v, "'")
+func (p *Parser) expectPunct(v string) error {
+	if !p.acceptPunct(v) {
+		return p.expected(fmt.Sprintf("%q", v))
 	}
+	return nil
+}
 
-	if i, err := strconv.Atoi(v); err == nil {
-		return i
+func (p *Parser) acceptOperator(v string) bool {
+	tok := p.peek()
+	if tok.Type == OperatorToken && tok.Value == v {
+		p.next()
+		return true
 	}
+	return false
+}
 
-	return v
+func (p *Parser) expectOperator(v string) error {
+	if !p.acceptOperator(v) {
+		return p.expected(fmt.Sprintf("%q", v))
+	}
+	return nil
 }
 
-func parseWhere(whereClause string) []Filter {
-	conditions := strings.Split(whereClause, "AND") // simple AND only
-	filters := []Filter{}
+// parseIdent reads a table or column name. Quoted identifiers may be any
+// text; bare identifiers must not be reserved words.
+func (p *Parser) parseIdent() (string, error) {
+	tok := p.peek()
+	switch {
+	case tok.Type == QuotedIdentToken:
+		p.next()
+		return tok.Value, nil
+	case tok.Type == IdentToken && !reserved[strings.ToUpper(tok.Value)]:
+		p.next()
+		return tok.Value, nil
+	default:
+		return "", p.expected("identifier")
+	}
+}
 
-	for _, cond := range conditions {
-		cond = strings.TrimSpace(cond)
+// parseIdentList reads a parenthesized, comma separated list of identifiers.
+func (p *Parser) parseIdentList() ([]string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
 
-		// Detect operator
-		var op string
-		for _, o := range []string{"!=", ">=", "<=", "=", ">", "<"} {
-			if strings.Contains(cond, o) {
-				op = o
-				break
-			}
+	idents := []string{}
+	for {
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
 		}
+		idents = append(idents, ident)
 
-		if op == "" {
-			panic("unsupported operator in WHERE")
+		if !p.acceptPunct(",") {
+			break
 		}
+	}
 
-		parts := strings.SplitN(cond, op, 2)
-		col := strings.TrimSpace(parts[0])
-		valRaw := strings.TrimSpace(parts[1])
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	return idents, nil
+}
 
-		// Remove quotes for string literals
-		var val any
-		if strings.HasPrefix(valRaw, "'") && strings.HasSuffix(valRaw, "'") {
-			val = strings.Trim(valRaw, "'")
-		} else {
-			val = parseValue(valRaw) // parse numbers
+func (p *Parser) parseColumnType() (storage.ColumnType, error) {
+	tok := p.peek()
+	if tok.Type == IdentToken {
+		p.next()
+		switch strings.ToUpper(tok.Value) {
+		case "INT":
+			return storage.IntType, nil
+		case "TEXT":
+			return storage.TextType, nil
+		case "FLOAT":
+			return storage.FloatType, nil
+		case "BOOL":
+			return storage.BoolType, nil
+		case "DATE":
+			return storage.DateType, nil
 		}
+	}
+	err := newSyntaxError(tok.Pos, tok.String())
+	err.Expected = "column type (INT, TEXT, FLOAT, BOOL or DATE)"
+	return "", err
+}
 
-		filters = append(filters, Filter{
-			Column:   col,
-			Operator: op,
-			Value:    val,
-		})
+// parseValue reads a value of VALUES or SET. A literal is returned as its
+// constant: integers become int64, other numbers float64, TRUE/FALSE
+// bool, NULL nil and quoted strings their text. Any other expression is
+// returned for the engine to evaluate for each row; in SET it may read the
+// columns of the row, while VALUES has no row to read them from.
+func (p *Parser) parseValue(columns bool) (any, error) {
+	start := p.peek()
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if lit, ok := e.(*expr.Literal); ok {
+		return lit.Value, nil
 	}
+	if refs := expr.Columns(e); len(refs) > 0 && !columns {
+		return nil, p.errorAt(start, "column '%s' cannot be used in VALUES", refs[0])
+	}
+	return e, nil
+}
 
-	return filters
-}
\ No newline at end of file
+// parseWhere reads an optional WHERE clause. The condition is any boolean
+// expression; nil is returned when there is no WHERE.
+func (p *Parser) parseWhere() (expr.Expr, error) {
+	if !p.acceptKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
+
+// expected reports that the current token is not what the grammar allows
+// here.
+func (p *Parser) expected(what string) error {
+	tok := p.peek()
+	err := newSyntaxError(tok.Pos, tok.String())
+	err.Expected = what
+	return err
+}
+
+// errorAt reports an error at tok.
+func (p *Parser) errorAt(tok Token, format string, args ...any) error {
+	err := newSyntaxError(tok.Pos, tok.String())
+	err.Message = fmt.Sprintf(format, args...)
+	return err
+}
@@ -1 +1,1013 @@
 package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+func TestParseSelect(t *testing.T) {
+	q, err := Parse("select id, name from users where name = 'FROM here' and id >= 2;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != SelectQuery || q.Table != "users" {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	if len(q.Columns) != 2 || q.Columns[0] != "id" || q.Columns[1] != "name" {
+		t.Fatalf("unexpected columns: %v", q.Columns)
+	}
+
+	if q.Where == nil || q.Where.String() != "name = 'FROM here' AND id >= 2" {
+		t.Fatalf("unexpected WHERE: %v", q.Where)
+	}
+}
+
+func TestParseInsert(t *testing.T) {
+	q, err := Parse("INSERT INTO users (id, names) VALUES (1, 'O''Brien, Pat') -- trailing comment")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != InsertQuery || len(q.Assignments) != 2 {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	if q.Assignments[1].Value != "O'Brien, Pat" {
+		t.Fatalf("unexpected value: %v", q.Assignments[1].Value)
+	}
+
+	if _, err := Parse("INSERT INTO users (id, names) VALUES (1)"); err == nil {
+		t.Fatal("expected error for columns/values mismatch, got nil")
+	}
+}
+
+func TestParseUpdateAndDelete(t *testing.T) {
+	q, err := Parse("UPDATE users SET names = 'ANDROID', age = 31 WHERE id <> 1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != UpdateQuery || len(q.Assignments) != 2 || q.Assignments[0].Value != "ANDROID" {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	if q.Where == nil || q.Where.String() != "id != 1" {
+		t.Fatalf("unexpected WHERE: %v", q.Where)
+	}
+
+	q, err = Parse("delete from users /* all of them */")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != DeleteQuery || q.Table != "users" || q.Where != nil {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+}
+
+func TestParseDDL(t *testing.T) {
+	q, err := Parse("alter table users add column age int;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != AddColumnQuery || q.Columns[0] != "age" || q.ColumnTypes[0] != "INT" {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	for sql, want := range map[string]QueryType{
+		"CREATE TABLE users":     CreateTableQuery,
+		"show tables":            ShowTablesQuery,
+		`DESCRIBE "Order Items"`: DescribeTableQuery,
+		"checkpoint;":            CheckpointQuery,
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		if q.Type != want {
+			t.Fatalf("parse %q: expected %s, got %s", sql, want, q.Type)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, sql := range []string{
+		"",
+		";",
+		"SEL",
+		"SELECT FROM users",
+		"SELECT * FROM users WHERE id =",
+		"SELECT * FROM users WHERE name = 'unterminated",
+		"UPDATE users SET",
+		"ALTER TABLE users ADD COLUMN age NUMBER",
+		"SELECT * FROM users; SELECT * FROM users",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Fatalf("expected error parsing %q, got nil", sql)
+		}
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	_, err := Parse("SELECT id\nFORM users")
+
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("expected *SyntaxError, got %T (%v)", err, err)
+	}
+
+	if syntaxErr.Line != 2 || syntaxErr.Column != 1 {
+		t.Fatalf("expected error at line 2, column 1, got line %d, column %d", syntaxErr.Line, syntaxErr.Column)
+	}
+
+	if syntaxErr.Token != "FORM" || syntaxErr.Expected != "FROM" {
+		t.Fatalf("unexpected token/expected: %q / %q", syntaxErr.Token, syntaxErr.Expected)
+	}
+
+	_, err = Parse("SELECT * FROM users WHERE name = 'open")
+	syntaxErr, ok = err.(*SyntaxError)
+	if !ok || syntaxErr.Column != 34 {
+		t.Fatalf("expected unterminated string error at column 34, got %v", err)
+	}
+}
+
+func TestParseCreateTableDefinitions(t *testing.T) {
+	q, err := Parse(`CREATE TABLE enrollments (
+		student_id INT NOT NULL,
+		course_id INT,
+		email TEXT UNIQUE NOT NULL,
+		note,
+		CONSTRAINT pk_enrollments PRIMARY KEY (student_id, course_id),
+		UNIQUE (email, note)
+	);`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Type != CreateTableQuery || len(q.ColumnDefs) != 4 || len(q.Constraints) != 2 {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	email := q.ColumnDefs[2]
+	if email.Type != "TEXT" || !email.Unique || !email.NotNull || email.PrimaryKey {
+		t.Fatalf("unexpected email column: %+v", email)
+	}
+
+	if q.ColumnDefs[3].Type != "TEXT" {
+		t.Fatalf("expected untyped column to default to TEXT, got %s", q.ColumnDefs[3].Type)
+	}
+
+	pk := q.Constraints[0]
+	if pk.Name != "pk_enrollments" || pk.Type != "PRIMARY KEY" || len(pk.Columns) != 2 {
+		t.Fatalf("unexpected primary key constraint: %+v", pk)
+	}
+
+	q, err = Parse("ALTER TABLE users ADD COLUMN id INT PRIMARY KEY")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if len(q.ColumnDefs) != 1 || !q.ColumnDefs[0].PrimaryKey {
+		t.Fatalf("unexpected column definition: %+v", q.ColumnDefs)
+	}
+
+	if _, err := Parse("CREATE TABLE users (id INT PRIMARY)"); err == nil {
+		t.Fatal("expected error for PRIMARY without KEY, got nil")
+	}
+}
+
+func TestParseTypedLiterals(t *testing.T) {
+	q, err := Parse("INSERT INTO t (a, b, c, d, e, f) VALUES (12, -1.5, TRUE, NULL, CAST('7' AS INT), CAST('2024-01-02' AS DATE))")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	want := []any{int64(12), -1.5, true, nil, int64(7)}
+	for i, w := range want {
+		if got := q.Assignments[i].Value; got != w {
+			t.Errorf("value %d = %v (%T), want %v (%T)", i, got, got, w, w)
+		}
+	}
+	if _, ok := q.Assignments[5].Value.(time.Time); !ok {
+		t.Errorf("CAST AS DATE gave %T, want time.Time", q.Assignments[5].Value)
+	}
+
+	// A cast that fails is left to fail when the statement runs
+	q, err = Parse("INSERT INTO t (a) VALUES (CAST('x' AS INT))")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	cast, ok := q.Assignments[0].Value.(expr.Expr)
+	if !ok {
+		t.Fatalf("invalid CAST gave %T, want an expression", q.Assignments[0].Value)
+	}
+	if _, err := cast.Eval(nil); err == nil {
+		t.Fatal("expected error evaluating invalid CAST, got nil")
+	}
+
+	// Other expressions are kept for the engine; only SET may read columns
+	q, err = Parse("UPDATE t SET n = CAST(f AS INT), f = f * 2, g = -1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	for i, want := range []string{"CAST(f AS INT)", "f * 2"} {
+		if x, ok := q.Assignments[i].Value.(expr.Expr); !ok || x.String() != want {
+			t.Errorf("SET value %d = %v, want %s", i, q.Assignments[i].Value, want)
+		}
+	}
+	if q.Assignments[2].Value != int64(-1) {
+		t.Errorf("SET g = %v (%T), want -1", q.Assignments[2].Value, q.Assignments[2].Value)
+	}
+	for _, sql := range []string{
+		"INSERT INTO t (a) VALUES (abc)",
+		"INSERT INTO t (a) VALUES (a + 1)",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseIsNull(t *testing.T) {
+	q, err := Parse("SELECT * FROM users WHERE email IS NULL AND age IS NOT NULL AND name = NULL")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Where == nil || q.Where.String() != "email IS NULL AND age IS NOT NULL AND name = NULL" {
+		t.Fatalf("unexpected WHERE: %v", q.Where)
+	}
+
+	if _, err := Parse("SELECT * FROM users WHERE email IS 3"); err == nil {
+		t.Fatal("expected error for IS without NULL, got nil")
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	cases := map[string]string{
+		"a + b * 2 > 10 AND NOT c":                "a + b * 2 > 10 AND NOT c",
+		"(a + b) * 2":                             "(a + b) * 2",
+		"a - (b - c)":                             "a - (b - c)",
+		"a = 1 OR b = 2 AND c = 3":                "a = 1 OR b = 2 AND c = 3",
+		"(a = 1 OR b = 2) AND c <> 3":             "(a = 1 OR b = 2) AND c != 3",
+		"email IS NOT NULL AND length(email) > 3": "email IS NOT NULL AND LENGTH(email) > 3",
+		"created <= current_date":                 "created <= CURRENT_DATE",
+		"CAST(price AS INT) >= -5":                "CAST(price AS INT) >= -5",
+	}
+
+	for src, want := range cases {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) failed: %v", src, err)
+			continue
+		}
+		if got := e.String(); got != want {
+			t.Errorf("ParseExpr(%q).String() = %q, want %q", src, got, want)
+		}
+
+		// Rendering must parse back to the same expression
+		again, err := ParseExpr(e.String())
+		if err != nil || again.String() != want {
+			t.Errorf("round trip of %q gave %v, %v", want, again, err)
+		}
+	}
+
+	for _, src := range []string{"a +", "nosuchfunc(1)", "LOWER(a, b)", "a b"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestParseDefaultAndCheck(t *testing.T) {
+	q, err := Parse(`CREATE TABLE orders (
+		id INT PRIMARY KEY,
+		qty INT DEFAULT 1 NOT NULL CHECK (qty > 0),
+		placed DATE DEFAULT CURRENT_DATE,
+		lo INT, hi INT,
+		CONSTRAINT ordered CHECK (lo <= hi)
+	)`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	qty := q.ColumnDefs[1]
+	if !qty.NotNull || qty.Default == nil || qty.Default.String() != "1" || qty.Check.String() != "qty > 0" {
+		t.Fatalf("unexpected qty definition: %+v", qty)
+	}
+	if q.ColumnDefs[2].Default.String() != "CURRENT_DATE" {
+		t.Fatalf("unexpected placed default: %v", q.ColumnDefs[2].Default)
+	}
+
+	if len(q.Constraints) != 1 {
+		t.Fatalf("expected one table constraint, got %+v", q.Constraints)
+	}
+	c := q.Constraints[0]
+	if c.Name != "ordered" || c.Check.String() != "lo <= hi" || len(c.Columns) != 2 {
+		t.Fatalf("unexpected CHECK constraint: %+v", c)
+	}
+}
+
+func TestParseForeignKeys(t *testing.T) {
+	q, err := Parse(`CREATE TABLE orders (
+		id INT PRIMARY KEY,
+		customer INT NOT NULL REFERENCES customers ON DELETE CASCADE,
+		sku TEXT, region TEXT,
+		CONSTRAINT orders_product FOREIGN KEY (sku, region) REFERENCES products (sku, region)
+			ON UPDATE SET NULL ON DELETE NO ACTION
+	)`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	ref := q.ColumnDefs[1].References
+	if !q.ColumnDefs[1].NotNull || ref == nil || ref.Table != "customers" || len(ref.Columns) != 0 ||
+		ref.OnDelete != storage.Cascade || ref.OnUpdate != "" {
+		t.Fatalf("unexpected column reference: %+v", ref)
+	}
+
+	c := q.Constraints[0]
+	if c.Type != storage.ForeignKeyConstraint || c.Name != "orders_product" || len(c.Columns) != 2 {
+		t.Fatalf("unexpected FOREIGN KEY constraint: %+v", c)
+	}
+	if c.References.Table != "products" || len(c.References.Columns) != 2 ||
+		c.References.OnUpdate != storage.SetNull || c.References.OnDelete != storage.NoAction {
+		t.Fatalf("unexpected FOREIGN KEY target: %+v", c.References)
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE t (a INT REFERENCES)",
+		"CREATE TABLE t (a INT REFERENCES p ON DELETE DROP)",
+		"CREATE TABLE t (a INT REFERENCES p ON DELETE CASCADE ON DELETE RESTRICT)",
+		"CREATE TABLE t (a INT, FOREIGN KEY a REFERENCES p)",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseIdentityAndSequences(t *testing.T) {
+	q, err := Parse(`CREATE TABLE t (
+		a INT PRIMARY KEY AUTOINCREMENT,
+		b INT GENERATED ALWAYS AS IDENTITY,
+		c INT GENERATED BY DEFAULT AS IDENTITY (INCREMENT BY -1 START WITH 0)
+	)`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	a, b, c := q.ColumnDefs[0], q.ColumnDefs[1], q.ColumnDefs[2]
+	if !a.PrimaryKey || a.Identity != storage.IdentityByDefault || a.IdentityOptions != nil {
+		t.Fatalf("unexpected AUTOINCREMENT column: %+v", a)
+	}
+	if b.Identity != storage.IdentityAlways {
+		t.Fatalf("unexpected GENERATED ALWAYS column: %+v", b)
+	}
+	if c.Identity != storage.IdentityByDefault || c.IdentityOptions == nil ||
+		c.IdentityOptions.Increment != -1 || *c.IdentityOptions.Start != 0 {
+		t.Fatalf("unexpected identity options: %+v", c.IdentityOptions)
+	}
+
+	q, err = Parse("CREATE SEQUENCE s START 5 INCREMENT 2")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != CreateSequenceQuery || q.Sequence.Name != "s" || *q.Sequence.Start != 5 || q.Sequence.Increment != 2 {
+		t.Fatalf("unexpected sequence: %+v", q.Sequence)
+	}
+
+	q, err = Parse("INSERT INTO t (a, b) VALUES (nextval('s'), 1)")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if call, ok := q.Assignments[0].Value.(*expr.SequenceCall); !ok || call.Func != "NEXTVAL" || call.Sequence != "s" {
+		t.Fatalf("unexpected nextval value: %#v", q.Assignments[0].Value)
+	}
+
+	for _, sql := range []string{
+		"CREATE SEQUENCE s INCREMENT BY 0",
+		"CREATE SEQUENCE s START WITH 1.5",
+		"CREATE TABLE t (a INT GENERATED AS IDENTITY)",
+		"INSERT INTO t (a) VALUES (nextval(s))",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseIndexes(t *testing.T) {
+	q, err := Parse("CREATE UNIQUE INDEX users_email ON users (email, name)")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != CreateIndexQuery || q.Index != "users_email" || q.Table != "users" || !q.Unique ||
+		len(q.Columns) != 2 || q.Columns[0] != "email" || q.Columns[1] != "name" {
+		t.Fatalf("unexpected CREATE INDEX: %+v", q)
+	}
+
+	q, err = Parse("DROP INDEX users_email;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != DropIndexQuery || q.Index != "users_email" {
+		t.Fatalf("unexpected DROP INDEX: %+v", q)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX ON users (email)",
+		"CREATE INDEX i ON users ()",
+		"CREATE INDEX i users (email)",
+		"DROP INDEX",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseExplain(t *testing.T) {
+	q, err := Parse("EXPLAIN SELECT name FROM users WHERE id = 1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != ExplainQuery || q.Analyze || q.Statement == nil || q.Statement.Type != SelectQuery ||
+		q.Statement.Table != "users" {
+		t.Fatalf("unexpected EXPLAIN: %+v", q)
+	}
+
+	q, err = Parse("EXPLAIN ANALYZE DELETE FROM users WHERE id = 1;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != ExplainQuery || !q.Analyze || q.Statement.Type != DeleteQuery {
+		t.Fatalf("unexpected EXPLAIN ANALYZE: %+v", q)
+	}
+
+	for _, sql := range []string{
+		"EXPLAIN",
+		"EXPLAIN ANALYZE",
+		"EXPLAIN CREATE TABLE t (id INT)",
+		"EXPLAIN EXPLAIN SELECT * FROM t",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseWhere(t *testing.T) {
+	for sql, want := range map[string]string{
+		"SELECT * FROM t WHERE a = 1 OR b = 2":              "a = 1 OR b = 2",
+		"SELECT * FROM t WHERE (a = 1 OR b = 2) AND NOT c":  "(a = 1 OR b = 2) AND NOT c",
+		"SELECT * FROM t WHERE name = 'ANDROID' AND id > 1": "name = 'ANDROID' AND id > 1",
+		"SELECT * FROM t WHERE price * qty > total - 10":    "price * qty > total - 10",
+		"DELETE FROM t WHERE NOT (a < b OR a IS NULL)":      "NOT (a < b OR a IS NULL)",
+		"UPDATE t SET a = 1 WHERE ((a = b)) AND c >= d + 1": "a = b AND c >= d + 1",
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if q.Where == nil || q.Where.String() != want {
+			t.Errorf("Parse(%q) WHERE = %v, want %s", sql, q.Where, want)
+		}
+	}
+
+	// The value 'ANDROID' holds AND but is one string
+	q, _ := Parse("SELECT * FROM t WHERE name = 'ANDROID'")
+	if b, ok := q.Where.(*expr.Binary); !ok || b.Op != "=" {
+		t.Fatalf("unexpected WHERE: %#v", q.Where)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE",
+		"SELECT * FROM t WHERE (a = 1",
+		"SELECT * FROM t WHERE a = 1 OR",
+		"SELECT * FROM t WHERE a = 1)",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParsePredicates(t *testing.T) {
+	for sql, want := range map[string]string{
+		"SELECT * FROM t WHERE a IN (1, 2, 3)":                    "a IN (1, 2, 3)",
+		"SELECT * FROM t WHERE a NOT IN ('x') AND b = 1":          "a NOT IN ('x') AND b = 1",
+		"SELECT * FROM t WHERE a BETWEEN 1 AND 2 AND b = 3":       "a BETWEEN 1 AND 2 AND b = 3",
+		"SELECT * FROM t WHERE a + 1 NOT BETWEEN b AND c * 2":     "a + 1 NOT BETWEEN b AND c * 2",
+		"SELECT * FROM t WHERE name LIKE 'A%' OR name ILIKE 'b_'": "name LIKE 'A%' OR name ILIKE 'b_'",
+		"SELECT * FROM t WHERE name NOT LIKE '%x%'":               "name NOT LIKE '%x%'",
+		"SELECT * FROM t WHERE name REGEXP '^a+'":                 "name ~ '^a+'",
+		"SELECT * FROM t WHERE name ~ 'a' AND name !~ 'b'":        "name ~ 'a' AND name !~ 'b'",
+		"DELETE FROM t WHERE NOT a IN (1)":                        "NOT a IN (1)",
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if q.Where == nil || q.Where.String() != want {
+			t.Errorf("Parse(%q) WHERE = %v, want %s", sql, q.Where, want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE a IN ()",
+		"SELECT * FROM t WHERE a IN 1",
+		"SELECT * FROM t WHERE a BETWEEN 1",
+		"SELECT * FROM t WHERE a NOT = 1",
+		"SELECT * FROM t WHERE name LIKE",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseOrderByLimit(t *testing.T) {
+	q, err := Parse("SELECT a, b FROM t WHERE a > 1 ORDER BY a DESC, b * 2 NULLS FIRST, c ASC NULLS LAST LIMIT 10 OFFSET 5")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(q.OrderBy) != 3 || q.Limit == nil || *q.Limit != 10 || q.Offset != 5 {
+		t.Fatalf("unexpected query %+v", q)
+	}
+	for i, want := range []OrderItem{
+		{Desc: true, NullsFirst: true},
+		{Desc: false, NullsFirst: true},
+		{Desc: false, NullsFirst: false},
+	} {
+		got := q.OrderBy[i]
+		if got.Desc != want.Desc || got.NullsFirst != want.NullsFirst {
+			t.Errorf("ORDER BY item %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if got := q.OrderBy[1].Expr.String(); got != "b * 2" {
+		t.Errorf("ORDER BY item 1 = %s", got)
+	}
+
+	for sql, want := range map[string][2]int64{
+		"SELECT * FROM t OFFSET 3 ROWS LIMIT 2": {2, 3},
+		"SELECT * FROM t LIMIT ALL OFFSET 1":    {-1, 1},
+		"SELECT * FROM t ORDER BY a LIMIT 0":    {0, 0},
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		limit := int64(-1)
+		if q.Limit != nil {
+			limit = *q.Limit
+		}
+		if limit != want[0] || q.Offset != want[1] {
+			t.Errorf("Parse(%q) LIMIT %d OFFSET %d, want %v", sql, limit, q.Offset, want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t ORDER a",
+		"SELECT * FROM t ORDER BY",
+		"SELECT * FROM t ORDER BY a NULLS",
+		"SELECT * FROM t LIMIT -1",
+		"SELECT * FROM t LIMIT 1.5",
+		"SELECT * FROM t LIMIT 1 LIMIT 2",
+		"SELECT * FROM t LIMIT 1 ORDER BY a",
+		"DELETE FROM t ORDER BY a",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	q, err := Parse("SELECT dept, COUNT(*), count(DISTINCT name), AVG(salary * 2) FROM staff WHERE active GROUP BY dept, age / 10 HAVING SUM(salary) > 100 ORDER BY COUNT(*) DESC")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	want := []string{"dept", "COUNT(*)", "COUNT(DISTINCT name)", "AVG(salary * 2)"}
+	if strings.Join(q.Columns, "|") != strings.Join(want, "|") || len(q.Select) != 4 {
+		t.Errorf("columns = %q, want %q", q.Columns, want)
+	}
+	if agg, ok := q.Select[2].(*expr.Aggregate); !ok || agg.Func != "COUNT" || !agg.Distinct {
+		t.Errorf("unexpected item %#v", q.Select[2])
+	}
+	if len(q.GroupBy) != 2 || q.GroupBy[1].String() != "age / 10" {
+		t.Errorf("unexpected GROUP BY %v", q.GroupBy)
+	}
+	if q.Having == nil || q.Having.String() != "SUM(salary) > 100" {
+		t.Errorf("unexpected HAVING %v", q.Having)
+	}
+	if len(q.OrderBy) != 1 || q.OrderBy[0].Expr.String() != "COUNT(*)" {
+		t.Errorf("unexpected ORDER BY %v", q.OrderBy)
+	}
+
+	for _, sql := range []string{
+		"SELECT SUM(*) FROM t",
+		"SELECT COUNT() FROM t",
+		"SELECT MAX(a, b) FROM t",
+		"SELECT SUM(COUNT(a)) FROM t",
+		"SELECT a FROM t GROUP a",
+		"SELECT a FROM t GROUP BY",
+		"SELECT a FROM t HAVING",
+		"SELECT a FROM t ORDER BY a GROUP BY a",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseJoins(t *testing.T) {
+	q, err := Parse("SELECT u.name, o.total FROM users AS u JOIN orders o ON u.id = o.user_id LEFT OUTER JOIN teams USING (team_id, org) CROSS JOIN days, weeks w WHERE u.id > 1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if q.Table != "users" || q.Alias != "u" {
+		t.Errorf("FROM %s %s, want users u", q.Table, q.Alias)
+	}
+	if strings.Join(q.Columns, "|") != "name|total" {
+		t.Errorf("columns = %q", q.Columns)
+	}
+	if ref, ok := q.Select[0].(*expr.ColumnRef); !ok || ref.Table != "u" || ref.Name != "name" {
+		t.Errorf("unexpected item %#v", q.Select[0])
+	}
+
+	want := []struct {
+		typ   JoinType
+		table string
+		alias string
+		on    string
+		using string
+	}{
+		{InnerJoin, "orders", "o", "u.id = o.user_id", ""},
+		{LeftJoin, "teams", "", "", "team_id|org"},
+		{CrossJoin, "days", "", "", ""},
+		{CrossJoin, "weeks", "w", "", ""},
+	}
+	if len(q.Joins) != len(want) {
+		t.Fatalf("got %d joins, want %d", len(q.Joins), len(want))
+	}
+	for i, w := range want {
+		j := q.Joins[i]
+		on := ""
+		if j.On != nil {
+			on = j.On.String()
+		}
+		if j.Type != w.typ || j.Table != w.table || j.Alias != w.alias || on != w.on || strings.Join(j.Using, "|") != w.using {
+			t.Errorf("join %d = %+v, want %+v", i, j, w)
+		}
+	}
+	if q.Where == nil || q.Where.String() != "u.id > 1" {
+		t.Errorf("unexpected WHERE %v", q.Where)
+	}
+
+	for sql, typ := range map[string]JoinType{
+		"SELECT * FROM a INNER JOIN b ON a.x = b.x":   InnerJoin,
+		"SELECT * FROM a RIGHT JOIN b ON a.x = b.x":   RightJoin,
+		"SELECT * FROM a FULL OUTER JOIN b USING (x)": FullJoin,
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if len(q.Joins) != 1 || q.Joins[0].Type != typ {
+			t.Errorf("Parse(%q) joins = %+v, want %s", sql, q.Joins, typ)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM a JOIN b",
+		"SELECT * FROM a LEFT b ON a.x = b.x",
+		"SELECT * FROM a INNER OUTER JOIN b ON a.x = b.x",
+		"SELECT * FROM a CROSS JOIN b ON a.x = b.x",
+		"SELECT * FROM a JOIN b USING ()",
+		"SELECT * FROM a JOIN ON a.x = 1",
+		"SELECT a. FROM a",
+		"SELECT * FROM a AS",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseSubqueries(t *testing.T) {
+	q, err := Parse("SELECT name FROM users u WHERE id IN (SELECT user_id FROM orders) AND NOT EXISTS (SELECT * FROM bans b WHERE b.user_id = u.id) AND age > (SELECT AVG(age) FROM users)")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	var subs []*expr.Subquery
+	expr.Walk(q.Where, func(n expr.Expr) {
+		if s, ok := n.(*expr.Subquery); ok {
+			subs = append(subs, s)
+		}
+	})
+	want := []struct {
+		kind expr.SubqueryKind
+		sql  string
+	}{
+		{expr.InSubquery, "id IN (SELECT user_id FROM orders)"},
+		{expr.ExistsSubquery, "EXISTS (SELECT * FROM bans b WHERE b.user_id = u.id)"},
+		{expr.ScalarSubquery, "(SELECT AVG(age) FROM users)"},
+	}
+	if len(subs) != len(want) {
+		t.Fatalf("got %d subqueries, want %d", len(subs), len(want))
+	}
+	for i, w := range want {
+		if subs[i].Kind != w.kind || subs[i].String() != w.sql {
+			t.Errorf("subquery %d = %s %s, want %s %s", i, subs[i].Kind, subs[i], w.kind, w.sql)
+		}
+	}
+
+	q, err = Parse("SELECT t.id FROM (SELECT id FROM users WHERE age > 3 ORDER BY id DESC LIMIT 2) AS t JOIN (SELECT * FROM teams) tm ON t.id = tm.id")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Table != "" || q.Derived == nil || q.Alias != "t" {
+		t.Fatalf("FROM %q %v %q, want a derived table t", q.Table, q.Derived, q.Alias)
+	}
+	if got := q.Derived.String(); got != "SELECT id FROM users WHERE age > 3 ORDER BY id DESC LIMIT 2" {
+		t.Errorf("derived table = %s", got)
+	}
+	if len(q.Joins) != 1 || q.Joins[0].Derived == nil || q.Joins[0].Alias != "tm" {
+		t.Errorf("joins = %+v, want a derived table tm", q.Joins)
+	}
+	if got := q.String(); got != "SELECT t.id FROM (SELECT id FROM users WHERE age > 3 ORDER BY id DESC LIMIT 2) t JOIN (SELECT * FROM teams) tm ON t.id = tm.id" {
+		t.Errorf("String() = %s", got)
+	}
+
+	// EXISTS is not reserved
+	if _, err := Parse("SELECT exists FROM flags"); err != nil {
+		t.Errorf("EXISTS as a column: %v", err)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM (SELECT * FROM a)",
+		"SELECT * FROM a WHERE x IN (SELECT y FROM b",
+		"SELECT * FROM a WHERE EXISTS (DELETE FROM b)",
+		"SELECT * FROM a WHERE x = (SELECT FROM b)",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	q, err := Parse("WITH RECURSIVE tree (id, depth) AS (SELECT id, depth FROM nodes WHERE parent IS NULL UNION ALL SELECT n.id, t.depth FROM nodes n JOIN tree t ON n.parent = t.id), leaves AS (SELECT id FROM tree) SELECT * FROM leaves")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Type != SelectQuery || q.Table != "leaves" || len(q.With) != 2 {
+		t.Fatalf("unexpected query %+v", q)
+	}
+	tree := q.With[0]
+	if tree.Name != "tree" || strings.Join(tree.Columns, "|") != "id|depth" || !tree.UnionAll || tree.Step == nil {
+		t.Errorf("unexpected CTE %+v", tree)
+	}
+	if got := tree.Query.String(); got != "SELECT id, depth FROM nodes WHERE parent IS NULL" {
+		t.Errorf("anchor = %s", got)
+	}
+	if got := tree.Step.String(); got != "SELECT n.id, t.depth FROM nodes n JOIN tree t ON n.parent = t.id" {
+		t.Errorf("step = %s", got)
+	}
+	if leaves := q.With[1]; leaves.Name != "leaves" || leaves.Step != nil || leaves.Columns != nil {
+		t.Errorf("unexpected CTE %+v", leaves)
+	}
+
+	for sql, typ := range map[string]QueryType{
+		"WITH x AS (SELECT id FROM a) INSERT INTO b (id) SELECT id FROM x":               InsertQuery,
+		"WITH x AS (SELECT id FROM a) UPDATE b SET n = 1 WHERE id IN (SELECT id FROM x)": UpdateQuery,
+		"WITH x AS (SELECT id FROM a) DELETE FROM b WHERE id IN (SELECT id FROM x)":      DeleteQuery,
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if q.Type != typ || len(q.With) != 1 {
+			t.Errorf("Parse(%q) = %s with %d CTEs", sql, q.Type, len(q.With))
+		}
+	}
+
+	q, err = Parse("EXPLAIN WITH x AS (SELECT id FROM a) SELECT id FROM x")
+	if err != nil || q.Statement == nil || len(q.Statement.With) != 1 {
+		t.Errorf("EXPLAIN WITH: %+v, %v", q, err)
+	}
+
+	q, err = Parse("INSERT INTO b (id, name) SELECT id, name FROM a WHERE id > 1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Source == nil || strings.Join(q.Columns, "|") != "id|name" || q.Source.String() != "SELECT id, name FROM a WHERE id > 1" {
+		t.Errorf("unexpected INSERT ... SELECT %+v", q)
+	}
+
+	for _, sql := range []string{
+		"WITH x AS (SELECT id FROM a)",
+		"WITH x (SELECT id FROM a) SELECT * FROM x",
+		"WITH x AS SELECT id FROM a SELECT * FROM x",
+		"WITH x AS (SELECT id FROM a), x AS (SELECT id FROM b) SELECT * FROM x",
+		"WITH x AS ((SELECT id FROM a ORDER BY id) ORDER BY id) SELECT * FROM x",
+		"WITH RECURSIVE x AS (SELECT id FROM a UNION INSERT INTO b (id) VALUES (1)) SELECT * FROM x",
+		"WITH x AS (SELECT id FROM a) CREATE TABLE y",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseSetOperations(t *testing.T) {
+	q, err := Parse("SELECT a FROM t UNION ALL SELECT a FROM u EXCEPT SELECT a FROM v INTERSECT SELECT a FROM w ORDER BY a DESC LIMIT 5")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	// INTERSECT binds tighter; UNION and EXCEPT apply left to right
+	if q.SetOp != Except || q.All || q.Left.SetOp != Union || !q.Left.All || q.Right.SetOp != Intersect {
+		t.Fatalf("unexpected tree %s %v (%s) (%s)", q.SetOp, q.All, q.Left.SetOp, q.Right.SetOp)
+	}
+	if len(q.OrderBy) != 1 || q.Limit == nil || *q.Limit != 5 || q.Right.Right.OrderBy != nil || q.Right.Right.Limit != nil {
+		t.Errorf("ORDER BY and LIMIT should belong to the combined rows: %+v", q)
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT a FROM t UNION SELECT b FROM u":                                 "SELECT a FROM t UNION SELECT b FROM u",
+		"SELECT a FROM t INTERSECT ALL SELECT a FROM u":                         "SELECT a FROM t INTERSECT ALL SELECT a FROM u",
+		"(SELECT a FROM t ORDER BY a LIMIT 1) UNION SELECT a FROM u ORDER BY a": "(SELECT a FROM t ORDER BY a LIMIT 1) UNION SELECT a FROM u ORDER BY a",
+		"SELECT a FROM t UNION (SELECT a FROM u UNION ALL SELECT a FROM v)":     "SELECT a FROM t UNION (SELECT a FROM u UNION ALL SELECT a FROM v)",
+		"(SELECT a FROM t UNION SELECT a FROM u) INTERSECT SELECT a FROM v":     "(SELECT a FROM t UNION SELECT a FROM u) INTERSECT SELECT a FROM v",
+		"SELECT * FROM (SELECT a FROM t EXCEPT SELECT a FROM u) d":              "SELECT * FROM (SELECT a FROM t EXCEPT SELECT a FROM u) d",
+		"SELECT a FROM t WHERE a IN (SELECT a FROM u UNION SELECT a FROM v)":    "SELECT a FROM t WHERE a IN (SELECT a FROM u UNION SELECT a FROM v)",
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if got := q.String(); got != want {
+			t.Errorf("Parse(%q) = %s, want %s", sql, got, want)
+		}
+		// The rendered SQL parses back to the same query
+		if again, err := Parse(q.String()); err != nil || again.String() != want {
+			t.Errorf("reparsing %q: %v, %v", want, again, err)
+		}
+	}
+
+	// A recursive CTE's step is the SELECT after its last UNION
+	q, err = Parse("WITH RECURSIVE r AS (SELECT a FROM t UNION SELECT a FROM u UNION ALL SELECT a FROM r) SELECT * FROM r")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if c := q.With[0]; c.Query.SetOp != Union || c.Step == nil || c.Step.Table != "r" || !c.UnionAll {
+		t.Errorf("unexpected CTE %+v", c)
+	}
+
+	for _, sql := range []string{
+		"SELECT a FROM t UNION",
+		"SELECT a FROM t UNION ALL INSERT INTO u (a) VALUES (1)",
+		"SELECT a FROM t INTERSECT (SELECT a FROM u",
+		"(SELECT a FROM t ORDER BY a) ORDER BY a",
+		"(SELECT a FROM t LIMIT 1) LIMIT 2",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseSelectList(t *testing.T) {
+	q, err := Parse("SELECT DISTINCT ON (cat, price > 2) name AS n, price * 1.16 AS gross, p.*, UPPER(name) FROM products p ORDER BY cat")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	want := []string{"n", "gross", "p.*", "UPPER(name)"}
+	if strings.Join(q.Columns, "|") != strings.Join(want, "|") || len(q.Select) != 4 {
+		t.Errorf("columns = %q, want %q", q.Columns, want)
+	}
+	if star, ok := q.Select[2].(*expr.Star); !ok || star.Table != "p" {
+		t.Errorf("Select[2] = %#v, want p.*", q.Select[2])
+	}
+	if q.Distinct || len(q.DistinctOn) != 2 || q.DistinctOn[1].String() != "price > 2" {
+		t.Errorf("unexpected DISTINCT ON %v %v", q.Distinct, q.DistinctOn)
+	}
+
+	// A lone * keeps the table's rows as they are
+	q, err = Parse("SELECT ALL * FROM t")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if q.Select != nil || strings.Join(q.Columns, "|") != "*" {
+		t.Errorf("SELECT * gave %v %v", q.Select, q.Columns)
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT DISTINCT a, b FROM t":                       "SELECT DISTINCT a, b FROM t",
+		"SELECT DISTINCT ON (a) a, b FROM t ORDER BY a":     "SELECT DISTINCT ON (a) a, b FROM t ORDER BY a",
+		"SELECT a AS x, a + 1 AS y, a + 1 FROM t":           "SELECT a AS x, a + 1 AS y, a + 1 FROM t",
+		"SELECT *, t.*, b AS b FROM t":                      "SELECT *, t.*, b FROM t",
+		"SELECT \"order\".* FROM \"order\"":                 "SELECT order.* FROM order",
+		"SELECT COUNT(*) AS n FROM t GROUP BY a ORDER BY n": "SELECT COUNT(*) AS n FROM t GROUP BY a ORDER BY n",
+		"SELECT nextval('s') AS n, 1 + 2 WHERE TRUE":        "SELECT nextval('s') AS n, 1 + 2 WHERE TRUE",
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if got := q.String(); got != want {
+			t.Errorf("Parse(%q) = %s, want %s", sql, got, want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT a AS FROM t",
+		"SELECT a b FROM t",
+		"SELECT DISTINCT ON a FROM t",
+		"SELECT DISTINCT ON () a FROM t",
+		"SELECT t. FROM t",
+		"SELECT * AS x FROM t",
+		"SELECT *",
+		"SELECT t.* WHERE TRUE",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	q, err := Parse("SELECT ROW_NUMBER() OVER (PARTITION BY a, b ORDER BY c DESC) AS rn, SUM(x) OVER (ORDER BY c ROWS BETWEEN 2 PRECEDING AND CURRENT ROW), COUNT(*) OVER () FROM t")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	rn, ok := q.Select[0].(*expr.Window)
+	if !ok || rn.Func != "ROW_NUMBER" || len(rn.Partition) != 2 || len(rn.Order) != 1 || !rn.Order[0].Desc || rn.Frame != nil {
+		t.Errorf("unexpected window %#v", q.Select[0])
+	}
+	sum, ok := q.Select[1].(*expr.Window)
+	if !ok || sum.Agg == nil || sum.Agg.Func != "SUM" || sum.Frame == nil || sum.Frame.Range ||
+		sum.Frame.Start.Type != expr.Preceding || sum.Frame.End.Type != expr.CurrentRow {
+		t.Errorf("unexpected window %#v", q.Select[1])
+	}
+	if q.Columns[2] != "COUNT(*) OVER ()" {
+		t.Errorf("column named %q", q.Columns[2])
+	}
+	// An aggregate used as a window function does not group the query
+	if expr.HasAggregate(q.Select[1]) {
+		t.Errorf("%s should not count as an aggregate", q.Select[1])
+	}
+
+	for sql, want := range map[string]string{
+		"SELECT LAG(x, 1, 0) OVER (ORDER BY a NULLS FIRST) FROM t":                                   "SELECT LAG(x, 1, 0) OVER (ORDER BY a NULLS FIRST) FROM t",
+		"SELECT SUM(x) OVER (ROWS 3 PRECEDING) FROM t":                                               "SELECT SUM(x) OVER (ROWS BETWEEN 3 PRECEDING AND CURRENT ROW) FROM t",
+		"SELECT AVG(x) OVER (ORDER BY a RANGE BETWEEN 1.5 PRECEDING AND UNBOUNDED FOLLOWING) FROM t": "SELECT AVG(x) OVER (ORDER BY a RANGE BETWEEN 1.5 PRECEDING AND UNBOUNDED FOLLOWING) FROM t",
+		"SELECT g, SUM(SUM(x)) OVER (ORDER BY g) FROM t GROUP BY g":                                  "SELECT g, SUM(SUM(x)) OVER (ORDER BY g) FROM t GROUP BY g",
+		"select rank() over (order by a) from t":                                                     "SELECT RANK() OVER (ORDER BY a) FROM t",
+	} {
+		q, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", sql, err)
+			continue
+		}
+		if got := q.String(); got != want {
+			t.Errorf("Parse(%q) = %s, want %s", sql, got, want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT ROW_NUMBER() FROM t",
+		"SELECT RANK(a) OVER () FROM t",
+		"SELECT UPPER(x) OVER () FROM t",
+		"SELECT SUM(x) OVER (ROWS BETWEEN CURRENT ROW AND 1 PRECEDING) FROM t",
+		"SELECT SUM(x) OVER (ROWS UNBOUNDED FOLLOWING) FROM t",
+		"SELECT SUM(x) OVER (ROWS BETWEEN 1 PRECEDING AND UNBOUNDED PRECEDING) FROM t",
+		"SELECT SUM(x) OVER (ROWS 1.5 PRECEDING) FROM t",
+		"SELECT SUM(x) OVER (RANGE 1 PRECEDING) FROM t",
+		"SELECT SUM(x) OVER (ORDER BY a ROWS BETWEEN 1 AND 2 FOLLOWING) FROM t",
+		"SELECT SUM(ROW_NUMBER() OVER ()) FROM t",
+		"SELECT ROW_NUMBER() OVER (ORDER BY RANK() OVER ()) FROM t",
+		"SELECT SUM(SUM(x)) FROM t",
+		"SELECT COUNT(*) OVER (PARTITION a) FROM t",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", sql)
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package planner
+
+import (
+	"math"
+	"time"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Access paths
+// --------------------------
+
+// AccessType is the way a plan reads the rows of its table.
+type AccessType string
+
+const (
+	FullScan         AccessType = "FULL SCAN"
+	PrimaryKeyLookup AccessType = "PRIMARY KEY LOOKUP"
+	IndexScan        AccessType = "INDEX SCAN"
+)
+
+// AccessPath is how a SELECT, UPDATE or DELETE finds its rows. Filters
+// holds the filters the access path is built from:
+//
+//   - PrimaryKeyLookup: one "=" filter per primary key column, in key order.
+//   - IndexScan: "=" filters on the leading columns of Index, in index
+//     order, then at most one lower and one upper bound on the next column.
+//
+// Residual lists the conjuncts of WHERE the access path does not apply.
+// The engine still checks the whole condition against every row the
+// access path returns. An Ordered index scan returns its rows in index
+// key order, which is the order of the SELECT's ORDER BY, rather than in
+// ID order.
+type AccessPath struct {
+	Type     AccessType
+	Index    string // IndexScan
+	Filters  []Filter
+	Residual []expr.Expr
+	Ordered  bool // IndexScan
+
+	Rows float64 // estimated rows passing every filter
+	Cost float64 // estimated work, in rows read by a full scan
+}
+
+// Costs are measured in rows read by a full scan. An index scan first
+// descends the index and then fetches every matching row out of order and
+// sorts them, which costs more per row than reading the table in order; as
+// with PostgreSQL's random_page_cost, an index only pays off when it skips
+// more than three quarters of the table.
+const (
+	lookupCost   = 1.0
+	indexRowCost = 4.0
+
+	// defaultRangeSelectivity is assumed for a range whose bound is not
+	// known when planning or cannot be placed between the column's min
+	// and max.
+	defaultRangeSelectivity = 1.0 / 3
+
+	// defaultEqualSelectivity is assumed for an equality the statistics
+	// say nothing about, such as one between two columns, and
+	// defaultSelectivity for any other condition.
+	defaultEqualSelectivity = 0.005
+	defaultSelectivity      = 0.5
+
+	// defaultMatchSelectivity is assumed for a pattern match with no
+	// fixed prefix.
+	defaultMatchSelectivity = 0.1
+)
+
+// ChooseAccess picks the cheapest access path for reading the rows of
+// table that can pass where, comparing a full scan with a primary key
+// lookup and a scan of each usable index. Row estimates come from the
+// table's statistics.
+func ChooseAccess(table *storage.Table, where expr.Expr) *AccessPath {
+	return ChooseOrderedAccess(table, where, nil, table.Name)
+}
+
+// ChooseOrderedAccess is ChooseAccess for a SELECT that sorts its rows by
+// order, in which table is called name. It also weighs reading the rows in
+// the order of an index whose columns order follows, which saves sorting
+// them, and marks the access path Ordered if that is cheaper.
+func ChooseOrderedAccess(table *storage.Table, where expr.Expr, order []SortKey, name string) *AccessPath {
+	stats := table.Stats()
+	n := float64(stats.Rows)
+
+	terms := Conjuncts(where)
+	var filters []Filter
+	var termOf []int // position in terms of each filter
+	implied := make([]int, len(terms))
+	inexact := make([]bool, len(terms))
+	for i, term := range terms {
+		fs, exact := termFilters(term)
+		for _, f := range fs {
+			filters = append(filters, f)
+			termOf = append(termOf, i)
+		}
+		implied[i] = len(fs)
+		inexact[i] = !exact
+	}
+
+	// An access path applies a term when it uses every filter the term
+	// implies and those filters are the whole term
+	path := func(typ AccessType, index string, used []int, rows, cost float64) *AccessPath {
+		p := &AccessPath{Type: typ, Index: index, Rows: rows, Cost: cost}
+		applied := make([]int, len(terms))
+		for _, i := range used {
+			p.Filters = append(p.Filters, filters[i])
+			applied[termOf[i]]++
+		}
+		for i, term := range terms {
+			if inexact[i] || applied[i] == 0 || applied[i] < implied[i] {
+				p.Residual = append(p.Residual, term)
+			}
+		}
+		return p
+	}
+
+	// indexCost estimates scanning ix with the filters at the positions
+	// used, of which the first equalities are "=" or IN
+	indexCost := func(ix *storage.Index, used []int, equalities int) float64 {
+		applied := make([]Filter, len(used))
+		for i, pos := range used {
+			applied[i] = filters[pos]
+		}
+		// Every combination of the values of the equalities is a separate
+		// descent of the index
+		keys := keyCount(filters, used[:equalities])
+		matched := n * selectivity(stats, applied)
+		if ix.Unique && equalities == len(ix.Columns) {
+			matched = math.Min(matched, keys)
+		}
+		return math.Log2(n+1)*keys + indexRowCost*matched
+	}
+
+	rows := n * conjunctionSelectivity(stats, terms)
+	best := path(FullScan, "", nil, rows, n)
+
+	if used := primaryKeyFilters(table, filters); used != nil {
+		keys := keyCount(filters, used)
+		if cost := lookupCost * keys; cost < best.Cost {
+			best = path(PrimaryKeyLookup, "", used, math.Min(rows, keys), cost)
+		}
+	}
+
+	for _, ix := range table.Indexes {
+		used, equalities := indexFilters(table, ix, filters)
+		if len(used) == 0 {
+			continue
+		}
+		if cost := indexCost(ix, used, equalities); cost < best.Cost {
+			best = path(IndexScan, ix.Name, used, rows, cost)
+		}
+	}
+
+	if len(order) == 0 {
+		return best
+	}
+
+	// Any other access path is followed by a sort
+	sorted := best.Cost + best.Rows*math.Log2(best.Rows+1)
+	for _, ix := range table.Indexes {
+		used, equalities := indexFilters(table, ix, filters)
+		used, fixed := orderedFilters(filters, used, equalities)
+		if !indexOrders(ix, fixed, order, name) {
+			continue
+		}
+		if cost := indexCost(ix, used, fixed); cost < sorted {
+			best = path(IndexScan, ix.Name, used, rows, cost)
+			best.Ordered = true
+			sorted = cost
+		}
+	}
+	return best
+}
+
+// planAccess chooses the access path of a plan reading a single table of
+// db. It leaves it to the engine when WHERE reads something other than
+// the table's columns: a column of an enclosing query, or a subquery,
+// whose values are only known once the engine has resolved them. The
+// engine also chooses the access paths of joined tables, once it knows
+// which terms apply to each; a name WITH declares is not a table at all.
+func planAccess(plan *Plan, db *storage.Database) {
+	if db == nil || plan.TableName == "" || len(plan.Joins) > 0 {
+		return
+	}
+	for _, cte := range plan.With {
+		if cte.Name == plan.TableName {
+			return
+		}
+	}
+	table, ok := db.Tables[plan.TableName]
+	if !ok || !readsOnly(plan.Where, table, plan.Alias) {
+		return
+	}
+	// The rows of a grouped SELECT are sorted after they are grouped
+	var keys []SortKey
+	if plan.Type == SelectPlan && !plan.Grouped {
+		keys = plan.OrderBy
+	}
+	plan.Access = ChooseOrderedAccess(table, plan.Where, keys, sourceName(table.Name, plan.Alias))
+}
+
+// readsOnly reports whether e reads nothing but columns of table, which
+// is called alias if it has one, and has no subquery.
+func readsOnly(e expr.Expr, table *storage.Table, alias string) bool {
+	name := sourceName(table.Name, alias)
+	ok := true
+	expr.Walk(e, func(n expr.Expr) {
+		switch n := n.(type) {
+		case *expr.ColumnRef:
+			if n.Table != "" && n.Table != name || column(table, n.Name) == nil {
+				ok = false
+			}
+		case *expr.Subquery:
+			ok = false
+		}
+	})
+	return ok
+}
+
+// sourceName returns the name a query calls table by: its alias if it
+// has one.
+func sourceName(table, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return table
+}
+
+// primaryKeyFilters returns, for every primary key column in key order, the
+// position of an "=" or IN filter the primary index can look up, or nil if
+// some key column has none.
+func primaryKeyFilters(table *storage.Table, filters []Filter) []int {
+	var used []int
+	for _, col := range table.Columns {
+		if !col.IsPrimaryKey {
+			continue
+		}
+		// PrimaryIndex holds exact values, so a constant has to be of the
+		// column's own type
+		i := findEquality(filters, col.Name, func(v any) bool {
+			return computed(v) || storage.TypeOf(v) == col.ColumnType
+		})
+		if i < 0 {
+			return nil
+		}
+		used = append(used, i)
+	}
+	return used
+}
+
+// indexFilters returns the positions of the filters an index scan of ix
+// can use, and how many of them are equalities: "=" or IN on a prefix of
+// the index's columns, then the tightest lower and upper bound on the next
+// one.
+func indexFilters(table *storage.Table, ix *storage.Index, filters []Filter) (used []int, equalities int) {
+	for _, name := range ix.Columns {
+		col := column(table, name)
+		i := findEquality(filters, name, func(v any) bool { return Indexable(col, v) })
+		if i < 0 {
+			break
+		}
+		used = append(used, i)
+	}
+	equalities = len(used)
+	if equalities == len(ix.Columns) {
+		return used, equalities
+	}
+
+	col := column(table, ix.Columns[equalities])
+	lo, hi := -1, -1
+	for i, f := range filters {
+		if f.Column != col.Name || !Indexable(col, f.Value) {
+			continue
+		}
+		switch f.Operator {
+		case ">", ">=":
+			if lo < 0 || tighter(f.Value, filters[lo].Value, 1) {
+				lo = i
+			}
+		case "<", "<=":
+			if hi < 0 || tighter(f.Value, filters[hi].Value, -1) {
+				hi = i
+			}
+		}
+	}
+	if lo >= 0 {
+		used = append(used, lo)
+	}
+	if hi >= 0 {
+		used = append(used, hi)
+	}
+	return used, equalities
+}
+
+// orderedFilters trims the filters indexFilters chose for an index scan
+// to those that read the index in a single pass, so that the rows come in
+// key order: an IN with more than one value starts a new pass for each
+// value. It returns the positions kept and the number of leading index
+// columns they hold to a single value.
+func orderedFilters(filters []Filter, used []int, equalities int) ([]int, int) {
+	for fixed, pos := range used[:equalities] {
+		if len(filters[pos].Values()) > 1 {
+			return used[:fixed], fixed
+		}
+	}
+	return used, equalities
+}
+
+// indexOrders reports whether keys sort the rows of a scan of ix whose
+// first fixed columns hold a single value the way the index does. Its
+// keys ascend with NULL last, and rows with equal keys come in ID order,
+// as a sort leaves them; so every key must ascend with NULLS LAST and be
+// one of the fixed columns or the next of the others. name is what keys
+// call the index's table.
+func indexOrders(ix *storage.Index, fixed int, keys []SortKey, name string) bool {
+	next := fixed
+	for _, k := range keys {
+		ref, ok := k.Expr.(*expr.ColumnRef)
+		if !ok || k.Desc || k.NullsFirst || ref.Table != "" && ref.Table != name {
+			return false
+		}
+		switch {
+		case containsColumn(ix.Columns[:fixed], ref.Name):
+		case next < len(ix.Columns) && ix.Columns[next] == ref.Name:
+			next++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func containsColumn(columns []string, name string) bool {
+	for _, col := range columns {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Indexable reports whether v can be compared with the values of col
+// without an error, so that an index finds the same rows a scan would.
+// NULL never matches a comparison and is left to the scan. A value
+// computed when the statement runs is assumed to be indexable; the engine
+// checks it again once it is known.
+func Indexable(col *storage.Column, v any) bool {
+	if computed(v) {
+		return true
+	}
+	if v == nil {
+		return false
+	}
+	t := storage.TypeOf(v)
+	numeric := func(t storage.ColumnType) bool { return t == storage.IntType || t == storage.FloatType }
+	return t == col.ColumnType || (numeric(t) && numeric(col.ColumnType))
+}
+
+// computed reports whether v is only known when the statement runs, such
+// as nextval('seq').
+func computed(v any) bool {
+	_, ok := v.(expr.Expr)
+	return ok
+}
+
+// tighter reports whether v narrows a range more than current; dir is 1
+// for lower bounds and -1 for upper bounds. Computed values are never
+// known to be tighter.
+func tighter(v, current any, dir int) bool {
+	if computed(v) || computed(current) {
+		return false
+	}
+	cmp, err := storage.Compare(v, current)
+	return err == nil && cmp*dir > 0
+}
+
+// findEquality returns the position of an "=" filter on column whose value
+// passes ok, or failing that of an IN filter whose values all do.
+func findEquality(filters []Filter, column string, ok func(any) bool) int {
+	for _, op := range []string{"=", "IN"} {
+		for i, f := range filters {
+			if f.Column == column && f.Operator == op && all(f.Values(), ok) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func all(values []any, ok func(any) bool) bool {
+	for _, v := range values {
+		if !ok(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyCount returns the number of combinations of the values of the "=" and
+// IN filters at the given positions.
+func keyCount(filters []Filter, positions []int) float64 {
+	keys := 1.0
+	for _, i := range positions {
+		keys *= float64(len(filters[i].Values()))
+	}
+	return keys
+}
+
+func column(table *storage.Table, name string) *storage.Column {
+	for _, col := range table.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// --------------------------
+// Selectivity
+// --------------------------
+
+// conjunctionSelectivity estimates the fraction of rows for which every
+// term is true. Terms are assumed to be independent; filters on the same
+// column are estimated together.
+func conjunctionSelectivity(stats *storage.TableStats, terms []expr.Expr) float64 {
+	if stats.Rows == 0 {
+		return 0
+	}
+
+	var filters []Filter
+	sel := 1.0
+	for _, term := range terms {
+		if fs, _ := termFilters(term); len(fs) > 0 {
+			filters = append(filters, fs...)
+			continue
+		}
+		sel *= termSelectivity(stats, term)
+	}
+	return sel * selectivity(stats, filters)
+}
+
+// termSelectivity estimates the fraction of rows for which a condition
+// that is not a filter is true.
+func termSelectivity(stats *storage.TableStats, term expr.Expr) float64 {
+	switch x := term.(type) {
+	case *expr.Binary:
+		switch x.Op {
+		case "AND":
+			return conjunctionSelectivity(stats, Conjuncts(x))
+		case "OR":
+			l := conjunctionSelectivity(stats, Conjuncts(x.L))
+			r := conjunctionSelectivity(stats, Conjuncts(x.R))
+			return l + r - l*r
+		case "=":
+			return defaultEqualSelectivity
+		case "!=":
+			return 1 - defaultEqualSelectivity
+		case "<", "<=", ">", ">=":
+			return defaultRangeSelectivity
+		}
+	case *expr.Unary:
+		if x.Op == "NOT" {
+			return 1 - conjunctionSelectivity(stats, Conjuncts(x.X))
+		}
+	case *expr.In:
+		if x.Not {
+			return 1 - conjunctionSelectivity(stats, []expr.Expr{&expr.In{X: x.X, List: x.List}})
+		}
+		return math.Min(1, float64(len(x.List))*defaultEqualSelectivity)
+	case *expr.Between:
+		if x.Not {
+			return 1 - conjunctionSelectivity(stats, []expr.Expr{&expr.Between{X: x.X, Lo: x.Lo, Hi: x.Hi}})
+		}
+		return defaultRangeSelectivity
+	case *expr.Match:
+		if x.Not {
+			return 1 - conjunctionSelectivity(stats, []expr.Expr{&expr.Match{Op: x.Op, X: x.X, Pattern: x.Pattern}})
+		}
+		return defaultMatchSelectivity
+	case *expr.Literal:
+		if x.Value == true {
+			return 1
+		}
+		return 0
+	}
+	return defaultSelectivity
+}
+
+// selectivity estimates the fraction of rows passing every filter. Filters
+// on different columns are assumed to be independent; a lower and an
+// upper bound on the same column are combined into one range.
+func selectivity(stats *storage.TableStats, filters []Filter) float64 {
+	if stats.Rows == 0 {
+		return 0
+	}
+
+	byColumn := make(map[string][]Filter)
+	var order []string
+	for _, f := range filters {
+		if _, ok := byColumn[f.Column]; !ok {
+			order = append(order, f.Column)
+		}
+		byColumn[f.Column] = append(byColumn[f.Column], f)
+	}
+
+	sel := 1.0
+	for _, name := range order {
+		sel *= columnSelectivity(stats, byColumn[name])
+	}
+	return sel
+}
+
+// columnSelectivity estimates the fraction of rows passing every filter
+// on one column.
+func columnSelectivity(stats *storage.TableStats, filters []Filter) float64 {
+	// A column added since the statistics were gathered is assumed to
+	// hold distinct values
+	cs := stats.Columns[filters[0].Column]
+	if cs == nil {
+		cs = &storage.ColumnStats{Distinct: stats.Rows}
+	}
+	n := float64(stats.Rows)
+	nonNull := clamp(1 - float64(cs.Nulls)/n)
+
+	sel := 1.0
+	lower, upper := 1.0, 1.0
+	ranged := false
+	for _, f := range filters {
+		switch f.Operator {
+		case "IS NULL":
+			sel *= 1 - nonNull
+			continue
+		case "IS NOT NULL":
+			sel *= nonNull
+			continue
+		}
+
+		if f.Value == nil && f.Operator != "IN" {
+			return 0 // comparisons with NULL never match
+		}
+
+		switch f.Operator {
+		case "=":
+			sel *= equalSelectivity(cs, f.Value) * nonNull
+		case "IN":
+			seen := make(map[any]bool)
+			in := 0.0
+			for _, v := range f.Values() {
+				if v == nil || seen[v] {
+					continue
+				}
+				seen[v] = true
+				in += equalSelectivity(cs, v)
+			}
+			sel *= math.Min(in, 1) * nonNull
+		case "!=":
+			sel *= (1 - equalSelectivity(cs, f.Value)) * nonNull
+		case "<", "<=":
+			ranged = true
+			if frac, ok := fractionBelow(cs, f.Value); ok {
+				upper = math.Min(upper, clamp(frac))
+			} else {
+				upper = math.Min(upper, defaultRangeSelectivity)
+			}
+		case ">", ">=":
+			ranged = true
+			if frac, ok := fractionBelow(cs, f.Value); ok {
+				lower = math.Min(lower, clamp(1-frac))
+			} else {
+				lower = math.Min(lower, defaultRangeSelectivity)
+			}
+		}
+	}
+
+	if ranged {
+		// Both bounds together select what neither excludes. A range
+		// within the stored values is taken to hold at least one of them,
+		// as interpolation says little about a short text prefix.
+		frac := lower + upper - 1
+		if frac > 0 && cs.Distinct > 0 {
+			frac = math.Max(frac, 1/float64(cs.Distinct))
+		}
+		sel *= math.Max(frac, 0) * nonNull
+	}
+	return sel
+}
+
+// equalSelectivity estimates the fraction of non-NULL values equal to v,
+// assuming the distinct values are equally common.
+func equalSelectivity(cs *storage.ColumnStats, v any) float64 {
+	if cs.Distinct == 0 {
+		return 0
+	}
+	if frac, ok := fractionBelow(cs, v); ok && (frac < 0 || frac > 1) {
+		return 0 // outside the range of stored values
+	}
+	return 1 / float64(cs.Distinct)
+}
+
+// fractionBelow places v between the column's min and max by linear
+// interpolation. The result is below 0 or above 1 when v lies outside that
+// range. ok is false when v is not known or not numeric or a date.
+func fractionBelow(cs *storage.ColumnStats, v any) (float64, bool) {
+	x, ok := ordinal(v)
+	lo, okLo := ordinal(cs.Min)
+	hi, okHi := ordinal(cs.Max)
+	if !ok || !okLo || !okHi {
+		return 0, false
+	}
+	if hi == lo {
+		switch {
+		case x < lo:
+			return -1, true
+		case x > hi:
+			return 2, true
+		default:
+			return 0.5, true
+		}
+	}
+	return (x - lo) / (hi - lo), true
+}
+
+func clamp(frac float64) float64 {
+	return math.Max(0, math.Min(1, frac))
+}
+
+// ordinal maps numbers, dates and text onto a line for interpolation.
+// Text is placed by its first bytes, read as a base-256 fraction.
+func ordinal(v any) (float64, bool) {
+	switch x := v.(type) {
+	case string:
+		f, scale := 0.0, 1.0
+		for i := 0; i < len(x) && i < 6; i++ {
+			scale /= 256
+			f += float64(x[i]) * scale
+		}
+		return f, true
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case time.Time:
+		return float64(x.Unix()), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,232 @@
+package planner
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+func accessTable(t *testing.T, rows int) *storage.Table {
+	db := storage.NewDatabase()
+	table, _ := db.CreateTable("orders")
+	for _, col := range []*storage.Column{
+		{Name: "id", ColumnType: storage.IntType, IsPrimaryKey: true},
+		{Name: "customer", ColumnType: storage.IntType},
+		{Name: "status", ColumnType: storage.TextType},
+	} {
+		if err := table.AddColumn(col); err != nil {
+			t.Fatalf("add column failed: %v", err)
+		}
+	}
+
+	statuses := []string{"open", "paid", "shipped", "closed"}
+	for i := 0; i < rows; i++ {
+		table.AppendRow(&storage.Row{Data: map[string]any{
+			"id":       int64(i),
+			"customer": int64(i % 100),
+			"status":   statuses[i%len(statuses)],
+		}})
+	}
+
+	for _, ix := range []*storage.Index{
+		{Name: "orders_customer", Table: "orders", Columns: []string{"customer"}},
+		{Name: "orders_status", Table: "orders", Columns: []string{"status"}},
+	} {
+		if err := db.CreateIndex(ix); err != nil {
+			t.Fatalf("create index failed: %v", err)
+		}
+	}
+	return table
+}
+
+func TestChooseAccess(t *testing.T) {
+	table := accessTable(t, 1000)
+
+	for _, tc := range []struct {
+		name  string
+		where string
+		want  AccessType
+		index string
+		rows  float64
+	}{
+		{"no filters", "", FullScan, "", 1000},
+		{"primary key", "id = 7", PrimaryKeyLookup, "", 1},
+		{"selective index", "customer = 3", IndexScan, "orders_customer", 10},
+		{"column on the right", "3 = customer", IndexScan, "orders_customer", 10},
+		{"narrow range", "customer >= 10 AND customer < 20", IndexScan, "orders_customer", 101},
+		// A quarter of the table is cheaper to read in order
+		{"unselective index", "status = 'paid'", FullScan, "", 250},
+		{"wide range", "customer > 5", FullScan, "", 949},
+		{"outside min and max", "customer = 500", IndexScan, "orders_customer", 0},
+		{"float key needs a scan", "id = 7.0", FullScan, "", 1},
+		{"computed key", "id = currval('s')", PrimaryKeyLookup, "", 1},
+		{"nextval is not known in advance", "id = nextval('s')", FullScan, "", 5},
+		{"most selective index wins", "status = 'open' AND customer = 4", IndexScan, "orders_customer", 2.5},
+		{"index with other conditions", "customer = 3 AND (status = 'open' OR id < 0)", IndexScan, "orders_customer", 2.5},
+		{"OR needs a scan", "customer = 3 OR customer = 4", FullScan, "", 19.9},
+		{"NOT", "NOT status = 'open'", FullScan, "", 750},
+		{"column against column", "id = customer", FullScan, "", 5},
+		{"IN on the primary key", "id IN (1, 2, 3)", PrimaryKeyLookup, "", 3},
+		{"IN on an index", "customer IN (3, 4)", IndexScan, "orders_customer", 20},
+		{"single item IN", "customer IN (3)", IndexScan, "orders_customer", 10},
+		{"NOT IN needs a scan", "customer NOT IN (3, 4)", FullScan, "", 980},
+		{"BETWEEN", "customer BETWEEN 10 AND 19", IndexScan, "orders_customer", 91},
+		{"LIKE prefix", "status LIKE 'pa%'", FullScan, "", 250},
+		{"LIKE without wildcards", "status LIKE 'paid'", FullScan, "", 250},
+		{"leading wildcard", "status LIKE '%d'", FullScan, "", 100},
+		{"regexp prefix", "status ~ '^sh'", FullScan, "", 250},
+	} {
+		var where expr.Expr
+		if tc.where != "" {
+			var err error
+			if where, err = parser.ParseExpr(tc.where); err != nil {
+				t.Fatalf("%s: parse failed: %v", tc.name, err)
+			}
+		}
+
+		path := ChooseAccess(table, where)
+		if path.Type != tc.want || path.Index != tc.index {
+			t.Errorf("%s: chose %s %s, want %s %s", tc.name, path.Type, path.Index, tc.want, tc.index)
+		}
+		if math.Abs(path.Rows-tc.rows) > 0.5 {
+			t.Errorf("%s: estimated %.1f rows, want %.1f", tc.name, path.Rows, tc.rows)
+		}
+	}
+
+	// The filters an index applies are not residual
+	where, _ := parser.ParseExpr("customer = 3 AND status = 'open'")
+	path := ChooseAccess(table, where)
+	if len(path.Filters) != 1 || path.Filters[0].String() != "customer = 3" ||
+		len(path.Residual) != 1 || path.Residual[0].String() != "status = 'open'" {
+		t.Errorf("unexpected filters %v and residual %v", path.Filters, path.Residual)
+	}
+
+	// A LIKE prefix only narrows the rows down, so the pattern is still
+	// checked
+	where, _ = parser.ParseExpr("customer BETWEEN 1 AND 2 AND status LIKE 'o%n'")
+	path = ChooseAccess(table, where)
+	if len(path.Filters) != 2 || len(path.Residual) != 1 || path.Residual[0].String() != "status LIKE 'o%n'" {
+		t.Errorf("unexpected filters %v and residual %v", path.Filters, path.Residual)
+	}
+
+	// On a tiny table reading everything is cheapest
+	small := accessTable(t, 4)
+	where, _ = parser.ParseExpr("customer = 1")
+	if path := ChooseAccess(small, where); path.Type != FullScan {
+		t.Errorf("expected a full scan of a tiny table, got %s", path.Type)
+	}
+}
+
+func TestChooseOrderedAccess(t *testing.T) {
+	db := storage.NewDatabase()
+	db.Tables["orders"] = accessTable(t, 1000)
+
+	for _, tc := range []struct {
+		sql     string
+		want    AccessType
+		index   string
+		ordered bool
+	}{
+		{"SELECT * FROM orders ORDER BY customer", IndexScan, "orders_customer", true},
+		{"SELECT * FROM orders o ORDER BY o.customer", IndexScan, "orders_customer", true},
+		{"SELECT * FROM orders WHERE customer > 5 ORDER BY customer LIMIT 10", IndexScan, "orders_customer", true},
+		{"SELECT status AS customer FROM orders ORDER BY customer", IndexScan, "orders_status", true},
+		// Sorting the few rows an index finds is cheaper
+		{"SELECT * FROM orders WHERE customer IN (3, 4) ORDER BY customer", IndexScan, "orders_customer", false},
+		{"SELECT * FROM orders WHERE status = 'paid' ORDER BY customer", FullScan, "", false},
+		// The index does not give this order
+		{"SELECT * FROM orders ORDER BY customer DESC", FullScan, "", false},
+		{"SELECT * FROM orders ORDER BY customer NULLS FIRST", FullScan, "", false},
+		{"SELECT * FROM orders ORDER BY customer, id", FullScan, "", false},
+		{"SELECT * FROM orders ORDER BY customer + 1", FullScan, "", false},
+		{"SELECT customer, COUNT(*) FROM orders GROUP BY customer ORDER BY customer", FullScan, "", false},
+	} {
+		q, err := parser.Parse(tc.sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", tc.sql, err)
+		}
+		plan, err := CreatePlan(q, db)
+		if err != nil {
+			t.Fatalf("CreatePlan(%q) failed: %v", tc.sql, err)
+		}
+		path := plan.Access
+		if path.Type != tc.want || path.Index != tc.index || path.Ordered != tc.ordered {
+			t.Errorf("%s: chose %s %s ordered=%v, want %s %s ordered=%v",
+				tc.sql, path.Type, path.Index, path.Ordered, tc.want, tc.index, tc.ordered)
+		}
+	}
+}
+
+func TestTermFilters(t *testing.T) {
+	for cond, want := range map[string]string{
+		"a IN (1, 2)":        "a IN (1, 2)",
+		"a IN (1)":           "a = 1",
+		"a BETWEEN 1 AND 5":  "a >= 1 AND a <= 5",
+		"name LIKE 'ab'":     "name = 'ab'",
+		"name LIKE 'ab%'":    "name >= 'ab' AND name < 'ac'",
+		"name LIKE 'a\\_b%'": "name >= 'a_b' AND name < 'a_c'",
+		"name ~ '^ab+'":      "name >= 'a' AND name < 'b'",
+		"a NOT IN (1, 2)":    "",
+		"name LIKE '%b'":     "",
+		"name ILIKE 'ab%'":   "",
+		"name ~ 'ab'":        "",
+		"a IN (1, b)":        "",
+	} {
+		e, err := parser.ParseExpr(cond)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", cond, err)
+		}
+		filters, _ := termFilters(e)
+		parts := make([]string, len(filters))
+		for i, f := range filters {
+			parts[i] = f.String()
+		}
+		if got := strings.Join(parts, " AND "); got != want {
+			t.Errorf("%s: filters %q, want %q", cond, got, want)
+		}
+	}
+}
+
+func TestCreatePlanChoosesAccess(t *testing.T) {
+	db := storage.NewDatabase()
+	db.Tables["orders"] = accessTable(t, 1000)
+
+	for sql, want := range map[string]AccessType{
+		"SELECT * FROM orders WHERE id = 7":                 PrimaryKeyLookup,
+		"SELECT * FROM orders o WHERE o.customer = 3":       IndexScan,
+		"UPDATE orders SET status = 'x' WHERE customer = 3": IndexScan,
+		"DELETE FROM orders":                                FullScan,
+		// Left to the engine
+		"SELECT * FROM orders WHERE id = (SELECT MAX(id) FROM orders)":                    "",
+		"SELECT * FROM orders WHERE id = other.id":                                        "",
+		"SELECT * FROM orders WHERE missing = 7":                                          "",
+		"WITH orders AS (SELECT id FROM orders) SELECT * FROM orders WHERE id = 1":        "",
+		"SELECT * FROM orders JOIN orders AS o2 ON orders.id = o2.id WHERE orders.id = 1": "",
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		plan, err := CreatePlan(q, db)
+		if err != nil {
+			t.Fatalf("CreatePlan(%q) failed: %v", sql, err)
+		}
+		var got AccessType
+		if plan.Access != nil {
+			got = plan.Access.Type
+		}
+		if got != want {
+			t.Errorf("CreatePlan(%q) chose %q, want %q", sql, got, want)
+		}
+	}
+
+	q, _ := parser.Parse("EXPLAIN SELECT * FROM orders WHERE id = 7")
+	plan, err := CreatePlan(q, db)
+	if err != nil || plan.Statement.Access == nil || plan.Statement.Access.Type != PrimaryKeyLookup {
+		t.Errorf("EXPLAIN did not choose the statement's access path: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package planner
+
+import (
+	"regexp/syntax"
+	"strings"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+)
+
+// --------------------------
+// Filters
+// --------------------------
+
+// Filter is a condition "column op value" whose value is known before any
+// row is read: a constant, or an expression such as currval('seq') that the
+// engine evaluates once per statement. The planner finds filters among the
+// conjuncts of WHERE and builds access paths and row estimates from them.
+type Filter struct {
+	Column   string
+	Operator string // =, !=, <, <=, >, >=, IN, IS NULL, IS NOT NULL
+	Value    any    // a []any for IN
+}
+
+// String renders the filter as SQL, e.g. "age > 25".
+func (f Filter) String() string {
+	switch f.Operator {
+	case "IS NULL", "IS NOT NULL":
+		return f.Column + " " + f.Operator
+	case "IN":
+		items := make([]string, 0, len(f.Values()))
+		for _, v := range f.Values() {
+			items = append(items, SQLValue(v))
+		}
+		return f.Column + " IN (" + strings.Join(items, ", ") + ")"
+	}
+	return f.Column + " " + f.Operator + " " + SQLValue(f.Value)
+}
+
+// Values returns the values the column is compared with: the list of an
+// IN filter, or the single value of any other.
+func (f Filter) Values() []any {
+	if list, ok := f.Value.([]any); ok && f.Operator == "IN" {
+		return list
+	}
+	return []any{f.Value}
+}
+
+// SQLValue renders a constant, or a value computed when the statement
+// runs, as SQL.
+func SQLValue(v any) string {
+	if e, ok := v.(expr.Expr); ok {
+		return e.String()
+	}
+	return (&expr.Literal{Value: v}).String()
+}
+
+// Conjuncts splits cond into the terms joined by its top-level ANDs. A nil
+// condition has none.
+func Conjuncts(cond expr.Expr) []expr.Expr {
+	if cond == nil {
+		return nil
+	}
+	if b, ok := cond.(*expr.Binary); ok && b.Op == "AND" {
+		return append(Conjuncts(b.L), Conjuncts(b.R)...)
+	}
+	return []expr.Expr{cond}
+}
+
+// Conjoin joins terms with AND. It returns nil for no terms.
+func Conjoin(terms []expr.Expr) expr.Expr {
+	var cond expr.Expr
+	for _, term := range terms {
+		if cond == nil {
+			cond = term
+			continue
+		}
+		cond = &expr.Binary{Op: "AND", L: cond, R: term}
+	}
+	return cond
+}
+
+// flipped gives the operator that keeps a comparison's meaning when its
+// sides are swapped.
+var flipped = map[string]string{
+	"=": "=", "!=": "!=",
+	"<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// termFilters returns the filters term implies. exact is false when the
+// filters only narrow down the rows the term can be true for, as the
+// prefix range of a LIKE pattern does. A comparison with the column on
+// the right is turned around.
+func termFilters(term expr.Expr) (filters []Filter, exact bool) {
+	switch x := term.(type) {
+	case *expr.IsNull:
+		ref, ok := x.X.(*expr.ColumnRef)
+		if !ok {
+			break
+		}
+		if x.Not {
+			return []Filter{{Column: ref.Name, Operator: "IS NOT NULL"}}, true
+		}
+		return []Filter{{Column: ref.Name, Operator: "IS NULL"}}, true
+
+	case *expr.Binary:
+		if _, ok := flipped[x.Op]; !ok {
+			break
+		}
+		if ref, ok := x.L.(*expr.ColumnRef); ok {
+			if v, ok := known(x.R); ok {
+				return []Filter{{Column: ref.Name, Operator: x.Op, Value: v}}, true
+			}
+		}
+		if ref, ok := x.R.(*expr.ColumnRef); ok {
+			if v, ok := known(x.L); ok {
+				return []Filter{{Column: ref.Name, Operator: flipped[x.Op], Value: v}}, true
+			}
+		}
+
+	case *expr.In:
+		ref, ok := x.X.(*expr.ColumnRef)
+		if !ok || x.Not {
+			break
+		}
+		values := make([]any, len(x.List))
+		for i, item := range x.List {
+			if values[i], ok = known(item); !ok {
+				return nil, false
+			}
+		}
+		if len(values) == 1 {
+			return []Filter{{Column: ref.Name, Operator: "=", Value: values[0]}}, true
+		}
+		return []Filter{{Column: ref.Name, Operator: "IN", Value: values}}, true
+
+	case *expr.Between:
+		ref, ok := x.X.(*expr.ColumnRef)
+		if !ok || x.Not {
+			break
+		}
+		lo, lok := known(x.Lo)
+		hi, hok := known(x.Hi)
+		if !lok || !hok {
+			break
+		}
+		return []Filter{
+			{Column: ref.Name, Operator: ">=", Value: lo},
+			{Column: ref.Name, Operator: "<=", Value: hi},
+		}, true
+
+	case *expr.Match:
+		ref, ok := x.X.(*expr.ColumnRef)
+		lit, isLit := x.Pattern.(*expr.Literal)
+		if !ok || !isLit || x.Not {
+			break
+		}
+		pattern, isText := lit.Value.(string)
+		if !isText {
+			break
+		}
+
+		var prefix string
+		switch x.Op {
+		case "LIKE":
+			var whole bool
+			if prefix, whole = expr.LikePrefix(pattern); whole {
+				return []Filter{{Column: ref.Name, Operator: "=", Value: prefix}}, true
+			}
+		case "~":
+			prefix = regexpPrefix(pattern)
+		}
+		return prefixFilters(ref.Name, prefix), false
+	}
+	return nil, false
+}
+
+// prefixFilters returns the range of strings that start with prefix.
+func prefixFilters(column, prefix string) []Filter {
+	if prefix == "" {
+		return nil
+	}
+	filters := []Filter{{Column: column, Operator: ">=", Value: prefix}}
+
+	// The first string past the range increments the last byte that can be
+	// incremented and drops the rest
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return append(filters, Filter{Column: column, Operator: "<", Value: string(b[:i+1])})
+		}
+	}
+	return filters
+}
+
+// regexpPrefix returns the literal text every match of an anchored regular
+// expression starts with, or "" if there is none.
+func regexpPrefix(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	lit := re.Sub[1]
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return ""
+	}
+	return string(lit.Rune)
+}
+
+// known returns the value of e if it can be computed before reading any
+// row: the constant of a literal, or e itself when it refers to no column
+// and gives the same result each time, unlike nextval or a subquery
+// correlated with the rows being read.
+func known(e expr.Expr) (any, bool) {
+	if lit, ok := e.(*expr.Literal); ok {
+		return lit.Value, true
+	}
+
+	stable := true
+	expr.Walk(e, func(n expr.Expr) {
+		switch n := n.(type) {
+		case *expr.ColumnRef:
+			stable = false
+		case *expr.SequenceCall:
+			if n.Func == "NEXTVAL" {
+				stable = false
+			}
+		case *expr.Subquery:
+			if n.Correlated {
+				stable = false
+			}
+		}
+	})
+	if !stable {
+		return nil, false
+	}
+	return e, true
+}
@@ -0,0 +1,129 @@
+package planner
+
+import (
+	"math"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+// --------------------------
+// Join methods
+// --------------------------
+
+// JoinMethod is the way a plan joins two inputs.
+type JoinMethod string
+
+const (
+	NestedLoop JoinMethod = "NESTED LOOP"
+	HashJoin   JoinMethod = "HASH JOIN"
+)
+
+// JoinInput is one side of a join: the tables whose rows it produces, by
+// the name their columns are qualified with, and its estimates. A derived
+// table, FROM (SELECT ...) alias, is there with a nil table.
+type JoinInput struct {
+	Tables map[string]*storage.Table
+	Rows   float64
+	Cost   float64
+}
+
+// JoinPath is how a SELECT joins the rows of the tables before a join
+// with those of the joined table.
+//
+//   - NestedLoop: every pair of rows is checked against Residual.
+//   - HashJoin: the right rows are put in a hash table by RightKeys, and
+//     each left row is paired with those whose keys equal its LeftKeys,
+//     then checked against Residual. Keys holding NULL match nothing.
+type JoinPath struct {
+	Method    JoinMethod
+	LeftKeys  []expr.Expr // HashJoin
+	RightKeys []expr.Expr
+	Residual  []expr.Expr
+
+	Rows float64 // estimated rows joined, outer rows included
+	Cost float64 // estimated work, including the inputs
+}
+
+// ChooseJoin picks how to join left and right on the conjuncts of a join
+// condition, whose column references are all qualified. Each conjunct
+// "l = r" with l over the left tables only and r over the right ones only
+// is an equi-join key; with any of them the join is a hash join, which
+// reads each input once instead of comparing every pair of rows.
+func ChooseJoin(typ parser.JoinType, left, right JoinInput, cond []expr.Expr) *JoinPath {
+	p := &JoinPath{Method: NestedLoop}
+	for _, term := range cond {
+		b, ok := term.(*expr.Binary)
+		if ok && b.Op == "=" {
+			switch {
+			case within(b.L, left.Tables) && within(b.R, right.Tables):
+				p.LeftKeys = append(p.LeftKeys, b.L)
+				p.RightKeys = append(p.RightKeys, b.R)
+				continue
+			case within(b.R, left.Tables) && within(b.L, right.Tables):
+				p.LeftKeys = append(p.LeftKeys, b.R)
+				p.RightKeys = append(p.RightKeys, b.L)
+				continue
+			}
+		}
+		p.Residual = append(p.Residual, term)
+	}
+
+	// Each key keeps the pairs whose values are equal; the side with more
+	// distinct values decides how many do
+	sel := math.Pow(defaultSelectivity, float64(len(p.Residual)))
+	for i := range p.LeftKeys {
+		d := math.Max(distinct(p.LeftKeys[i], left.Tables), distinct(p.RightKeys[i], right.Tables))
+		sel /= d
+	}
+	p.Rows = left.Rows * right.Rows * sel
+
+	switch typ {
+	case parser.LeftJoin:
+		p.Rows = math.Max(p.Rows, left.Rows)
+	case parser.RightJoin:
+		p.Rows = math.Max(p.Rows, right.Rows)
+	case parser.FullJoin:
+		p.Rows = math.Max(p.Rows, math.Max(left.Rows, right.Rows))
+	}
+
+	if len(p.LeftKeys) > 0 {
+		p.Method = HashJoin
+		p.Cost = left.Cost + right.Cost + left.Rows + right.Rows
+	} else {
+		p.Cost = left.Cost + right.Cost + left.Rows*right.Rows
+	}
+	return p
+}
+
+// FilterRows estimates how many of rows pass the conjuncts cond, for a
+// condition the statistics of a single table cannot tell about.
+func FilterRows(rows float64, cond []expr.Expr) float64 {
+	return rows * math.Pow(defaultSelectivity, float64(len(cond)))
+}
+
+// within reports whether e refers to columns, all of them of tables.
+func within(e expr.Expr, tables map[string]*storage.Table) bool {
+	refs := 0
+	ok := true
+	expr.Walk(e, func(n expr.Expr) {
+		if ref, isRef := n.(*expr.ColumnRef); isRef {
+			refs++
+			_, found := tables[ref.Table]
+			ok = ok && found
+		}
+	})
+	return ok && refs > 0
+}
+
+// distinct returns the number of distinct values of a join key: those of
+// its column, or 1/defaultEqualSelectivity for any other expression.
+func distinct(e expr.Expr, tables map[string]*storage.Table) float64 {
+	if ref, ok := e.(*expr.ColumnRef); ok && tables[ref.Table] != nil {
+		if cs := tables[ref.Table].Stats().Columns[ref.Name]; cs != nil {
+			return math.Max(float64(cs.Distinct), 1)
+		}
+	}
+	return 1 / defaultEqualSelectivity
+}
@@ -0,0 +1,79 @@
+package planner
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
+)
+
+func TestChooseJoin(t *testing.T) {
+	orders := accessTable(t, 1000) // 100 customers
+	others := accessTable(t, 200)
+	left := JoinInput{Tables: map[string]*storage.Table{"o": orders}, Rows: 1000, Cost: 1000}
+	right := JoinInput{Tables: map[string]*storage.Table{"p": others}, Rows: 200, Cost: 200}
+
+	for _, tc := range []struct {
+		name     string
+		typ      parser.JoinType
+		cond     string
+		method   JoinMethod
+		keys     string // left = right, ...
+		residual string
+		rows     float64
+	}{
+		{"equi-join", parser.InnerJoin, "o.customer = p.id", HashJoin, "o.customer = p.id", "", 1000},
+		{"keys on the other sides", parser.InnerJoin, "p.id = o.customer", HashJoin, "o.customer = p.id", "", 1000},
+		{"key with a residual", parser.InnerJoin, "o.customer = p.id AND o.status != p.status", HashJoin, "o.customer = p.id", "o.status != p.status", 500},
+		{"two keys", parser.InnerJoin, "o.id = p.id AND o.status = p.status", HashJoin, "o.id = p.id, o.status = p.status", "", 50},
+		{"computed key", parser.InnerJoin, "o.customer + 1 = p.customer", HashJoin, "o.customer + 1 = p.customer", "", 1000},
+		{"inequality", parser.InnerJoin, "o.customer < p.id", NestedLoop, "", "o.customer < p.id", 100000},
+		{"one side only", parser.InnerJoin, "o.id = o.customer", NestedLoop, "", "o.id = o.customer", 100000},
+		{"cross join", parser.CrossJoin, "", NestedLoop, "", "", 200000},
+		{"left join keeps left rows", parser.LeftJoin, "o.id = p.id", HashJoin, "o.id = p.id", "", 1000},
+		{"right join keeps right rows", parser.RightJoin, "o.id = p.id AND o.id < 0", HashJoin, "o.id = p.id", "o.id < 0", 200},
+	} {
+		var cond []string
+		if tc.cond != "" {
+			cond = strings.Split(tc.cond, " AND ")
+		}
+		terms := make([]expr.Expr, len(cond))
+		for i, c := range cond {
+			e, err := parser.ParseExpr(c)
+			if err != nil {
+				t.Fatalf("%s: parse failed: %v", tc.name, err)
+			}
+			terms[i] = e
+		}
+
+		path := ChooseJoin(tc.typ, left, right, terms)
+		keys := make([]string, len(path.LeftKeys))
+		for i := range path.LeftKeys {
+			keys[i] = path.LeftKeys[i].String() + " = " + path.RightKeys[i].String()
+		}
+		residual := ""
+		if rest := Conjoin(path.Residual); rest != nil {
+			residual = rest.String()
+		}
+
+		if path.Method != tc.method || strings.Join(keys, ", ") != tc.keys || residual != tc.residual {
+			t.Errorf("%s: chose %s on %q with residual %q, want %s on %q with %q",
+				tc.name, path.Method, keys, residual, tc.method, tc.keys, tc.residual)
+		}
+		if math.Abs(path.Rows-tc.rows) > 0.5 {
+			t.Errorf("%s: estimated %.1f rows, want %.1f", tc.name, path.Rows, tc.rows)
+		}
+	}
+
+	// A hash join reads each input once
+	on, _ := parser.ParseExpr("o.customer = p.id")
+	if path := ChooseJoin(parser.InnerJoin, left, right, Conjuncts(on)); path.Cost != 2400 {
+		t.Errorf("hash join cost %.1f, want 2400", path.Cost)
+	}
+	if path := ChooseJoin(parser.CrossJoin, left, right, nil); path.Cost != 201200 {
+		t.Errorf("nested loop cost %.1f, want 201200", path.Cost)
+	}
+}
@@ -3,7 +3,9 @@ package planner
 import (
 	"fmt"
 
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
 	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+	"github.com/MartinMurithi/NovaDB.git/internal/storage"
 )
 
 // --------------------------
@@ -21,58 +23,217 @@ const (
 	DeletePlan PlanType = "DELETE"
 
 	// DDL
-	CreateTablePlan   PlanType = "CREATE_TABLE"
-	AddColumnPlan     PlanType = "ADD_COLUMN"
-	ShowTablesPlan    PlanType = "SHOW_TABLES"
-	DescribeTablePlan PlanType = "DESCRIBE_TABLE"
-)
+	CreateTablePlan    PlanType = "CREATE_TABLE"
+	CreateSequencePlan PlanType = "CREATE_SEQUENCE"
+	CreateIndexPlan    PlanType = "CREATE_INDEX"
+	DropIndexPlan      PlanType = "DROP_INDEX"
+	AddColumnPlan      PlanType = "ADD_COLUMN"
+	ShowTablesPlan     PlanType = "SHOW_TABLES"
+	DescribeTablePlan  PlanType = "DESCRIBE_TABLE"
+	CheckpointPlan     PlanType = "CHECKPOINT"
 
+	ExplainPlan PlanType = "EXPLAIN"
+)
 
-// Filter represents a WHERE clause condition
-type Filter struct {
-	Column   string
-	Operator string // <, >, <=, >=, =, !=
-	Value    any
-}
 
 // Plan represents an executable plan for a query
 type Plan struct {
 	Type      PlanType
 	TableName string
 
-	// SELECT
+	// SELECT; Columns names the result columns of Select, the selected
+	// expressions, which is nil for SELECT *. The engine expands each
+	// *expr.Star of Select into the columns it stands for
 	Columns []string
-	Filters []Filter
+	Select  []expr.Expr
+
+	// SELECT DISTINCT / DISTINCT ON (exprs)
+	Distinct   bool
+	DistinctOn []expr.Expr
+
+	// SELECT ... FROM TableName [AS Alias] JOIN ...; the engine resolves
+	// column references against the joined tables. FROM (SELECT ...) Alias
+	// has the derived table's query in Derived and no TableName
+	Derived *parser.Query
+	Alias   string
+	Joins   []parser.Join
+
+	// WHERE of SELECT / UPDATE / DELETE; nil when there is none
+	Where expr.Expr
+
+	// SELECT ... GROUP BY ... HAVING ...; Grouped is set when the query
+	// aggregates, which it also does when the select list or ORDER BY has
+	// an aggregate but there is no GROUP BY
+	GroupBy []expr.Expr
+	Having  expr.Expr
+	Grouped bool
+
+	// Windowed is set when the select list, DISTINCT ON or ORDER BY has
+	// a window function, computed over the rows left after HAVING
+	Windowed bool
+
+	// SELECT ... ORDER BY ... LIMIT n OFFSET m; Limit is nil without LIMIT
+	OrderBy []SortKey
+	Limit   *int64
+	Offset  int64
+
+	// SELECT ... UNION [ALL] SELECT ...; a compound SELECT combines the
+	// rows of the plans Left and Right, then sorts and limits them. The
+	// engine names its result columns after those of Left
+	SetOp       parser.SetOp
+	All         bool
+	Left, Right *Plan
+
+	// SELECT / UPDATE / DELETE of a single table; the access path CreatePlan
+	// chose for reading it. When it is nil the engine chooses one
+	Access *AccessPath
 
 	// INSERT / UPDATE
 	Values map[string]any
 
+	// INSERT INTO TableName (Columns) SELECT ...; the engine plans Source
+	Source *parser.Query
+
+	// WITH of SELECT / INSERT / UPDATE / DELETE; the engine plans each
+	// query, which the statement then reads like a table
+	With []parser.CTE
+
 	// DDL
-	ColumnsToAdd []string // For ADD COLUMN
-	ColumnTypes  []string // Types for ADD COLUMN
+	ColumnsToAdd []string              // For ADD COLUMN
+	ColumnTypes  []string              // Types for ADD COLUMN
+	Schema       []*storage.Column     // Column definitions for CREATE TABLE / ADD COLUMN
+	Constraints  []*storage.Constraint // Table-level constraints for CREATE TABLE
+	ForeignKeys  []*storage.ForeignKey // Foreign keys declared by CREATE TABLE
+	Sequences    []*storage.Sequence   // CREATE SEQUENCE, or the sequences of identity columns
+
+	// CREATE INDEX / DROP INDEX; the indexed columns are in Columns
+	IndexName string
+	Unique    bool
+
+	// EXPLAIN [ANALYZE]; Statement is the plan being explained
+	Analyze   bool
+	Statement *Plan
+}
+
+// SortKey is one key of ORDER BY. NullsFirst is resolved by the parser,
+// so it holds the default for the direction when NULLS is not given.
+type SortKey struct {
+	Expr       expr.Expr
+	Desc       bool
+	NullsFirst bool
+}
+
+// String renders the key as SQL, leaving out NULLS when it is the default.
+func (k SortKey) String() string {
+	s := k.Expr.String()
+	if k.Desc {
+		s += " DESC"
+	}
+	if k.NullsFirst != k.Desc {
+		if k.NullsFirst {
+			s += " NULLS FIRST"
+		} else {
+			s += " NULLS LAST"
+		}
+	}
+	return s
 }
 
+// QueryPlanColumn is the column holding the lines of EXPLAIN output.
+const QueryPlanColumn = "QUERY PLAN"
+
 // --------------------------
 // CreatePlan
 // --------------------------
 
-func CreatePlan(q *parser.Query) (*Plan, error) {
+// CreatePlan turns a parsed query into a plan. The access paths of
+// statements reading a single table are chosen from the tables of db,
+// which may be nil.
+func CreatePlan(q *parser.Query, db *storage.Database) (*Plan, error) {
 	switch q.Type {
 
 	// --------------------------
 	case parser.CreateTableQuery:
+		schema, constraints, foreignKeys, err := planSchema(q)
+		if err != nil {
+			return nil, err
+		}
+
+		sequences := []*storage.Sequence{}
+		for _, def := range q.ColumnDefs {
+			if def.Identity == "" {
+				continue
+			}
+			options := def.IdentityOptions
+			if options == nil {
+				options = &parser.SequenceDef{Increment: 1}
+			}
+			seq, err := newSequence(storage.IdentitySequence(q.Table, def.Name), options)
+			if err != nil {
+				return nil, err
+			}
+			sequences = append(sequences, seq)
+		}
+
 		return &Plan{
-			Type:      CreateTablePlan,
+			Type:        CreateTablePlan,
+			TableName:   q.Table,
+			Schema:      schema,
+			Constraints: constraints,
+			ForeignKeys: foreignKeys,
+			Sequences:   sequences,
+		}, nil
+
+	// --------------------------
+	case parser.CreateSequenceQuery:
+		seq, err := newSequence(q.Sequence.Name, q.Sequence)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Plan{
+			Type:      CreateSequencePlan,
+			Sequences: []*storage.Sequence{seq},
+		}, nil
+
+	// --------------------------
+	case parser.CreateIndexQuery:
+		return &Plan{
+			Type:      CreateIndexPlan,
 			TableName: q.Table,
+			IndexName: q.Index,
+			Unique:    q.Unique,
+			Columns:   q.Columns,
+		}, nil
+
+	// --------------------------
+	case parser.DropIndexQuery:
+		return &Plan{
+			Type:      DropIndexPlan,
+			IndexName: q.Index,
 		}, nil
 
 	// --------------------------
 	case parser.AddColumnQuery:
+		schema, _, foreignKeys, err := planSchema(q)
+		if err != nil {
+			return nil, err
+		}
+		if len(foreignKeys) > 0 {
+			return nil, fmt.Errorf("foreign keys can only be declared in CREATE TABLE")
+		}
+		for _, col := range schema {
+			if col.Identity != "" {
+				return nil, fmt.Errorf("identity columns can only be declared in CREATE TABLE")
+			}
+		}
+
 		return &Plan{
 			Type:         AddColumnPlan,
 			TableName:    q.Table,
 			ColumnsToAdd: q.Columns,
 			ColumnTypes:  q.ColumnTypes,
+			Schema:       schema,
 		}, nil
 
 	// --------------------------
@@ -88,6 +249,27 @@ func CreatePlan(q *parser.Query) (*Plan, error) {
 			TableName: q.Table,
 		}, nil
 
+	// --------------------------
+	case parser.CheckpointQuery:
+		return &Plan{
+			Type: CheckpointPlan,
+		}, nil
+
+	// --------------------------
+	case parser.ExplainQuery:
+		stmt, err := CreatePlan(q.Statement, db)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Plan{
+			Type:      ExplainPlan,
+			TableName: stmt.TableName,
+			Columns:   []string{QueryPlanColumn},
+			Analyze:   q.Analyze,
+			Statement: stmt,
+		}, nil
+
 	// --------------------------
 	case parser.SelectQuery:
 		cols := q.Columns
@@ -95,24 +277,76 @@ func CreatePlan(q *parser.Query) (*Plan, error) {
 			cols = []string{"*"}
 		}
 
-		filters := make([]Filter, len(q.Filters))
-		for i, f := range q.Filters {
-			filters[i] = Filter{
-				Column:   f.Column,
-				Operator: f.Operator,
-				Value:    f.Value,
+		orderBy := make([]SortKey, len(q.OrderBy))
+		for i, item := range q.OrderBy {
+			orderBy[i] = SortKey{Expr: item.Expr, Desc: item.Desc, NullsFirst: item.NullsFirst}
+		}
+
+		if q.SetOp != "" {
+			return planSetOp(q, orderBy, db)
+		}
+
+		// ORDER BY and DISTINCT ON may name a result column
+		var distinctOn []expr.Expr
+		for _, e := range q.DistinctOn {
+			out, err := outputColumn(q, e)
+			if err != nil {
+				return nil, err
 			}
+			distinctOn = append(distinctOn, out)
+		}
+		for i, k := range orderBy {
+			out, err := outputColumn(q, k.Expr)
+			if err != nil {
+				return nil, err
+			}
+			orderBy[i].Expr = out
 		}
 
-		return &Plan{
-			Type:      SelectPlan,
-			TableName: q.Table,
-			Columns:   cols,
-			Filters:   filters,
-		}, nil
+		grouped, err := checkGrouping(q, orderBy, distinctOn)
+		if err != nil {
+			return nil, err
+		}
+		windowed, err := checkWindows(q, orderBy, distinctOn)
+		if err != nil {
+			return nil, err
+		}
+
+		plan := &Plan{
+			Type:       SelectPlan,
+			TableName:  q.Table,
+			Columns:    cols,
+			Select:     q.Select,
+			Distinct:   q.Distinct,
+			DistinctOn: distinctOn,
+			Derived:    q.Derived,
+			Alias:      q.Alias,
+			Joins:      q.Joins,
+			Where:      q.Where,
+			GroupBy:    q.GroupBy,
+			Having:     q.Having,
+			Grouped:    grouped,
+			Windowed:   windowed,
+			OrderBy:    orderBy,
+			Limit:      q.Limit,
+			Offset:     q.Offset,
+			With:       q.With,
+		}
+		planAccess(plan, db)
+		return plan, nil
 
 	// --------------------------
 	case parser.InsertQuery:
+		if q.Source != nil {
+			return &Plan{
+				Type:      InsertPlan,
+				TableName: q.Table,
+				Columns:   q.Columns,
+				Source:    q.Source,
+				With:      q.With,
+			}, nil
+		}
+
 		values := make(map[string]any)
 		for _, a := range q.Assignments {
 			values[a.Column] = a.Value
@@ -122,6 +356,7 @@ func CreatePlan(q *parser.Query) (*Plan, error) {
 			Type:      InsertPlan,
 			TableName: q.Table,
 			Values:    values,
+			With:      q.With,
 		}, nil
 
 	// --------------------------
@@ -131,38 +366,26 @@ func CreatePlan(q *parser.Query) (*Plan, error) {
 			values[a.Column] = a.Value
 		}
 
-		filters := make([]Filter, len(q.Filters))
-		for i, f := range q.Filters {
-			filters[i] = Filter{
-				Column:   f.Column,
-				Operator: f.Operator,
-				Value:    f.Value,
-			}
-		}
-
-		return &Plan{
+		plan := &Plan{
 			Type:      UpdatePlan,
 			TableName: q.Table,
 			Values:    values,
-			Filters:   filters,
-		}, nil
+			Where:     q.Where,
+			With:      q.With,
+		}
+		planAccess(plan, db)
+		return plan, nil
 
 	// --------------------------
 	case parser.DeleteQuery:
-		filters := make([]Filter, len(q.Filters))
-		for i, f := range q.Filters {
-			filters[i] = Filter{
-				Column:   f.Column,
-				Operator: f.Operator,
-				Value:    f.Value,
-			}
-		}
-
-		return &Plan{
+		plan := &Plan{
 			Type:      DeletePlan,
 			TableName: q.Table,
-			Filters:   filters,
-		}, nil
+			Where:     q.Where,
+			With:      q.With,
+		}
+		planAccess(plan, db)
+		return plan, nil
 
 	// --------------------------
 	default:
@@ -170,3 +393,255 @@ func CreatePlan(q *parser.Query) (*Plan, error) {
 	}
 }
 
+// planSetOp plans a compound SELECT. Its ORDER BY can only read the
+// combined rows, which have no aggregates or window functions to sort by.
+func planSetOp(q *parser.Query, orderBy []SortKey, db *storage.Database) (*Plan, error) {
+	for _, k := range orderBy {
+		if expr.HasAggregate(k.Expr) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in ORDER BY of %s", q.SetOp)
+		}
+		if expr.HasWindow(k.Expr) {
+			return nil, fmt.Errorf("window functions are not allowed in ORDER BY of %s", q.SetOp)
+		}
+	}
+	left, err := CreatePlan(q.Left, db)
+	if err != nil {
+		return nil, err
+	}
+	right, err := CreatePlan(q.Right, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plan{
+		Type:    SelectPlan,
+		Columns: left.Columns,
+		SetOp:   q.SetOp,
+		All:     q.All,
+		Left:    left,
+		Right:   right,
+		OrderBy: orderBy,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+		With:    q.With,
+	}, nil
+}
+
+// checkGrouping reports whether a SELECT aggregates its rows and checks
+// that aggregates only appear where they can be computed. Whether the
+// selected columns are grouped is checked against the table by the engine.
+// orderBy and distinctOn are those of the query, with result columns
+// replaced by what they select.
+func checkGrouping(q *parser.Query, orderBy []SortKey, distinctOn []expr.Expr) (bool, error) {
+	if q.Where != nil && expr.HasAggregate(q.Where) {
+		return false, fmt.Errorf("aggregate functions are not allowed in WHERE")
+	}
+	for _, e := range q.GroupBy {
+		if expr.HasAggregate(e) {
+			return false, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+	}
+
+	grouped := len(q.GroupBy) > 0 || q.Having != nil
+	for _, item := range q.Select {
+		grouped = grouped || expr.HasAggregate(item)
+	}
+	for _, k := range orderBy {
+		grouped = grouped || expr.HasAggregate(k.Expr)
+	}
+	for _, e := range distinctOn {
+		grouped = grouped || expr.HasAggregate(e)
+	}
+
+	if grouped {
+		if q.Select == nil {
+			return false, fmt.Errorf("SELECT * cannot be used with GROUP BY or aggregates")
+		}
+		for _, item := range q.Select {
+			if star, ok := item.(*expr.Star); ok {
+				return false, fmt.Errorf("SELECT %s cannot be used with GROUP BY or aggregates", star)
+			}
+		}
+	}
+	return grouped, nil
+}
+
+// checkWindows reports whether a SELECT has window functions and checks
+// that they only appear where they can be computed: after WHERE, GROUP BY
+// and HAVING have had their say about the rows.
+func checkWindows(q *parser.Query, orderBy []SortKey, distinctOn []expr.Expr) (bool, error) {
+	if q.Where != nil && expr.HasWindow(q.Where) {
+		return false, fmt.Errorf("window functions are not allowed in WHERE")
+	}
+	for _, e := range q.GroupBy {
+		if expr.HasWindow(e) {
+			return false, fmt.Errorf("window functions are not allowed in GROUP BY")
+		}
+	}
+	if q.Having != nil && expr.HasWindow(q.Having) {
+		return false, fmt.Errorf("window functions are not allowed in HAVING")
+	}
+	for _, j := range q.Joins {
+		if j.On != nil && expr.HasWindow(j.On) {
+			return false, fmt.Errorf("window functions are not allowed in JOIN conditions")
+		}
+	}
+
+	windowed := false
+	for _, item := range q.Select {
+		windowed = windowed || expr.HasWindow(item)
+	}
+	for _, k := range orderBy {
+		windowed = windowed || expr.HasWindow(k.Expr)
+	}
+	for _, e := range distinctOn {
+		windowed = windowed || expr.HasWindow(e)
+	}
+	return windowed, nil
+}
+
+// outputColumn returns what the result column e names is selected as,
+// when e is the bare name of a result column, such as an alias; otherwise
+// e itself. A name given to several different result columns is
+// ambiguous.
+func outputColumn(q *parser.Query, e expr.Expr) (expr.Expr, error) {
+	ref, ok := e.(*expr.ColumnRef)
+	if !ok || ref.Table != "" {
+		return e, nil
+	}
+	var out expr.Expr
+	for i, item := range q.Select {
+		if q.Columns[i] != ref.Name {
+			continue
+		}
+		if out != nil && out.String() != item.String() {
+			return nil, fmt.Errorf("column reference '%s' is ambiguous", ref.Name)
+		}
+		out = item
+	}
+	if out == nil {
+		return e, nil
+	}
+	return out, nil
+}
+
+// planSchema turns the column definitions and table constraints of a DDL
+// query into storage columns, constraints and foreign keys, rejecting
+// duplicate columns and more than one primary key. Foreign keys are checked
+// against the referenced table when the engine creates them.
+func planSchema(q *parser.Query) ([]*storage.Column, []*storage.Constraint, []*storage.ForeignKey, error) {
+	schema := []*storage.Column{}
+	foreignKeys := []*storage.ForeignKey{}
+	seen := make(map[string]bool)
+	primaryKeys := 0
+
+	for _, def := range q.ColumnDefs {
+		if seen[def.Name] {
+			return nil, nil, nil, fmt.Errorf("column %s is defined more than once", def.Name)
+		}
+		seen[def.Name] = true
+
+		if def.PrimaryKey {
+			primaryKeys++
+		}
+
+		col := &storage.Column{
+			Name:         def.Name,
+			ColumnType:   def.Type,
+			IsPrimaryKey: def.PrimaryKey,
+			IsUnique:     def.Unique,
+			NotNull:      def.NotNull || def.PrimaryKey || def.Identity != "",
+			Identity:     def.Identity,
+		}
+
+		// Store interface values only when set, so a missing DEFAULT or
+		// CHECK stays a nil storage.Expr
+		if def.Default != nil {
+			if cols := expr.Columns(def.Default); len(cols) > 0 {
+				return nil, nil, nil, fmt.Errorf("DEFAULT for column %s cannot refer to column %s", def.Name, cols[0])
+			}
+			col.Default = def.Default
+			if _, err := col.DefaultValue(); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if def.Check != nil {
+			col.Check = def.Check
+		}
+		if def.References != nil {
+			foreignKeys = append(foreignKeys, foreignKey("", []string{def.Name}, q.Table, def.References))
+		}
+
+		schema = append(schema, col)
+	}
+
+	// CHECK conditions may refer to any column of the table
+	for _, def := range q.ColumnDefs {
+		if def.Check == nil {
+			continue
+		}
+		for _, ref := range expr.Columns(def.Check) {
+			if !seen[ref] {
+				return nil, nil, nil, fmt.Errorf("CHECK on column %s refers to unknown column %s", def.Name, ref)
+			}
+		}
+	}
+
+	constraints := []*storage.Constraint{}
+	for _, c := range q.Constraints {
+		for _, col := range c.Columns {
+			if !seen[col] {
+				return nil, nil, nil, fmt.Errorf("%s refers to unknown column %s", c.Type, col)
+			}
+		}
+
+		if c.Type == storage.PrimaryKeyConstraint {
+			primaryKeys++
+		}
+		if c.Type == storage.ForeignKeyConstraint {
+			foreignKeys = append(foreignKeys, foreignKey(c.Name, c.Columns, q.Table, c.References))
+			continue
+		}
+
+		constraint := &storage.Constraint{
+			Name:    c.Name,
+			Type:    c.Type,
+			Columns: c.Columns,
+		}
+		if c.Check != nil {
+			constraint.Check = c.Check
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	if primaryKeys > 1 {
+		return nil, nil, nil, fmt.Errorf("multiple primary keys for table %s are not allowed", q.Table)
+	}
+
+	return schema, constraints, foreignKeys, nil
+}
+
+// newSequence builds a storage sequence from its declared options. A
+// sequence without START begins at 1, or at -1 when it counts down.
+func newSequence(name string, def *parser.SequenceDef) (*storage.Sequence, error) {
+	start := int64(1)
+	if def.Increment < 0 {
+		start = -1
+	}
+	if def.Start != nil {
+		start = *def.Start
+	}
+	return storage.NewSequence(name, start, def.Increment)
+}
+
+func foreignKey(name string, columns []string, table string, ref *parser.References) *storage.ForeignKey {
+	return &storage.ForeignKey{
+		Name:       name,
+		Table:      table,
+		Columns:    columns,
+		RefTable:   ref.Table,
+		RefColumns: ref.Columns,
+		OnDelete:   ref.OnDelete,
+		OnUpdate:   ref.OnUpdate,
+	}
+}
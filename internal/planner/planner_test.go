@@ -3,6 +3,7 @@ package planner
 import (
 	"testing"
 
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
 	"github.com/MartinMurithi/NovaDB.git/internal/parser"
 )
 
@@ -11,12 +12,10 @@ func TestCreatePlan(t *testing.T) {
 		Type:    parser.SelectQuery,
 		Table:   "users",
 		Columns: []string{"id", "name"},
-		Filters: []parser.Filter{
-			{Column: "id", Operator: "=", Value: 1},
-		},
+		Where:   &expr.Binary{Op: "=", L: &expr.ColumnRef{Name: "id"}, R: &expr.Literal{Value: int64(1)}},
 	}
 
-	plan, err := CreatePlan(q)
+	plan, err := CreatePlan(q, nil)
 	if err != nil {
 		t.Fatalf("planner failed: %v", err)
 	}
@@ -29,7 +28,160 @@ func TestCreatePlan(t *testing.T) {
 		t.Fatalf("unexpected columns: %v", plan.Columns)
 	}
 
-	if len(plan.Filters) != 1 || plan.Filters[0].Column != "id" {
-		t.Fatalf("unexpected filters: %v", plan.Filters)
+	if plan.Where == nil || plan.Where.String() != "id = 1" {
+		t.Fatalf("unexpected WHERE: %v", plan.Where)
 	}
-}
\ No newline at end of file
+}
+
+func TestCreateTablePlan(t *testing.T) {
+	q, err := parser.Parse("CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE NOT NULL, a INT, b INT, UNIQUE (a, b))")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	plan, err := CreatePlan(q, nil)
+	if err != nil {
+		t.Fatalf("planner failed: %v", err)
+	}
+
+	if len(plan.Schema) != 4 || !plan.Schema[0].IsPrimaryKey || !plan.Schema[0].NotNull {
+		t.Fatalf("unexpected schema: %+v", plan.Schema)
+	}
+
+	if !plan.Schema[1].IsUnique || !plan.Schema[1].NotNull {
+		t.Fatalf("unexpected email column: %+v", plan.Schema[1])
+	}
+
+	if len(plan.Constraints) != 1 || len(plan.Constraints[0].Columns) != 2 {
+		t.Fatalf("unexpected constraints: %+v", plan.Constraints)
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE t (a INT PRIMARY KEY, b INT PRIMARY KEY)",
+		"CREATE TABLE t (a INT PRIMARY KEY, b INT, PRIMARY KEY (b))",
+		"CREATE TABLE t (a INT, a TEXT)",
+		"CREATE TABLE t (a INT, UNIQUE (b))",
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		if _, err := CreatePlan(q, nil); err == nil {
+			t.Fatalf("expected planner error for %q, got nil", sql)
+		}
+	}
+}
+
+func TestCreateAggregatePlan(t *testing.T) {
+	for sql, grouped := range map[string]bool{
+		"SELECT a, b FROM t":                                      false,
+		"SELECT COUNT(*) FROM t":                                  true,
+		"SELECT a, SUM(b) FROM t GROUP BY a":                      true,
+		"SELECT a FROM t GROUP BY a":                              true,
+		"SELECT a FROM t GROUP BY a HAVING a > 1":                 true,
+		"SELECT a FROM t ORDER BY MAX(b)":                         true,
+		"SELECT a / 10, COUNT(DISTINCT b) FROM t GROUP BY a / 10": true,
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		plan, err := CreatePlan(q, nil)
+		if err != nil {
+			t.Errorf("CreatePlan(%q) failed: %v", sql, err)
+			continue
+		}
+		if plan.Grouped != grouped {
+			t.Errorf("CreatePlan(%q) grouped = %v, want %v", sql, plan.Grouped, grouped)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE COUNT(*) > 1",
+		"SELECT COUNT(*) FROM t GROUP BY SUM(a)",
+		"SELECT * FROM t GROUP BY a",
+		"SELECT t.*, COUNT(*) FROM t",
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		if _, err := CreatePlan(q, nil); err == nil {
+			t.Errorf("CreatePlan(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestCreateWindowPlan(t *testing.T) {
+	for sql, want := range map[string][2]bool{ // grouped, windowed
+		"SELECT a, ROW_NUMBER() OVER (ORDER BY b) FROM t":           {false, true},
+		"SELECT a, SUM(b) OVER (PARTITION BY a) FROM t":             {false, true},
+		"SELECT a FROM t ORDER BY RANK() OVER (ORDER BY a)":         {false, true},
+		"SELECT a, RANK() OVER (ORDER BY a) AS r FROM t ORDER BY r": {false, true},
+		"SELECT a, SUM(SUM(b)) OVER () FROM t GROUP BY a":           {true, true},
+		"SELECT a, COUNT(*) FROM t GROUP BY a":                      {true, false},
+		"SELECT * FROM (SELECT a, RANK() OVER () AS r FROM t) d":    {false, false},
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		plan, err := CreatePlan(q, nil)
+		if err != nil {
+			t.Errorf("CreatePlan(%q) failed: %v", sql, err)
+			continue
+		}
+		if plan.Grouped != want[0] || plan.Windowed != want[1] {
+			t.Errorf("CreatePlan(%q) grouped, windowed = %v, %v, want %v", sql, plan.Grouped, plan.Windowed, want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT a FROM t WHERE ROW_NUMBER() OVER () > 1",
+		"SELECT a FROM t GROUP BY RANK() OVER (ORDER BY a)",
+		"SELECT a FROM t GROUP BY a HAVING COUNT(*) OVER () > 1",
+		"SELECT t.a FROM t JOIN u ON RANK() OVER () = u.a",
+		"SELECT a FROM t UNION SELECT a FROM u ORDER BY ROW_NUMBER() OVER ()",
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		if _, err := CreatePlan(q, nil); err == nil {
+			t.Errorf("CreatePlan(%q) succeeded, want error", sql)
+		}
+	}
+}
+
+func TestCreateSetOpPlan(t *testing.T) {
+	q, err := parser.Parse("SELECT a FROM t UNION ALL SELECT COUNT(*) FROM u INTERSECT SELECT b FROM v ORDER BY a LIMIT 3")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	plan, err := CreatePlan(q, nil)
+	if err != nil {
+		t.Fatalf("planner failed: %v", err)
+	}
+	if plan.Type != SelectPlan || plan.SetOp != parser.Union || !plan.All || plan.TableName != "" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if plan.Left.TableName != "t" || plan.Right.SetOp != parser.Intersect || !plan.Right.Left.Grouped {
+		t.Errorf("unexpected operands %+v, %+v", plan.Left, plan.Right)
+	}
+	if len(plan.Columns) != 1 || plan.Columns[0] != "a" || len(plan.OrderBy) != 1 || *plan.Limit != 3 {
+		t.Errorf("unexpected columns %v, ORDER BY %v or LIMIT", plan.Columns, plan.OrderBy)
+	}
+
+	for _, sql := range []string{
+		"SELECT a FROM t UNION SELECT a FROM u WHERE SUM(a) > 1",
+		"SELECT a FROM t EXCEPT SELECT a FROM u ORDER BY MAX(a)",
+	} {
+		q, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		if _, err := CreatePlan(q, nil); err == nil {
+			t.Errorf("CreatePlan(%q) succeeded, want error", sql)
+		}
+	}
+}
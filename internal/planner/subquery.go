@@ -0,0 +1,77 @@
+package planner
+
+import (
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+)
+
+// --------------------------
+// Decorrelation
+// --------------------------
+
+// Decorrelate splits the WHERE of a correlated subquery so that it can run
+// once instead of once per row of the enclosing query. Each conjunct
+// "inner = outer", with inner over the subquery's own columns and outer
+// over the columns of the enclosing query only (expr.OuterRef), is a key:
+// the rows the subquery gives for an outer row are those of rest whose
+// inner keys equal the outer keys of that row.
+//
+// ok is false when there is no key or a conjunct of rest still refers to
+// the enclosing query.
+func Decorrelate(where expr.Expr) (inner, outer, rest []expr.Expr, ok bool) {
+	for _, term := range Conjuncts(where) {
+		if b, isEq := term.(*expr.Binary); isEq && b.Op == "=" {
+			switch {
+			case refersTo(b.L, false) && refersTo(b.R, true):
+				inner, outer = append(inner, b.L), append(outer, b.R)
+				continue
+			case refersTo(b.R, false) && refersTo(b.L, true):
+				inner, outer = append(inner, b.R), append(outer, b.L)
+				continue
+			}
+		}
+		if Correlated(term) {
+			return nil, nil, nil, false
+		}
+		rest = append(rest, term)
+	}
+	return inner, outer, rest, len(inner) > 0
+}
+
+// refersTo reports whether e refers to columns, all of them of the
+// enclosing query when outer is set and all of the subquery's own
+// otherwise.
+func refersTo(e expr.Expr, outer bool) bool {
+	own, enclosing := 0, 0
+	expr.Walk(e, func(n expr.Expr) {
+		switch n := n.(type) {
+		case *expr.ColumnRef:
+			own++
+		case *expr.OuterRef:
+			enclosing++
+		case *expr.Subquery:
+			if n.Correlated {
+				own++ // neither side only
+				enclosing++
+			}
+		}
+	})
+	if outer {
+		return enclosing > 0 && own == 0
+	}
+	return own > 0 && enclosing == 0
+}
+
+// Correlated reports whether e refers to the enclosing query, itself or
+// through a correlated subquery.
+func Correlated(e expr.Expr) bool {
+	found := false
+	expr.Walk(e, func(n expr.Expr) {
+		switch n := n.(type) {
+		case *expr.OuterRef:
+			found = true
+		case *expr.Subquery:
+			found = found || n.Correlated
+		}
+	})
+	return found
+}
@@ -0,0 +1,63 @@
+package planner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MartinMurithi/NovaDB.git/internal/expr"
+	"github.com/MartinMurithi/NovaDB.git/internal/parser"
+)
+
+func TestDecorrelate(t *testing.T) {
+	outer := &expr.OuterRow{}
+	// Columns named o.* are those of the enclosing query
+	parse := func(sql string) expr.Expr {
+		e, err := parser.ParseExpr(sql)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", sql, err)
+		}
+		return expr.Replace(e, func(n expr.Expr) (expr.Expr, bool) {
+			if ref, ok := n.(*expr.ColumnRef); ok && ref.Table == "o" {
+				return &expr.OuterRef{Name: ref.String(), Row: outer}, true
+			}
+			return nil, false
+		})
+	}
+
+	for _, tc := range []struct {
+		where string
+		keys  string // inner = outer, ...
+		rest  string
+		ok    bool
+	}{
+		{"user_id = o.id", "user_id = o.id", "", true},
+		{"o.id = user_id AND total > 3", "user_id = o.id", "total > 3", true},
+		{"user_id = o.id AND kind = o.kind + 1", "user_id = o.id, kind = o.kind + 1", "", true},
+		{"user_id + 1 = o.id * 2 AND status = 'new'", "user_id + 1 = o.id * 2", "status = 'new'", true},
+		{"user_id = o.id AND total > o.total", "", "", false},
+		{"user_id > o.id", "", "", false},
+		{"user_id = id", "", "", false},
+		{"user_id = o.id + total", "", "", false},
+		{"total > 3", "", "", false},
+	} {
+		inner, outerKeys, rest, ok := Decorrelate(parse(tc.where))
+		if ok != tc.ok {
+			t.Errorf("%s: ok = %v, want %v", tc.where, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		keys := make([]string, len(inner))
+		for i := range inner {
+			keys[i] = inner[i].String() + " = " + outerKeys[i].String()
+		}
+		got := ""
+		if r := Conjoin(rest); r != nil {
+			got = r.String()
+		}
+		if strings.Join(keys, ", ") != tc.keys || got != tc.rest {
+			t.Errorf("%s: keys %q rest %q, want %q and %q", tc.where, keys, got, tc.keys, tc.rest)
+		}
+	}
+}
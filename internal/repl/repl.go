@@ -1,6 +1,7 @@
 package repl
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -19,7 +20,7 @@ var sqlKeywords = []string{
 	"SELECT", "FROM", "WHERE", "INSERT", "INTO", "VALUES",
 	"UPDATE", "SET", "DELETE", "AND", "OR",
 	"CREATE", "TABLE", "ALTER", "ADD", "COLUMN",
-	"SHOW", "DESCRIBE",
+	"SHOW", "DESCRIBE", "EXPLAIN", "ANALYZE",
 }
 
 func highlightSQL(sql string) string {
@@ -53,7 +54,7 @@ func PrintRows(rows []*storage.Row, columns []string, table *storage.Table) {
 	}
 	for _, row := range rows {
 		for _, col := range columns {
-			valLen := len(fmt.Sprintf("%v", row.Data[col]))
+			valLen := len(storage.FormatValue(row.Data[col]))
 			if valLen > widths[col] {
 				widths[col] = valLen
 			}
@@ -73,13 +74,39 @@ func PrintRows(rows []*storage.Row, columns []string, table *storage.Table) {
 	// Print rows
 	for _, row := range rows {
 		for _, col := range columns {
-			val := row.Data[col]
-			fmt.Printf("%-*v ", widths[col], val)
+			val := storage.FormatValue(row.Data[col])
+			fmt.Printf("%-*s ", widths[col], val)
 		}
 		fmt.Println()
 	}
 }
 
+// --------------------------
+// FormatSyntaxError: show the offending line with a caret
+// --------------------------
+func FormatSyntaxError(sql string, err *parser.SyntaxError) string {
+	lines := strings.Split(sql, "\n")
+	if err.Line < 1 || err.Line > len(lines) {
+		return err.Error()
+	}
+	line := lines[err.Line-1]
+
+	// Keep tabs so the caret lines up with the source as the terminal shows it
+	var pad strings.Builder
+	for i, r := range []rune(line) {
+		if i >= err.Column-1 {
+			break
+		}
+		if r == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+
+	return fmt.Sprintf("%s\n%s\n%s^", err.Error(), line, pad.String())
+}
+
 // --------------------------
 // Main REPL
 // --------------------------
@@ -109,7 +136,10 @@ func Run(db *storage.Database, eng *engine.Engine) {
 		}
 
 		// Collect multi-line SQL until ';'
-		buffer.WriteString(" " + line)
+		if buffer.Len() > 0 {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(line)
 		if !strings.HasSuffix(line, ";") {
 			continue
 		}
@@ -130,14 +160,19 @@ func Run(db *storage.Database, eng *engine.Engine) {
 		// --------------------------
 		query, err := parser.Parse(sql)
 		if err != nil {
-			fmt.Printf("Parse error: %v\n", err)
+			var syntaxErr *parser.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				fmt.Printf("Parse error: %s\n", FormatSyntaxError(sql, syntaxErr))
+			} else {
+				fmt.Printf("Parse error: %v\n", err)
+			}
 			continue
 		}
 
 		// --------------------------
 		// Plan
 		// --------------------------
-		plan, err := planner.CreatePlan(query)
+		plan, err := eng.Plan(query)
 		if err != nil {
 			fmt.Printf("Planner error: %v\n", err)
 			continue
@@ -157,12 +192,12 @@ func Run(db *storage.Database, eng *engine.Engine) {
 		// --------------------------
 		switch plan.Type {
 		case planner.SelectPlan:
-			table, ok := db.Tables[plan.TableName]
-			if !ok {
-				fmt.Printf("Table %s not found\n", plan.TableName)
-				continue
-			}
-			PrintRows(rows, plan.Columns, table)
+			// A derived table in FROM, a compound SELECT or a SELECT without FROM
+			// has no table of its own
+			PrintRows(rows, plan.Columns, db.Tables[plan.TableName])
+
+		case planner.ExplainPlan:
+			PrintRows(rows, plan.Columns, nil)
 
 		case planner.ShowTablesPlan:
 			fmt.Println("Tables:")
@@ -170,6 +205,26 @@ func Run(db *storage.Database, eng *engine.Engine) {
 				fmt.Println(" -", r.Data["table_name"])
 			}
 
+		case planner.InsertPlan:
+			if len(rows) != 1 {
+				fmt.Printf("%d rows inserted\n", len(rows))
+				break
+			}
+
+			// Show the key of the new row, which may have been generated
+			table := db.Tables[plan.TableName]
+			keys := []string{}
+			for _, col := range table.Columns {
+				if col.IsPrimaryKey || col.Identity != "" {
+					keys = append(keys, fmt.Sprintf("%s = %s", col.Name, storage.FormatValue(rows[0].Data[col.Name])))
+				}
+			}
+			if len(keys) == 0 {
+				fmt.Println("1 row inserted")
+			} else {
+				fmt.Printf("1 row inserted (%s)\n", strings.Join(keys, ", "))
+			}
+
 		case planner.DescribeTablePlan:
 			table, ok := db.Tables[plan.TableName]
 			if !ok {
@@ -178,7 +233,16 @@ func Run(db *storage.Database, eng *engine.Engine) {
 			}
 			fmt.Printf("Columns in %s:\n", table.Name)
 			for _, col := range table.Columns {
-				fmt.Printf(" - %s (%s)\n", col.Name, col.ColumnType)
+				fmt.Printf(" - %s (%s)\n", col.Name, col.Definition())
+			}
+			for _, c := range table.Constraints {
+				fmt.Printf(" - %s\n", c)
+			}
+			for _, fk := range db.ForeignKeysOf(table.Name) {
+				fmt.Printf(" - %s\n", fk)
+			}
+			for _, ix := range table.Indexes {
+				fmt.Printf(" - %s\n", ix)
 			}
 
 		default:
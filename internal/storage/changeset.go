@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChangeSet is the complete effect of one statement: the rows it inserts,
+// updates or deletes itself plus every row changed by foreign key actions.
+// It is built and validated against a virtual view of the database without
+// modifying any table, so a statement that fails leaves nothing behind.
+//
+// Once validated, Records lists the changes for the write-ahead log and
+// Apply performs them.
+type ChangeSet struct {
+	db      *Database
+	order   []string // tables in the order they were first touched
+	changes map[string]*tableChanges
+
+	// keys removed from a parent under NO ACTION; checked once the whole
+	// statement is known
+	pending []removedKey
+}
+
+type tableChanges struct {
+	table    *Table
+	updates  map[RowID]map[string]any // row -> changed columns
+	deleted  map[RowID]bool
+	inserted []map[string]any
+}
+
+type removedKey struct {
+	fk  *ForeignKey
+	key []any
+}
+
+// NewChangeSet starts an empty change set against db.
+func (db *Database) NewChangeSet() *ChangeSet {
+	return &ChangeSet{db: db, changes: make(map[string]*tableChanges)}
+}
+
+// Insert adds a new row to table. data must already be prepared with
+// Table.PrepareInsert.
+func (cs *ChangeSet) Insert(table *Table, data map[string]any) {
+	tc := cs.table(table)
+	tc.inserted = append(tc.inserted, data)
+}
+
+// Update sets values on the row id of table and carries out the ON UPDATE
+// action of every foreign key whose referenced key changes.
+func (cs *ChangeSet) Update(table *Table, id RowID, values map[string]any) error {
+	if err := table.checkRowID(id); err != nil {
+		return err
+	}
+
+	tc := cs.table(table)
+	if tc.deleted[id] {
+		return nil
+	}
+
+	old := cs.image(tc, id)
+	if tc.updates[id] == nil {
+		tc.updates[id] = make(map[string]any, len(values))
+	}
+	for col, val := range values {
+		tc.updates[id][col] = val
+	}
+
+	return cs.keyRemoved(table, old, cs.image(tc, id))
+}
+
+// Delete removes the row id of table and carries out the ON DELETE action
+// of every foreign key that refers to it.
+func (cs *ChangeSet) Delete(table *Table, id RowID) error {
+	if err := table.checkRowID(id); err != nil {
+		return err
+	}
+
+	tc := cs.table(table)
+	if tc.deleted[id] {
+		return nil
+	}
+
+	old := cs.image(tc, id)
+	tc.deleted[id] = true
+
+	return cs.keyRemoved(table, old, nil)
+}
+
+// Validate checks every changed table against its own constraints and
+// every foreign key touched by the change set, on the state the database
+// would be in afterwards.
+func (cs *ChangeSet) Validate() error {
+	for _, name := range cs.order {
+		tc := cs.changes[name]
+		if err := tc.table.validate(tc.updates, tc.deleted, tc.inserted); err != nil {
+			return err
+		}
+	}
+
+	// Referencing rows that were written must point at a parent row
+	for _, name := range cs.order {
+		tc := cs.changes[name]
+		for _, fk := range cs.db.ForeignKeysOf(name) {
+			if err := cs.checkReferences(tc, fk); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keys removed under NO ACTION must not be referenced any more, unless
+	// the statement put the key back
+	for _, r := range cs.pending {
+		if cs.holds(cs.db.Tables[r.fk.RefTable], r.fk.RefColumns, r.key) {
+			continue
+		}
+		if cs.holds(cs.db.Tables[r.fk.Table], r.fk.Columns, r.key) {
+			return cs.violation(r.fk, r.key)
+		}
+	}
+
+	return nil
+}
+
+// Records returns the log records that reproduce the change set: per
+// table, updates and deletes in row ID order, then inserts. Inserts come
+// last so that replaying them reuses the IDs the deletes freed, exactly as
+// Apply does.
+func (cs *ChangeSet) Records() []*Record {
+	var recs []*Record
+	for _, name := range cs.order {
+		tc := cs.changes[name]
+
+		for _, id := range sortedRowIDs(tc.updates) {
+			if !tc.deleted[id] {
+				recs = append(recs, &Record{Type: RecordUpdate, Table: name, Row: id, Data: tc.updates[id]})
+			}
+		}
+
+		for _, id := range sortedRowIDs(tc.deleted) {
+			recs = append(recs, &Record{Type: RecordDelete, Table: name, Row: id})
+		}
+
+		for _, data := range tc.inserted {
+			recs = append(recs, &Record{Type: RecordInsert, Table: name, Data: data})
+		}
+	}
+	return recs
+}
+
+// Apply performs the change set. It should only be called after Validate
+// succeeded.
+func (cs *ChangeSet) Apply() error {
+	for _, name := range cs.order {
+		tc := cs.changes[name]
+
+		for _, id := range sortedRowIDs(tc.updates) {
+			if tc.deleted[id] {
+				continue
+			}
+			if err := tc.table.UpdateAt(id, tc.updates[id]); err != nil {
+				return err
+			}
+		}
+
+		if err := tc.table.DeleteRows(sortedRowIDs(tc.deleted)); err != nil {
+			return err
+		}
+
+		for _, data := range tc.inserted {
+			tc.table.AppendRow(&Row{Data: data})
+		}
+	}
+	return nil
+}
+
+// table returns the changes recorded for t, creating them on first use.
+func (cs *ChangeSet) table(t *Table) *tableChanges {
+	tc, ok := cs.changes[t.Name]
+	if !ok {
+		tc = &tableChanges{
+			table:   t,
+			updates: make(map[RowID]map[string]any),
+			deleted: make(map[RowID]bool),
+		}
+		cs.changes[t.Name] = tc
+		cs.order = append(cs.order, t.Name)
+	}
+	return tc
+}
+
+// image returns the row id as the change set would leave it.
+func (cs *ChangeSet) image(tc *tableChanges, id RowID) map[string]any {
+	row := tc.table.rows[id].Data
+	image := make(map[string]any, len(row))
+	for col, val := range row {
+		image[col] = val
+	}
+	for col, val := range tc.updates[id] {
+		image[col] = val
+	}
+	return image
+}
+
+// keyRemoved runs the foreign key actions for a parent row whose image
+// changed from old to updated; updated is nil when the row is deleted.
+func (cs *ChangeSet) keyRemoved(parent *Table, old, updated map[string]any) error {
+	for _, fk := range cs.db.ForeignKeys {
+		if fk.RefTable != parent.Name {
+			continue
+		}
+
+		oldKey, ok := keyOf(old, fk.RefColumns)
+		if !ok {
+			continue
+		}
+
+		action := fk.OnDelete
+		var newKey []any
+		if updated != nil {
+			newKey, _ = keyOf(updated, fk.RefColumns)
+			if newKey != nil && compositeKey(newKey) == compositeKey(oldKey) {
+				continue
+			}
+			action = fk.OnUpdate
+		}
+
+		child := cs.db.Tables[fk.Table]
+		ids, inserted := cs.matching(child, fk.Columns, oldKey)
+
+		switch action {
+		case NoAction:
+			cs.pending = append(cs.pending, removedKey{fk: fk, key: oldKey})
+			continue
+		case Restrict:
+			if len(ids) > 0 || inserted > 0 {
+				return cs.violation(fk, oldKey)
+			}
+			continue
+		}
+
+		// Rows inserted by this statement are checked in Validate
+		for _, id := range ids {
+			var err error
+			switch {
+			case action == Cascade && updated == nil:
+				err = cs.Delete(child, id)
+			case action == Cascade:
+				err = cs.Update(child, id, columnValues(fk.Columns, newKey))
+			case action == SetNull:
+				err = cs.Update(child, id, columnValues(fk.Columns, nil))
+			case action == SetDefault:
+				var values map[string]any
+				values, err = defaultValues(child, fk.Columns)
+				if err == nil {
+					err = cs.Update(child, id, values)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferences makes sure every row written to the referencing table of
+// fk has a matching parent row.
+func (cs *ChangeSet) checkReferences(tc *tableChanges, fk *ForeignKey) error {
+	var written []map[string]any
+	for id := range tc.updates {
+		if !tc.deleted[id] {
+			written = append(written, cs.image(tc, id))
+		}
+	}
+	written = append(written, tc.inserted...)
+
+	parent := cs.db.Tables[fk.RefTable]
+	indexed := parent.keyIndex(fk.RefColumns) != nil
+	var parentKeys map[any]bool
+	for _, row := range written {
+		key, ok := keyOf(row, fk.Columns)
+		if !ok {
+			continue
+		}
+
+		var found bool
+		if indexed {
+			found = cs.holds(parent, fk.RefColumns, key)
+		} else {
+			// Without an index the parent's keys are collected once
+			if parentKeys == nil {
+				parentKeys = make(map[any]bool)
+				for _, image := range cs.live(parent) {
+					if k, ok := keyOf(image, fk.RefColumns); ok {
+						parentKeys[compositeKey(k)] = true
+					}
+				}
+			}
+			found = parentKeys[compositeKey(key)]
+		}
+
+		if !found {
+			return fmt.Errorf("insert or update on table %s violates foreign key %s: key (%s)=(%s) is not present in table %s",
+				fk.Table, fk.Name, strings.Join(fk.Columns, ", "), formatKey(key), fk.RefTable)
+		}
+	}
+	return nil
+}
+
+// live returns the images of every row of t the change set keeps,
+// including the rows it inserts.
+func (cs *ChangeSet) live(t *Table) []map[string]any {
+	tc, changed := cs.changes[t.Name]
+
+	images := make([]map[string]any, 0, t.count)
+	t.Scan(func(id RowID, row *Row) bool {
+		switch {
+		case !changed:
+			images = append(images, row.Data)
+		case tc.deleted[id]:
+		case tc.updates[id] != nil:
+			images = append(images, cs.image(tc, id))
+		default:
+			images = append(images, row.Data)
+		}
+		return true
+	})
+	if !changed {
+		return images
+	}
+	return append(images, tc.inserted...)
+}
+
+// matching returns the IDs of the existing, not deleted rows of t whose
+// columns hold key in the change set's view, and the number of rows the
+// change set inserts into t with that key. An index over the columns
+// finds the stored rows with the key, and only the rows the change set
+// updates are looked at besides; without one every row is scanned.
+func (cs *ChangeSet) matching(t *Table, columns []string, key []any) (ids []RowID, inserted int) {
+	want := compositeKey(key)
+	holds := func(data map[string]any) bool {
+		k, ok := keyOf(data, columns)
+		return ok && compositeKey(k) == want
+	}
+	tc := cs.changes[t.Name]
+
+	if lookup := t.keyIndex(columns); lookup != nil {
+		for _, id := range lookup(key) {
+			if tc != nil && (tc.deleted[id] || tc.updates[id] != nil) {
+				continue
+			}
+			if holds(t.rows[id].Data) {
+				ids = append(ids, id)
+			}
+		}
+		if tc != nil {
+			for id := range tc.updates {
+				if !tc.deleted[id] && holds(cs.image(tc, id)) {
+					ids = append(ids, id)
+				}
+			}
+		}
+		SortRowIDs(ids)
+	} else {
+		t.Scan(func(id RowID, row *Row) bool {
+			data := row.Data
+			if tc != nil {
+				if tc.deleted[id] {
+					return true
+				}
+				if tc.updates[id] != nil {
+					data = cs.image(tc, id)
+				}
+			}
+			if holds(data) {
+				ids = append(ids, id)
+			}
+			return true
+		})
+	}
+
+	if tc != nil {
+		for _, data := range tc.inserted {
+			if holds(data) {
+				inserted++
+			}
+		}
+	}
+	return ids, inserted
+}
+
+// keyIndex returns a function finding the IDs of the stored rows of t
+// whose columns hold a key, given in the order of columns, using the
+// primary index or a secondary index that starts with the columns. It
+// returns nil when no index does. The rows are found as stored, so they
+// may hold other values in a change set.
+func (t *Table) keyIndex(columns []string) func(key []any) []RowID {
+	if pk := t.primaryKeyColumns(); len(pk) == len(columns) {
+		match := true
+		for _, col := range pk {
+			match = match && containsString(columns, col.Name)
+		}
+		if match {
+			return func(key []any) []RowID {
+				values := columnValues(columns, key)
+				k := make([]any, len(pk))
+				for i, col := range pk {
+					k[i] = values[col.Name]
+				}
+				if id, ok := t.PrimaryIndex[compositeKey(k)]; ok {
+					return []RowID{id}
+				}
+				return nil
+			}
+		}
+	}
+
+	for _, ix := range t.Indexes {
+		if len(ix.Columns) < len(columns) {
+			continue
+		}
+		match := true
+		for _, col := range ix.Columns[:len(columns)] {
+			match = match && containsString(columns, col)
+		}
+		if match {
+			ix := ix
+			return func(key []any) []RowID {
+				return ix.Lookup(ix.keyOf(columnValues(columns, key))[:len(columns)])
+			}
+		}
+	}
+	return nil
+}
+
+// holds reports whether some row of t holds key in columns in the change
+// set's view.
+func (cs *ChangeSet) holds(t *Table, columns []string, key []any) bool {
+	ids, inserted := cs.matching(t, columns, key)
+	return len(ids) > 0 || inserted > 0
+}
+
+func (cs *ChangeSet) violation(fk *ForeignKey, key []any) error {
+	return fmt.Errorf("update or delete on table %s violates foreign key %s on table %s: key (%s)=(%s) is still referenced",
+		fk.RefTable, fk.Name, fk.Table, strings.Join(fk.RefColumns, ", "), formatKey(key))
+}
+
+func columnValues(columns []string, values []any) map[string]any {
+	m := make(map[string]any, len(columns))
+	for i, col := range columns {
+		if values != nil {
+			m[col] = values[i]
+		} else {
+			m[col] = nil
+		}
+	}
+	return m
+}
+
+func defaultValues(t *Table, columns []string) (map[string]any, error) {
+	m := make(map[string]any, len(columns))
+	for _, name := range columns {
+		v, err := t.column(name).DefaultValue()
+		if err != nil {
+			return nil, err
+		}
+		m[name] = v
+	}
+	return m, nil
+}
+
+func formatKey(key []any) string {
+	parts := make([]string, len(key))
+	for i, v := range key {
+		parts[i] = FormatValue(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortedRowIDs returns the keys of m in ascending order.
+func sortedRowIDs[V any](m map[RowID]V) []RowID {
+	ids := make([]RowID, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return SortRowIDs(ids)
+}
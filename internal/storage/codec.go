@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Value tags used by the on-disk encoding. Every value stored in a row is
+// written as a single tag byte followed by its payload.
+const (
+	tagNull byte = iota
+	tagInt
+	tagInt64
+	tagFloat
+	tagBool
+	tagString
+	tagTime
+)
+
+// encoder appends the binary representation of storage values to a buffer.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.byte(1)
+		return
+	}
+	e.byte(0)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// value encodes a single row value. Only the Go types NovaDB stores in rows
+// are supported; anything else is reported as an error.
+func (e *encoder) value(v any) error {
+	switch x := v.(type) {
+	case nil:
+		e.byte(tagNull)
+	case int:
+		e.byte(tagInt)
+		e.varint(int64(x))
+	case int64:
+		e.byte(tagInt64)
+		e.varint(x)
+	case float64:
+		e.byte(tagFloat)
+		e.uvarint(math.Float64bits(x))
+	case bool:
+		e.byte(tagBool)
+		e.bool(x)
+	case string:
+		e.byte(tagString)
+		e.string(x)
+	case time.Time:
+		e.byte(tagTime)
+		b, err := x.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.string(string(b))
+	default:
+		return fmt.Errorf("cannot encode value of type %T", v)
+	}
+	return nil
+}
+
+// data encodes a row's column/value map. Keys are written in sorted order so
+// the same row always produces the same bytes.
+func (e *encoder) data(m map[string]any) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.string(k)
+		if err := e.value(m[k]); err != nil {
+			return fmt.Errorf("column %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (e *encoder) column(c *Column) {
+	e.string(c.Name)
+	e.string(string(c.ColumnType))
+	e.bool(c.IsPrimaryKey)
+	e.bool(c.IsUnique)
+	e.bool(c.NotNull)
+	e.expr(c.Default)
+	e.expr(c.Check)
+	e.string(string(c.Identity))
+}
+
+func (e *encoder) constraint(c *Constraint) {
+	e.string(c.Name)
+	e.string(string(c.Type))
+	e.uvarint(uint64(len(c.Columns)))
+	for _, col := range c.Columns {
+		e.string(col)
+	}
+	e.expr(c.Check)
+}
+
+func (e *encoder) foreignKey(fk *ForeignKey) {
+	e.string(fk.Name)
+	e.string(fk.Table)
+	e.uvarint(uint64(len(fk.Columns)))
+	for _, col := range fk.Columns {
+		e.string(col)
+	}
+	e.string(fk.RefTable)
+	e.uvarint(uint64(len(fk.RefColumns)))
+	for _, col := range fk.RefColumns {
+		e.string(col)
+	}
+	e.string(string(fk.OnDelete))
+	e.string(string(fk.OnUpdate))
+}
+
+// index writes an index definition; entries are rebuilt when it is read.
+func (e *encoder) index(ix *Index) {
+	e.string(ix.Name)
+	e.string(ix.Table)
+	e.uvarint(uint64(len(ix.Columns)))
+	for _, col := range ix.Columns {
+		e.string(col)
+	}
+	e.bool(ix.Unique)
+}
+
+func (e *encoder) sequence(s *Sequence) {
+	e.string(s.Name)
+	e.varint(s.Start)
+	e.varint(s.Increment)
+	e.varint(s.Value)
+	e.bool(s.Called)
+}
+
+// expr writes an expression as its SQL text; an empty string means none.
+func (e *encoder) expr(x Expr) {
+	if x == nil {
+		e.string("")
+		return
+	}
+	e.string(x.String())
+}
+
+// decoder reads values written by encoder. The first error is sticky: once
+// set, every later read returns a zero value and the error is kept in err.
+type decoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.buf) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.buf[d.off]
+	d.off++
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.off:])
+	if n <= 0 {
+		d.fail("invalid uvarint at offset %d", d.off)
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.off:])
+	if n <= 0 {
+		d.fail("invalid varint at offset %d", d.off)
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.buf)-d.off) < n {
+		d.fail("string length %d exceeds remaining data", n)
+		return ""
+	}
+	s := string(d.buf[d.off : d.off+int(n)])
+	d.off += int(n)
+	return s
+}
+
+func (d *decoder) value() any {
+	switch tag := d.byte(); tag {
+	case tagNull:
+		return nil
+	case tagInt:
+		return int(d.varint())
+	case tagInt64:
+		return d.varint()
+	case tagFloat:
+		return math.Float64frombits(d.uvarint())
+	case tagBool:
+		return d.bool()
+	case tagString:
+		return d.string()
+	case tagTime:
+		var t time.Time
+		if err := t.UnmarshalBinary([]byte(d.string())); err != nil {
+			d.fail("invalid time value: %v", err)
+		}
+		return t
+	default:
+		d.fail("unknown value tag %d", tag)
+		return nil
+	}
+}
+
+func (d *decoder) data() map[string]any {
+	n := d.uvarint()
+	m := make(map[string]any, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		k := d.string()
+		m[k] = d.value()
+	}
+	return m
+}
+
+func (d *decoder) column() *Column {
+	return &Column{
+		Name:         d.string(),
+		ColumnType:   ColumnType(d.string()),
+		IsPrimaryKey: d.bool(),
+		IsUnique:     d.bool(),
+		NotNull:      d.bool(),
+		Default:      d.expr(),
+		Check:        d.expr(),
+		Identity:     IdentityKind(d.string()),
+	}
+}
+
+func (d *decoder) constraint() *Constraint {
+	c := &Constraint{
+		Name: d.string(),
+		Type: ConstraintType(d.string()),
+	}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		c.Columns = append(c.Columns, d.string())
+	}
+	c.Check = d.expr()
+	return c
+}
+
+func (d *decoder) foreignKey() *ForeignKey {
+	fk := &ForeignKey{Name: d.string(), Table: d.string()}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		fk.Columns = append(fk.Columns, d.string())
+	}
+	fk.RefTable = d.string()
+	n = d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		fk.RefColumns = append(fk.RefColumns, d.string())
+	}
+	fk.OnDelete = RefAction(d.string())
+	fk.OnUpdate = RefAction(d.string())
+	return fk
+}
+
+func (d *decoder) index() *Index {
+	ix := &Index{Name: d.string(), Table: d.string()}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		ix.Columns = append(ix.Columns, d.string())
+	}
+	ix.Unique = d.bool()
+	return ix
+}
+
+func (d *decoder) sequence() *Sequence {
+	return &Sequence{
+		Name:      d.string(),
+		Start:     d.varint(),
+		Increment: d.varint(),
+		Value:     d.varint(),
+		Called:    d.bool(),
+	}
+}
+
+// expr reads an expression written by encoder.expr and compiles it with
+// ParseExpr.
+func (d *decoder) expr() Expr {
+	text := d.string()
+	if text == "" || d.err != nil {
+		return nil
+	}
+	if ParseExpr == nil {
+		d.fail("cannot read expression %q: no expression parser registered", text)
+		return nil
+	}
+
+	x, err := ParseExpr(text)
+	if err != nil {
+		d.fail("invalid stored expression %q: %v", text, err)
+		return nil
+	}
+	return x
+}
@@ -1,9 +1,37 @@
 package storage
 
 type Column struct {
-	Name   string
+	Name         string
 	ColumnType   ColumnType
 	IsPrimaryKey bool
 	IsUnique     bool
+	NotNull      bool
+	Default      Expr         // value for rows that leave the column out; nil means NULL
+	Check        Expr         // column-level CHECK condition, or nil
+	Identity     IdentityKind // set for identity columns, backed by IdentitySequence
 }
 
+// Definition renders the column's type and constraints the way they would
+// appear in CREATE TABLE, e.g. "INT PRIMARY KEY" or "INT DEFAULT 0".
+func (c *Column) Definition() string {
+	def := string(c.ColumnType)
+	if c.IsPrimaryKey {
+		def += " PRIMARY KEY"
+	}
+	if c.IsUnique && !c.IsPrimaryKey {
+		def += " UNIQUE"
+	}
+	if c.NotNull && !c.IsPrimaryKey {
+		def += " NOT NULL"
+	}
+	if c.Default != nil {
+		def += " DEFAULT " + c.Default.String()
+	}
+	if c.Check != nil {
+		def += " CHECK (" + c.Check.String() + ")"
+	}
+	if c.Identity != "" {
+		def += " GENERATED " + string(c.Identity) + " AS IDENTITY"
+	}
+	return def
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ConstraintType string
+
+const (
+	PrimaryKeyConstraint ConstraintType = "PRIMARY KEY"
+	UniqueConstraint     ConstraintType = "UNIQUE"
+	CheckConstraint      ConstraintType = "CHECK"
+
+	// ForeignKeyConstraint is only used while parsing; foreign keys are
+	// stored as ForeignKey in the Database catalog.
+	ForeignKeyConstraint ConstraintType = "FOREIGN KEY"
+)
+
+// Constraint is a table-level constraint, as declared by
+// "PRIMARY KEY (a, b)", "UNIQUE (a, b)" or "CHECK (a < b)".
+type Constraint struct {
+	Name    string // optional, from CONSTRAINT name
+	Type    ConstraintType
+	Columns []string // for CHECK, the columns the condition refers to
+	Check   Expr     // CHECK only
+}
+
+func (c *Constraint) String() string {
+	s := fmt.Sprintf("%s (%s)", c.Type, strings.Join(c.Columns, ", "))
+	if c.Type == CheckConstraint {
+		s = fmt.Sprintf("CHECK (%s)", c.Check)
+	}
+	if c.Name != "" {
+		s = "CONSTRAINT " + c.Name + " " + s
+	}
+	return s
+}
+
+// AddConstraint adds a table-level constraint.
+//
+// A PRIMARY KEY marks its columns as the table's primary key and makes them
+// NOT NULL. A single-column UNIQUE is folded into Column.IsUnique; UNIQUE
+// over several columns is kept in Constraints and checked as a tuple. CHECK
+// constraints are kept in Constraints and evaluated against every row.
+//
+// Constraints can only be added while the table is empty, and a table has
+// at most one primary key.
+func (t *Table) AddConstraint(c *Constraint) error {
+	if c == nil || (len(c.Columns) == 0 && c.Type != CheckConstraint) {
+		return fmt.Errorf("constraint must name at least one column")
+	}
+	if c.Type == CheckConstraint && c.Check == nil {
+		return fmt.Errorf("CHECK constraint has no condition")
+	}
+
+	if t.Len() > 0 {
+		return fmt.Errorf("cannot add %s constraint to table %s because it already has rows", c.Type, t.Name)
+	}
+
+	cols := make([]*Column, 0, len(c.Columns))
+	seen := make(map[string]bool)
+	for _, name := range c.Columns {
+		col := t.column(name)
+		if col == nil {
+			return fmt.Errorf("column %s does not exist in table %s", name, t.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("column %s appears twice in %s constraint", name, c.Type)
+		}
+		seen[name] = true
+		cols = append(cols, col)
+	}
+
+	switch c.Type {
+	case PrimaryKeyConstraint:
+		if len(t.primaryKeyColumns()) > 0 {
+			return fmt.Errorf("table %s already has a primary key", t.Name)
+		}
+		for _, col := range cols {
+			col.IsPrimaryKey = true
+			col.NotNull = true
+		}
+
+	case UniqueConstraint:
+		if len(cols) == 1 {
+			cols[0].IsUnique = true
+			return nil
+		}
+		t.Constraints = append(t.Constraints, c)
+
+	case CheckConstraint:
+		t.Constraints = append(t.Constraints, c)
+
+	default:
+		return fmt.Errorf("unknown constraint type %s", c.Type)
+	}
+
+	return nil
+}
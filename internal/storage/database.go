@@ -3,14 +3,17 @@ package storage
 import "fmt"
 
 type Database struct {
-	Tables map[string]*Table
+	Tables      map[string]*Table
+	ForeignKeys []*ForeignKey
+	Sequences   map[string]*Sequence
 }
 
 // NewDatabase initializes and returns a new Database instance.
 // The database starts with an empty table catalog.
 func NewDatabase() *Database {
 	return &Database{
-		Tables: make(map[string]*Table),
+		Tables:    make(map[string]*Table),
+		Sequences: make(map[string]*Sequence),
 	}
 }
 
@@ -40,8 +43,7 @@ func (db *Database) CreateTable(name string) (*Table, error) {
 	t := &Table{
 		Name:         name,
 		Columns:      make([]*Column, 0),
-		Rows:         make([]*Row, 0),
-		PrimaryIndex: make(map[any]int),
+		PrimaryIndex: make(map[any]RowID),
 	}
 
 	// Register the table in the database
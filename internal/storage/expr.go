@@ -0,0 +1,91 @@
+package storage
+
+import "fmt"
+
+// Expr is a SQL expression kept in the schema: a column DEFAULT or a CHECK
+// constraint. Expressions are built by package expr; storage evaluates them
+// and persists them as their SQL text.
+type Expr interface {
+	Eval(row map[string]any) (any, error)
+	String() string
+}
+
+// ParseExpr compiles the SQL text of a stored expression when a schema is
+// read back from a snapshot or the write-ahead log. It is set by the engine,
+// since storage cannot depend on the parser.
+var ParseExpr func(sql string) (Expr, error)
+
+// DefaultValue evaluates the column's DEFAULT and converts it to the
+// column type. A column without a DEFAULT defaults to NULL.
+func (c *Column) DefaultValue() (any, error) {
+	if c.Default == nil {
+		return nil, nil
+	}
+
+	v, err := c.Default.Eval(nil)
+	if err != nil {
+		return nil, fmt.Errorf("default for column %s: %w", c.Name, err)
+	}
+
+	v, err = Coerce(v, c.ColumnType)
+	if err != nil {
+		return nil, fmt.Errorf("default for column %s: %w", c.Name, err)
+	}
+	return v, nil
+}
+
+// CheckValue reports whether the column's NOT NULL and CHECK constraints
+// hold for row.
+func (c *Column) CheckValue(row map[string]any) error {
+	if c.NotNull && row[c.Name] == nil {
+		return fmt.Errorf("column %s cannot be null", c.Name)
+	}
+
+	if c.Check != nil {
+		ok, err := holds(c.Check, row)
+		if err != nil {
+			return fmt.Errorf("CHECK on column %s: %w", c.Name, err)
+		}
+		if !ok {
+			return fmt.Errorf("column %s violates CHECK (%s)", c.Name, c.Check)
+		}
+	}
+
+	return nil
+}
+
+// checkRow reports whether row passes the table-level CHECK constraints.
+func (t *Table) checkRow(row map[string]any) error {
+	for _, c := range t.Constraints {
+		if c.Type != CheckConstraint {
+			continue
+		}
+
+		ok, err := holds(c.Check, row)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c, err)
+		}
+		if !ok {
+			return fmt.Errorf("row violates %s", c)
+		}
+	}
+	return nil
+}
+
+// holds evaluates a CHECK condition. Like in SQL, only FALSE fails: a
+// condition that is NULL (unknown) passes.
+func holds(e Expr, row map[string]any) (bool, error) {
+	v, err := e.Eval(row)
+	if err != nil {
+		return false, err
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return true, nil
+	case bool:
+		return x, nil
+	default:
+		return false, fmt.Errorf("expected BOOL condition but got %s", TypeOf(v))
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RefAction is what happens to referencing rows when the row they refer to
+// is deleted or its key is updated.
+type RefAction string
+
+const (
+	NoAction   RefAction = "NO ACTION" // fail unless the statement leaves no orphans
+	Restrict   RefAction = "RESTRICT"  // fail as soon as a referenced key goes away
+	Cascade    RefAction = "CASCADE"   // delete the referencing rows, or update their keys
+	SetNull    RefAction = "SET NULL"
+	SetDefault RefAction = "SET DEFAULT"
+)
+
+// ForeignKey requires the values of Columns in Table to match a row of
+// RefTable on RefColumns. A row with a NULL in any of Columns is not
+// checked. Foreign keys span two tables, so they are kept in the Database
+// catalog rather than on a Table.
+type ForeignKey struct {
+	Name       string
+	Table      string
+	Columns    []string
+	RefTable   string
+	RefColumns []string // empty until resolved to RefTable's primary key
+	OnDelete   RefAction
+	OnUpdate   RefAction
+}
+
+func (fk *ForeignKey) String() string {
+	s := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)",
+		strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	if fk.OnDelete != NoAction {
+		s += " ON DELETE " + string(fk.OnDelete)
+	}
+	if fk.OnUpdate != NoAction {
+		s += " ON UPDATE " + string(fk.OnUpdate)
+	}
+	if fk.Name != "" {
+		s = "CONSTRAINT " + fk.Name + " " + s
+	}
+	return s
+}
+
+// AddForeignKey validates fk against the catalog and registers it. Missing
+// RefColumns default to the referenced table's primary key, a missing name
+// to "<table>_<columns>_fkey" and missing actions to NO ACTION.
+//
+// The referenced columns must be the primary key or a UNIQUE constraint of
+// the referenced table and have the same types as the referencing columns.
+// Foreign keys can only be added while the referencing table is empty.
+func (db *Database) AddForeignKey(fk *ForeignKey) error {
+	child, ok := db.Tables[fk.Table]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", fk.Table)
+	}
+	parent, ok := db.Tables[fk.RefTable]
+	if !ok {
+		return fmt.Errorf("referenced table %s does not exist", fk.RefTable)
+	}
+
+	if child.Len() > 0 {
+		return fmt.Errorf("cannot add foreign key to table %s because it already has rows", fk.Table)
+	}
+
+	if len(fk.RefColumns) == 0 {
+		for _, col := range parent.primaryKeyColumns() {
+			fk.RefColumns = append(fk.RefColumns, col.Name)
+		}
+		if len(fk.RefColumns) == 0 {
+			return fmt.Errorf("referenced table %s has no primary key", fk.RefTable)
+		}
+	}
+	if len(fk.Columns) != len(fk.RefColumns) {
+		return fmt.Errorf("foreign key has %d columns but references %d", len(fk.Columns), len(fk.RefColumns))
+	}
+
+	for i, name := range fk.Columns {
+		col := child.column(name)
+		if col == nil {
+			return fmt.Errorf("column %s does not exist in table %s", name, fk.Table)
+		}
+		ref := parent.column(fk.RefColumns[i])
+		if ref == nil {
+			return fmt.Errorf("column %s does not exist in table %s", fk.RefColumns[i], fk.RefTable)
+		}
+		if col.ColumnType != ref.ColumnType {
+			return fmt.Errorf("foreign key column %s is %s but referenced column %s.%s is %s",
+				name, col.ColumnType, fk.RefTable, ref.Name, ref.ColumnType)
+		}
+	}
+
+	if !parent.isUniqueKey(fk.RefColumns) {
+		return fmt.Errorf("referenced columns %s (%s) are not a primary key or UNIQUE", fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	}
+
+	if fk.OnDelete == "" {
+		fk.OnDelete = NoAction
+	}
+	if fk.OnUpdate == "" {
+		fk.OnUpdate = NoAction
+	}
+	for _, action := range []RefAction{fk.OnDelete, fk.OnUpdate} {
+		switch action {
+		case NoAction, Restrict, Cascade, SetNull, SetDefault:
+		default:
+			return fmt.Errorf("unknown foreign key action %s", action)
+		}
+	}
+
+	if fk.Name == "" {
+		fk.Name = fk.Table + "_" + strings.Join(fk.Columns, "_") + "_fkey"
+	}
+	for _, existing := range db.ForeignKeys {
+		if existing.Table == fk.Table && existing.Name == fk.Name {
+			return fmt.Errorf("foreign key %s already exists on table %s", fk.Name, fk.Table)
+		}
+	}
+
+	db.ForeignKeys = append(db.ForeignKeys, fk)
+	return nil
+}
+
+// ForeignKeysOf returns the foreign keys declared on table.
+func (db *Database) ForeignKeysOf(table string) []*ForeignKey {
+	var fks []*ForeignKey
+	for _, fk := range db.ForeignKeys {
+		if fk.Table == table {
+			fks = append(fks, fk)
+		}
+	}
+	return fks
+}
+
+// isUniqueKey reports whether the columns, in any order, are the primary
+// key or a UNIQUE constraint of the table.
+func (t *Table) isUniqueKey(columns []string) bool {
+	want := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		want[c] = true
+	}
+
+	for _, key := range t.uniqueKeys() {
+		if len(key.columns) != len(want) {
+			continue
+		}
+		match := true
+		for _, c := range key.columns {
+			if !want[c] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// keyOf returns the values of columns in row, and whether all of them are
+// non-NULL.
+func keyOf(row map[string]any, columns []string) ([]any, bool) {
+	values := make([]any, len(columns))
+	for i, col := range columns {
+		if row[col] == nil {
+			return nil, false
+		}
+		values[i] = row[col]
+	}
+	return values, true
+}
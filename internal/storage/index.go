@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Index is an ordered secondary index over one or more columns of a table,
+// created by CREATE [UNIQUE] INDEX. Entries are kept sorted by the indexed
+// values, compared column by column with NULL after every other value, and
+// point at row IDs.
+//
+// The table keeps its indexes up to date in AppendRow, UpdateAt, DeleteAt
+// and DeleteRows. A UNIQUE index is also enforced like a UNIQUE constraint.
+type Index struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+
+	entries *skipList
+}
+
+// indexEntry is one row in an index. The row ID breaks ties between equal
+// keys so every entry is distinct.
+type indexEntry struct {
+	key []any
+	id  RowID
+}
+
+// Bound is one end of an index range. Key may be shorter than the index's
+// column list, in which case only its leading columns are compared.
+type Bound struct {
+	Key       []any
+	Inclusive bool
+}
+
+func (ix *Index) String() string {
+	s := fmt.Sprintf("INDEX %s (%s)", ix.Name, strings.Join(ix.Columns, ", "))
+	if ix.Unique {
+		s = "UNIQUE " + s
+	}
+	return s
+}
+
+// Len returns the number of entries in the index.
+func (ix *Index) Len() int {
+	return ix.entries.length
+}
+
+// Scan calls fn with the ID of every row whose key lies between lo and hi,
+// in key order, until fn returns false. A nil bound leaves that end of the
+// range open.
+func (ix *Index) Scan(lo, hi *Bound, fn func(id RowID) bool) {
+	var node *skipNode
+	if lo == nil {
+		node = ix.entries.head.next[0]
+	} else {
+		node = ix.entries.seek(func(e indexEntry) bool {
+			c := comparePrefix(e.key, lo.Key)
+			return c < 0 || (c == 0 && !lo.Inclusive)
+		})
+	}
+
+	for ; node != nil; node = node.next[0] {
+		if hi != nil {
+			c := comparePrefix(node.entry.key, hi.Key)
+			if c > 0 || (c == 0 && !hi.Inclusive) {
+				return
+			}
+		}
+		if !fn(node.entry.id) {
+			return
+		}
+	}
+}
+
+// Lookup returns the IDs of the rows whose key starts with key.
+func (ix *Index) Lookup(key []any) []RowID {
+	var ids []RowID
+	bound := &Bound{Key: key, Inclusive: true}
+	ix.Scan(bound, bound, func(id RowID) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+func (ix *Index) keyOf(data map[string]any) []any {
+	key := make([]any, len(ix.Columns))
+	for i, col := range ix.Columns {
+		key[i] = data[col]
+	}
+	return key
+}
+
+func (ix *Index) add(data map[string]any, id RowID) {
+	ix.entries.insert(indexEntry{key: ix.keyOf(data), id: id})
+}
+
+func (ix *Index) remove(data map[string]any, id RowID) {
+	ix.entries.remove(indexEntry{key: ix.keyOf(data), id: id})
+}
+
+// build fills the index from scratch with the rows of t.
+func (ix *Index) build(t *Table) {
+	ix.entries = newSkipList()
+	t.Scan(func(id RowID, row *Row) bool {
+		ix.add(row.Data, id)
+		return true
+	})
+}
+
+// covers reports whether the index is over exactly columns, in any order.
+func (ix *Index) covers(columns []string) bool {
+	if len(ix.Columns) != len(columns) {
+		return false
+	}
+	for _, col := range columns {
+		if !containsString(ix.Columns, col) {
+			return false
+		}
+	}
+	return true
+}
+
+// --------------------------
+// Catalog
+// --------------------------
+
+// CreateIndex validates ix, builds it over the table's current rows and
+// registers it. Index names are unique across the database. A UNIQUE index
+// cannot be created over rows that already repeat a key.
+func (db *Database) CreateIndex(ix *Index) error {
+	if ix.Name == "" {
+		return fmt.Errorf("index name cannot be empty")
+	}
+	if t, _ := db.FindIndex(ix.Name); t != nil {
+		return fmt.Errorf("index %s already exists", ix.Name)
+	}
+
+	t, ok := db.Tables[ix.Table]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", ix.Table)
+	}
+	if len(ix.Columns) == 0 {
+		return fmt.Errorf("index %s must name at least one column", ix.Name)
+	}
+	seen := make(map[string]bool)
+	for _, col := range ix.Columns {
+		if t.column(col) == nil {
+			return fmt.Errorf("column %s does not exist in table %s", col, t.Name)
+		}
+		if seen[col] {
+			return fmt.Errorf("column %s appears twice in index %s", col, ix.Name)
+		}
+		seen[col] = true
+	}
+
+	ix.build(t)
+	if ix.Unique {
+		var prev *indexEntry
+		for node := ix.entries.head.next[0]; node != nil; node = node.next[0] {
+			if hasNullValue(node.entry.key) {
+				continue
+			}
+			if prev != nil && compareKeys(prev.key, node.entry.key) == 0 {
+				return fmt.Errorf("cannot create unique index %s: key (%s)=(%s) is duplicated",
+					ix.Name, strings.Join(ix.Columns, ", "), formatKey(node.entry.key))
+			}
+			prev = &node.entry
+		}
+	}
+
+	t.Indexes = append(t.Indexes, ix)
+	return nil
+}
+
+// DropIndex removes the index with the given name.
+func (db *Database) DropIndex(name string) error {
+	t, ix := db.FindIndex(name)
+	if ix == nil {
+		return fmt.Errorf("index %s does not exist", name)
+	}
+
+	for i, other := range t.Indexes {
+		if other == ix {
+			t.Indexes = append(t.Indexes[:i], t.Indexes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// FindIndex returns the index with the given name and its table, or nils.
+func (db *Database) FindIndex(name string) (*Table, *Index) {
+	for _, t := range db.Tables {
+		for _, ix := range t.Indexes {
+			if ix.Name == name {
+				return t, ix
+			}
+		}
+	}
+	return nil, nil
+}
+
+// IndexOn returns an index whose first column is column, preferring the
+// one with the fewest columns, or nil.
+func (t *Table) IndexOn(column string) *Index {
+	var best *Index
+	for _, ix := range t.Indexes {
+		if ix.Columns[0] == column && (best == nil || len(ix.Columns) < len(best.Columns)) {
+			best = ix
+		}
+	}
+	return best
+}
+
+// rebuildIndexes rebuilds every index from the rows.
+func (t *Table) rebuildIndexes() {
+	for _, ix := range t.Indexes {
+		ix.build(t)
+	}
+}
+
+// --------------------------
+// Key ordering
+// --------------------------
+
+// compareKeys orders two index keys column by column. NULL sorts after
+// every other value; values that cannot be compared are ordered by type
+// name so the order stays total.
+func compareKeys(a, b []any) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareKeyValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// comparePrefix compares key against the leading columns held by prefix.
+func comparePrefix(key, prefix []any) int {
+	if len(key) > len(prefix) {
+		key = key[:len(prefix)]
+	}
+	return compareKeys(key, prefix)
+}
+
+func compareKeyValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	c, err := Compare(a, b)
+	if err != nil {
+		return strings.Compare(string(TypeOf(a)), string(TypeOf(b)))
+	}
+	return c
+}
+
+func compareEntries(a, b indexEntry) int {
+	if c := compareKeys(a.key, b.key); c != 0 {
+		return c
+	}
+	return int(a.id - b.id)
+}
+
+func hasNullValue(key []any) bool {
+	for _, v := range key {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// --------------------------
+// Skip list
+// --------------------------
+
+const maxSkipLevel = 24
+
+// skipList is an ordered set of index entries. Each node is linked on a
+// random number of levels, so searches skip ahead in O(log n) expected
+// steps while inserts and removals only touch the neighbouring nodes.
+type skipList struct {
+	head   *skipNode
+	level  int
+	length int
+	rnd    *rand.Rand
+}
+
+type skipNode struct {
+	entry indexEntry
+	next  []*skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, maxSkipLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+}
+
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < maxSkipLevel && l.rnd.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// seek returns the first node for which before is false. before must be
+// true for a prefix of the list and false afterwards.
+func (l *skipList) seek(before func(indexEntry) bool) *skipNode {
+	node := l.head
+	for level := l.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && before(node.next[level].entry) {
+			node = node.next[level]
+		}
+	}
+	return node.next[0]
+}
+
+// predecessors returns, for every level, the last node before e.
+func (l *skipList) predecessors(e indexEntry) []*skipNode {
+	update := make([]*skipNode, maxSkipLevel)
+	node := l.head
+	for level := l.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && compareEntries(node.next[level].entry, e) < 0 {
+			node = node.next[level]
+		}
+		update[level] = node
+	}
+	return update
+}
+
+func (l *skipList) insert(e indexEntry) {
+	update := l.predecessors(e)
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	node := &skipNode{entry: e, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	l.length++
+}
+
+func (l *skipList) remove(e indexEntry) bool {
+	update := l.predecessors(e)
+
+	node := update[0].next[0]
+	if node == nil || compareEntries(node.entry, e) != 0 {
+		return false
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newIndexedTable(t *testing.T) (*Database, *Table) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("people")
+	for _, col := range []*Column{
+		{Name: "id", ColumnType: IntType, IsPrimaryKey: true},
+		{Name: "age", ColumnType: IntType},
+		{Name: "name", ColumnType: TextType},
+	} {
+		if err := table.AddColumn(col); err != nil {
+			t.Fatalf("add column failed: %v", err)
+		}
+	}
+	return db, table
+}
+
+func scan(ix *Index, lo, hi *Bound) []RowID {
+	var ids []RowID
+	ix.Scan(lo, hi, func(id RowID) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+func TestIndexScan(t *testing.T) {
+	db, table := newIndexedTable(t)
+
+	ages := []any{int64(40), nil, int64(20), int64(30), int64(20)}
+	for i, age := range ages {
+		table.AppendRow(&Row{Data: map[string]any{"id": int64(i), "age": age, "name": fmt.Sprint("p", i)}})
+	}
+
+	ix := &Index{Name: "people_age", Table: "people", Columns: []string{"age"}}
+	if err := db.CreateIndex(ix); err != nil {
+		t.Fatalf("create index failed: %v", err)
+	}
+
+	// Key order, equal keys by row ID, NULL last
+	if got := fmt.Sprint(scan(ix, nil, nil)); got != "[2 4 3 0 1]" {
+		t.Fatalf("full scan = %s", got)
+	}
+	if got := fmt.Sprint(ix.Lookup([]any{int64(20)})); got != "[2 4]" {
+		t.Fatalf("lookup 20 = %s", got)
+	}
+	lo := &Bound{Key: []any{int64(20)}, Inclusive: false}
+	hi := &Bound{Key: []any{int64(40)}, Inclusive: true}
+	if got := fmt.Sprint(scan(ix, lo, hi)); got != "[3 0]" {
+		t.Fatalf("scan (20, 40] = %s", got)
+	}
+
+	// The index follows updates and deletes
+	if err := table.UpdateAt(0, map[string]any{"age": int64(10)}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if err := table.DeleteRows([]RowID{2}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if got := fmt.Sprint(scan(ix, nil, nil)); got != "[0 4 3 1]" {
+		t.Fatalf("scan after update and delete = %s", got)
+	}
+	if ix.Len() != table.Len() {
+		t.Fatalf("index has %d entries for %d rows", ix.Len(), table.Len())
+	}
+
+	if err := db.CreateIndex(&Index{Name: "people_age", Table: "people", Columns: []string{"name"}}); err == nil {
+		t.Fatal("expected error for a duplicate index name")
+	}
+	if err := db.DropIndex("people_age"); err != nil || len(table.Indexes) != 0 {
+		t.Fatalf("drop index failed: %v", err)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db, table := newIndexedTable(t)
+	table.AppendRow(&Row{Data: map[string]any{"id": int64(1), "age": int64(20), "name": "a"}})
+	table.AppendRow(&Row{Data: map[string]any{"id": int64(2), "age": int64(20), "name": "b"}})
+
+	if err := db.CreateIndex(&Index{Name: "u_age", Table: "people", Columns: []string{"age"}, Unique: true}); err == nil {
+		t.Fatal("expected error creating a unique index over duplicates")
+	}
+	if len(table.Indexes) != 0 {
+		t.Fatal("failed index was registered")
+	}
+
+	if err := db.CreateIndex(&Index{Name: "u_name", Table: "people", Columns: []string{"name"}, Unique: true}); err != nil {
+		t.Fatalf("create unique index failed: %v", err)
+	}
+	if err := table.Insert(&Row{Data: map[string]any{"id": 3, "name": "a"}}); err == nil {
+		t.Fatal("expected unique index violation on insert")
+	}
+	if err := table.Insert(&Row{Data: map[string]any{"id": 3}}); err != nil {
+		t.Fatalf("NULL key rejected by unique index: %v", err)
+	}
+	// Swapping keys within one statement is allowed
+	if err := table.ValidateUpdate(map[RowID]map[string]any{0: {"name": "b"}, 1: {"name": "a"}}); err != nil {
+		t.Fatalf("swap rejected: %v", err)
+	}
+	if err := table.ValidateUpdate(map[RowID]map[string]any{0: {"name": "b"}}); err == nil {
+		t.Fatal("expected unique index violation on update")
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// RowID identifies a row within its table. A row keeps its ID for as long
+// as it exists, whatever happens to other rows, so the primary index,
+// secondary indexes and WAL records can point at it directly. The ID of a
+// deleted row is reused by a later insert.
+type RowID int
+
+// Rows are stored in a slot map: slot i holds the row with ID i, or nil
+// once that row is deleted. Freed IDs go on a min-heap and inserts take the
+// lowest one first, so the IDs handed out depend only on which slots are
+// free. Replaying the WAL over a snapshot therefore assigns the same IDs
+// the rows had when the records were written.
+
+// Len returns the number of rows in the table.
+func (t *Table) Len() int {
+	return t.count
+}
+
+// Row returns the row with the given ID, or nil if there is none.
+func (t *Table) Row(id RowID) *Row {
+	if id < 0 || int(id) >= len(t.rows) {
+		return nil
+	}
+	return t.rows[id]
+}
+
+// Scan calls fn for every row in ID order until fn returns false.
+func (t *Table) Scan(fn func(id RowID, row *Row) bool) {
+	for i, row := range t.rows {
+		if row != nil && !fn(RowID(i), row) {
+			return
+		}
+	}
+}
+
+// RowIDs returns the IDs of every row in ascending order.
+func (t *Table) RowIDs() []RowID {
+	ids := make([]RowID, 0, t.count)
+	t.Scan(func(id RowID, _ *Row) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+// GetRows returns all rows in the table in ID order.
+func (t *Table) GetRows() []*Row {
+	rows := make([]*Row, 0, t.count)
+	t.Scan(func(_ RowID, row *Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	return rows
+}
+
+// sortRowIDs sorts ids ascending and returns them.
+func SortRowIDs(ids []RowID) []RowID {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// checkRowID returns an error unless a row with the given ID exists.
+func (t *Table) checkRowID(id RowID) error {
+	if t.Row(id) == nil {
+		return fmt.Errorf("row %d does not exist in table %s", id, t.Name)
+	}
+	return nil
+}
+
+// store puts row into its slot, taking the lowest free ID.
+func (t *Table) store(row *Row) RowID {
+	id := RowID(len(t.rows))
+	if len(t.free) > 0 {
+		id = heap.Pop(&t.free).(RowID)
+		t.rows[id] = row
+	} else {
+		t.rows = append(t.rows, row)
+	}
+	t.count++
+	t.changes++
+	return id
+}
+
+// release empties the slot of id and makes the ID available again.
+func (t *Table) release(id RowID) {
+	t.rows[id] = nil
+	heap.Push(&t.free, id)
+	t.count--
+	t.changes++
+}
+
+// freeList is a min-heap of the IDs of deleted rows.
+type freeList []RowID
+
+func (f freeList) Len() int           { return len(f) }
+func (f freeList) Less(i, j int) bool { return f[i] < f[j] }
+func (f freeList) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+func (f *freeList) Push(x any) {
+	*f = append(*f, x.(RowID))
+}
+
+func (f *freeList) Pop() any {
+	old := *f
+	id := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return id
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStableRowIDs(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("users")
+	table.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	table.AddColumn(&Column{Name: "name", ColumnType: TextType})
+
+	var ids []RowID
+	for i, name := range []string{"a", "b", "c", "d"} {
+		ids = append(ids, table.AppendRow(&Row{Data: map[string]any{"id": int64(i + 1), "name": name}}))
+	}
+	held := table.Row(ids[3])
+
+	// Deleting rows leaves the others where they are
+	if err := table.DeleteRows([]RowID{ids[0], ids[2]}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if table.Len() != 2 || table.Row(ids[0]) != nil || table.Row(ids[3]) != held {
+		t.Fatalf("rows moved after delete: %+v", table.GetRows())
+	}
+	if id, err := table.RowIDByPK(4); err != nil || id != ids[3] {
+		t.Fatalf("primary key 4 found at %v, %v; want %v", id, err, ids[3])
+	}
+	if err := table.DeleteAt(ids[2]); err == nil {
+		t.Fatal("expected error deleting a row twice, got nil")
+	}
+
+	// Snapshot and reload keep IDs and free slots
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := WriteSnapshot(path, db, 1); err != nil {
+		t.Fatalf("write snapshot failed: %v", err)
+	}
+	loaded := NewDatabase()
+	if _, err := LoadSnapshot(path, loaded); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+
+	for _, tbl := range []*Table{table, loaded.Tables["users"]} {
+		if tbl.Row(ids[3]) == nil || tbl.Row(ids[3]).Data["name"] != "d" {
+			t.Fatalf("row d lost its ID: %+v", tbl.GetRows())
+		}
+
+		// New rows fill the lowest free slot first
+		first := tbl.AppendRow(&Row{Data: map[string]any{"id": int64(5), "name": "e"}})
+		second := tbl.AppendRow(&Row{Data: map[string]any{"id": int64(6), "name": "f"}})
+		third := tbl.AppendRow(&Row{Data: map[string]any{"id": int64(7), "name": "g"}})
+		if first != ids[0] || second != ids[2] || third != RowID(len(ids)) {
+			t.Fatalf("inserts took IDs %d, %d, %d", first, second, third)
+		}
+		if tbl.Len() != 5 {
+			t.Fatalf("expected 5 rows, got %d", tbl.Len())
+		}
+	}
+}
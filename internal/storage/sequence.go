@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+)
+
+// Sequence is a named counter handing out INT values, as created by
+// CREATE SEQUENCE or implicitly for an identity column.
+//
+// Value is the last value handed out. Until the first call of Next it is
+// one step before Start and Called is false.
+type Sequence struct {
+	Name      string
+	Start     int64
+	Increment int64
+	Value     int64
+	Called    bool
+}
+
+// IdentityKind says how an identity column gets its values.
+type IdentityKind string
+
+const (
+	// IdentityByDefault generates a value when the insert leaves the column
+	// out or sets it to NULL. AUTOINCREMENT columns are BY DEFAULT.
+	IdentityByDefault IdentityKind = "BY DEFAULT"
+	// IdentityAlways always generates the value; inserting or updating one
+	// explicitly is an error.
+	IdentityAlways IdentityKind = "ALWAYS"
+)
+
+// NewSequence returns a sequence whose first value is start.
+func NewSequence(name string, start, increment int64) (*Sequence, error) {
+	if name == "" {
+		return nil, fmt.Errorf("sequence name cannot be empty")
+	}
+	if increment == 0 {
+		return nil, fmt.Errorf("INCREMENT of sequence %s cannot be zero", name)
+	}
+	return &Sequence{Name: name, Start: start, Increment: increment, Value: start - increment}, nil
+}
+
+// IdentitySequence names the sequence behind an identity column.
+func IdentitySequence(table, column string) string {
+	return table + "_" + column + "_seq"
+}
+
+// Next returns the value the sequence hands out next, without consuming
+// it. Use Set to consume it once the change has been logged.
+func (s *Sequence) Next() (int64, error) {
+	if !s.Called {
+		return s.Start, nil
+	}
+	if (s.Increment > 0 && s.Value > math.MaxInt64-s.Increment) ||
+		(s.Increment < 0 && s.Value < math.MinInt64-s.Increment) {
+		return 0, fmt.Errorf("sequence %s reached its limit", s.Name)
+	}
+	return s.Value + s.Increment, nil
+}
+
+// Current returns the last value handed out.
+func (s *Sequence) Current() (int64, error) {
+	if !s.Called {
+		return 0, fmt.Errorf("currval of sequence %s is not yet defined", s.Name)
+	}
+	return s.Value, nil
+}
+
+// Set records v as the last value handed out.
+func (s *Sequence) Set(v int64) {
+	s.Value = v
+	s.Called = true
+}
+
+// Behind reports whether v is past the last value handed out in the
+// direction the sequence counts, so that handing out values from the
+// current position could eventually collide with v.
+func (s *Sequence) Behind(v int64) bool {
+	if s.Increment > 0 {
+		return v > s.Value
+	}
+	return v < s.Value
+}
+
+// CreateSequence registers seq in the catalog.
+func (db *Database) CreateSequence(seq *Sequence) error {
+	if db.Sequences == nil {
+		db.Sequences = make(map[string]*Sequence)
+	}
+	if _, exists := db.Sequences[seq.Name]; exists {
+		return fmt.Errorf("sequence %s already exists", seq.Name)
+	}
+	db.Sequences[seq.Name] = seq
+	return nil
+}
+
+// Sequence looks up a sequence by name.
+func (db *Database) Sequence(name string) (*Sequence, error) {
+	seq, ok := db.Sequences[name]
+	if !ok {
+		return nil, fmt.Errorf("sequence %s does not exist", name)
+	}
+	return seq, nil
+}
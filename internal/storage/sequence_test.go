@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+	seq, err := NewSequence("s", 10, -3)
+	if err != nil {
+		t.Fatalf("NewSequence failed: %v", err)
+	}
+	if _, err := seq.Current(); err == nil {
+		t.Fatal("expected Current before the first value to fail")
+	}
+
+	for _, want := range []int64{10, 7, 4} {
+		v, err := seq.Next()
+		if err != nil || v != want {
+			t.Fatalf("Next = %v, %v; want %d", v, err, want)
+		}
+		seq.Set(v)
+	}
+	if v, _ := seq.Current(); v != 4 {
+		t.Fatalf("Current = %d, want 4", v)
+	}
+
+	// A descending sequence is behind values below its last one
+	if !seq.Behind(0) || seq.Behind(5) {
+		t.Fatal("Behind does not follow the direction of the sequence")
+	}
+
+	seq.Set(math.MaxInt64 - 1)
+	seq.Increment = 2
+	if _, err := seq.Next(); err == nil {
+		t.Fatal("expected an error when the sequence overflows")
+	}
+
+	if _, err := NewSequence("z", 1, 0); err == nil {
+		t.Fatal("expected an error for a zero increment")
+	}
+}
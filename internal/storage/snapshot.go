@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// snapshotMagic starts every snapshot file.
+var snapshotMagic = []byte("NOVASNAP")
+
+// snapshotVersion is bumped whenever the snapshot layout changes. Older
+// versions are rejected rather than misread.
+const snapshotVersion = 7
+
+// WriteSnapshot serializes every table in the database to path. lsn is the
+// last WAL record reflected in the snapshot; recovery skips records up to
+// and including it.
+//
+// The snapshot is written to a temporary file, fsynced and renamed into
+// place, so a crash never leaves a partially written snapshot at path.
+func WriteSnapshot(path string, db *Database, lsn uint64) error {
+	e := &encoder{}
+	e.buf = append(e.buf, snapshotMagic...)
+	e.uvarint(snapshotVersion)
+	e.uvarint(lsn)
+
+	names := make([]string, 0, len(db.Tables))
+	for name := range db.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		if err := e.table(db.Tables[name]); err != nil {
+			return fmt.Errorf("snapshot table %s: %w", name, err)
+		}
+	}
+
+	e.uvarint(uint64(len(db.ForeignKeys)))
+	for _, fk := range db.ForeignKeys {
+		e.foreignKey(fk)
+	}
+
+	seqs := make([]string, 0, len(db.Sequences))
+	for name := range db.Sequences {
+		seqs = append(seqs, name)
+	}
+	sort.Strings(seqs)
+
+	e.uvarint(uint64(len(seqs)))
+	for _, name := range seqs {
+		e.sequence(db.Sequences[name])
+	}
+
+	// Trailing checksum over everything before it
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	if _, err := f.Write(e.buf); err != nil {
+		f.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// LoadSnapshot reads the snapshot at path into db and returns the LSN it
+// covers. A missing file is not an error: the database is left untouched
+// and an LSN of zero is returned.
+func LoadSnapshot(path string, db *Database) (uint64, error) {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	if len(buf) < len(snapshotMagic)+4 || !bytes.Equal(buf[:len(snapshotMagic)], snapshotMagic) {
+		return 0, fmt.Errorf("%s is not a NovaDB snapshot", path)
+	}
+
+	body := buf[:len(buf)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return 0, fmt.Errorf("snapshot %s is corrupt", path)
+	}
+
+	d := &decoder{buf: body, off: len(snapshotMagic)}
+	if version := d.uvarint(); version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	lsn := d.uvarint()
+
+	tables := make(map[string]*Table)
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		t := d.table()
+		tables[t.Name] = t
+	}
+
+	var fks []*ForeignKey
+	count = d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		fks = append(fks, d.foreignKey())
+	}
+
+	seqs := make(map[string]*Sequence)
+	count = d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		seq := d.sequence()
+		seqs[seq.Name] = seq
+	}
+
+	if d.err != nil {
+		return 0, fmt.Errorf("decode snapshot: %w", d.err)
+	}
+
+	db.Tables = tables
+	db.ForeignKeys = fks
+	db.Sequences = seqs
+	return lsn, nil
+}
+
+func (e *encoder) table(t *Table) error {
+	e.string(t.Name)
+
+	e.uvarint(uint64(len(t.Columns)))
+	for _, col := range t.Columns {
+		e.column(col)
+	}
+
+	e.uvarint(uint64(len(t.Constraints)))
+	for _, c := range t.Constraints {
+		e.constraint(c)
+	}
+
+	e.uvarint(uint64(len(t.Indexes)))
+	for _, ix := range t.Indexes {
+		e.index(ix)
+	}
+
+	// Rows keep their IDs: the number of slots comes first, then every
+	// row with its ID. The free slots are the ones left out.
+	e.uvarint(uint64(len(t.rows)))
+	e.uvarint(uint64(t.count))
+	var err error
+	t.Scan(func(id RowID, row *Row) bool {
+		e.uvarint(uint64(id))
+		err = e.data(row.Data)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.uvarint(uint64(len(t.PrimaryIndex)))
+	for key, id := range t.PrimaryIndex {
+		if err := e.value(key); err != nil {
+			return fmt.Errorf("primary key: %w", err)
+		}
+		e.uvarint(uint64(id))
+	}
+
+	return nil
+}
+
+func (d *decoder) table() *Table {
+	t := &Table{
+		Name:         d.string(),
+		Columns:      make([]*Column, 0),
+		PrimaryIndex: make(map[any]RowID),
+	}
+
+	cols := d.uvarint()
+	for i := uint64(0); i < cols && d.err == nil; i++ {
+		t.Columns = append(t.Columns, d.column())
+	}
+
+	constraints := d.uvarint()
+	for i := uint64(0); i < constraints && d.err == nil; i++ {
+		t.Constraints = append(t.Constraints, d.constraint())
+	}
+
+	indexes := d.uvarint()
+	for i := uint64(0); i < indexes && d.err == nil; i++ {
+		t.Indexes = append(t.Indexes, d.index())
+	}
+
+	slots := d.uvarint()
+	rows := d.uvarint()
+	if d.err == nil && rows > slots {
+		d.fail("table %s has more rows than slots", t.Name)
+	}
+	if d.err == nil {
+		t.rows = make([]*Row, slots)
+	}
+	for i := uint64(0); i < rows && d.err == nil; i++ {
+		id := d.uvarint()
+		data := d.data()
+		if d.err == nil && (id >= slots || t.rows[id] != nil) {
+			d.fail("row %d of %s is out of place", id, t.Name)
+		}
+		if d.err == nil {
+			t.rows[id] = &Row{Data: data}
+			t.count++
+		}
+	}
+	// Listed in ascending order, the free IDs already form a valid heap
+	for id, row := range t.rows {
+		if row == nil {
+			t.free = append(t.free, RowID(id))
+		}
+	}
+
+	keys := d.uvarint()
+	for i := uint64(0); i < keys && d.err == nil; i++ {
+		key := d.value()
+		id := RowID(d.uvarint())
+		if t.Row(id) == nil {
+			d.fail("primary index of %s points at a missing row", t.Name)
+		}
+		t.PrimaryIndex[key] = id
+	}
+
+	// Index entries are not stored; they are rebuilt from the rows
+	t.rebuildIndexes()
+
+	return t
+}
+
+// syncDir fsyncs a directory so that a rename inside it is durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
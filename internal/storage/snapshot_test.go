@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("products")
+	table.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	table.AddColumn(&Column{Name: "name", ColumnType: TextType, IsUnique: true})
+	table.AddColumn(&Column{Name: "price", ColumnType: FloatType})
+	table.AddColumn(&Column{Name: "added_date", ColumnType: DateType})
+
+	added := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	table.Insert(&Row{Data: map[string]any{"id": 1, "name": "mouse", "price": 1999.99, "added_date": added}})
+	table.Insert(&Row{Data: map[string]any{"id": 2, "name": "keyboard", "price": 1500.0}})
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := WriteSnapshot(path, db, 42); err != nil {
+		t.Fatalf("write snapshot failed: %v", err)
+	}
+
+	loaded := NewDatabase()
+	lsn, err := LoadSnapshot(path, loaded)
+	if err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+
+	if lsn != 42 {
+		t.Fatalf("expected LSN 42, got %d", lsn)
+	}
+
+	got := loaded.Tables["products"]
+	if got == nil || len(got.Columns) != 4 || !got.Columns[1].IsUnique {
+		t.Fatalf("schema not restored: %+v", got)
+	}
+
+	row, err := got.GetRowByPK(1)
+	if err != nil {
+		t.Fatalf("failed to get row by primary key: %v", err)
+	}
+
+	if row.Data["name"] != "mouse" || !row.Data["added_date"].(time.Time).Equal(added) {
+		t.Fatalf("row not restored: %+v", row.Data)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	db := NewDatabase()
+	db.CreateTable("users")
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := WriteSnapshot(path, db, 1); err != nil {
+		t.Fatalf("write snapshot failed: %v", err)
+	}
+
+	buf, _ := os.ReadFile(path)
+	buf[len(buf)-6] ^= 0xff
+	os.WriteFile(path, buf, 0o644)
+
+	if _, err := LoadSnapshot(path, NewDatabase()); err == nil {
+		t.Fatal("expected error loading corrupt snapshot, got nil")
+	}
+
+	// A missing snapshot just means there is nothing to load
+	if _, err := LoadSnapshot(filepath.Join(t.TempDir(), "missing.db"), NewDatabase()); err != nil {
+		t.Fatalf("expected no error for missing snapshot, got %v", err)
+	}
+}
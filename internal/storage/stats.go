@@ -0,0 +1,81 @@
+package storage
+
+// TableStats summarises the contents of a table for the query planner.
+type TableStats struct {
+	Rows    int
+	Columns map[string]*ColumnStats
+}
+
+// ColumnStats describes the values of one column. Min and Max are nil when
+// the column holds no values other than NULL.
+type ColumnStats struct {
+	Distinct int // distinct values, not counting NULL
+	Nulls    int
+	Min      any
+	Max      any
+}
+
+// Statistics are gathered by a full scan and reused until enough rows have
+// changed since, like PostgreSQL's autoanalyze: more than statsThreshold
+// plus statsScale times the number of rows. They are also gathered again
+// when the number of rows has grown or shrunk by more than statsDrift
+// times since, which covers statistics gathered while the table was still
+// empty or small.
+const (
+	statsThreshold = 50
+	statsScale     = 0.1
+	statsDrift     = 2
+)
+
+// Stats returns statistics about the table. The row count is always exact;
+// the column statistics may lag behind recent changes.
+func (t *Table) Stats() *TableStats {
+	if t.stats == nil || t.statsStale() {
+		t.stats = t.gatherStats()
+		t.changes = 0
+	}
+
+	stats := *t.stats
+	stats.Rows = t.count
+	return &stats
+}
+
+// statsStale reports whether the cached statistics should be gathered
+// again.
+func (t *Table) statsStale() bool {
+	if float64(t.changes) > statsThreshold+statsScale*float64(t.count) {
+		return true
+	}
+	gathered := t.stats.Rows
+	return t.count > statsDrift*gathered || gathered > statsDrift*t.count
+}
+
+// gatherStats scans the table and computes fresh statistics.
+func (t *Table) gatherStats() *TableStats {
+	stats := &TableStats{Rows: t.count, Columns: make(map[string]*ColumnStats, len(t.Columns))}
+
+	for _, col := range t.Columns {
+		cs := &ColumnStats{}
+		seen := make(map[any]bool)
+		t.Scan(func(_ RowID, row *Row) bool {
+			v := row.Data[col.Name]
+			if v == nil {
+				cs.Nulls++
+				return true
+			}
+
+			seen[v] = true
+			if cs.Min == nil || compareKeyValues(v, cs.Min) < 0 {
+				cs.Min = v
+			}
+			if cs.Max == nil || compareKeyValues(v, cs.Max) > 0 {
+				cs.Max = v
+			}
+			return true
+		})
+		cs.Distinct = len(seen)
+		stats.Columns[col.Name] = cs
+	}
+
+	return stats
+}
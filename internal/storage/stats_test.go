@@ -0,0 +1,48 @@
+package storage
+
+import "testing"
+
+func TestTableStats(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("items")
+	table.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	table.AddColumn(&Column{Name: "price", ColumnType: FloatType})
+
+	for i, price := range []any{2.5, nil, 10.0, 2.5} {
+		table.AppendRow(&Row{Data: map[string]any{"id": int64(i), "price": price}})
+	}
+
+	stats := table.Stats()
+	price := stats.Columns["price"]
+	if stats.Rows != 4 || price.Distinct != 2 || price.Nulls != 1 || price.Min != 2.5 || price.Max != 10.0 {
+		t.Fatalf("unexpected stats: %+v %+v", stats, price)
+	}
+
+	// A few changes only update the row count
+	table.AppendRow(&Row{Data: map[string]any{"id": int64(10), "price": 99.0}})
+	stats = table.Stats()
+	if stats.Rows != 5 || stats.Columns["price"].Max != 10.0 {
+		t.Fatalf("expected cached column stats with a fresh row count: %+v", stats)
+	}
+
+	// Many changes make them stale
+	for i := 11; i < 11+2*statsThreshold; i++ {
+		table.AppendRow(&Row{Data: map[string]any{"id": int64(i), "price": 1.0}})
+	}
+	stats = table.Stats()
+	if stats.Columns["price"].Max != 99.0 || stats.Columns["price"].Min != 1.0 || stats.Columns["id"].Distinct != stats.Rows {
+		t.Fatalf("stats were not refreshed: %+v", stats.Columns["price"])
+	}
+
+	// Stats gathered on an empty table do not outlive its first rows
+	empty, _ := db.CreateTable("empty")
+	empty.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	empty.Stats()
+	for i := 0; i < 40; i++ {
+		empty.AppendRow(&Row{Data: map[string]any{"id": int64(i)}})
+	}
+	stats = empty.Stats()
+	if id := stats.Columns["id"]; id.Distinct != 40 || id.Min != int64(0) || id.Max != int64(39) {
+		t.Fatalf("stats of a filled table were not refreshed: %+v", id)
+	}
+}
@@ -1,14 +1,25 @@
 package storage
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Table represents a database table with a name, schema (columns),
 // row data, and a primary key index.
 type Table struct {
 	Name         string
 	Columns      []*Column
-	Rows         []*Row
-	PrimaryIndex map[any]int // Maps primary key values to row indices
+	PrimaryIndex map[any]RowID // Maps primary key values to row IDs
+	Constraints  []*Constraint // Multi-column UNIQUE constraints
+	Indexes      []*Index      // Secondary indexes from CREATE INDEX
+
+	rows  []*Row   // slot per RowID, nil once the row is deleted
+	free  freeList // IDs of deleted rows
+	count int      // rows in use
+
+	stats   *TableStats // cached by Stats
+	changes int         // rows written since stats were gathered
 }
 
 // AddColumn adds a new column to the table schema.
@@ -30,7 +41,17 @@ func (t *Table) AddColumn(c *Column) error {
 		}
 	}
 
+	if c.Identity != "" {
+		if c.ColumnType != IntType {
+			return fmt.Errorf("identity column %s must be INT", c.Name)
+		}
+		if c.Default != nil {
+			return fmt.Errorf("identity column %s cannot have a DEFAULT", c.Name)
+		}
+	}
+
 	t.Columns = append(t.Columns, c)
+	t.stats = nil
 	return nil
 }
 
@@ -53,90 +74,263 @@ func (t *Table) DropColumn(name string) error {
 	return fmt.Errorf("column %s does not exist", name)
 }
 
+// Insert prepares a row with PrepareInsert, validates it against the
+// table's constraints and appends it.
 func (t *Table) Insert(row *Row) error {
 	if row == nil {
 		return fmt.Errorf("row cannot be nil")
 	}
 
-	// Find primary key column
-	var pkColumn *Column
-	for _, col := range t.Columns {
-		if col.IsPrimaryKey {
-			pkColumn = col
-			break
-		}
+	data, err := t.PrepareInsert(row.Data)
+	if err != nil {
+		return err
 	}
+	row.Data = data
 
-	if pkColumn == nil {
-		return fmt.Errorf("table has no primary key")
+	if err := t.ValidateInsert(row); err != nil {
+		return err
 	}
 
-	pkValue, exists := row.Data[pkColumn.Name]
-	if !exists {
-		return fmt.Errorf("primary key %s missing", pkColumn.Name)
+	t.AppendRow(row)
+	return nil
+}
+
+// ValidateInsert reports whether row could be inserted into the table
+// without violating the primary key, column or UNIQUE constraints. The
+// table is not modified.
+func (t *Table) ValidateInsert(row *Row) error {
+	if row == nil {
+		return fmt.Errorf("row cannot be nil")
+	}
+
+	return t.validate(nil, nil, []map[string]any{row.Data})
+}
+
+// ValidateUpdate reports whether the updates, keyed by row ID, could
+// be applied together without violating any constraint. The updates are
+// checked as one statement: a row may take a key another updated row gives
+// up. The table is not modified.
+func (t *Table) ValidateUpdate(updates map[RowID]map[string]any) error {
+	return t.validate(updates, nil, nil)
+}
+
+// validate checks the table as it would look after applying updates to the
+// rows with their IDs, removing the deleted rows and adding inserted. Only
+// the changed rows are checked against the NOT NULL, CHECK and key
+// constraints; rows that are left alone are trusted.
+func (t *Table) validate(updates map[RowID]map[string]any, deleted map[RowID]bool, inserted []map[string]any) error {
+	ids := make([]RowID, 0, len(updates))
+	for id := range updates {
+		if err := t.checkRowID(id); err != nil {
+			return err
+		}
+		if !deleted[id] {
+			ids = append(ids, id)
+		}
+	}
+	SortRowIDs(ids)
+
+	// Build the new image of every changed row
+	changed := make([]map[string]any, 0, len(updates)+len(inserted))
+	for _, id := range ids {
+		row := t.rows[id]
+		image := make(map[string]any, len(row.Data))
+		for col, val := range row.Data {
+			image[col] = val
+		}
+		for col, val := range updates[id] {
+			image[col] = val
+		}
+		changed = append(changed, image)
 	}
+	changed = append(changed, inserted...)
 
-	// Enforce primary key uniqueness
-	if _, exists := t.PrimaryIndex[pkValue]; exists {
-		return fmt.Errorf("duplicate primary key value %v", pkValue)
+	//  Ensure column exists before writing data to it
+	for _, data := range changed {
+		for key := range data {
+			if t.column(key) == nil {
+				return fmt.Errorf("column %s does not exist in table %s", key, t.Name)
+			}
+		}
 	}
-	
-	//  Ensure column exists before inserting data to it
-	for key := range row.Data {
-		found := false
+
+	for _, data := range changed {
+		for _, col := range t.primaryKeyColumns() {
+			if v, exists := data[col.Name]; !exists || v == nil {
+				return fmt.Errorf("primary key %s missing", col.Name)
+			}
+		}
+
+		// Enforce NOT NULL and CHECK
 		for _, col := range t.Columns {
-			if col.Name == key {
-				found = true
-				break
+			if err := col.CheckValue(data); err != nil {
+				return err
 			}
 		}
-		if !found {
-			return fmt.Errorf("column %s does not exist in table %s", key, t.Name)
+		if err := t.checkRow(data); err != nil {
+			return err
 		}
 	}
 
-	// Enforce UNIQUE constraints (non-primary)
-	for _, col := range t.Columns {
-		if col.IsUnique && !col.IsPrimaryKey {
-			newVal := row.Data[col.Name]
-
-			for _, existingRow := range t.Rows {
-				if existingRow.Data[col.Name] == newVal {
-					return fmt.Errorf(
-						"duplicate value %v for unique column %s",
-						newVal,
-						col.Name,
-					)
+	// Enforce the primary key, every UNIQUE constraint and UNIQUE index
+	for _, key := range t.uniqueKeys() {
+		// Keys with an index only look up the changed rows' keys; the
+		// others collect the keys of every unchanged row
+		lookup := t.keyLookup(key)
+
+		seen := make(map[any]bool, len(changed))
+		if lookup == nil {
+			t.Scan(func(id RowID, row *Row) bool {
+				if _, replaced := updates[id]; !replaced && !deleted[id] && !key.hasNull(row.Data) {
+					seen[key.value(row.Data)] = true
+				}
+				return true
+			})
+		}
+
+		for _, data := range changed {
+			// NULL is never equal to anything, so it cannot collide
+			if key.hasNull(data) {
+				continue
+			}
+
+			v := key.value(data)
+			if seen[v] {
+				return key.duplicate(data)
+			}
+			seen[v] = true
+
+			if lookup == nil {
+				continue
+			}
+			for _, id := range lookup(data) {
+				if _, replaced := updates[id]; !replaced && !deleted[id] {
+					return key.duplicate(data)
 				}
 			}
 		}
 	}
 
-	// Insert row
-	t.Rows = append(t.Rows, row)
-	t.PrimaryIndex[pkValue] = len(t.Rows) - 1
+	return nil
+}
 
+// keyLookup returns a function finding the IDs of the rows that share
+// data's value of key, using the primary index or a secondary index over
+// the key's columns. It returns nil when no index covers the key.
+func (t *Table) keyLookup(key uniqueKey) func(data map[string]any) []RowID {
+	if key.primary {
+		return func(data map[string]any) []RowID {
+			if id, ok := t.PrimaryIndex[key.value(data)]; ok {
+				return []RowID{id}
+			}
+			return nil
+		}
+	}
+
+	for _, ix := range t.Indexes {
+		if ix.covers(key.columns) {
+			ix := ix
+			return func(data map[string]any) []RowID {
+				return ix.Lookup(ix.keyOf(data))
+			}
+		}
+	}
 	return nil
 }
 
-// GetRows returns all rows in the table
-func (t *Table) GetRows() []*Row {
-	return t.Rows
+// uniqueKey is a set of columns whose values must be unique across rows.
+type uniqueKey struct {
+	columns []string
+	primary bool
+	index   string // set for UNIQUE indexes
 }
 
-// GetRowByPK retrieves a row by primary key value
-func (t *Table) GetRowByPK(pk any) (*Row, error) {
+// uniqueKeys lists the primary key followed by the single and multi-column
+// UNIQUE constraints of the table.
+func (t *Table) uniqueKeys() []uniqueKey {
+	var keys []uniqueKey
 
-	if t.PrimaryIndex == nil {
-		return nil, fmt.Errorf("table has no primary key index")
+	if pk := t.primaryKeyColumns(); len(pk) > 0 {
+		key := uniqueKey{primary: true}
+		for _, col := range pk {
+			key.columns = append(key.columns, col.Name)
+		}
+		keys = append(keys, key)
 	}
 
-	index, exists := t.PrimaryIndex[pk]
-	if !exists {
-		return nil, fmt.Errorf("row with primary key %v not found", pk)
+	for _, col := range t.Columns {
+		if col.IsUnique && !col.IsPrimaryKey {
+			keys = append(keys, uniqueKey{columns: []string{col.Name}})
+		}
+	}
+
+	for _, c := range t.Constraints {
+		if c.Type == UniqueConstraint {
+			keys = append(keys, uniqueKey{columns: c.Columns})
+		}
+	}
+
+	for _, ix := range t.Indexes {
+		if ix.Unique {
+			keys = append(keys, uniqueKey{columns: ix.Columns, index: ix.Name})
+		}
+	}
+
+	return keys
+}
+
+// value returns the comparable key of data for this constraint.
+func (k uniqueKey) value(data map[string]any) any {
+	values := make([]any, len(k.columns))
+	for i, col := range k.columns {
+		values[i] = data[col]
+	}
+	return compositeKey(values)
+}
+
+// hasNull reports whether any column of the key is NULL in data.
+func (k uniqueKey) hasNull(data map[string]any) bool {
+	for _, col := range k.columns {
+		if data[col] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicate builds the error reported when data repeats an existing key.
+func (k uniqueKey) duplicate(data map[string]any) error {
+	switch {
+	case k.primary && len(k.columns) == 1:
+		return fmt.Errorf("duplicate primary key value %v", data[k.columns[0]])
+	case k.primary:
+		parts := make([]string, len(k.columns))
+		for i, col := range k.columns {
+			parts[i] = fmt.Sprintf("%v", data[col])
+		}
+		return fmt.Errorf("duplicate primary key value (%s)", strings.Join(parts, ", "))
+	case k.index != "":
+		values := make([]any, len(k.columns))
+		for i, col := range k.columns {
+			values[i] = data[col]
+		}
+		return fmt.Errorf("duplicate key (%s)=(%s) violates unique index %s", strings.Join(k.columns, ", "), formatKey(values), k.index)
+	case len(k.columns) == 1:
+		return fmt.Errorf("duplicate value %v for unique column %s", data[k.columns[0]], k.columns[0])
+	default:
+		return fmt.Errorf("duplicate value for unique columns (%s)", strings.Join(k.columns, ", "))
+	}
+}
+
+// GetRowByPK retrieves a row by primary key value. Tables with a composite
+// primary key are looked up with a []any holding one value per key column.
+func (t *Table) GetRowByPK(pk any) (*Row, error) {
+	id, err := t.RowIDByPK(pk)
+	if err != nil {
+		return nil, err
 	}
 
-	return t.Rows[index], nil
+	return t.rows[id], nil
 }
 
 // FilterRows returns all rows matching a column-value pair
@@ -155,72 +349,248 @@ func (t *Table) FilterRows(column string, value any) ([]*Row, error) {
 		return nil, fmt.Errorf("column %s does not exist", column)
 	}
 
-	for _, row := range t.Rows {
+	value, err := Coerce(value, t.column(column).ColumnType)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", column, err)
+	}
+
+	if ix := t.IndexOn(column); ix != nil && value != nil {
+		for _, id := range SortRowIDs(ix.Lookup([]any{value})) {
+			result = append(result, t.rows[id])
+		}
+		return result, nil
+	}
+
+	t.Scan(func(_ RowID, row *Row) bool {
 		if v, ok := row.Data[column]; ok && v == value {
 			result = append(result, row)
 		}
-	}
+		return true
+	})
 
 	return result, nil
 }
 
-// Update updates a row identified by its primary key
+// Update updates a row identified by its primary key. The new values are
+// converted to their column types and checked against every constraint,
+// and the primary index follows the row if its key changes.
 func (t *Table) Update(pk any, updates map[string]any) error {
-	if t.PrimaryIndex == nil {
-		return fmt.Errorf("table has no primary key index")
+	id, err := t.RowIDByPK(pk)
+	if err != nil {
+		return err
 	}
 
-	index, exists := t.PrimaryIndex[pk]
+	updates, err = t.Coerce(updates)
+	if err != nil {
+		return err
+	}
+
+	if err := t.ValidateUpdate(map[RowID]map[string]any{id: updates}); err != nil {
+		return err
+	}
+
+	return t.UpdateAt(id, updates)
+}
+
+// Delete removes a row by primary key
+func (t *Table) Delete(pk any) error {
+	id, err := t.RowIDByPK(pk)
+	if err != nil {
+		return err
+	}
+
+	return t.DeleteAt(id)
+}
+
+// RowIDByPK returns the ID of the row with the given primary key.
+// Tables with a composite primary key are looked up with a []any.
+func (t *Table) RowIDByPK(pk any) (RowID, error) {
+	if len(t.primaryKeyColumns()) == 0 {
+		return 0, fmt.Errorf("table %s has no primary key", t.Name)
+	}
+
+	id, exists := t.PrimaryIndex[t.lookupKey(pk)]
 	if !exists {
-		return fmt.Errorf("row with primary key %v not found", pk)
+		return 0, fmt.Errorf("row with primary key %v not found", pk)
 	}
 
-	row := t.Rows[index]
+	return id, nil
+}
 
-	// Update each column
-	for colName, newValue := range updates {
-		found := false
-		for _, col := range t.Columns {
-			if col.Name == colName {
-				row.Data[colName] = newValue
-				found = true
+// AppendRow stores a row without any constraint checks, indexing its
+// primary key if the table has one and adding it to every secondary index.
+// The row takes the lowest free ID, which is returned.
+func (t *Table) AppendRow(row *Row) RowID {
+	id := t.store(row)
+
+	if key, ok := t.primaryKey(row); ok {
+		t.PrimaryIndex[key] = id
+	}
+	for _, ix := range t.Indexes {
+		ix.add(row.Data, id)
+	}
+	return id
+}
+
+// UpdateAt overwrites the given columns of the row with the given ID.
+func (t *Table) UpdateAt(id RowID, updates map[string]any) error {
+	if err := t.checkRowID(id); err != nil {
+		return err
+	}
+
+	row := t.rows[id]
+	oldKey, hadKey := t.primaryKey(row)
+
+	// Take the row out of the indexes whose columns change
+	var touched []*Index
+	for _, ix := range t.Indexes {
+		for _, col := range ix.Columns {
+			if _, ok := updates[col]; ok {
+				touched = append(touched, ix)
+				ix.remove(row.Data, id)
 				break
 			}
 		}
-		if !found {
-			return fmt.Errorf("column %s does not exist", colName)
+	}
+
+	for col, val := range updates {
+		row.Data[col] = val
+	}
+	t.changes++
+
+	for _, ix := range touched {
+		ix.add(row.Data, id)
+	}
+
+	// Re-index the row if its primary key changed
+	if newKey, ok := t.primaryKey(row); ok || hadKey {
+		if hadKey {
+			delete(t.PrimaryIndex, oldKey)
+		}
+		if ok {
+			t.PrimaryIndex[newKey] = id
 		}
 	}
 
 	return nil
 }
 
-// Delete removes a row by primary key
-func (t *Table) Delete(pk any) error {
-	if t.PrimaryIndex == nil {
-		return fmt.Errorf("table has no primary key index")
+// DeleteAt removes the row with the given ID from the primary and
+// secondary indexes and frees its slot. No other row is affected.
+func (t *Table) DeleteAt(id RowID) error {
+	if err := t.checkRowID(id); err != nil {
+		return err
 	}
 
-	index, exists := t.PrimaryIndex[pk]
-	if !exists {
-		return fmt.Errorf("row with primary key %v not found", pk)
+	row := t.rows[id]
+	if key, ok := t.primaryKey(row); ok {
+		delete(t.PrimaryIndex, key)
+	}
+	for _, ix := range t.Indexes {
+		ix.remove(row.Data, id)
 	}
+	t.release(id)
 
-	// Remove row from slice
-	t.Rows = append(t.Rows[:index], t.Rows[index+1:]...)
+	return nil
+}
 
-	// Remove from primary index
-	delete(t.PrimaryIndex, pk)
+// DeleteRows removes the rows with the given IDs. Either all of them are
+// removed or, if one does not exist, none.
+func (t *Table) DeleteRows(ids []RowID) error {
+	for _, id := range ids {
+		if err := t.checkRowID(id); err != nil {
+			return err
+		}
+	}
 
-	// Update indexes for remaining rows
-	for i := index; i < len(t.Rows); i++ {
-		for _, col := range t.Columns {
-			if col.IsPrimaryKey {
-				t.PrimaryIndex[t.Rows[i].Data[col.Name]] = i
-				break
-			}
+	for _, id := range ids {
+		if t.rows[id] == nil {
+			continue // listed twice
+		}
+		if err := t.DeleteAt(id); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// column returns the column with the given name, or nil.
+func (t *Table) column(name string) *Column {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// primaryKeyColumns returns the primary key columns in table order. It is
+// empty if the table has no primary key.
+func (t *Table) primaryKeyColumns() []*Column {
+	var cols []*Column
+	for _, col := range t.Columns {
+		if col.IsPrimaryKey {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// primaryKey returns the PrimaryIndex key of row. A single-column key is the
+// column value itself; a composite key is an encoding of all key values.
+// ok is false if the table has no primary key or row lacks a key value.
+func (t *Table) primaryKey(row *Row) (any, bool) {
+	cols := t.primaryKeyColumns()
+	if len(cols) == 0 {
+		return nil, false
+	}
+
+	values := make([]any, len(cols))
+	for i, col := range cols {
+		v, exists := row.Data[col.Name]
+		if !exists {
+			return nil, false
+		}
+		values[i] = v
+	}
+
+	return compositeKey(values), true
+}
+
+// lookupKey converts a primary key passed in by a caller into the form
+// stored in PrimaryIndex, so GetRowByPK(1) finds the INT key int64(1).
+func (t *Table) lookupKey(pk any) any {
+	values, ok := pk.([]any)
+	if !ok {
+		values = []any{pk}
+	}
+
+	cols := t.primaryKeyColumns()
+	key := make([]any, len(values))
+	for i, v := range values {
+		key[i] = v
+		if i < len(cols) {
+			if c, err := Coerce(v, cols[i].ColumnType); err == nil {
+				key[i] = c
+			}
+		}
+	}
+	return compositeKey(key)
+}
+
+// compositeKey returns a comparable map key for values. A single value is
+// used as is so that GetRowByPK(1) keeps working for simple keys.
+func compositeKey(values []any) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	e := &encoder{}
+	for _, v := range values {
+		if err := e.value(v); err != nil {
+			e.string(fmt.Sprintf("%T:%v", v, v))
+		}
+	}
+	return string(e.buf)
+}
@@ -201,7 +201,7 @@ func TestReadRows(t *testing.T) {
 
 	// Filter rows
 	filtered, err := table.FilterRows("name", "Bob")
-	if err != nil || len(filtered) != 1 || filtered[0].Data["id"] != 2 {
+	if err != nil || len(filtered) != 1 || filtered[0].Data["id"] != int64(2) {
 		t.Fatal("failed to filter row by column")
 	}
 }
@@ -310,7 +310,7 @@ func TestDeleteRow(t *testing.T) {
 	}
 
 	// Verify deletion
-	if len(table.Rows) != 0 {
+	if table.Len() != 0 {
 		t.Fatal("row was not deleted")
 	}
 
@@ -321,3 +321,79 @@ func TestDeleteRow(t *testing.T) {
 
 	t.Log("row deleted successfully")
 }
+
+func TestCompositeKeyConstraints(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("enrollments")
+	table.AddColumn(&Column{Name: "student_id", ColumnType: IntType})
+	table.AddColumn(&Column{Name: "course_id", ColumnType: IntType})
+	table.AddColumn(&Column{Name: "seat", ColumnType: IntType})
+	table.AddColumn(&Column{Name: "room", ColumnType: TextType})
+
+	if err := table.AddConstraint(&Constraint{Type: PrimaryKeyConstraint, Columns: []string{"student_id", "course_id"}}); err != nil {
+		t.Fatalf("failed to add primary key: %v", err)
+	}
+	if err := table.AddConstraint(&Constraint{Type: UniqueConstraint, Columns: []string{"seat", "room"}}); err != nil {
+		t.Fatalf("failed to add unique constraint: %v", err)
+	}
+	if err := table.AddConstraint(&Constraint{Type: PrimaryKeyConstraint, Columns: []string{"seat"}}); err == nil {
+		t.Fatal("expected error adding a second primary key, got nil")
+	}
+
+	if err := table.Insert(&Row{Data: map[string]any{"student_id": 1, "course_id": 1, "seat": 1, "room": "A"}}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if err := table.Insert(&Row{Data: map[string]any{"student_id": 1, "course_id": 2, "seat": 1, "room": "B"}}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	// Same composite key
+	if err := table.Insert(&Row{Data: map[string]any{"student_id": 1, "course_id": 2, "seat": 2, "room": "B"}}); err == nil {
+		t.Fatal("expected duplicate primary key error, got nil")
+	}
+
+	// Same (seat, room) tuple
+	if err := table.Insert(&Row{Data: map[string]any{"student_id": 2, "course_id": 1, "seat": 1, "room": "A"}}); err == nil {
+		t.Fatal("expected duplicate unique tuple error, got nil")
+	}
+
+	// Primary key columns are NOT NULL
+	if err := table.Insert(&Row{Data: map[string]any{"student_id": 3, "course_id": nil}}); err == nil {
+		t.Fatal("expected NOT NULL error, got nil")
+	}
+
+	row, err := table.GetRowByPK([]any{1, 2})
+	if err != nil || row.Data["room"] != "B" {
+		t.Fatalf("failed to get row by composite key: %v", err)
+	}
+
+	if err := table.Delete([]any{1, 1}); err != nil {
+		t.Fatalf("delete by composite key failed: %v", err)
+	}
+	if _, err := table.GetRowByPK([]any{1, 2}); err != nil {
+		t.Fatalf("primary index not shifted after delete: %v", err)
+	}
+}
+
+func TestUniqueAllowsNulls(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("people")
+	table.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	table.AddColumn(&Column{Name: "email", ColumnType: TextType, IsUnique: true})
+
+	for i := 1; i <= 2; i++ {
+		if err := table.Insert(&Row{Data: map[string]any{"id": i, "email": nil}}); err != nil {
+			t.Fatalf("insert of NULL into unique column failed: %v", err)
+		}
+	}
+	if err := table.Insert(&Row{Data: map[string]any{"id": 3}}); err != nil {
+		t.Fatalf("insert without unique column failed: %v", err)
+	}
+	if v, exists := table.Row(2).Data["email"]; !exists || v != nil {
+		t.Fatalf("omitted column should be stored as NULL, got %v (present: %v)", v, exists)
+	}
+
+	if err := table.Insert(&Row{Data: map[string]any{"id": nil}}); err == nil {
+		t.Fatal("expected error for NULL primary key, got nil")
+	}
+}
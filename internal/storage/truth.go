@@ -0,0 +1,48 @@
+package storage
+
+// Truth is the result of a SQL condition under three-valued logic. Any
+// comparison involving NULL is Unknown, and WHERE keeps a row only when its
+// condition is True.
+type Truth int8
+
+const (
+	False Truth = iota
+	Unknown
+	True
+)
+
+// TruthOf converts a Go bool to True or False.
+func TruthOf(b bool) Truth {
+	if b {
+		return True
+	}
+	return False
+}
+
+// And is False if either side is False, otherwise Unknown if either side
+// is Unknown.
+func (t Truth) And(o Truth) Truth {
+	return min(t, o)
+}
+
+// Or is True if either side is True, otherwise Unknown if either side is
+// Unknown.
+func (t Truth) Or(o Truth) Truth {
+	return max(t, o)
+}
+
+// Not swaps True and False and leaves Unknown alone.
+func (t Truth) Not() Truth {
+	return True - t
+}
+
+func (t Truth) String() string {
+	switch t {
+	case True:
+		return "TRUE"
+	case False:
+		return "FALSE"
+	default:
+		return "UNKNOWN"
+	}
+}
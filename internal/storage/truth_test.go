@@ -0,0 +1,32 @@
+package storage
+
+import "testing"
+
+func TestTruthLogic(t *testing.T) {
+	cases := []struct {
+		a, b    Truth
+		and, or Truth
+	}{
+		{True, True, True, True},
+		{True, Unknown, Unknown, True},
+		{True, False, False, True},
+		{Unknown, Unknown, Unknown, Unknown},
+		{Unknown, False, False, Unknown},
+		{False, False, False, False},
+	}
+	for _, tc := range cases {
+		if got := tc.a.And(tc.b); got != tc.and {
+			t.Errorf("%s AND %s = %s, want %s", tc.a, tc.b, got, tc.and)
+		}
+		if got := tc.b.And(tc.a); got != tc.and {
+			t.Errorf("%s AND %s = %s, want %s", tc.b, tc.a, got, tc.and)
+		}
+		if got := tc.a.Or(tc.b); got != tc.or {
+			t.Errorf("%s OR %s = %s, want %s", tc.a, tc.b, got, tc.or)
+		}
+	}
+
+	if True.Not() != False || False.Not() != True || Unknown.Not() != Unknown {
+		t.Error("NOT does not follow three-valued logic")
+	}
+}
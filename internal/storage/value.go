@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rows hold values of a small set of Go types, one per ColumnType:
+//
+//	INT   int64
+//	FLOAT float64
+//	BOOL  bool
+//	TEXT  string
+//	DATE  time.Time (midnight UTC)
+//
+// A nil value is NULL in any column.
+
+// DateLayout is the text form of DATE values.
+const DateLayout = "2006-01-02"
+
+// TypeOf returns the column type a value belongs to, or a description of
+// the Go type if it is not a storable value.
+func TypeOf(v any) ColumnType {
+	switch v.(type) {
+	case nil:
+		return "NULL"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return IntType
+	case float32, float64:
+		return FloatType
+	case bool:
+		return BoolType
+	case string:
+		return TextType
+	case time.Time:
+		return DateType
+	default:
+		return ColumnType(fmt.Sprintf("%T", v))
+	}
+}
+
+// Coerce converts v to the canonical Go type for a column of type t. Only
+// conversions that cannot lose information are made implicitly: integers
+// widen to FLOAT and text in YYYY-MM-DD form is accepted for DATE.
+// Anything else is a type mismatch. NULL is returned unchanged.
+func Coerce(v any, t ColumnType) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case IntType:
+		if i, ok := toInt64(v); ok {
+			return i, nil
+		}
+	case FloatType:
+		if f, ok := toFloat64(v); ok {
+			return f, nil
+		}
+	case BoolType:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case TextType:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case DateType:
+		switch x := v.(type) {
+		case time.Time:
+			return truncateDate(x), nil
+		case string:
+			if d, err := time.Parse(DateLayout, x); err == nil {
+				return d, nil
+			}
+		}
+	default:
+		// Columns without a known type accept any value
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("cannot assign %s value %s to %s column", TypeOf(v), quoteValue(v), t)
+}
+
+// Cast converts v to type t the way CAST(v AS t) does. Beyond what Coerce
+// allows it parses text, rounds FLOAT to INT, maps BOOL to and from
+// integers and renders any value as TEXT.
+func Cast(v any, t ColumnType) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	if c, err := Coerce(v, t); err == nil {
+		return c, nil
+	}
+
+	switch t {
+	case IntType:
+		switch x := v.(type) {
+		case float32, float64:
+			f, _ := toFloat64(x)
+			if math.IsNaN(f) || f > math.MaxInt64 || f < math.MinInt64 {
+				break
+			}
+			return int64(math.Round(f)), nil
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case FloatType:
+		if s, ok := v.(string); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return f, nil
+			}
+		}
+	case BoolType:
+		switch x := v.(type) {
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(x)); err == nil {
+				return b, nil
+			}
+		default:
+			if i, ok := toInt64(x); ok {
+				return i != 0, nil
+			}
+		}
+	case TextType:
+		return FormatValue(v), nil
+	case DateType:
+		if s, ok := v.(string); ok {
+			if d, err := time.Parse(DateLayout, strings.TrimSpace(s)); err == nil {
+				return d, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("cannot cast %s value %s to %s", TypeOf(v), quoteValue(v), t)
+}
+
+// Compare orders two non-NULL values of compatible types, returning -1, 0
+// or 1. INT and FLOAT values compare numerically with each other.
+func Compare(a, b any) (int, error) {
+	if ai, ok := toInt64(a); ok {
+		if bi, ok := toInt64(b); ok {
+			return compareOrdered(ai, bi), nil
+		}
+	}
+	if af, ok := toFloat64(a); ok {
+		if bf, ok := toFloat64(b); ok {
+			return compareOrdered(af, bf), nil
+		}
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case y:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot compare %s with %s", TypeOf(a), TypeOf(b))
+}
+
+// FormatValue renders a value for display: DATE as YYYY-MM-DD, FLOAT in its
+// shortest form and NULL as NULL.
+func FormatValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return x.Format(DateLayout)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Coerce converts every value in data to the type of its column and
+// returns the converted copy. Values for unknown columns are copied as they
+// are and left for validation to report.
+func (t *Table) Coerce(data map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(data))
+	for name, v := range data {
+		col := t.column(name)
+		if col == nil {
+			out[name] = v
+			continue
+		}
+
+		c, err := Coerce(v, col.ColumnType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		out[name] = c
+	}
+	return out, nil
+}
+
+// PrepareInsert converts the values of a new row with Coerce and fills in
+// every column the row leaves out with its DEFAULT, or NULL.
+func (t *Table) PrepareInsert(data map[string]any) (map[string]any, error) {
+	out, err := t.Coerce(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, col := range t.Columns {
+		if _, exists := out[col.Name]; exists {
+			continue
+		}
+		if out[col.Name], err = col.DefaultValue(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func quoteValue(v any) string {
+	if s, ok := v.(string); ok {
+		return "'" + s + "'"
+	}
+	return FormatValue(v)
+}
+
+func truncateDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func toInt64(v any) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint:
+		return int64(x), uint64(x) <= math.MaxInt64
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		return int64(x), x <= math.MaxInt64
+	}
+	return 0, false
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoerce(t *testing.T) {
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	valid := []struct {
+		typ  ColumnType
+		in   any
+		want any
+	}{
+		{IntType, 7, int64(7)},
+		{IntType, int32(-3), int64(-3)},
+		{FloatType, 2, float64(2)},
+		{FloatType, 2.5, 2.5},
+		{BoolType, true, true},
+		{TextType, "hi", "hi"},
+		{DateType, "2024-01-02", date},
+		{DateType, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), date},
+		{IntType, nil, nil},
+	}
+	for _, tc := range valid {
+		got, err := Coerce(tc.in, tc.typ)
+		if err != nil || got != tc.want {
+			t.Errorf("Coerce(%v, %s) = %v (%T), %v; want %v (%T)", tc.in, tc.typ, got, got, err, tc.want, tc.want)
+		}
+	}
+
+	invalid := []struct {
+		typ ColumnType
+		in  any
+	}{
+		{IntType, 3.5},
+		{IntType, "12"},
+		{TextType, 12},
+		{BoolType, "true"},
+		{DateType, "January 2"},
+	}
+	for _, tc := range invalid {
+		if _, err := Coerce(tc.in, tc.typ); err == nil {
+			t.Errorf("Coerce(%v, %s) succeeded, want type mismatch", tc.in, tc.typ)
+		}
+	}
+}
+
+func TestCast(t *testing.T) {
+	cases := []struct {
+		typ  ColumnType
+		in   any
+		want any
+	}{
+		{IntType, "42", int64(42)},
+		{IntType, 2.6, int64(3)},
+		{FloatType, "1.5", 1.5},
+		{BoolType, "false", false},
+		{TextType, int64(5), "5"},
+		{TextType, 0.25, "0.25"},
+		{DateType, "2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		got, err := Cast(tc.in, tc.typ)
+		if err != nil || got != tc.want {
+			t.Errorf("Cast(%v, %s) = %v, %v; want %v", tc.in, tc.typ, got, err, tc.want)
+		}
+	}
+
+	if _, err := Cast("abc", IntType); err == nil {
+		t.Error("Cast('abc', INT) succeeded, want error")
+	}
+}
+
+func TestTableRejectsMismatchedTypes(t *testing.T) {
+	db := NewDatabase()
+	table, _ := db.CreateTable("items")
+	table.AddColumn(&Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true})
+	table.AddColumn(&Column{Name: "price", ColumnType: FloatType})
+
+	if err := table.Insert(&Row{Data: map[string]any{"id": 1, "price": 3}}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if table.Row(0).Data["price"] != float64(3) {
+		t.Fatalf("INT was not widened to FLOAT: %T", table.Row(0).Data["price"])
+	}
+
+	if err := table.Insert(&Row{Data: map[string]any{"id": "two"}}); err == nil {
+		t.Fatal("expected type mismatch for TEXT in INT column, got nil")
+	}
+	if err := table.Update(1, map[string]any{"price": "cheap"}); err == nil {
+		t.Fatal("expected type mismatch on update, got nil")
+	}
+
+	if _, err := table.GetRowByPK(int64(1)); err != nil {
+		t.Fatalf("lookup by int64 key failed: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// RecordType identifies the operation stored in a WAL record.
+type RecordType byte
+
+const (
+	RecordCreateTable RecordType = iota + 1
+	RecordAddColumn
+	RecordInsert
+	RecordUpdate
+	RecordDelete
+	RecordAddConstraint
+	RecordAddForeignKey
+	RecordCreateSequence
+	RecordSetSequence
+	RecordCreateIndex
+	RecordDropIndex
+)
+
+// Record is a single logged change. Rows are addressed by their RowID.
+// An insert does not carry the ID: the row takes the lowest free ID again
+// on replay, so records must be replayed in LSN order against the state
+// they were written from.
+type Record struct {
+	LSN        uint64
+	Type       RecordType
+	Table      string
+	Column     *Column        // RecordAddColumn
+	Constraint *Constraint    // RecordAddConstraint
+	ForeignKey *ForeignKey    // RecordAddForeignKey
+	Sequence   *Sequence      // RecordCreateSequence, RecordSetSequence (Name and Value)
+	Index      *Index         // RecordCreateIndex, RecordDropIndex (Name only)
+	Row        RowID          // RecordUpdate, RecordDelete
+	Data       map[string]any // RecordInsert (full row), RecordUpdate (changed columns)
+}
+
+// recordHeaderSize is the framing in front of every record:
+// a 4 byte payload length followed by a 4 byte CRC32 of the payload.
+const recordHeaderSize = 8
+
+// WAL is an append-only write-ahead log. Every Append is fsynced before it
+// returns, so a change that has been acknowledged survives a crash.
+type WAL struct {
+	mu      sync.Mutex
+	file    *os.File
+	nextLSN uint64
+}
+
+// OpenWAL opens (or creates) the log file at path. Records already in the
+// file are not applied; call Replay for that.
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+
+	return &WAL{file: f, nextLSN: 1}, nil
+}
+
+// Replay reads every complete record in the log and passes it to apply in
+// order. A torn or corrupt record at the end of the file (left behind by a
+// crash in the middle of a write) is discarded and the file is truncated to
+// the last good record so that new appends start from a clean tail. A bad
+// record with others after it cannot have been torn by a crash, and is an
+// error rather than a reason to throw the later records away.
+func (w *WAL) Replay(apply func(*Record) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("replay wal: %w", err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("replay wal: %w", err)
+	}
+
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(w.file, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return fmt.Errorf("replay wal: %w", err)
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+
+		// A record longer than what is left was cut short by a crash
+		end := offset + int64(recordHeaderSize) + int64(size)
+		if end > info.Size() {
+			break
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(w.file, payload); err != nil {
+			return fmt.Errorf("replay wal: %w", err)
+		}
+
+		var rec *Record
+		if crc32.ChecksumIEEE(payload) != sum {
+			err = errors.New("checksum mismatch")
+		} else {
+			rec, err = decodeRecord(payload)
+		}
+		if err != nil {
+			if end == info.Size() {
+				break
+			}
+			return fmt.Errorf("replay wal: corrupt record at offset %d: %w", offset, err)
+		}
+
+		if err := apply(rec); err != nil {
+			return fmt.Errorf("replay wal record %d: %w", rec.LSN, err)
+		}
+
+		if rec.LSN >= w.nextLSN {
+			w.nextLSN = rec.LSN + 1
+		}
+		offset = end
+	}
+
+	// Drop anything after the last complete record
+	if err := w.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("replay wal: %w", err)
+	}
+
+	return w.file.Sync()
+}
+
+// Append assigns LSNs to the records, writes them to the end of the log and
+// fsyncs the file. Records passed in a single call are synced together.
+func (w *WAL) Append(recs ...*Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf []byte
+	for _, rec := range recs {
+		rec.LSN = w.nextLSN
+
+		payload, err := encodeRecord(rec)
+		if err != nil {
+			return fmt.Errorf("encode wal record: %w", err)
+		}
+
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+		buf = append(buf, payload...)
+
+		w.nextLSN++
+	}
+
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+
+	return w.file.Sync()
+}
+
+// LastLSN returns the LSN of the most recently appended record, or zero if
+// nothing has been logged yet.
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.nextLSN - 1
+}
+
+// SkipTo makes sure the next appended record gets an LSN of at least lsn.
+// It is used after loading a snapshot so that new records are never
+// mistaken for ones the snapshot already covers.
+func (w *WAL) SkipTo(lsn uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if lsn > w.nextLSN {
+		w.nextLSN = lsn
+	}
+}
+
+// Reset discards every record in the log. It is called once a snapshot
+// covering all of them has been safely written. LSNs keep increasing.
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+
+	return w.file.Sync()
+}
+
+// Close closes the underlying log file.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+func encodeRecord(rec *Record) ([]byte, error) {
+	e := &encoder{}
+	e.uvarint(rec.LSN)
+	e.byte(byte(rec.Type))
+	e.string(rec.Table)
+
+	switch rec.Type {
+	case RecordCreateTable:
+	case RecordAddColumn:
+		e.column(rec.Column)
+	case RecordAddConstraint:
+		e.constraint(rec.Constraint)
+	case RecordAddForeignKey:
+		e.foreignKey(rec.ForeignKey)
+	case RecordCreateSequence, RecordSetSequence:
+		e.sequence(rec.Sequence)
+	case RecordCreateIndex, RecordDropIndex:
+		e.index(rec.Index)
+	case RecordInsert:
+		if err := e.data(rec.Data); err != nil {
+			return nil, err
+		}
+	case RecordUpdate:
+		e.uvarint(uint64(rec.Row))
+		if err := e.data(rec.Data); err != nil {
+			return nil, err
+		}
+	case RecordDelete:
+		e.uvarint(uint64(rec.Row))
+	default:
+		return nil, fmt.Errorf("unknown record type %d", rec.Type)
+	}
+
+	return e.buf, nil
+}
+
+func decodeRecord(payload []byte) (*Record, error) {
+	d := &decoder{buf: payload}
+	rec := &Record{
+		LSN:   d.uvarint(),
+		Type:  RecordType(d.byte()),
+		Table: d.string(),
+	}
+
+	switch rec.Type {
+	case RecordCreateTable:
+	case RecordAddColumn:
+		rec.Column = d.column()
+	case RecordAddConstraint:
+		rec.Constraint = d.constraint()
+	case RecordAddForeignKey:
+		rec.ForeignKey = d.foreignKey()
+	case RecordCreateSequence, RecordSetSequence:
+		rec.Sequence = d.sequence()
+	case RecordCreateIndex, RecordDropIndex:
+		rec.Index = d.index()
+	case RecordInsert:
+		rec.Data = d.data()
+	case RecordUpdate:
+		rec.Row = RowID(d.uvarint())
+		rec.Data = d.data()
+	case RecordDelete:
+		rec.Row = RowID(d.uvarint())
+	default:
+		d.fail("unknown record type %d", rec.Type)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return rec, nil
+}
+
+// Apply performs the change described by rec against the database. It is
+// used during recovery to rebuild the in-memory state from the log.
+func (db *Database) Apply(rec *Record) error {
+	switch rec.Type {
+	case RecordCreateTable:
+		_, err := db.CreateTable(rec.Table)
+		return err
+	case RecordAddForeignKey:
+		return db.AddForeignKey(rec.ForeignKey)
+	case RecordCreateSequence:
+		seq := *rec.Sequence
+		return db.CreateSequence(&seq)
+	case RecordSetSequence:
+		seq, err := db.Sequence(rec.Sequence.Name)
+		if err != nil {
+			return err
+		}
+		seq.Set(rec.Sequence.Value)
+		return nil
+	case RecordCreateIndex:
+		ix := *rec.Index
+		return db.CreateIndex(&ix)
+	case RecordDropIndex:
+		return db.DropIndex(rec.Index.Name)
+	}
+
+	t, ok := db.Tables[rec.Table]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", rec.Table)
+	}
+
+	switch rec.Type {
+	case RecordAddColumn:
+		return t.AddColumn(rec.Column)
+	case RecordAddConstraint:
+		return t.AddConstraint(rec.Constraint)
+	case RecordInsert:
+		data, err := t.Coerce(rec.Data)
+		if err != nil {
+			return err
+		}
+		t.AppendRow(&Row{Data: data})
+		return nil
+	case RecordUpdate:
+		data, err := t.Coerce(rec.Data)
+		if err != nil {
+			return err
+		}
+		return t.UpdateAt(rec.Row, data)
+	case RecordDelete:
+		return t.DeleteAt(rec.Row)
+	default:
+		return fmt.Errorf("unknown record type %d", rec.Type)
+	}
+}
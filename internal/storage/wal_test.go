@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestLog(t *testing.T, path string) {
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("failed to open wal: %v", err)
+	}
+	defer wal.Close()
+
+	recs := []*Record{
+		{Type: RecordCreateTable, Table: "users"},
+		{Type: RecordAddColumn, Table: "users", Column: &Column{Name: "id", ColumnType: IntType, IsPrimaryKey: true}},
+		{Type: RecordAddColumn, Table: "users", Column: &Column{Name: "name", ColumnType: TextType}},
+		{Type: RecordInsert, Table: "users", Data: map[string]any{"id": 1, "name": "Alice"}},
+		{Type: RecordInsert, Table: "users", Data: map[string]any{"id": 2, "name": "Bob"}},
+		{Type: RecordUpdate, Table: "users", Row: 1, Data: map[string]any{"name": "Bobby"}},
+		{Type: RecordDelete, Table: "users", Row: 0},
+	}
+
+	for _, rec := range recs {
+		if err := wal.Append(rec); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	writeTestLog(t, path)
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("failed to reopen wal: %v", err)
+	}
+	defer wal.Close()
+
+	db := NewDatabase()
+	if err := wal.Replay(db.Apply); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	table, ok := db.Tables["users"]
+	if !ok {
+		t.Fatal("expected users table after replay")
+	}
+
+	if len(table.Columns) != 2 || !table.Columns[0].IsPrimaryKey {
+		t.Fatalf("unexpected columns after replay: %+v", table.Columns)
+	}
+
+	row, err := table.GetRowByPK(2)
+	if err != nil {
+		t.Fatalf("failed to get row by primary key: %v", err)
+	}
+
+	if table.Len() != 1 || row.Data["name"] != "Bobby" {
+		t.Fatalf("unexpected rows after replay: %+v", table.GetRows())
+	}
+
+	// New records continue the LSN sequence
+	rec := &Record{Type: RecordCreateTable, Table: "orders"}
+	if err := wal.Append(rec); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if rec.LSN != 8 {
+		t.Fatalf("expected LSN 8, got %d", rec.LSN)
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	writeTestLog(t, path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+
+	// Simulate a crash half way through writing the last record
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("failed to reopen wal: %v", err)
+	}
+	defer wal.Close()
+
+	db := NewDatabase()
+	if err := wal.Replay(db.Apply); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	// The delete was lost, everything before it was recovered
+	if db.Tables["users"].Len() != 2 {
+		t.Fatalf("expected 2 rows, got %d", db.Tables["users"].Len())
+	}
+
+	if err := wal.Append(&Record{Type: RecordDelete, Table: "users", Row: 0}); err != nil {
+		t.Fatalf("append after torn tail failed: %v", err)
+	}
+
+	db = NewDatabase()
+	if err := wal.Replay(db.Apply); err != nil {
+		t.Fatalf("second replay failed: %v", err)
+	}
+
+	if db.Tables["users"].Len() != 1 {
+		t.Fatalf("expected 1 row after second replay, got %d", db.Tables["users"].Len())
+	}
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	writeTestLog(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	// A bad record with good ones after it is reported, and nothing is
+	// truncated
+	bad := append([]byte{}, data...)
+	bad[len(bad)/2] ^= 0xff
+	if err := os.WriteFile(path, bad, 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("failed to reopen wal: %v", err)
+	}
+	if err := wal.Replay(NewDatabase().Apply); err == nil {
+		t.Fatal("expected error replaying a corrupt record, got nil")
+	}
+	wal.Close()
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("wal was truncated after a corrupt record: %v, %v", info.Size(), err)
+	}
+
+	// A header claiming more bytes than the file has left, or a garbled
+	// last record, is a torn write and is dropped
+	garbled := append([]byte{}, data...)
+	garbled[len(garbled)-1] ^= 0xff
+	for _, tc := range []struct {
+		log  []byte
+		rows int
+	}{
+		{append(append([]byte{}, data...), 0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0, 1), 1},
+		{garbled, 2},
+	} {
+		if err := os.WriteFile(path, tc.log, 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		wal, err := OpenWAL(path)
+		if err != nil {
+			t.Fatalf("failed to reopen wal: %v", err)
+		}
+		db := NewDatabase()
+		if err := wal.Replay(db.Apply); err != nil {
+			t.Fatalf("replay with a torn tail failed: %v", err)
+		}
+		wal.Close()
+		if db.Tables["users"].Len() != tc.rows {
+			t.Fatalf("expected %d rows, got %d", tc.rows, db.Tables["users"].Len())
+		}
+	}
+}
@@ -215,7 +215,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MartinMurithi/NovaDB.git/internal/engine"
 	"github.com/MartinMurithi/NovaDB.git/internal/parser"
@@ -263,7 +265,7 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 			return
 		}
 
-		_, err := db.CreateTable(body.Name)
+		_, err := eng.CreateTable(body.Name)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -281,15 +283,14 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 
 		cols := []gin.H{}
 		for _, col := range table.Columns {
-			cols = append(cols, gin.H{"name": col.Name, "type": col.ColumnType})
+			cols = append(cols, gin.H{"name": col.Name, "type": col.ColumnType, "definition": col.Definition()})
 		}
 		c.JSON(http.StatusOK, gin.H{"columns": cols})
 	})
 
 	r.POST("/table/:name/column", func(c *gin.Context) {
 		tableName := c.Param("name")
-		table, ok := db.Tables[tableName]
-		if !ok {
+		if _, ok := db.Tables[tableName]; !ok {
 			c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
 			return
 		}
@@ -321,10 +322,14 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 			return
 		}
 
-		table.AddColumn(&storage.Column{
+		err := eng.AddColumn(tableName, &storage.Column{
 			Name:       body.Name,
 			ColumnType: colType,
 		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
@@ -332,23 +337,47 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 	// --------------------------
 	// CRUD endpoints
 	// --------------------------
+	// GET /table/:name?order=col&dir=desc&limit=50&offset=100 returns one
+	// page of rows; without parameters it returns every row
 	r.GET("/table/:name", func(c *gin.Context) {
 		tableName := c.Param("name")
-		sql := fmt.Sprintf("SELECT * FROM %s;", tableName)
-		query, err := parser.Parse(sql)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		sql := "SELECT * FROM " + tableName
+
+		if col := c.Query("order"); col != "" {
+			if !eng.TableHasColumn(tableName, col) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("column '%s' does not exist in table '%s'", col, tableName)})
+				return
+			}
+			sql += " ORDER BY " + col
+			switch strings.ToLower(c.DefaultQuery("dir", "asc")) {
+			case "asc":
+			case "desc":
+				sql += " DESC"
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dir must be asc or desc"})
+				return
+			}
+		}
+		for _, param := range []string{"limit", "offset"} {
+			v := c.Query(param)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a non-negative integer", param)})
+				return
+			}
+			sql += fmt.Sprintf(" %s %d", strings.ToUpper(param), n)
 		}
 
-		plan, _ := planner.CreatePlan(query)
-		rows, err := eng.ExecutePlan(plan)
+		rows, err := execSQL(eng, sql)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, rows)
+		c.JSON(http.StatusOK, rowsJSON(rows, db.Tables[tableName]))
 	})
 
 	r.POST("/table/:name", func(c *gin.Context) {
@@ -363,12 +392,7 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 		vals := []string{}
 		for k, v := range body {
 			cols = append(cols, k)
-			switch val := v.(type) {
-			case string:
-				vals = append(vals, fmt.Sprintf("'%s'", val))
-			default:
-				vals = append(vals, fmt.Sprintf("%v", val))
-			}
+			vals = append(vals, sqlLiteral(v))
 		}
 
 		sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
@@ -377,15 +401,18 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 			strings.Join(vals, ", "),
 		)
 
-		query, _ := parser.Parse(sql)
-		plan, _ := planner.CreatePlan(query)
-		_, err := eng.ExecutePlan(plan)
+		rows, err := execSQL(eng, sql)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		// Return the stored row so callers learn generated keys
+		resp := gin.H{"status": "ok"}
+		if len(rows) > 0 {
+			resp["row"] = rowsJSON(rows, db.Tables[tableName])[0]
+		}
+		c.JSON(http.StatusOK, resp)
 	})
 
 	r.PUT("/table/:name/:id", func(c *gin.Context) {
@@ -400,19 +427,11 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 
 		setParts := []string{}
 		for k, v := range body {
-			switch val := v.(type) {
-			case string:
-				setParts = append(setParts, fmt.Sprintf("%s='%s'", k, val))
-			default:
-				setParts = append(setParts, fmt.Sprintf("%s=%v", k, val))
-			}
+			setParts = append(setParts, fmt.Sprintf("%s=%s", k, sqlLiteral(v)))
 		}
 
 		sql := fmt.Sprintf("UPDATE %s SET %s WHERE id=%s;", tableName, strings.Join(setParts, ", "), id)
-		query, _ := parser.Parse(sql)
-		plan, _ := planner.CreatePlan(query)
-		_, err := eng.ExecutePlan(plan)
-		if err != nil {
+		if _, err := execSQL(eng, sql); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -424,10 +443,7 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 		tableName := c.Param("name")
 		id := c.Param("id")
 		sql := fmt.Sprintf("DELETE FROM %s WHERE id=%s;", tableName, id)
-		query, _ := parser.Parse(sql)
-		plan, _ := planner.CreatePlan(query)
-		_, err := eng.ExecutePlan(plan)
-		if err != nil {
+		if _, err := execSQL(eng, sql); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -435,6 +451,90 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// --------------------------
+	// Queries
+	// --------------------------
+
+	// POST /query {"sql": "SELECT ..."} runs a statement and returns the
+	// names of its result columns with its rows, e.g. "dept" and
+	// "COUNT(*)" for SELECT dept, COUNT(*) FROM staff GROUP BY dept, or
+	// "gross" for SELECT price * 1.16 AS gross FROM products.
+	r.POST("/query", func(c *gin.Context) {
+		var body struct {
+			SQL string `json:"sql"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query, err := parser.Parse(body.SQL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		plan, err := eng.Plan(query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rows, err := eng.ExecutePlan(plan)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only SELECT * returns rows shaped like the table's
+		table := db.Tables[plan.TableName]
+		columns := resultColumns(plan, table)
+		if len(plan.Columns) != 1 || plan.Columns[0] != "*" {
+			table = nil
+		}
+		c.JSON(http.StatusOK, gin.H{"columns": columns, "rows": rowsJSON(rows, table)})
+	})
+
+	// --------------------------
+	// Query plans
+	// --------------------------
+
+	// POST /explain {"sql": "SELECT ...", "analyze": false} returns the plan
+	// tree of a SELECT, INSERT, UPDATE or DELETE. The statement may also
+	// start with EXPLAIN [ANALYZE] itself. With analyze it is executed.
+	r.POST("/explain", func(c *gin.Context) {
+		var body struct {
+			SQL     string `json:"sql"`
+			Analyze bool   `json:"analyze"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query, err := parser.Parse(body.SQL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		analyze := body.Analyze
+		if query.Type == parser.ExplainQuery {
+			analyze = analyze || query.Analyze
+			query = query.Statement
+		}
+
+		plan, err := eng.Plan(query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node, err := eng.Explain(plan, analyze)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"plan": planJSON(node), "text": node.Lines()})
+	})
+
 	// --------------------------
 	// Start server
 	// --------------------------
@@ -443,3 +543,90 @@ func Run(db *storage.Database, eng *engine.Engine, addr string) {
 		log.Fatalf("failed to run server: %v", err)
 	}
 }
+
+// execSQL parses, plans and executes sql.
+func execSQL(eng *engine.Engine, sql string) ([]*storage.Row, error) {
+	query, err := parser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := eng.Plan(query)
+	if err != nil {
+		return nil, err
+	}
+	return eng.ExecutePlan(plan)
+}
+
+// sqlLiteral renders a decoded JSON value as a SQL literal. JSON null
+// becomes NULL.
+func sqlLiteral(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(val, "'", "''") + "'"
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// rowsJSON shapes rows for the API. Every column of the table is present in
+// each row, NULL as JSON null, and DATE values are rendered as YYYY-MM-DD.
+func rowsJSON(rows []*storage.Row, table *storage.Table) []gin.H {
+	out := make([]gin.H, 0, len(rows))
+	for _, row := range rows {
+		data := gin.H{}
+		if table != nil {
+			for _, col := range table.Columns {
+				data[col.Name] = nil
+			}
+		}
+		for col, val := range row.Data {
+			if d, ok := val.(time.Time); ok {
+				data[col] = d.Format(storage.DateLayout)
+				continue
+			}
+			data[col] = val
+		}
+		out = append(out, gin.H{"Data": data})
+	}
+	return out
+}
+
+// resultColumns names the columns of a statement's result in order. SELECT *
+// has the table's columns.
+func resultColumns(plan *planner.Plan, table *storage.Table) []string {
+	if len(plan.Columns) == 1 && plan.Columns[0] == "*" && table != nil {
+		cols := make([]string, len(table.Columns))
+		for i, col := range table.Columns {
+			cols[i] = col.Name
+		}
+		return cols
+	}
+	return plan.Columns
+}
+
+// planJSON shapes a plan tree for the API. Actual figures are only present
+// for EXPLAIN ANALYZE, with times in milliseconds.
+func planJSON(node *engine.PlanNode) gin.H {
+	out := gin.H{
+		"operator":       node.Operator,
+		"details":        node.Details,
+		"estimated_rows": node.Rows,
+		"cost":           node.Cost,
+	}
+	if node.Details == nil {
+		out["details"] = []string{}
+	}
+	if node.Analyzed {
+		out["actual_rows"] = node.ActualRows
+		out["time_ms"] = node.Time.Seconds() * 1000
+	}
+
+	children := make([]gin.H, 0, len(node.Children))
+	for _, child := range node.Children {
+		children = append(children, planJSON(child))
+	}
+	out["children"] = children
+	return out
+}